@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"noobular/internal/db"
+)
+
+// Account page
+
+func handleAccountPage(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	tokens, err := ctx.dbClient.GetAccessTokens(user.Id)
+	if err != nil {
+		return err
+	}
+	uiTokens := make([]UiAccessToken, len(tokens))
+	for i, token := range tokens {
+		uiTokens[i] = NewUiAccessToken(token)
+	}
+	return ctx.renderer.RenderAccountPage(w, UiAccountPage{uiTokens})
+}
+
+// Access tokens
+
+const MaxAccessTokens = 16
+
+type createAccessTokenRequest struct {
+	name  string
+	scope db.TokenScope
+}
+
+func parseCreateAccessTokenRequest(r *http.Request) (createAccessTokenRequest, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return createAccessTokenRequest{}, err
+	}
+	return createAccessTokenRequest{r.Form.Get("name"), db.TokenScope(r.Form.Get("scope"))}, nil
+}
+
+func validateCreateAccessTokenRequest(limits Limits, req createAccessTokenRequest) error {
+	if req.name == "" {
+		return fmt.Errorf("Token name cannot be empty")
+	}
+	if len(req.name) > limits.MaxTitleLength {
+		return fmt.Errorf("Token name cannot be longer than %d characters", limits.MaxTitleLength)
+	}
+	if !db.ValidTokenScope(string(req.scope)) {
+		return fmt.Errorf("Invalid token scope: %s", req.scope)
+	}
+	return nil
+}
+
+// Responds with the new token, the only time it's shown.
+func handleCreateAccessToken(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	req, err := parseCreateAccessTokenRequest(r)
+	if err != nil {
+		return badRequestErrorf("Error parsing create access token request: %v", err)
+	}
+	err = validateCreateAccessTokenRequest(ctx.limits, req)
+	if err != nil {
+		return badRequestErrorf("Error validating create access token request: %v", err)
+	}
+	tokens, err := ctx.dbClient.GetAccessTokens(user.Id)
+	if err != nil {
+		return err
+	}
+	if len(tokens) >= MaxAccessTokens {
+		return conflictErrorf("Cannot have more than %d access tokens", MaxAccessTokens)
+	}
+	token, secret, err := ctx.dbClient.CreateAccessToken(user.Id, req.name, req.scope)
+	if err != nil {
+		return err
+	}
+	return ctx.renderer.RenderAccessTokenCreated(w, UiCreatedAccessToken{NewUiAccessToken(token), secret})
+}
+
+func handleDeleteAccessToken(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	tokenId, err := strconv.ParseInt(r.PathValue("tokenId"), 10, 64)
+	if err != nil {
+		return err
+	}
+	err = ctx.dbClient.DeleteAccessToken(user.Id, tokenId)
+	if err == sql.ErrNoRows {
+		return notFoundErrorf("Access token %d not found", tokenId)
+	}
+	if err != nil {
+		return err
+	}
+	// Nothing to render
+	return nil
+}
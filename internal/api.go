@@ -0,0 +1,571 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"noobular/internal/db"
+)
+
+// The JSON API, for programs like mobile apps and scripts rather than
+// browsers. It's checked and stored the same way as the pages, with camelCase
+// JSON bodies, and errors as {"error": {"code": "not_found", "message": "..."}}
+// where the code is the kind of error. Lists are paginated with ?page=, from 1,
+// and ?perPage=, and come as {"items": [...], "page": 1, "perPage": 20, "total": 42}.
+
+const ApiPrefix = "/api/v1"
+
+func isApiRequest(r *http.Request) bool {
+	return r.URL.Path == ApiPrefix || strings.HasPrefix(r.URL.Path, ApiPrefix+"/")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Requests are small, since modules are uploaded as source elsewhere.
+const maxApiRequestLength = 1 << 20
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxApiRequestLength))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		return badRequestErrorf("Invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// Errors
+
+type ApiError struct {
+	Code    ErrorKind `json:"code"`
+	Message string    `json:"message"`
+	// Only set for internal errors, which don't say what went wrong
+	RequestId string `json:"requestId,omitempty"`
+}
+
+type ApiErrorResponse struct {
+	Error ApiError `json:"error"`
+}
+
+func NewApiErrorResponse(err HandlerError, requestId string) ApiErrorResponse {
+	return ApiErrorResponse{ApiError{err.Kind, err.Message, requestId}}
+}
+
+// Pagination
+
+const DefaultPerPage = 20
+const MaxPerPage = 100
+
+type ApiPage struct {
+	Items   interface{} `json:"items"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"perPage"`
+	Total   int         `json:"total"`
+}
+
+type pageRequest struct {
+	page    int
+	perPage int
+}
+
+func parsePageRequest(r *http.Request) (pageRequest, error) {
+	req := pageRequest{1, DefaultPerPage}
+	if page := r.URL.Query().Get("page"); page != "" {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			return pageRequest{}, badRequestErrorf("page must be a positive integer")
+		}
+		req.page = n
+	}
+	if perPage := r.URL.Query().Get("perPage"); perPage != "" {
+		n, err := strconv.Atoi(perPage)
+		if err != nil || n < 1 || n > MaxPerPage {
+			return pageRequest{}, badRequestErrorf("perPage must be between 1 and %d", MaxPerPage)
+		}
+		req.perPage = n
+	}
+	return req, nil
+}
+
+// How many items come before the page.
+func (p pageRequest) offset() int {
+	return (p.page - 1) * p.perPage
+}
+
+// Returns the range of the items on the page, out of total items.
+func (p pageRequest) bounds(total int) (int, int) {
+	start := min(p.offset(), total)
+	return start, min(start+p.perPage, total)
+}
+
+func (p pageRequest) response(items interface{}, total int) ApiPage {
+	return ApiPage{items, p.page, p.perPage, total}
+}
+
+// Users
+
+type ApiUser struct {
+	Id       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+func handleApiGetUser(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	return writeJSON(w, http.StatusOK, ApiUser{user.Id, user.Username})
+}
+
+// Courses
+
+type ApiCourse struct {
+	Id          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+}
+
+func NewApiCourse(course db.Course) ApiCourse {
+	return ApiCourse{course.Id, course.Title, course.Description, course.Public}
+}
+
+func writeApiCourses(w http.ResponseWriter, r *http.Request, courses []db.Course) error {
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return err
+	}
+	start, end := page.bounds(len(courses))
+	apiCourses := make([]ApiCourse, 0, end-start)
+	for _, course := range courses[start:end] {
+		apiCourses = append(apiCourses, NewApiCourse(course))
+	}
+	return writeJSON(w, http.StatusOK, page.response(apiCourses, len(courses)))
+}
+
+// Public courses, like the browse page. There can be any number of them,
+// so only the page asked for is loaded.
+func handleApiGetCourses(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return err
+	}
+	total, err := ctx.dbClient.CountPublicCourses()
+	if err != nil {
+		return err
+	}
+	courses, err := ctx.dbClient.GetPublicCoursesPage(page.perPage, page.offset())
+	if err != nil {
+		return err
+	}
+	apiCourses := make([]ApiCourse, len(courses))
+	for i, course := range courses {
+		apiCourses[i] = NewApiCourse(course)
+	}
+	return writeJSON(w, http.StatusOK, page.response(apiCourses, total))
+}
+
+func handleApiGetTeacherCourses(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	courses, err := ctx.dbClient.GetTeacherCourses(user.Id)
+	if err != nil {
+		return err
+	}
+	return writeApiCourses(w, r, courses)
+}
+
+func handleApiGetStudentCourses(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	courses, err := ctx.dbClient.GetEnrolledCourses(user.Id)
+	if err != nil {
+		return err
+	}
+	return writeApiCourses(w, r, courses)
+}
+
+type apiCreateModuleRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+type apiCreateCourseRequest struct {
+	Title       string                   `json:"title"`
+	Description string                   `json:"description"`
+	Public      bool                     `json:"public"`
+	Modules     []apiCreateModuleRequest `json:"modules"`
+}
+
+func handleApiCreateCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	var req apiCreateCourseRequest
+	err := readJSON(w, r, &req)
+	if err != nil {
+		return err
+	}
+	moduleTitles := make([]string, len(req.Modules))
+	moduleDescriptions := make([]string, len(req.Modules))
+	for i, module := range req.Modules {
+		moduleTitles[i] = module.Title
+		moduleDescriptions[i] = module.Description
+	}
+	err = validateCourseRequest(ctx.limits, req.Title, req.Description, moduleTitles, moduleDescriptions)
+	if err != nil {
+		return badRequestErrorf("%v", err)
+	}
+	tx, err := ctx.dbClient.Begin()
+	defer tx.Rollback()
+	if err != nil {
+		return err
+	}
+	course, err := db.CreateCourse(tx, user.Id, req.Title, req.Description, req.Public)
+	if err != nil {
+		return err
+	}
+	for i := range req.Modules {
+		_, err := db.CreateModule(tx, course.Id, moduleTitles[i], moduleDescriptions[i])
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, NewApiCourse(course))
+}
+
+// How the user is related to a course, which decides what they can see.
+type courseAccess struct {
+	teacher  bool
+	enrolled bool
+}
+
+// Returns the course in the request's path if the user can see it, i.e.
+// they teach it, are enrolled in it, or it's public. Courses they can't see
+// aren't found, so private courses stay private.
+func getApiCourse(r *http.Request, ctx HandlerContext, user db.User) (db.Course, courseAccess, error) {
+	courseId, err := strconv.Atoi(r.PathValue("courseId"))
+	if err != nil {
+		return db.Course{}, courseAccess{}, err
+	}
+	course, err := ctx.dbClient.GetCourse(courseId)
+	if err != nil {
+		return db.Course{}, courseAccess{}, err
+	}
+	_, err = ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	if err != nil && err != sql.ErrNoRows {
+		return db.Course{}, courseAccess{}, err
+	}
+	teacher := err == nil
+	_, err = ctx.dbClient.GetEnrollment(user.Id, courseId)
+	if err != nil && err != sql.ErrNoRows {
+		return db.Course{}, courseAccess{}, err
+	}
+	enrolled := err == nil
+	if !course.Public && !teacher && !enrolled {
+		return db.Course{}, courseAccess{}, notFoundErrorf("Course %d not found", courseId)
+	}
+	return course, courseAccess{teacher, enrolled}, nil
+}
+
+func handleApiGetCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	course, _, err := getApiCourse(r, ctx, user)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, NewApiCourse(course))
+}
+
+// Enrollments
+
+func handleApiEnroll(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	course, access, err := getApiCourse(r, ctx, user)
+	if err != nil {
+		return err
+	}
+	if access.enrolled {
+		return conflictErrorf("Already enrolled in course %d", course.Id)
+	}
+	if !course.Public {
+		return forbiddenErrorf("Cannot enroll in private course.")
+	}
+	tx, err := ctx.dbClient.Begin()
+	defer tx.Rollback()
+	if err != nil {
+		return err
+	}
+	_, err = db.InsertEnrollment(tx, user.Id, course.Id)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	ctx.metrics.CountEnrollment()
+	return writeJSON(w, http.StatusCreated, NewApiCourse(course))
+}
+
+// Modules
+
+type ApiModule struct {
+	Id            int    `json:"id"`
+	CourseId      int    `json:"courseId"`
+	VersionNumber int64  `json:"versionNumber"`
+	Title         string `json:"title"`
+	Description   string `json:"description"`
+	BlockCount    int    `json:"blockCount"`
+	// Ids of the modules to complete before this one
+	Prereqs []int `json:"prereqs"`
+}
+
+func NewApiModule(courseId int, version db.ModuleVersion, blockCount int, prereqs []db.Prereq) ApiModule {
+	prereqIds := make([]int, len(prereqs))
+	for i, prereq := range prereqs {
+		prereqIds[i] = prereq.PrereqModuleId
+	}
+	return ApiModule{version.ModuleId, courseId, version.VersionNumber, version.Title, version.Description, blockCount, prereqIds}
+}
+
+func getApiModule(ctx HandlerContext, module db.Module) (ApiModule, error) {
+	version, err := ctx.dbClient.GetLatestModuleVersion(module.Id)
+	if err != nil {
+		return ApiModule{}, err
+	}
+	blockCount, err := ctx.dbClient.GetBlockCount(version.Id)
+	if err != nil {
+		return ApiModule{}, err
+	}
+	prereqs, err := ctx.dbClient.GetPrereqs(module.Id)
+	if err != nil {
+		return ApiModule{}, err
+	}
+	return NewApiModule(module.CourseId, version, blockCount, prereqs), nil
+}
+
+// Returns every module in the course, in the same number of queries however many it has.
+func getApiModules(ctx HandlerContext, courseId int) ([]ApiModule, error) {
+	modules, err := ctx.dbClient.GetLatestModuleVersions([]int{courseId})
+	if err != nil {
+		return nil, err
+	}
+	prereqs, err := ctx.dbClient.GetCoursePrereqs(courseId)
+	if err != nil {
+		return nil, err
+	}
+	prereqsByModule := make(map[int][]db.Prereq) // moduleId -> its prereqs
+	for _, prereq := range prereqs {
+		prereqsByModule[prereq.ModuleId] = append(prereqsByModule[prereq.ModuleId], prereq)
+	}
+	apiModules := make([]ApiModule, len(modules))
+	for i, module := range modules {
+		apiModules[i] = NewApiModule(courseId, module.Version, module.BlockCount, prereqsByModule[module.Version.ModuleId])
+	}
+	return apiModules, nil
+}
+
+func handleApiGetModules(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	course, _, err := getApiCourse(r, ctx, user)
+	if err != nil {
+		return err
+	}
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return err
+	}
+	apiModules, err := getApiModules(ctx, course.Id)
+	if err != nil {
+		return err
+	}
+	start, end := page.bounds(len(apiModules))
+	return writeJSON(w, http.StatusOK, page.response(apiModules[start:end], len(apiModules)))
+}
+
+func getApiCourseModule(r *http.Request, ctx HandlerContext, user db.User) (db.Module, courseAccess, error) {
+	course, access, err := getApiCourse(r, ctx, user)
+	if err != nil {
+		return db.Module{}, courseAccess{}, err
+	}
+	moduleId, err := strconv.Atoi(r.PathValue("moduleId"))
+	if err != nil {
+		return db.Module{}, courseAccess{}, err
+	}
+	module, err := ctx.dbClient.GetModule(course.Id, moduleId)
+	if err == sql.ErrNoRows {
+		return db.Module{}, courseAccess{}, notFoundErrorf("Module %d not found", moduleId)
+	}
+	return module, access, err
+}
+
+func handleApiGetModule(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	module, _, err := getApiCourseModule(r, ctx, user)
+	if err != nil {
+		return err
+	}
+	apiModule, err := getApiModule(ctx, module)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, apiModule)
+}
+
+// Module versions
+
+type ApiModuleVersion struct {
+	Id            int64  `json:"id"`
+	ModuleId      int    `json:"moduleId"`
+	VersionNumber int64  `json:"versionNumber"`
+	Title         string `json:"title"`
+	Description   string `json:"description"`
+	BlockCount    int    `json:"blockCount"`
+	// The whole module, blocks and all, in the JSON protocol format. Only
+	// teachers get this, since it has the answers.
+	Module json.RawMessage `json:"module,omitempty"`
+}
+
+// The latest version of a module.
+func handleApiGetModuleVersion(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	module, access, err := getApiCourseModule(r, ctx, user)
+	if err != nil {
+		return err
+	}
+	version, err := ctx.dbClient.GetLatestModuleVersion(module.Id)
+	if err != nil {
+		return err
+	}
+	blockCount, err := ctx.dbClient.GetBlockCount(version.Id)
+	if err != nil {
+		return err
+	}
+	apiVersion := ApiModuleVersion{version.Id, module.Id, version.VersionNumber, version.Title, version.Description, blockCount, nil}
+	if access.teacher {
+		protocolModule, err := getProtocolModule(ctx, version)
+		if err != nil {
+			return err
+		}
+		apiVersion.Module, err = protocolModule.JSON()
+		if err != nil {
+			return err
+		}
+	}
+	return writeJSON(w, http.StatusOK, apiVersion)
+}
+
+// Knowledge points
+
+type ApiKnowledgePoint struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func handleApiGetKnowledgePoints(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	course, _, err := getApiCourse(r, ctx, user)
+	if err != nil {
+		return err
+	}
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return err
+	}
+	knowledgePoints, err := ctx.dbClient.GetKnowledgePoints(int64(course.Id))
+	if err != nil {
+		return err
+	}
+	start, end := page.bounds(len(knowledgePoints))
+	apiKnowledgePoints := make([]ApiKnowledgePoint, 0, end-start)
+	for _, knowledgePoint := range knowledgePoints[start:end] {
+		apiKnowledgePoints = append(apiKnowledgePoints, ApiKnowledgePoint{knowledgePoint.Id, knowledgePoint.Name})
+	}
+	return writeJSON(w, http.StatusOK, page.response(apiKnowledgePoints, len(knowledgePoints)))
+}
+
+// Prereqs
+
+type ApiPrereq struct {
+	ModuleId       int `json:"moduleId"`
+	PrereqModuleId int `json:"prereqModuleId"`
+}
+
+func handleApiGetPrereqs(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	course, _, err := getApiCourse(r, ctx, user)
+	if err != nil {
+		return err
+	}
+	page, err := parsePageRequest(r)
+	if err != nil {
+		return err
+	}
+	prereqs, err := ctx.dbClient.GetCoursePrereqs(course.Id)
+	if err != nil {
+		return err
+	}
+	apiPrereqs := make([]ApiPrereq, len(prereqs))
+	for i, prereq := range prereqs {
+		apiPrereqs[i] = ApiPrereq{prereq.ModuleId, prereq.PrereqModuleId}
+	}
+	start, end := page.bounds(len(apiPrereqs))
+	return writeJSON(w, http.StatusOK, page.response(apiPrereqs[start:end], len(apiPrereqs)))
+}
+
+// Progress
+
+type ApiModuleProgress struct {
+	ModuleId int    `json:"moduleId"`
+	Title    string `json:"title"`
+	// The block the student is up to, which is the block count once they've completed it
+	BlockIndex int  `json:"blockIndex"`
+	BlockCount int  `json:"blockCount"`
+	Started    bool `json:"started"`
+	Completed  bool `json:"completed"`
+	// Whether they've completed the module's prereqs, so they can take it
+	Available   bool       `json:"available"`
+	Points      int        `json:"points"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+type ApiProgress struct {
+	CourseId    int                 `json:"courseId"`
+	TotalPoints int                 `json:"totalPoints"`
+	Modules     []ApiModuleProgress `json:"modules"`
+}
+
+// The user's progress through a course they're enrolled in, like the student course page.
+func handleApiGetProgress(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	course, access, err := getApiCourse(r, ctx, user)
+	if err != nil {
+		return err
+	}
+	if !access.enrolled {
+		return notFoundErrorf("Not enrolled in course %d", course.Id)
+	}
+	modules, err := ctx.dbClient.GetModuleProgress(user.Id, course.Id)
+	if err != nil {
+		return err
+	}
+	prereqs, err := ctx.dbClient.GetCoursePrereqs(course.Id)
+	if err != nil {
+		return err
+	}
+	completed := completedModules(modules)
+	progress := ApiProgress{course.Id, 0, make([]ApiModuleProgress, len(modules))}
+	for i, module := range modules {
+		// Students stay on the version they started
+		moduleProgress := ApiModuleProgress{
+			ModuleId:   module.ModuleId,
+			Title:      module.Version.Title,
+			BlockIndex: module.Visit.BlockIndex,
+			BlockCount: module.BlockCount,
+			Started:    module.Started,
+			Completed:  module.Completed(),
+			Available:  completedPrereqs(prereqs, module.ModuleId, completed),
+		}
+		if module.Awarded {
+			moduleProgress.Points = module.Point.Count
+			moduleProgress.CompletedAt = &modules[i].Point.CreatedAt
+		}
+		progress.TotalPoints += moduleProgress.Points
+		progress.Modules[i] = moduleProgress
+	}
+	return writeJSON(w, http.StatusOK, progress)
+}
@@ -4,8 +4,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
@@ -18,12 +19,12 @@ import (
 func checkCookie(r *http.Request, jwtSecret []byte) (int64, error) {
 	tokenCookie, err := r.Cookie("session_token")
 	if err != nil {
-		log.Println("No session token")
+		slog.DebugContext(r.Context(), "No session token")
 		return 0, err
 	}
 	userId, err := ValidateJwt(jwtSecret, tokenCookie.Value)
 	if err != nil {
-		log.Println("Invalid session token:", err)
+		slog.InfoContext(r.Context(), "Invalid session token", "error", err)
 		return 0, err
 	}
 	return userId, nil
@@ -38,19 +39,90 @@ func authRequiredHandler(handler UserHandler) HandlerMapHandler {
 		}
 		user, err := ctx.dbClient.GetUser(userId)
 		if err == sql.ErrNoRows {
-			log.Println("User not found")
+			slog.InfoContext(r.Context(), "Session user not found", "userId", userId)
 			http.Redirect(w, r, "/signup", http.StatusSeeOther)
 			return nil
 		}
 		if err != nil {
-			log.Println("Error getting user:", err)
+			slog.ErrorContext(r.Context(), "Error getting user", "error", err)
 			http.Redirect(w, r, "/signin", http.StatusSeeOther)
 			return nil
 		}
+		setRequestUser(r.Context(), user.Id)
 		return handler(w, r, ctx, user)
 	}
 }
 
+// Like authRequiredHandler, but for routes tools use, so it also accepts a personal
+// access token with the scope as an "Authorization: Bearer <token>" header.
+// Requests with a token that isn't valid fail instead of redirecting to sign in.
+func tokenAuthHandler(scope db.TokenScope, handler UserHandler) HandlerMapHandler {
+	cookieHandler := authRequiredHandler(handler)
+	return func(w http.ResponseWriter, r *http.Request, ctx HandlerContext) error {
+		user, ok, err := checkAccessToken(r, ctx, scope)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return cookieHandler(w, r, ctx)
+		}
+		setRequestUser(r.Context(), user.Id)
+		return handler(w, r, ctx, user)
+	}
+}
+
+// Like tokenAuthHandler, but for the API, so requests that aren't signed in
+// fail instead of redirecting to sign in.
+func apiAuthHandler(scope db.TokenScope, handler UserHandler) HandlerMapHandler {
+	return func(w http.ResponseWriter, r *http.Request, ctx HandlerContext) error {
+		user, ok, err := checkAccessToken(r, ctx, scope)
+		if err != nil {
+			return err
+		}
+		if ok {
+			setRequestUser(r.Context(), user.Id)
+			return handler(w, r, ctx, user)
+		}
+		userId, err := checkCookie(r, ctx.jwtSecret)
+		if err != nil {
+			return unauthorizedErrorf("Sign in or use an access token")
+		}
+		user, err = ctx.dbClient.GetUser(userId)
+		if err == sql.ErrNoRows {
+			return unauthorizedErrorf("Sign in or use an access token")
+		}
+		if err != nil {
+			return err
+		}
+		setRequestUser(r.Context(), user.Id)
+		return handler(w, r, ctx, user)
+	}
+}
+
+// Returns the user whose access token the request has, if it has one,
+// failing if the token isn't valid or doesn't have the scope.
+func checkAccessToken(r *http.Request, ctx HandlerContext, scope db.TokenScope) (db.User, bool, error) {
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return db.User{}, false, nil
+	}
+	token, err := ctx.dbClient.UseAccessToken(strings.TrimSpace(secret))
+	if err == sql.ErrNoRows {
+		return db.User{}, false, unauthorizedErrorf("Invalid access token")
+	}
+	if err != nil {
+		return db.User{}, false, err
+	}
+	if !token.Scope.Allows(scope) {
+		return db.User{}, false, forbiddenErrorf("Access token needs the %s scope", scope)
+	}
+	user, err := ctx.dbClient.GetUser(token.UserId)
+	if err != nil {
+		return db.User{}, false, err
+	}
+	return user, true, nil
+}
+
 func authOptionalHandler(handler OptionalUserHandler) HandlerMapHandler {
 	return func(w http.ResponseWriter, r *http.Request, ctx HandlerContext) error {
 		userId, err := checkCookie(r, ctx.jwtSecret)
@@ -58,9 +130,10 @@ func authOptionalHandler(handler OptionalUserHandler) HandlerMapHandler {
 		if loggedIn {
 			user, err := ctx.dbClient.GetUser(userId)
 			if err != nil {
-				log.Println("Error getting user:", err)
+				slog.ErrorContext(r.Context(), "Error getting user", "error", err)
 				return handler(w, r, ctx, nil)
 			} else {
+				setRequestUser(r.Context(), user.Id)
 				return handler(w, r, ctx, &user)
 			}
 		} else {
@@ -125,11 +198,11 @@ func GetWebAuthnUser(dbClient *db.DbClient, username string, create bool, failEx
 			return WebAuthnUser{}, err
 		}
 	} else if err != nil {
-		return WebAuthnUser{}, fmt.Errorf("Error getting user: %v", err)
+		return WebAuthnUser{}, fmt.Errorf("Error getting user: %w", err)
 	}
 	credential, err := dbClient.GetCredentialByUserId(user.Id)
 	if err == nil && failExistingCredential {
-		return WebAuthnUser{}, fmt.Errorf("User already has a credential")
+		return WebAuthnUser{}, conflictErrorf("User already has a credential")
 	} else if err == sql.ErrNoRows {
 		return WebAuthnUser{user, []webauthn.Credential{}}, nil
 	} else if err != nil {
@@ -186,15 +259,15 @@ const maxUsernameLength = 64
 func handleSignupBegin(w http.ResponseWriter, r *http.Request, ctx HandlerContext, webAuthn *webauthn.WebAuthn) error {
 	username := r.URL.Query().Get("username")
 	if username == "" {
-		return fmt.Errorf("empty username")
+		return badRequestErrorf("Username cannot be empty")
 	}
 	if len(username) > maxUsernameLength {
-		return fmt.Errorf("username too long, max %d characters", maxUsernameLength)
+		return badRequestErrorf("Username cannot be longer than %d characters", maxUsernameLength)
 	}
 	// TODO: delete user if they don't finish signup
 	webAuthnUser, err := GetWebAuthnUser(ctx.dbClient, username, true, true)
 	if err != nil {
-		return fmt.Errorf("Error getting webauthn user: %v", err)
+		return fmt.Errorf("Error getting webauthn user: %w", err)
 	}
 
 	// Begin registration
@@ -208,11 +281,11 @@ func handleSignupBegin(w http.ResponseWriter, r *http.Request, ctx HandlerContex
 func handleSignupFinish(w http.ResponseWriter, r *http.Request, ctx HandlerContext, webAuthn *webauthn.WebAuthn) error {
 	username := r.URL.Query().Get("username")
 	if username == "" {
-		return fmt.Errorf("empty username")
+		return badRequestErrorf("Username cannot be empty")
 	}
 	webAuthnUser, err := GetWebAuthnUser(ctx.dbClient, username, false, false)
 	if err != nil {
-		return fmt.Errorf("Error getting webauthn user: %v", err)
+		return fmt.Errorf("Error getting webauthn user: %w", err)
 	}
 
 	sessionData, err := ctx.dbClient.GetSession(webAuthnUser.User.Id)
@@ -227,7 +300,7 @@ func handleSignupFinish(w http.ResponseWriter, r *http.Request, ctx HandlerConte
 
 	webAuthnCredential, err := webAuthn.FinishRegistration(&webAuthnUser, session, r)
 	if err != nil {
-		return fmt.Errorf("Error finishing registration: %v", err)
+		return NewHandlerError(BadRequestErrorKind, "Could not register passkey", err)
 	}
 	credential, err := NewCredential(webAuthnUser.User.Id, *webAuthnCredential)
 	if err != nil {
@@ -237,7 +310,8 @@ func handleSignupFinish(w http.ResponseWriter, r *http.Request, ctx HandlerConte
 	if err != nil {
 		return fmt.Errorf("Error inserting credential: %v", err)
 	}
-	log.Printf("User %s registered with credentials", username)
+	slog.InfoContext(r.Context(), "User registered with credentials", "username", username)
+	ctx.metrics.CountSignup()
 
 	// TODO: add credentials to cookie and verify in auth middleware
 	// This would mean even if attacker gets our server's jwt secret
@@ -252,11 +326,11 @@ func handleSignupFinish(w http.ResponseWriter, r *http.Request, ctx HandlerConte
 func handleSigninBegin(w http.ResponseWriter, r *http.Request, ctx HandlerContext, webAuthn *webauthn.WebAuthn) error {
 	username := r.URL.Query().Get("username")
 	if username == "" {
-		return fmt.Errorf("empty username")
+		return badRequestErrorf("Username cannot be empty")
 	}
 	webAuthnUser, err := GetWebAuthnUser(ctx.dbClient, username, false, false)
 	if err != nil {
-		return fmt.Errorf("Error getting webauthn user: %v", err)
+		return fmt.Errorf("Error getting webauthn user: %w", err)
 	}
 
 	// Begin registration
@@ -270,11 +344,11 @@ func handleSigninBegin(w http.ResponseWriter, r *http.Request, ctx HandlerContex
 func handleSigninFinish(w http.ResponseWriter, r *http.Request, ctx HandlerContext, webAuthn *webauthn.WebAuthn) error {
 	username := r.URL.Query().Get("username")
 	if username == "" {
-		return fmt.Errorf("empty username")
+		return badRequestErrorf("Username cannot be empty")
 	}
 	webAuthnUser, err := GetWebAuthnUser(ctx.dbClient, username, false, false)
 	if err != nil {
-		return fmt.Errorf("Error getting webauthn user: %v", err)
+		return fmt.Errorf("Error getting webauthn user: %w", err)
 	}
 
 	sessionData, err := ctx.dbClient.GetSession(webAuthnUser.User.Id)
@@ -289,12 +363,12 @@ func handleSigninFinish(w http.ResponseWriter, r *http.Request, ctx HandlerConte
 
 	webAuthnCredential, err := webAuthn.FinishLogin(&webAuthnUser, session, r)
 	if err != nil {
-		return fmt.Errorf("Error finishing registration: %v", err)
+		return NewHandlerError(UnauthorizedErrorKind, "Could not verify passkey", err)
 	}
 
 	// Prevent replay attacks by checking the sign count has been incremented
 	if webAuthnCredential.Authenticator.CloneWarning {
-		return fmt.Errorf("Sign count not incremented, key may have been cloned!")
+		return unauthorizedErrorf("Sign count not incremented, key may have been cloned!")
 	}
 
 	credential, err := NewCredential(webAuthnUser.User.Id, *webAuthnCredential)
@@ -305,7 +379,7 @@ func handleSigninFinish(w http.ResponseWriter, r *http.Request, ctx HandlerConte
 	if err != nil {
 		return fmt.Errorf("Error updating credential: %v", err)
 	}
-	log.Printf("User %s logged in with credentials", username)
+	slog.InfoContext(r.Context(), "User logged in with credentials", "username", username)
 
 	cookie, err := CreateAuthCookie(ctx.jwtSecret, webAuthnUser.User.Id, ctx.env == Production)
 	http.SetCookie(w, &cookie)
@@ -1,12 +1,12 @@
 package client
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
 	"math/rand/v2"
+	"mime/multipart"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -14,18 +14,31 @@ import (
 type Client struct {
 	baseUrl       string
 	session_token *http.Cookie
+	// A personal access token, sent instead of the session token if set
+	accessToken string
 }
 
 func NewClient(baseUrl string, session_token *http.Cookie) Client {
-	return Client{baseUrl, session_token}
+	return Client{baseUrl, session_token, ""}
+}
+
+// Returns a client authenticated with a personal access token, e.g. for CI.
+func NewTokenClient(baseUrl string, accessToken string) Client {
+	return Client{baseUrl, nil, accessToken}
 }
 
 func (c Client) request(method string, path string, body string) *http.Response {
+	return c.requestWithContentType(method, path, body, "application/x-www-form-urlencoded")
+}
+
+func (c Client) requestWithContentType(method string, path string, body string, contentType string) *http.Response {
 	req, _ := http.NewRequest(method, c.baseUrl+path, strings.NewReader(body))
 	if method == "POST" || method == "PUT" {
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Content-Type", contentType)
 	}
-	if c.session_token != nil {
+	if c.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	} else if c.session_token != nil {
 		req.AddCookie(c.session_token)
 	}
 	resp, _ := http.DefaultClient.Do(req)
@@ -170,151 +183,104 @@ func (c Client) EditModule(courseId int64, moduleId int64, title string, descrip
 	return resp
 }
 
-func (c Client) UploadModule(courseId int64, moduleId int64, module string) (*http.Response, error) {
-	moduleTitle, moduleDescription, blocks, err := ParseModule(module)
-	if err != nil {
-		return nil, err
-	}
-	return c.EditModule(courseId, moduleId, moduleTitle, moduleDescription, blocks), nil
-}
-
-func ParseModule(module string) (string, string, []Block, error) {
-	metadataUnseen := 0
-	metadataProcessing := 1
-	metadataParsed := 2
-	metadataStatus := metadataUnseen
-	moduleTitle := ""
-	moduleDescription := ""
-	parsingNothing := 0
-	parsingContent := 1
-	parsingQuestion := 2
-	parsingChoice := 3
-	parsingCorrectChoice := 4
-	parsingExplanation := 5
-	parsingType := parsingNothing
-	buffer := []string{}
-	blocks := []Block{}
-	questionBuffer := QuestionBlock{}
-
-	finishPiece := func(parsingType int, newParsingType int, buffer []string, questionBuffer *QuestionBlock, blocks *[]Block) error {
-		text := strings.Join(buffer, "\n")
-		text = strings.TrimSpace(text)
-		if parsingType == parsingContent {
-			*blocks = append(*blocks, NewContentBlock(text))
-		} else if parsingType == parsingQuestion {
-			questionBuffer.Text = text
-		} else if parsingType == parsingChoice {
-			questionBuffer.Choices = append(questionBuffer.Choices, Choice{text, false})
-		} else if parsingType == parsingCorrectChoice {
-			questionBuffer.Choices = append(questionBuffer.Choices, Choice{text, true})
-		} else if parsingType == parsingExplanation {
-			questionBuffer.Explanation = text
-		}
+func ModuleSourceRoute(courseId, moduleId int64) string {
+	return fmt.Sprintf("/teacher/course/%d/module/%d/source", courseId, moduleId)
+}
 
-		if parsingType == parsingQuestion && !(newParsingType == parsingChoice || newParsingType == parsingCorrectChoice) {
-			return fmt.Errorf("question must be followed by choice or correct choice")
-		}
+// Uploads a module written in the markdown protocol as is, the server does the parsing.
+func (c Client) UploadModule(courseId int64, moduleId int64, module string) *http.Response {
+	return c.requestWithContentType("PUT", ModuleSourceRoute(courseId, moduleId), module, "text/markdown")
+}
 
-		justParsedChoice := parsingType == parsingChoice || parsingType == parsingCorrectChoice
-		nextParsingNonQuestion := newParsingType != parsingChoice && newParsingType != parsingCorrectChoice && newParsingType != parsingExplanation
-		justParsedExplanation := parsingType == parsingExplanation
-		finishedQuestion := justParsedExplanation || (justParsedChoice && nextParsingNonQuestion)
-		if finishedQuestion {
-			*blocks = append(*blocks, NewQuestionBlock(questionBuffer.Text, questionBuffer.Choices, questionBuffer.Explanation))
-			*questionBuffer = QuestionBlock{}
-		}
-		return nil
+// Uploads a module in the protocol's JSON form.
+func (c Client) UploadModuleJSON(courseId int64, moduleId int64, module string) *http.Response {
+	return c.requestWithContentType("PUT", ModuleSourceRoute(courseId, moduleId), module, "application/json")
+}
+
+func ImportQuestionsRoute(courseId, moduleId int64) string {
+	return fmt.Sprintf("/teacher/course/%d/module/%d/import", courseId, moduleId)
+}
+
+// Adds the questions from a question bank file, e.g. in the "gift" or "csv" format, to a module.
+func (c Client) ImportQuestions(courseId int64, moduleId int64, format string, file string) *http.Response {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("format", format)
+	part, _ := writer.CreateFormFile("file", "questions."+format)
+	part.Write([]byte(file))
+	writer.Close()
+	return c.requestWithContentType("POST", ImportQuestionsRoute(courseId, moduleId), body.String(), writer.FormDataContentType())
+}
+
+func (c Client) CreateKnowledgePoint(courseId int64, name string) *http.Response {
+	formData := url.Values{}
+	formData.Set("name", name)
+	resp := c.post(fmt.Sprintf("/teacher/course/%d/knowledge-point", courseId), formData.Encode())
+	return resp
+}
+
+func ExportModuleRoute(courseId, moduleId int64) string {
+	return fmt.Sprintf("/teacher/course/%d/module/%d/export", courseId, moduleId)
+}
+
+// Exports a module's latest version as "markdown", "json", or a "zip" with its assets.
+func (c Client) ExportModule(courseId int64, moduleId int64, format string) *http.Response {
+	route := ExportModuleRoute(courseId, moduleId)
+	if format != "markdown" {
+		route += "?format=" + url.QueryEscape(format)
 	}
+	return c.get(route)
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(module))
+func ExportCourseRoute(courseId int64) string {
+	return fmt.Sprintf("/teacher/course/%d/export", courseId)
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if metadataStatus == metadataUnseen && line == "" {
-			continue
-		}
-		if metadataStatus == metadataUnseen && line == "---" {
-			metadataStatus = metadataProcessing
-			continue
-		}
-		if metadataStatus == metadataProcessing && line == "---" {
-			metadataStatus = metadataParsed
-			continue
-		}
-		if metadataStatus == metadataProcessing {
-			parts := strings.SplitN(line, ": ", 2)
-			if len(parts) != 2 {
-				return "", "", nil, fmt.Errorf("metadata not key value")
-			}
-			key := parts[0]
-			value := parts[1]
-			if key == "title" {
-				moduleTitle = value
-			} else if key == "description" {
-				moduleDescription = value
-			}
-			continue
-		}
-		if metadataStatus != metadataParsed {
-			return "", "", nil, fmt.Errorf("metadata not parsed")
-		}
+func (c Client) ExportCourse(courseId int64) *http.Response {
+	return c.get(ExportCourseRoute(courseId))
+}
 
-		pattern := `^\[//\]: # \((.+?)\)$`
-		re := regexp.MustCompile(pattern)
-		matches := re.FindStringSubmatch(line)
-		if matches == nil {
-			buffer = append(buffer, line)
-			continue
-		}
+func ImportCourseRoute() string {
+	return "/teacher/course/import"
+}
 
-		// The first element is the whole match, the second is the captured group
-		parsedValue := matches[1]
-		values := strings.Split(parsedValue, " ")
-		valueType := values[0]
-		newParsingType := parsingNothing
-		switch valueType {
-		case "content":
-			newParsingType = parsingContent
-		case "question":
-			newParsingType = parsingQuestion
-		case "choice":
-			newParsingType = parsingChoice
-			if len(values) == 2 && values[1] == "correct" {
-				newParsingType = parsingCorrectChoice
-			}
-		case "explanation":
-			newParsingType = parsingExplanation
-		}
+// Creates a new course from a course bundle zip (see protocol.WriteBundle).
+func (c Client) ImportCourse(bundle []byte) *http.Response {
+	return c.requestWithContentType("POST", ImportCourseRoute(), string(bundle), "application/zip")
+}
 
-		// If we matched a new block, it means we're at the end
-		// of the previous block
-		err := finishPiece(parsingType, newParsingType, buffer, &questionBuffer, &blocks)
-		if err != nil {
-			return "", "", nil, err
-		}
+func UploadAssetRoute(courseId int64) string {
+	return fmt.Sprintf("/teacher/course/%d/asset", courseId)
+}
 
-		buffer = []string{}
-		parsingType = newParsingType
-	}
+// Uploads a file for the course's modules to reference, responding with the reference.
+func (c Client) UploadAsset(courseId int64, data []byte) *http.Response {
+	return c.requestWithContentType("POST", UploadAssetRoute(courseId), string(data), "application/octet-stream")
+}
 
-	err := finishPiece(parsingType, parsingNothing, buffer, &questionBuffer, &blocks)
-	if err != nil {
-		return "", "", nil, err
-	}
+func AssetRoute(hash string) string {
+	return "/asset/" + hash
+}
 
-	if err := scanner.Err(); err != nil {
-		return "", "", nil, err
-	}
-	if metadataStatus != metadataParsed {
-		return "", "", nil, fmt.Errorf("metadata not parsed")
-	}
+func ModulesRoute(courseId int64) string {
+	return fmt.Sprintf("/teacher/course/%d/module", courseId)
+}
 
-	return moduleTitle, moduleDescription, blocks, nil
+// Gets each of the course's modules with the hash of its latest version (see protocol.ModuleSummary).
+func (c Client) GetModuleSummaries(courseId int64) *http.Response {
+	return c.get(ModulesRoute(courseId))
 }
 
-func (c Client) CreateKnowledgePoint(courseId int64, name string) *http.Response {
-	formData := url.Values{}
-	formData.Set("name", name)
-	resp := c.post(fmt.Sprintf("/teacher/course/%d/knowledge-point", courseId), formData.Encode())
-	return resp
+// Creates a module from markdown protocol source, responding with the new module's id.
+func (c Client) CreateModule(courseId int64, module string) *http.Response {
+	return c.requestWithContentType("POST", ModulesRoute(courseId), module, "text/markdown")
+}
+
+// Creates a module from the protocol's JSON form, responding with the new module's id.
+func (c Client) CreateModuleJSON(courseId int64, module string) *http.Response {
+	return c.requestWithContentType("POST", ModulesRoute(courseId), module, "application/json")
+}
+
+func (c Client) DeleteModule(courseId int64, moduleId int64) *http.Response {
+	return c.delete(EditModuleRoute(courseId, moduleId))
 }
@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gopkg.in/yaml.v3"
+
+	"noobular/internal/db"
+	"noobular/internal/protocol"
+)
+
+// Server settings come from, in increasing precedence: defaults, a YAML
+// config file, environment variables, then command line flags. e.g.
+//
+//	env: production
+//	dbPath: /var/lib/noobular/noobular.db
+//	addr: :443
+//	metricsAddr: 127.0.0.1:9090
+//	baseUrl: https://noobular.com
+//	certPath: /etc/noobular/cert.pem
+//	keyPath: /etc/noobular/key.pem
+//	limits:
+//	  maxModules: 256
+//	log:
+//	  level: debug
+//
+// Anything left out falls back to its default, where some defaults depend
+// on the environment, e.g. the base URL. The JWT secret is only ever read
+// from the environment, so it doesn't end up in a file by accident.
+type Config struct {
+	Env    Environment `yaml:"env"`
+	DbPath string      `yaml:"dbPath"`
+	// The address to listen on, e.g. ":8080" or "127.0.0.1:8081"
+	Addr string `yaml:"addr"`
+	// Where to serve /metrics, e.g. an admin port that isn't public.
+	// Metrics aren't served at all if it's not set.
+	MetricsAddr string `yaml:"metricsAddr"`
+	// Where users reach the server, which passkeys are tied to
+	BaseUrl string `yaml:"baseUrl"`
+	// The WebAuthn relying party id, which defaults to the base URL's host
+	RPID string `yaml:"rpId"`
+	// The origins passkeys can be used from, which default to the base URL
+	Origins []string `yaml:"origins"`
+	// TLS certificate chain and private key files, needed in production
+	CertPath string    `yaml:"certPath"`
+	KeyPath  string    `yaml:"keyPath"`
+	Limits   Limits    `yaml:"limits"`
+	Log      LogConfig `yaml:"log"`
+}
+
+// Limits on what can be put in a course, so one course can't take over the server.
+type Limits struct {
+	MaxTitleLength        int `yaml:"maxTitleLength"`
+	MaxDescriptionLength  int `yaml:"maxDescriptionLength"`
+	MaxModules            int `yaml:"maxModules"`
+	MaxBlocks             int `yaml:"maxBlocks"`
+	MaxContentLength      int `yaml:"maxContentLength"`
+	MaxQuestionLength     int `yaml:"maxQuestionLength"`
+	MaxChoices            int `yaml:"maxChoices"`
+	MaxChoiceLength       int `yaml:"maxChoiceLength"`
+	MaxModuleSourceLength int `yaml:"maxModuleSourceLength"`
+	MaxAssets             int `yaml:"maxAssets"`
+	MaxAssetLength        int `yaml:"maxAssetLength"`
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxTitleLength:        TitleMaxLength,
+		MaxDescriptionLength:  DescriptionMaxLength,
+		MaxModules:            MaxModules,
+		MaxBlocks:             MaxBlocks,
+		MaxContentLength:      MaxContentLength,
+		MaxQuestionLength:     MaxQuestionLength,
+		MaxChoices:            MaxChoices,
+		MaxChoiceLength:       MaxChoiceLength,
+		MaxModuleSourceLength: MaxModuleSourceLength,
+		MaxAssets:             MaxAssets,
+		MaxAssetLength:        MaxAssetLength,
+	}
+}
+
+// The largest a course bundle can be while fitting within the limits.
+func (l Limits) MaxCourseBundleLength() int {
+	return l.MaxModules*l.MaxModuleSourceLength + l.MaxAssets*l.MaxAssetLength
+}
+
+// What a course bundle can have once decompressed.
+func (l Limits) BundleLimits() protocol.BundleLimits {
+	return protocol.BundleLimits{
+		MaxModules:      l.MaxModules,
+		MaxModuleLength: l.MaxModuleSourceLength,
+		MaxAssets:       l.MaxAssets,
+		MaxAssetLength:  l.MaxAssetLength,
+	}
+}
+
+// Every question has one knowledge point, so courses don't need more
+// than their modules could ask questions.
+func (l Limits) MaxKnowledgePoints() int {
+	return l.MaxModules * l.MaxBlocks
+}
+
+func (l Limits) Validate() error {
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"maxTitleLength", l.MaxTitleLength},
+		{"maxDescriptionLength", l.MaxDescriptionLength},
+		{"maxModules", l.MaxModules},
+		{"maxBlocks", l.MaxBlocks},
+		{"maxContentLength", l.MaxContentLength},
+		{"maxQuestionLength", l.MaxQuestionLength},
+		{"maxChoices", l.MaxChoices},
+		{"maxChoiceLength", l.MaxChoiceLength},
+		{"maxModuleSourceLength", l.MaxModuleSourceLength},
+		{"maxAssets", l.MaxAssets},
+		{"maxAssetLength", l.MaxAssetLength},
+	}
+	for _, limit := range limits {
+		if limit.value <= 0 {
+			return fmt.Errorf("limits.%s must be positive", limit.name)
+		}
+	}
+	return nil
+}
+
+// Returns the settings from the config file at path, if any, overridden by
+// the environment. Nothing is defaulted or validated yet, since flags can
+// still override them.
+func LoadConfig(path string, getenv func(string) string) (Config, error) {
+	config := Config{Limits: DefaultLimits()}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&config)
+		// An empty file just has nothing to override
+		if err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+	}
+	err := config.applyEnv(getenv)
+	if err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+func (c *Config) applyEnv(getenv func(string) string) error {
+	settings := []struct {
+		name  string
+		value *string
+	}{
+		{"NOOBULAR_DB_PATH", &c.DbPath},
+		{"NOOBULAR_ADDR", &c.Addr},
+		{"NOOBULAR_METRICS_ADDR", &c.MetricsAddr},
+		{"NOOBULAR_BASE_URL", &c.BaseUrl},
+		{"NOOBULAR_RP_ID", &c.RPID},
+		{"CERT_PATH", &c.CertPath},
+		{"PRIV_KEY_PATH", &c.KeyPath},
+		{"NOOBULAR_LOG_LEVEL", &c.Log.Level},
+	}
+	for _, setting := range settings {
+		if value := getenv(setting.name); value != "" {
+			*setting.value = value
+		}
+	}
+	if env := getenv("ENVIRONMENT"); env != "" {
+		c.Env = Environment(env)
+	}
+	if format := getenv("NOOBULAR_LOG_FORMAT"); format != "" {
+		c.Log.Format = LogFormat(format)
+	}
+	if forms := getenv("NOOBULAR_LOG_FORMS"); forms != "" {
+		c.Log.Forms = FormLogging(forms)
+	}
+	if origins := getenv("NOOBULAR_ORIGINS"); origins != "" {
+		c.Origins = SplitList(origins)
+	}
+	if port := getenv("PORT"); port != "" {
+		if _, err := strconv.Atoi(port); err != nil {
+			return fmt.Errorf("PORT must be an integer")
+		}
+		c.Addr = ":" + port
+	}
+	return nil
+}
+
+// Splits a comma separated list, e.g. of origins, ignoring spaces and empty items.
+func SplitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Returns the config with defaults for anything not set.
+func (c Config) WithDefaults() Config {
+	if c.Env == "" {
+		c.Env = Local
+	}
+	if c.DbPath == "" {
+		c.DbPath = db.DefaultDbPath
+	}
+	if c.Addr == "" {
+		c.Addr = ":8080"
+	}
+	if c.BaseUrl == "" {
+		c.BaseUrl = "http://localhost:8080"
+		if c.Env == Production {
+			c.BaseUrl = "https://noobular.com"
+		}
+	}
+	if c.RPID == "" {
+		if baseUrl, err := url.Parse(c.BaseUrl); err == nil {
+			c.RPID = baseUrl.Hostname()
+		}
+	}
+	if len(c.Origins) == 0 {
+		c.Origins = []string{strings.TrimSuffix(c.BaseUrl, "/")}
+	}
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = JSONLogFormat
+	}
+	if c.Log.Forms == "" {
+		c.Log.Forms = RedactedFormLogging
+	}
+	return c
+}
+
+// Checks the config is complete and makes sense, so problems show up at
+// startup rather than when someone first signs in or uploads something.
+func (c Config) Validate() error {
+	if c.Env != Local && c.Env != Production {
+		return fmt.Errorf("env must be %s or %s, not %q", Local, Production, c.Env)
+	}
+	if c.DbPath == "" {
+		return fmt.Errorf("dbPath cannot be empty")
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("invalid addr %q: %v", c.Addr, err)
+	}
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			return fmt.Errorf("invalid metricsAddr %q: %v", c.MetricsAddr, err)
+		}
+		if c.MetricsAddr == c.Addr {
+			return fmt.Errorf("metricsAddr must differ from addr, so metrics aren't public")
+		}
+	}
+	for _, u := range append([]string{c.BaseUrl}, c.Origins...) {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid URL %q, must be like https://example.com", u)
+		}
+	}
+	if c.RPID == "" {
+		return fmt.Errorf("rpId cannot be empty")
+	}
+	if c.Env == Production {
+		if c.CertPath == "" || c.KeyPath == "" {
+			return fmt.Errorf("certPath and keyPath must be set in %s", Production)
+		}
+		for _, path := range []string{c.CertPath, c.KeyPath} {
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+		}
+	}
+	err := c.Log.Validate()
+	if err != nil {
+		return err
+	}
+	return c.Limits.Validate()
+}
+
+func (c Config) WebAuthn() (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPDisplayName: "Noobular", // Display Name for your site
+		RPID:          c.RPID,     // Generally the domain name for your site
+		RPOrigins:     c.Origins,  // The origin URLs for WebAuthn requests
+	})
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"container/list"
+	"context"
+	"html/template"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+
+	"noobular/internal/db"
+)
+
+// Bump whenever how content renders changes, e.g. a new goldmark extension
+// or KaTeX version, so content cached by older versions is rendered again.
+const RendererVersion = 1
+
+// How many rendered contents to keep in memory.
+const DefaultContentCacheSize = 4096
+
+// Caches content rendered to HTML by the hash of its markdown, since
+// KaTeX is slow and content never changes once stored. The most recently
+// used are kept in memory, and everything rendered is stored in the db
+// so it outlives restarts.
+//
+// A nil *ContentCache renders content every time.
+type ContentCache struct {
+	dbClient *db.DbClient
+	metrics  *Metrics
+	// Logs use this, so they're tagged with the request that rendered the content
+	ctx    context.Context
+	memory *contentCacheMemory
+}
+
+// Shared by every context's view of a cache.
+type contentCacheMemory struct {
+	size int
+	mu   sync.Mutex
+	// hash -> element of order
+	entries map[string]*list.Element
+	// Most recently used at the front
+	order *list.List
+}
+
+type contentCacheEntry struct {
+	hash string
+	html template.HTML
+}
+
+// Returns a cache of content rendered by RendererVersion, clearing out
+// what older versions rendered.
+func NewContentCache(dbClient *db.DbClient, size int, metrics *Metrics) *ContentCache {
+	c := &ContentCache{
+		dbClient: dbClient,
+		metrics:  metrics,
+		ctx:      context.Background(),
+		memory: &contentCacheMemory{
+			size:    size,
+			entries: make(map[string]*list.Element),
+			order:   list.New(),
+		},
+	}
+	err := dbClient.DeleteStaleRenderedContent(RendererVersion)
+	if err != nil {
+		slog.WarnContext(c.ctx, "Failed to delete stale rendered content", "error", err)
+	}
+	return c
+}
+
+// Returns a cache sharing this one's content, whose logs and queries use ctx.
+func (c *ContentCache) WithContext(ctx context.Context) *ContentCache {
+	if c == nil {
+		return nil
+	}
+	return &ContentCache{c.dbClient.WithContext(ctx), c.metrics, ctx, c.memory}
+}
+
+// Like NewUiContentRendered, but only renders content that isn't cached.
+func (c *ContentCache) Render(content db.Content) (UiContent, error) {
+	if c == nil {
+		return NewUiContentRendered(content)
+	}
+	hash := db.ContentHash(content.Content)
+	if html, ok := c.memory.get(string(hash)); ok {
+		c.metrics.CountContentCacheLookup("memory")
+		return UiContent{content.Id, rand.Int(), content.Content, html}, nil
+	}
+	html, ok, err := c.dbClient.GetRenderedContent(hash, RendererVersion)
+	if err != nil {
+		return UiContent{}, err
+	}
+	if ok {
+		c.metrics.CountContentCacheLookup("db")
+		c.memory.put(string(hash), template.HTML(html))
+		return UiContent{content.Id, rand.Int(), content.Content, template.HTML(html)}, nil
+	}
+	c.metrics.CountContentCacheLookup("miss")
+	rendered, err := NewUiContentRendered(content)
+	if err != nil {
+		return UiContent{}, err
+	}
+	// Content that isn't stored, like a missing explanation, can't be stored rendered either
+	if content.Id > 0 {
+		err = c.dbClient.StoreRenderedContent(hash, RendererVersion, string(rendered.ContentTmpl))
+		if err != nil {
+			// It'll just be rendered again next time it isn't in memory
+			slog.WarnContext(c.ctx, "Failed to store rendered content", "contentId", content.Id, "error", err)
+		}
+	}
+	c.memory.put(string(hash), rendered.ContentTmpl)
+	return rendered, nil
+}
+
+func (c *contentCacheMemory) get(hash string) (template.HTML, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[hash]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(contentCacheEntry).html, true
+}
+
+func (c *contentCacheMemory) put(hash string, html template.HTML) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[hash] = c.order.PushFront(contentCacheEntry{hash, html})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(contentCacheEntry).hash)
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createAcceptedAnswerTable = `
+create table if not exists accepted_answers (
+	id integer primary key autoincrement,
+	question_id integer not null,
+	answer text not null,
+	regex bool not null,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+`
+
+// An answer to a text question that counts as correct, either an exact
+// string or a regular expression.
+type AcceptedAnswer struct {
+	Id         int
+	QuestionId int
+	Answer     string
+	Regex      bool
+}
+
+func NewAcceptedAnswer(id int, questionId int, answer string, regex bool) AcceptedAnswer {
+	return AcceptedAnswer{id, questionId, answer, regex}
+}
+
+const insertAcceptedAnswerQuery = `
+insert into accepted_answers(question_id, answer, regex)
+values(?, ?, ?);
+`
+
+func InsertAcceptedAnswer(tx *sql.Tx, questionId int64, answer string, regex bool) error {
+	_, err := tx.Exec(insertAcceptedAnswerQuery, questionId, answer, regex)
+	return err
+}
+
+const getAcceptedAnswersQuery = `
+select a.id, a.question_id, a.answer, a.regex
+from accepted_answers a
+where a.question_id = ?
+order by a.id;
+`
+
+func (c *DbClient) GetAcceptedAnswers(questionId int) ([]AcceptedAnswer, error) {
+	rows, err := c.query(getAcceptedAnswersQuery, questionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	accepted := []AcceptedAnswer{}
+	for rows.Next() {
+		answer := AcceptedAnswer{}
+		err := rows.Scan(&answer.Id, &answer.QuestionId, &answer.Answer, &answer.Regex)
+		if err != nil {
+			return nil, err
+		}
+		accepted = append(accepted, answer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return accepted, nil
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Personal access tokens let tools like the CLI act as a user without their
+// browser session. Only a hash of each token is stored, so the token itself
+// is only ever shown once, when it's created.
+const createAccessTokenTable = `
+create table if not exists access_tokens (
+	id integer primary key autoincrement,
+	user_id integer not null,
+	name text not null,
+	scope text not null,
+	hash blob not null unique check (length(hash) = 32),
+	created_at datetime not null,
+	last_used_at datetime, -- null until the token is first used
+	foreign key (user_id) references users(id) on delete cascade
+);
+`
+
+// What a token is allowed to do.
+type TokenScope string
+
+const (
+	// Read the user's courses, e.g. exporting them
+	CourseReadTokenScope TokenScope = "course:read"
+	// Read and change the user's courses, e.g. uploading modules
+	CourseWriteTokenScope TokenScope = "course:write"
+)
+
+func ValidTokenScope(scope string) bool {
+	return scope == string(CourseReadTokenScope) || scope == string(CourseWriteTokenScope)
+}
+
+// Returns whether a token with this scope can do what needs the required scope.
+func (s TokenScope) Allows(required TokenScope) bool {
+	return s == required || (s == CourseWriteTokenScope && required == CourseReadTokenScope)
+}
+
+// Tokens start with this, so they're easy to tell apart from session
+// tokens, and to find if they're accidentally committed somewhere.
+const AccessTokenPrefix = "noob_"
+
+type AccessToken struct {
+	Id         int64
+	UserId     int64
+	Name       string
+	Scope      TokenScope
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+func NewAccessToken(id int64, userId int64, name string, scope TokenScope, createdAt time.Time, lastUsedAt sql.NullTime) AccessToken {
+	return AccessToken{id, userId, name, scope, createdAt, lastUsedAt}
+}
+
+func accessTokenHash(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+const insertAccessTokenQuery = `
+insert into access_tokens(user_id, name, scope, hash, created_at)
+values(?, ?, ?, ?, ?);
+`
+
+// Creates a new token, returning it along with the secret token itself.
+func (c *DbClient) CreateAccessToken(userId int64, name string, scope TokenScope) (AccessToken, string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return AccessToken{}, "", err
+	}
+	token := AccessTokenPrefix + hex.EncodeToString(secret)
+	now := time.Now().UTC()
+	res, err := c.exec(insertAccessTokenQuery, userId, name, scope, accessTokenHash(token), now)
+	if err != nil {
+		return AccessToken{}, "", err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return AccessToken{}, "", err
+	}
+	return NewAccessToken(id, userId, name, scope, now, sql.NullTime{}), token, nil
+}
+
+const getAccessTokensQuery = `
+select t.id, t.user_id, t.name, t.scope, t.created_at, t.last_used_at
+from access_tokens t
+where t.user_id = ?
+order by t.id;
+`
+
+func (c *DbClient) GetAccessTokens(userId int64) ([]AccessToken, error) {
+	rows, err := c.query(getAccessTokensQuery, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []AccessToken{}
+	for rows.Next() {
+		var token AccessToken
+		err := rows.Scan(&token.Id, &token.UserId, &token.Name, &token.Scope, &token.CreatedAt, &token.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+const getAccessTokenByHashQuery = `
+select t.id, t.user_id, t.name, t.scope, t.created_at, t.last_used_at
+from access_tokens t
+where t.hash = ?;
+`
+
+const updateAccessTokenLastUsedQuery = `
+update access_tokens
+set last_used_at = ?
+where id = ?;
+`
+
+// Returns the token for the secret token, recording that it was used,
+// or sql.ErrNoRows if there's no such token, e.g. if it was revoked.
+func (c *DbClient) UseAccessToken(secret string) (AccessToken, error) {
+	if !strings.HasPrefix(secret, AccessTokenPrefix) {
+		return AccessToken{}, sql.ErrNoRows
+	}
+	var token AccessToken
+	row := c.queryRow(getAccessTokenByHashQuery, accessTokenHash(secret))
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &token.Scope, &token.CreatedAt, &token.LastUsedAt)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	now := time.Now().UTC()
+	_, err = c.exec(updateAccessTokenLastUsedQuery, now, token.Id)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	token.LastUsedAt = sql.NullTime{Time: now, Valid: true}
+	return token, nil
+}
+
+const deleteAccessTokenQuery = `
+delete from access_tokens
+where id = ? and user_id = ?;
+`
+
+// Revokes a user's token, returning sql.ErrNoRows if they have no such token.
+func (c *DbClient) DeleteAccessToken(userId int64, tokenId int64) error {
+	res, err := c.exec(deleteAccessTokenQuery, tokenId, userId)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -39,10 +39,42 @@ where not exists (select 1 from answers where user_id = ? and question_id = ?);
 `
 
 func (c *DbClient) StoreAnswer(userId int64, questionId int, choiceId int) error {
-	_, err := c.db.Exec(storeAnswerQuery, choiceId, userId, questionId, userId, questionId, choiceId, userId, questionId)
+	_, err := c.exec(storeAnswerQuery, choiceId, userId, questionId, userId, questionId, choiceId, userId, questionId)
 	return err
 }
 
+const deleteAnswersQuery = `
+delete from answers
+where user_id = ? and question_id = ?;
+`
+
+const insertAnswerQuery = `
+insert into answers(user_id, question_id, choice_id)
+values(?, ?, ?);
+`
+
+// Replaces the user's answer to a question with a list of chosen choices,
+// i.e. one answer row per choice, for questions where you can choose more than one
+// or where the order of the choices matters.
+func (c *DbClient) StoreAnswers(userId int64, questionId int, choiceIds []int) error {
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	defer tx.Rollback()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(deleteAnswersQuery, userId, questionId)
+	if err != nil {
+		return err
+	}
+	for _, choiceId := range choiceIds {
+		_, err = tx.Exec(insertAnswerQuery, userId, questionId, choiceId)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 const getAnswerQuery = `
 select a.choice_id
 from answers a
@@ -65,7 +97,7 @@ func GetAnswer(tx *sql.Tx, userId int64, questionId int) (int, error) {
 }
 
 func (c *DbClient) GetAnswer(userId int64, questionId int) (int, error) {
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(c.ctx, nil)
 	defer tx.Rollback()
 	if err != nil {
 		return 0, err
@@ -80,3 +112,33 @@ func (c *DbClient) GetAnswer(userId int64, questionId int) (int, error) {
 	}
 	return choiceId, nil
 }
+
+const getAnswersQuery = `
+select a.choice_id
+from answers a
+where a.user_id = ? and a.question_id = ?
+order by a.id;
+`
+
+// Returns the choice ids of every chosen choice for the question in the order
+// they were stored, which is empty if there is no answer for the question.
+func (c *DbClient) GetAnswers(userId int64, questionId int) ([]int, error) {
+	rows, err := c.query(getAnswersQuery, userId, questionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	choiceIds := []int{}
+	for rows.Next() {
+		var choiceId int
+		err := rows.Scan(&choiceId)
+		if err != nil {
+			return nil, err
+		}
+		choiceIds = append(choiceIds, choiceId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return choiceIds, nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"regexp"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Files like images that modules reference by hash, deduped the same way as content.
+const createAssetTable = `
+create table if not exists assets (
+	id integer primary key autoincrement,
+	hash blob not null unique check (length(hash) = 16),
+	content_type text not null,
+	data blob not null
+);
+`
+
+type Asset struct {
+	Id          int64
+	Hash        string // Hex encoded
+	ContentType string
+	Data        []byte
+}
+
+func NewAsset(id int64, hash string, contentType string, data []byte) Asset {
+	return Asset{id, hash, contentType, data}
+}
+
+// Content references an asset by "asset:" and its hex encoded hash, the
+// same references protocol.AssetReferences finds in a module's markdown.
+var assetReferenceRegex = regexp.MustCompile(`asset:([0-9a-f]{32})`)
+
+const insertAssetQuery = `
+insert into assets(hash, content_type, data)
+values(?, ?, ?);
+`
+
+func InsertAsset(tx *sql.Tx, contentType string, data []byte) (Asset, error) {
+	hash := contentHash(string(data))
+	// If the asset already exists, no need to store a duplicate.
+	row := tx.QueryRow("select id, content_type from assets where hash = ?", hash)
+	var id int64
+	var existingContentType string
+	if row.Scan(&id, &existingContentType) == nil {
+		return NewAsset(id, hex.EncodeToString(hash), existingContentType, data), nil
+	}
+	res, err := tx.Exec(insertAssetQuery, hash, contentType, data)
+	if err != nil {
+		return Asset{}, err
+	}
+	id, err = res.LastInsertId()
+	if err != nil {
+		return Asset{}, err
+	}
+	return NewAsset(id, hex.EncodeToString(hash), contentType, data), nil
+}
+
+const getAssetQuery = `
+select a.id, a.content_type, a.data
+from assets a
+where a.hash = ?;
+`
+
+// Returns sql.ErrNoRows if there's no asset with this hex hash.
+func (c *DbClient) GetAsset(hexHash string) (Asset, error) {
+	hash, err := hex.DecodeString(hexHash)
+	if err != nil {
+		return Asset{}, sql.ErrNoRows
+	}
+	row := c.queryRow(getAssetQuery, hash)
+	var id int64
+	var contentType string
+	var data []byte
+	err = row.Scan(&id, &contentType, &data)
+	if err != nil {
+		return Asset{}, err
+	}
+	return NewAsset(id, hexHash, contentType, data), nil
+}
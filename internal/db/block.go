@@ -58,7 +58,7 @@ order by b.block_index;
 `
 
 func (c *DbClient) GetBlocks(moduleVersionId int64) ([]Block, error) {
-	blockRows, err := c.db.Query(getBlocksQuery, moduleVersionId)
+	blockRows, err := c.query(getBlocksQuery, moduleVersionId)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +86,7 @@ and b.module_version_id = ?;
 `
 
 func (c *DbClient) GetBlock(moduleVersionId int64, blockIdx int) (Block, error) {
-	blockRow := c.db.QueryRow(getBlockQuery, blockIdx, moduleVersionId)
+	blockRow := c.queryRow(getBlockQuery, blockIdx, moduleVersionId)
 	block := Block{}
 	err := blockRow.Scan(&block.Id, &block.ModuleVersionId, &block.BlockIndex, &block.BlockType)
 	if err != nil {
@@ -102,7 +102,7 @@ where b.module_version_id = ?;
 `
 
 func (c *DbClient) GetBlockCount(moduleVersionId int64) (int, error) {
-	row := c.db.QueryRow(getBlockCountQuery, moduleVersionId)
+	row := c.queryRow(getBlockCountQuery, moduleVersionId)
 	var blockCount int
 	err := row.Scan(&blockCount)
 	if err != nil {
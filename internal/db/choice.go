@@ -12,6 +12,7 @@ create table if not exists choices (
 	question_id integer not null,
 	content_id integer not null,
 	correct bool not null,
+	position integer not null default 0,
 	foreign key (question_id) references questions(id) on delete cascade,
 	foreign key (content_id) references content(id) on delete cascade
 );
@@ -29,16 +30,19 @@ func NewChoice(id int, questionId int, contentId int, correct bool) Choice {
 }
 
 const insertChoiceQuery = `
-insert into choices(question_id, content_id, correct)
-values(?, ?, ?);
+insert into choices(question_id, content_id, correct, position)
+values(?, ?, ?, ?);
 `
 
-func InsertChoice(tx *sql.Tx, questionId int64, choiceText string, correct bool) (Choice, error) {
+// Choices are listed by position rather than id, so that ordering
+// question items can be inserted in any order without their ids giving
+// away the correct one.
+func InsertChoice(tx *sql.Tx, questionId int64, choiceText string, correct bool, position int) (Choice, error) {
 	choiceContentId, err := InsertContent(tx, choiceText)
 	if err != nil {
 		return Choice{}, err
 	}
-	res, err := tx.Exec(insertChoiceQuery, questionId, choiceContentId, correct)
+	res, err := tx.Exec(insertChoiceQuery, questionId, choiceContentId, correct, position)
 	if err != nil {
 		return Choice{}, err
 	}
@@ -56,7 +60,7 @@ where ch.id = ?;
 `
 
 func (c *DbClient) GetChoice(choiceId int) (Choice, error) {
-	row := c.db.QueryRow(getChoiceQuery, choiceId)
+	row := c.queryRow(getChoiceQuery, choiceId)
 	id := 0
 	questionId := 0
 	contentId := 0
@@ -91,11 +95,11 @@ const getChoicesForQuestionQuery = `
 select ch.id, ch.question_id, ch.content_id, ch.correct
 from choices ch
 where ch.question_id = ?
-order by ch.id;
+order by ch.position, ch.id;
 `
 
 func (c *DbClient) GetChoicesForQuestion(questionId int) ([]Choice, error) {
-	choiceRows, err := c.db.Query(getChoicesForQuestionQuery, questionId)
+	choiceRows, err := c.query(getChoicesForQuestionQuery, questionId)
 	if err != nil {
 		return nil, err
 	}
@@ -40,9 +40,13 @@ insert into content(hash, content)
 values(?, ?);
 `
 
-func InsertContent(tx *sql.Tx, content string) (int64, error) {
+func contentHash(content string) []byte {
 	hash32 := blake2b.Sum256([]byte(content))
-	hash := hash32[:16]
+	return hash32[:16]
+}
+
+func InsertContent(tx *sql.Tx, content string) (int64, error) {
+	hash := contentHash(content)
 	// If content already exists, no need to store duplicate.
 	row := tx.QueryRow("select id from content where hash = ?", hash)
 	var id int64
@@ -91,7 +95,7 @@ where id = ?;
 `
 
 func (c *DbClient) GetContent(contentId int) (Content, error) {
-	row := c.db.QueryRow(getContentQuery, contentId)
+	row := c.queryRow(getContentQuery, contentId)
 	id := 0
 	content := ""
 	err := row.Scan(&id, &content)
@@ -109,7 +113,7 @@ where cb.block_id = ?;
 `
 
 func (c *DbClient) GetContentFromBlock(blockId int) (Content, error) {
-	contentRow := c.db.QueryRow(getContentForBlockQuery, blockId)
+	contentRow := c.queryRow(getContentForBlockQuery, blockId)
 	content := Content{}
 	err := contentRow.Scan(&content.Id, &content.Content)
 	if err != nil {
@@ -126,7 +130,7 @@ from content;
 `
 
 func (c *DbClient) GetAllContent() ([]Content, error) {
-	rows, err := c.db.Query(getAllContentQuery)
+	rows, err := c.query(getAllContentQuery)
 	if err != nil {
 		return nil, err
 	}
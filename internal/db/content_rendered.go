@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Content rendered to HTML, by the hash of the content. Content never
+// changes once stored, so it only needs rendering again when the renderer
+// does, which bumps its version.
+const createContentRenderedTable = `
+create table if not exists content_rendered (
+	id integer primary key autoincrement,
+	hash blob not null check (length(hash) = 16),
+	renderer_version integer not null,
+	html text not null,
+	foreign key (hash) references content(hash) on delete cascade,
+	constraint content_rendered_ unique(hash) on conflict replace
+);
+`
+
+// The hash content is deduplicated, and its rendered HTML cached, by.
+func ContentHash(content string) []byte {
+	return contentHash(content)
+}
+
+const insertRenderedContentQuery = `
+insert into content_rendered(hash, renderer_version, html)
+values(?, ?, ?);
+`
+
+// Replaces whatever was rendered for the content before. The content
+// must be stored already.
+func (c *DbClient) StoreRenderedContent(hash []byte, rendererVersion int, html string) error {
+	_, err := c.exec(insertRenderedContentQuery, hash, rendererVersion, html)
+	return err
+}
+
+const getRenderedContentQuery = `
+select r.html
+from content_rendered r
+where r.hash = ? and r.renderer_version = ?;
+`
+
+// Returns the HTML the renderer version rendered the content to, and
+// whether it has been rendered by that version.
+func (c *DbClient) GetRenderedContent(hash []byte, rendererVersion int) (string, bool, error) {
+	row := c.queryRow(getRenderedContentQuery, hash, rendererVersion)
+	var html string
+	err := row.Scan(&html)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return html, true, nil
+}
+
+const deleteStaleRenderedContentQuery = `
+delete from content_rendered
+where renderer_version != ?;
+`
+
+// Deletes HTML rendered by any other renderer version, which won't be used again.
+func (c *DbClient) DeleteStaleRenderedContent(rendererVersion int) error {
+	_, err := c.exec(deleteStaleRenderedContentQuery, rendererVersion)
+	return err
+}
@@ -34,7 +34,24 @@ values(?, ?, ?, ?);
 `
 
 func (c *DbClient) CreateCourse(userId int64, title string, description string, public bool) (Course, error) {
-	res, err := c.db.Exec(insertCourseQuery, userId, title, description, public)
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	defer tx.Rollback()
+	if err != nil {
+		return Course{}, err
+	}
+	course, err := CreateCourse(tx, userId, title, description, public)
+	if err != nil {
+		return Course{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Course{}, err
+	}
+	return course, nil
+}
+
+func CreateCourse(tx *sql.Tx, userId int64, title string, description string, public bool) (Course, error) {
+	res, err := tx.Exec(insertCourseQuery, userId, title, description, public)
 	if err != nil {
 		return Course{}, err
 	}
@@ -83,7 +100,7 @@ func GetCourse(tx *sql.Tx, courseId int) (Course, error) {
 }
 
 func (c *DbClient) GetCourse(courseId int) (Course, error) {
-	row := c.db.QueryRow(getCourseQuery, courseId)
+	row := c.queryRow(getCourseQuery, courseId)
 	return rowToCourse(row)
 }
 
@@ -94,7 +111,7 @@ where c.id = ? and c.user_id = ?;
 `
 
 func (c *DbClient) GetTeacherCourse(courseId int, userId int64) (Course, error) {
-	row := c.db.QueryRow(getTeacherCourseQuery, courseId, userId)
+	row := c.queryRow(getTeacherCourseQuery, courseId, userId)
 	return rowToCourse(row)
 }
 
@@ -106,7 +123,7 @@ order by c.id;
 `
 
 func (c *DbClient) GetTeacherCourses(userId int64) ([]Course, error) {
-	courseRows, err := c.db.Query(getTeacherCoursesQuery, userId)
+	courseRows, err := c.query(getTeacherCoursesQuery, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -114,17 +131,16 @@ func (c *DbClient) GetTeacherCourses(userId int64) ([]Course, error) {
 	return rowsToCourses(courseRows)
 }
 
-const getPublicCoursesQuery = `
+const getPublicCoursesPageQuery = `
 select c.id, c.title, c.description, c.public
 from courses c
 where c.public = true
 order by c.id
-limit 32;
+limit ? offset ?;
 `
 
-// TODO: Add pagination
-func (c *DbClient) GetPublicCourses() ([]Course, error) {
-	courseRows, err := c.db.Query(getPublicCoursesQuery)
+func (c *DbClient) GetPublicCoursesPage(limit int, offset int) ([]Course, error) {
+	courseRows, err := c.query(getPublicCoursesPageQuery, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -132,6 +148,22 @@ func (c *DbClient) GetPublicCourses() ([]Course, error) {
 	return rowsToCourses(courseRows)
 }
 
+const countPublicCoursesQuery = `
+select count(*)
+from courses c
+where c.public = true;
+`
+
+func (c *DbClient) CountPublicCourses() (int, error) {
+	row := c.queryRow(countPublicCoursesQuery)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func rowsToCourses(courseRows *sql.Rows) ([]Course, error) {
 	var courses []Course
 	for courseRows.Next() {
@@ -158,7 +190,7 @@ where c.user_id = ? and c.id = ?;
 `
 
 func (c *DbClient) GetEditCourse(userId int64, courseId int) (Course, error) {
-	row := c.db.QueryRow(getEditCourseQuery, userId, courseId)
+	row := c.queryRow(getEditCourseQuery, userId, courseId)
 	return rowToCourse(row)
 }
 
@@ -170,7 +202,7 @@ where c.user_id = ? and m.id = ?;
 `
 
 func (c *DbClient) GetModuleCourse(userId int64, moduleId int) (Course, error) {
-	row := c.db.QueryRow(getModuleCourseQuery, userId, moduleId)
+	row := c.queryRow(getModuleCourseQuery, userId, moduleId)
 	return rowToCourse(row)
 }
 
@@ -180,7 +212,7 @@ where user_id = ? and id = ?;
 `
 
 func (c *DbClient) DeleteCourse(userId int64, courseId int) error {
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(c.ctx, nil)
 	defer tx.Rollback()
 	modules, err := c.GetModules(courseId)
 	for _, module := range modules {
@@ -193,6 +225,11 @@ func (c *DbClient) DeleteCourse(userId int64, courseId int) error {
 	if err != nil {
 		return err
 	}
+	// The course's assets went with it, so any no other course has can go too
+	err = deleteUnusedCourseAssets(tx, courseId, nil)
+	if err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -205,7 +242,7 @@ order by c.id;
 `
 
 func (c *DbClient) GetEnrolledCourses(userId int64) ([]Course, error) {
-	courseRows, err := c.db.Query(getEnrolledCoursesQuery, userId)
+	courseRows, err := c.query(getEnrolledCoursesQuery, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Assets are shared between courses, this is which courses uploaded which.
+const createCourseAssetTable = `
+create table if not exists course_assets (
+	id integer primary key autoincrement,
+	course_id integer not null,
+	asset_id integer not null,
+	foreign key (course_id) references courses(id) on delete cascade,
+	foreign key (asset_id) references assets(id) on delete cascade,
+	constraint course_asset_ unique(course_id, asset_id) on conflict ignore
+);
+`
+
+func InsertCourseAsset(tx *sql.Tx, courseId int, assetId int64) error {
+	_, err := tx.Exec("insert into course_assets(course_id, asset_id) values(?, ?);", courseId, assetId)
+	return err
+}
+
+const getCourseAssetsQuery = `
+select a.id, a.hash, a.content_type, a.data
+from assets a
+join course_assets ca on a.id = ca.asset_id
+where ca.course_id = ?
+order by ca.id;
+`
+
+func GetCourseAssets(tx *sql.Tx, courseId int) ([]Asset, error) {
+	rows, err := tx.Query(getCourseAssetsQuery, courseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToAssets(rows)
+}
+
+func (c *DbClient) GetCourseAssets(courseId int) ([]Asset, error) {
+	rows, err := c.query(getCourseAssetsQuery, courseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToAssets(rows)
+}
+
+func rowsToAssets(rows *sql.Rows) ([]Asset, error) {
+	assets := []Asset{}
+	for rows.Next() {
+		var id int64
+		var hash []byte
+		var contentType string
+		var data []byte
+		err := rows.Scan(&id, &hash, &contentType, &data)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, NewAsset(id, hex.EncodeToString(hash), contentType, data))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+const getCourseAssetHashesQuery = `
+select a.id, a.hash
+from assets a
+join course_assets ca on a.id = ca.asset_id
+where ca.course_id = ?;
+`
+
+// Returns the hex encoded hashes of the course's assets.
+func GetCourseAssetHashes(tx *sql.Tx, courseId int) (map[string]bool, error) {
+	rows, err := tx.Query(getCourseAssetHashesQuery, courseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := map[string]bool{}
+	for rows.Next() {
+		var id int64
+		var hash []byte
+		err := rows.Scan(&id, &hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes[hex.EncodeToString(hash)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// The content of the blocks that blockIdsQuery selects, for finding which
+// assets it references.
+const getBlockContentQuery = `
+with block_ids as (%s),
+question_ids as (
+	select question_id from knowledge_point_blocks where block_id in block_ids
+)
+select c.content
+from content c
+where c.id in (select content_id from content_blocks where block_id in block_ids)
+	or c.id in (select content_id from questions where id in question_ids)
+	or c.id in (select content_id from choices where question_id in question_ids)
+	or c.id in (select content_id from explanations where question_id in question_ids);
+`
+
+const moduleVersionBlockIdsQuery = `
+select b.id from blocks b
+join module_versions mv on b.module_version_id = mv.id
+where mv.module_id = ? and mv.version_number = ?
+`
+
+const moduleBlockIdsQuery = `
+select b.id from blocks b
+join module_versions mv on b.module_version_id = mv.id
+where mv.module_id = ?
+`
+
+const courseBlockIdsQuery = `
+select b.id from blocks b
+join module_versions mv on b.module_version_id = mv.id
+join modules m on mv.module_id = m.id
+where m.course_id = ?
+`
+
+// Returns the hashes of the assets referenced by the content of the blocks blockIdsQuery selects.
+func getBlockAssetReferences(tx *sql.Tx, blockIdsQuery string, args ...any) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf(getBlockContentQuery, blockIdsQuery), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := map[string]bool{}
+	for rows.Next() {
+		var content string
+		err := rows.Scan(&content)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range assetReferenceRegex.FindAllStringSubmatch(content, -1) {
+			hashes[match[1]] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+const deleteCourseAssetQuery = `
+delete from course_assets
+where course_id = ? and asset_id = ?;
+`
+
+// Assets no course has can be deleted, since modules are only accepted
+// when every asset they reference is one of their course's.
+const deleteOrphanedAssetsQuery = `
+delete from assets
+where id not in (select asset_id from course_assets);
+`
+
+// Once blocks are deleted, the course stops having the assets they referenced
+// that nothing left in the course does, and assets no course has are deleted.
+// Assets nothing has referenced yet, like ones just uploaded, are kept.
+func deleteUnusedCourseAssets(tx *sql.Tx, courseId int, removedHashes map[string]bool) error {
+	if len(removedHashes) > 0 {
+		remainingHashes, err := getBlockAssetReferences(tx, courseBlockIdsQuery, courseId)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(getCourseAssetHashesQuery, courseId)
+		if err != nil {
+			return err
+		}
+		unused := []int64{}
+		for rows.Next() {
+			var id int64
+			var hash []byte
+			err := rows.Scan(&id, &hash)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			hexHash := hex.EncodeToString(hash)
+			if removedHashes[hexHash] && !remainingHashes[hexHash] {
+				unused = append(unused, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, assetId := range unused {
+			_, err = tx.Exec(deleteCourseAssetQuery, courseId, assetId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err := tx.Exec(deleteOrphanedAssetsQuery)
+	return err
+}
@@ -31,7 +31,7 @@ values(?, ?, ?, ?, ?, ?, ?);
 `
 
 func (c *DbClient) InsertCredential(credential Credential) error {
-	_, err := c.db.Exec(insertCredentialQuery, credential.Id, credential.UserId, credential.PublicKey, credential.AttestationType, credential.Transport, credential.Flags, credential.Authenticator)
+	_, err := c.exec(insertCredentialQuery, credential.Id, credential.UserId, credential.PublicKey, credential.AttestationType, credential.Transport, credential.Flags, credential.Authenticator)
 	return err
 }
 
@@ -42,7 +42,7 @@ where id = ?;
 `
 
 func (c *DbClient) UpdateCredential(credential Credential) error {
-	_, err := c.db.Exec(updateCredentialQuery, credential.PublicKey, credential.AttestationType, credential.Transport, credential.Flags, credential.Authenticator, credential.Id)
+	_, err := c.exec(updateCredentialQuery, credential.PublicKey, credential.AttestationType, credential.Transport, credential.Flags, credential.Authenticator, credential.Id)
 	return err
 }
 
@@ -52,7 +52,7 @@ select id, user_id, public_key, attestation_type, transport, flags, authenticato
 
 func (c *DbClient) GetCredentialByUserId(userId int64) (Credential, error) {
 	var credential Credential
-	res := c.db.QueryRow(getCredentialsByUserIdQuery, userId)
+	res := c.queryRow(getCredentialsByUserIdQuery, userId)
 	err := res.Scan(&credential.Id, &credential.UserId, &credential.PublicKey, &credential.AttestationType, &credential.Transport, &credential.Flags, &credential.Authenticator)
 	if err != nil {
 		return Credential{}, err
@@ -81,11 +81,11 @@ values(?, ?);
 `
 
 func (c *DbClient) InsertSession(userId int64, sessionData []byte) error {
-	_, err := c.db.Exec(deleteSessionQuery, userId)
+	_, err := c.exec(deleteSessionQuery, userId)
 	if err != nil {
 		return err
 	}
-	_, err = c.db.Exec(insertSessionQuery, userId, sessionData)
+	_, err = c.exec(insertSessionQuery, userId, sessionData)
 	if err != nil {
 		return err
 	}
@@ -98,7 +98,7 @@ select session_data from sessions where user_id = ?;
 
 func (c *DbClient) GetSession(userId int64) ([]byte, error) {
 	var sessionData []byte
-	res := c.db.QueryRow(getSessionQuery, userId)
+	res := c.queryRow(getSessionQuery, userId)
 	err := res.Scan(&sessionData)
 	if err != nil {
 		return nil, err
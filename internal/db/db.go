@@ -1,10 +1,14 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"log/slog"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 // Conventions:
@@ -19,27 +23,43 @@ import (
 // To keep things simple, we don't need to always optimally do a single
 // query to get everything we need. Multiple queries that reuse existing
 // methods are fine.
+// Pages that show a whole module or course shouldn't make queries per
+// block or module though, so they use loaders like GetModuleContents
+// and GetModuleProgress.
 
 type DbClient struct {
 	db *sql.DB
+	// Queries use this, so they're canceled along with the request that made them
+	ctx context.Context
+	// Optional, told how long each statement took. Shared with the
+	// connections, which time them.
+	observer *atomic.Pointer[QueryObserver]
 }
 
+// Called with how long a statement took, and the function that ran it, e.g.
+// "GetCourse" for a DbClient method or "InsertModuleVersion" in a transaction.
+type QueryObserver func(method string, duration time.Duration)
+
+const DefaultDbPath = "test.db"
+
 func NewDbClient() *DbClient {
-	db, err := sql.Open("sqlite3", "test.db?_foreign_keys=on")
-	if err != nil {
-		log.Fatal(err)
-	}
-	initDb(db)
-	return &DbClient{db}
+	return NewFileDbClient(DefaultDbPath)
+}
+
+// Opens the database at path, creating and migrating it if needed.
+func NewFileDbClient(path string) *DbClient {
+	return openDbClient(path + "?_foreign_keys=on")
 }
 
 func NewMemoryDbClient() *DbClient {
-	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=on")
-	if err != nil {
-		log.Fatal(err)
-	}
+	return openDbClient(":memory:?_foreign_keys=on")
+}
+
+func openDbClient(dsn string) *DbClient {
+	observer := &atomic.Pointer[QueryObserver]{}
+	db := sql.OpenDB(observedConnector{dsn, &sqlite3.SQLiteDriver{}, observer})
 	initDb(db)
-	return &DbClient{db}
+	return &DbClient{db, context.Background(), observer}
 }
 
 func initDb(db *sql.DB) {
@@ -69,6 +89,16 @@ func initDb(db *sql.DB) {
 		createDbVersionTable,
 		createKnowledgePointTable,
 		createKnowledgePointBlockTable,
+		createNumericSolutionTable,
+		createNumericAnswerTable,
+		createTextSolutionTable,
+		createAcceptedAnswerTable,
+		createTextAnswerTable,
+		createModuleMetadataTable,
+		createAssetTable,
+		createCourseAssetTable,
+		createAccessTokenTable,
+		createContentRenderedTable,
 	}
 	for _, stmt := range stmts {
 		_, err := tx.Exec(stmt)
@@ -86,7 +116,7 @@ func initDb(db *sql.DB) {
 	} else if err != nil {
 		log.Fatal(err)
 	} else if version < latestVersion {
-		log.Println("New DB version available. Current:", version, "Latest:", latestVersion)
+		slog.Info("New DB version available", "current", version, "latest", latestVersion)
 		for version < latestVersion {
 			err = migrateToVersionFunc(version + 1)(tx)
 			if err != nil {
@@ -100,15 +130,38 @@ func initDb(db *sql.DB) {
 			if err != nil {
 				log.Fatal(err)
 			}
-			log.Println("Migrated to version:", version)
+			slog.Info("Migrated DB", "version", version)
 		}
 	}
-	log.Println("Db version:", version)
+	slog.Info("DB ready", "version", version)
 	tx.Commit()
 }
 
+// Returns a client sharing this one's database, whose queries and
+// transactions use ctx.
+func (c *DbClient) WithContext(ctx context.Context) *DbClient {
+	return &DbClient{c.db, ctx, c.observer}
+}
+
+// Sets the observer of every client sharing this one's database.
+func (c *DbClient) SetQueryObserver(observer QueryObserver) {
+	c.observer.Store(&observer)
+}
+
+func (c *DbClient) queryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *DbClient) query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+func (c *DbClient) exec(query string, args ...any) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
 func (c *DbClient) Begin() (*sql.Tx, error) {
-	return c.db.Begin()
+	return c.db.BeginTx(c.ctx, nil)
 }
 
 func (c *DbClient) Close() {
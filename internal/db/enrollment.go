@@ -52,7 +52,7 @@ where e.user_id = ? and e.course_id = ?;
 `
 
 func (c *DbClient) GetEnrollment(userId int64, courseId int) (Enrollment, error) {
-	row := c.db.QueryRow(getEnrollmentQuery, userId, courseId)
+	row := c.queryRow(getEnrollmentQuery, userId, courseId)
 	var enrollment Enrollment
 	err := row.Scan(&enrollment.Id, &enrollment.UserId, &enrollment.CourseId)
 	if err != nil {
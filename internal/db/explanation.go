@@ -37,7 +37,7 @@ where e.question_id = ?;
 `
 
 func (c *DbClient) GetExplanationForQuestion(questionId int) (Content, error) {
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(c.ctx, nil)
 	defer tx.Rollback()
 	if err != nil {
 		return Content{}, err
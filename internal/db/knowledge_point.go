@@ -45,25 +45,284 @@ func InsertKnowledgePoint(tx *sql.Tx, courseId int64, name string) (KnowledgePoi
 	return NewKnowledgePoint(id, courseId, name), nil
 }
 
+const getKnowledgePointByNameQuery = `
+select k.id, k.course_id, k.name
+from knowledge_points k
+where k.course_id = ? and k.name = ?
+order by k.id
+limit 1;
+`
+
+// Returns sql.ErrNoRows if the course has no knowledge point with this name.
+func GetKnowledgePointByName(tx *sql.Tx, courseId int64, name string) (KnowledgePoint, error) {
+	row := tx.QueryRow(getKnowledgePointByNameQuery, courseId, name)
+	var id int64
+	err := row.Scan(&id, &courseId, &name)
+	if err != nil {
+		return KnowledgePoint{}, err
+	}
+	return NewKnowledgePoint(id, courseId, name), nil
+}
+
+// Returns the course's knowledge point with this name, creating it if it doesn't exist yet.
+func GetOrInsertKnowledgePoint(tx *sql.Tx, courseId int64, name string) (KnowledgePoint, error) {
+	knowledgePoint, err := GetKnowledgePointByName(tx, courseId, name)
+	if err == sql.ErrNoRows {
+		return InsertKnowledgePoint(tx, courseId, name)
+	}
+	return knowledgePoint, err
+}
+
+const getKnowledgePointQuery = `
+select k.id, k.course_id, k.name
+from knowledge_points k
+where k.course_id = ? and k.id = ?;
+`
+
+// Returns sql.ErrNoRows if the course has no knowledge point with this id.
+func GetKnowledgePoint(tx *sql.Tx, courseId int64, knowledgePointId int64) (KnowledgePoint, error) {
+	row := tx.QueryRow(getKnowledgePointQuery, courseId, knowledgePointId)
+	var id int64
+	var name string
+	err := row.Scan(&id, &courseId, &name)
+	if err != nil {
+		return KnowledgePoint{}, err
+	}
+	return NewKnowledgePoint(id, courseId, name), nil
+}
+
+// Content is deduplicated by hash, so questions with the same text share a content id.
+const getKnowledgePointForQuestionQuery = `
+select k.id, k.course_id, k.name
+from knowledge_points k
+join questions q on k.id = q.knowledge_point_id
+join content c on q.content_id = c.id
+where k.course_id = ? and c.hash = ?
+order by q.id desc
+limit 1;
+`
+
+// Returns the knowledge point most recently tested by a question in the course
+// with exactly this text, or sql.ErrNoRows if there is none.
+func GetKnowledgePointForQuestion(tx *sql.Tx, courseId int64, question string) (KnowledgePoint, error) {
+	row := tx.QueryRow(getKnowledgePointForQuestionQuery, courseId, contentHash(question))
+	var id int64
+	var name string
+	err := row.Scan(&id, &courseId, &name)
+	if err != nil {
+		return KnowledgePoint{}, err
+	}
+	return NewKnowledgePoint(id, courseId, name), nil
+}
+
+func UpdateKnowledgePointName(tx *sql.Tx, knowledgePointId int64, name string) error {
+	_, err := tx.Exec("update knowledge_points set name = ? where id = ?;", name, knowledgePointId)
+	return err
+}
+
+// Earlier versions of the module are being replaced, so only other modules'
+// blocks and the new version's own blocks count.
+const isKnowledgePointUsedElsewhereQuery = `
+select exists(
+	select 1
+	from knowledge_point_blocks kb
+	join blocks b on kb.block_id = b.id
+	join module_versions mv on b.module_version_id = mv.id
+	where kb.knowledge_point_id = ? and (mv.module_id != ? or mv.id = ?)
+);
+`
+
+// Returns whether a block outside the module, or already in the module version
+// being inserted, asks a question testing the knowledge point.
+func IsKnowledgePointUsedElsewhere(tx *sql.Tx, knowledgePointId int64, moduleId int, moduleVersionId int64) (bool, error) {
+	var used bool
+	err := tx.QueryRow(isKnowledgePointUsedElsewhereQuery, knowledgePointId, moduleId, moduleVersionId).Scan(&used)
+	return used, err
+}
+
+const getKnowledgePointIdsForModuleVersionQuery = `
+select distinct kb.knowledge_point_id
+from knowledge_point_blocks kb
+join blocks b on kb.block_id = b.id
+join module_versions mv on b.module_version_id = mv.id
+where mv.module_id = ? and mv.version_number = ?;
+`
+
+func getKnowledgePointIdsForModuleVersion(tx *sql.Tx, moduleId int, versionNumber int64) ([]int64, error) {
+	rows, err := tx.Query(getKnowledgePointIdsForModuleVersionQuery, moduleId, versionNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToIds(rows)
+}
+
+const getKnowledgePointIdsForModuleQuery = `
+select distinct kb.knowledge_point_id
+from knowledge_point_blocks kb
+join blocks b on kb.block_id = b.id
+join module_versions mv on b.module_version_id = mv.id
+where mv.module_id = ?;
+`
+
+func getKnowledgePointIdsForModule(tx *sql.Tx, moduleId int) ([]int64, error) {
+	rows, err := tx.Query(getKnowledgePointIdsForModuleQuery, moduleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToIds(rows)
+}
+
+const getQuestionIdsForModuleVersionQuery = `
+select distinct kb.question_id
+from knowledge_point_blocks kb
+join blocks b on kb.block_id = b.id
+join module_versions mv on b.module_version_id = mv.id
+where mv.module_id = ? and mv.version_number = ? and kb.question_id is not null;
+`
+
+func getQuestionIdsForModuleVersion(tx *sql.Tx, moduleId int, versionNumber int64) ([]int64, error) {
+	rows, err := tx.Query(getQuestionIdsForModuleVersionQuery, moduleId, versionNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToIds(rows)
+}
+
+const getQuestionIdsForModuleQuery = `
+select distinct kb.question_id
+from knowledge_point_blocks kb
+join blocks b on kb.block_id = b.id
+join module_versions mv on b.module_version_id = mv.id
+where mv.module_id = ? and kb.question_id is not null;
+`
+
+func getQuestionIdsForModule(tx *sql.Tx, moduleId int) ([]int64, error) {
+	rows, err := tx.Query(getQuestionIdsForModuleQuery, moduleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToIds(rows)
+}
+
+func rowsToIds(rows *sql.Rows) ([]int64, error) {
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Questions belong to a single block, so once their blocks are deleted they can go too.
+const deleteOrphanedQuestionQuery = `
+delete from questions
+where id = ? and id not in (
+	select question_id from knowledge_point_blocks where question_id is not null
+);
+`
+
+const deleteOrphanedKnowledgePointQuery = `
+delete from knowledge_points
+where id = ? and id not in (select knowledge_point_id from questions);
+`
+
+// Deletes any of the given questions no longer asked by any block, and any of the
+// given knowledge points that no longer have questions testing them. These should
+// be what the deleted blocks used, so everything else, like knowledge points
+// created directly, is left alone.
+func deleteOrphanedQuestions(tx *sql.Tx, questionIds []int64, knowledgePointIds []int64) error {
+	for _, questionId := range questionIds {
+		_, err := tx.Exec(deleteOrphanedQuestionQuery, questionId)
+		if err != nil {
+			return err
+		}
+	}
+	for _, knowledgePointId := range knowledgePointIds {
+		_, err := tx.Exec(deleteOrphanedKnowledgePointQuery, knowledgePointId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const getKnowledgePointsQuery = `
+select k.id, k.course_id, k.name
+from knowledge_points k
+where k.course_id = ?
+order by k.id;
+`
+
+func GetKnowledgePoints(tx *sql.Tx, courseId int64) ([]KnowledgePoint, error) {
+	rows, err := tx.Query(getKnowledgePointsQuery, courseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToKnowledgePoints(rows)
+}
+
+func (c *DbClient) GetKnowledgePoints(courseId int64) ([]KnowledgePoint, error) {
+	rows, err := c.query(getKnowledgePointsQuery, courseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToKnowledgePoints(rows)
+}
+
+func rowsToKnowledgePoints(rows *sql.Rows) ([]KnowledgePoint, error) {
+	knowledgePoints := []KnowledgePoint{}
+	for rows.Next() {
+		var id int64
+		var courseId int64
+		var name string
+		err := rows.Scan(&id, &courseId, &name)
+		if err != nil {
+			return nil, err
+		}
+		knowledgePoints = append(knowledgePoints, NewKnowledgePoint(id, courseId, name))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return knowledgePoints, nil
+}
+
 // Knowledge point block
 
+// Since many questions can test the same knowledge point,
+// each block also points to the question it asks.
 const createKnowledgePointBlockTable = `
 create table if not exists knowledge_point_blocks (
 	id integer primary key autoincrement,
 	block_id integer not null unique,
 	knowledge_point_id integer not null,
+	question_id integer,
 	foreign key (block_id) references blocks(id) on delete cascade,
-	foreign key (knowledge_point_id) references knowledge_points(id) on delete cascade
+	foreign key (knowledge_point_id) references knowledge_points(id) on delete cascade,
+	foreign key (question_id) references questions(id) on delete cascade
 );
 `
 
 const insertKnowledgePointBlockQuery = `
-insert into knowledge_point_blocks(block_id, knowledge_point_id)
-values(?, ?);
+insert into knowledge_point_blocks(block_id, knowledge_point_id, question_id)
+values(?, ?, ?);
 `
 
-func InsertKnowledgePointBlock(tx *sql.Tx, blockId int64, knowledgePointId int64) error {
-	_, err := tx.Exec(insertKnowledgePointBlockQuery, blockId, knowledgePointId)
+func InsertKnowledgePointBlock(tx *sql.Tx, blockId int64, knowledgePointId int64, questionId int64) error {
+	_, err := tx.Exec(insertKnowledgePointBlockQuery, blockId, knowledgePointId, questionId)
 	return err
 }
 	
@@ -75,7 +334,7 @@ where kb.block_id = ?;
 `
 
 func (c *DbClient) GetKnowledgePointFromBlock(blockId int) (KnowledgePoint, error) {
-	row := c.db.QueryRow(getKnowledgePointFromBlockQuery, blockId)
+	row := c.queryRow(getKnowledgePointFromBlockQuery, blockId)
 	var id int64
 	var courseId int64
 	var name string
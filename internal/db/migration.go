@@ -37,6 +37,10 @@ func migrations() []DbMigration {
 		addPublicColumnToCoursesTable,
 		markdownQuestionChoiceMigration,
 		knowledgePointQuestionMigration,
+		addQuestionTypeColumnToQuestionsTable,
+		addGradingColumnToQuestionsTable,
+		sharedKnowledgePointMigration,
+		addPositionColumnToChoicesTable,
 	}
 }
 
@@ -461,3 +465,198 @@ func knowledgePointQuestionMigration(tx *sql.Tx) error {
 	}
 	return nil
 }
+
+const addQuestionTypeColumnToQuestionsTableQuery = `
+alter table questions
+add column
+question_type text not null default 'multiple_choice';
+`
+
+func addQuestionTypeColumnToQuestionsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(addQuestionTypeColumnToQuestionsTableQuery)
+	return err
+}
+
+const addGradingColumnToQuestionsTableQuery = `
+alter table questions
+add column
+grading text not null default 'all_or_nothing';
+`
+
+func addGradingColumnToQuestionsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(addGradingColumnToQuestionsTableQuery)
+	return err
+}
+
+// Questions used to each have their own knowledge point, so to let
+// questions share one we drop the unique constraint on questions and
+// point each block to its question directly. Sqlite can't drop a
+// constraint, so like knowledgePointQuestionMigration we recreate
+// questions, and every table referencing it so nothing cascades.
+const renameOldUniqueKnowledgePointQuestionTables = `
+alter table questions rename to questions_old;
+alter table choices rename to choices_old;
+alter table answers rename to answers_old;
+alter table explanations rename to explanations_old;
+alter table numeric_solutions rename to numeric_solutions_old;
+alter table numeric_answers rename to numeric_answers_old;
+alter table text_solutions rename to text_solutions_old;
+alter table accepted_answers rename to accepted_answers_old;
+alter table text_answers rename to text_answers_old;
+`
+
+// Just the actual create table functions at the time
+const createSharedKnowledgePointQuestionTables = `
+create table if not exists questions (
+	id integer primary key autoincrement,
+	knowledge_point_id integer not null,
+	content_id integer not null,
+	question_type text not null default 'multiple_choice',
+	grading text not null default 'all_or_nothing',
+	foreign key (knowledge_point_id) references knowledge_points(id) on delete cascade,
+	foreign key (content_id) references content(id) on delete cascade
+);
+
+create table if not exists choices (
+	id integer primary key autoincrement,
+	question_id integer not null,
+	content_id integer not null,
+	correct bool not null,
+	foreign key (question_id) references questions(id) on delete cascade,
+	foreign key (content_id) references content(id) on delete cascade
+);
+
+create table if not exists answers (
+	id integer primary key autoincrement,
+	user_id integer not null,
+	question_id integer not null,
+	choice_id integer not null,
+	foreign key (user_id) references users(id) on delete cascade,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+
+create table if not exists explanations (
+	id integer primary key autoincrement,
+	question_id integer not null,
+	content_id integer not null,
+	foreign key (question_id) references questions(id) on delete cascade,
+	foreign key (content_id) references content(id) on delete cascade
+);
+
+create table if not exists numeric_solutions (
+	id integer primary key autoincrement,
+	question_id integer not null unique,
+	value real not null,
+	tolerance real not null,
+	relative bool not null,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+
+create table if not exists numeric_answers (
+	id integer primary key autoincrement,
+	user_id integer not null,
+	question_id integer not null,
+	value real not null,
+	foreign key (user_id) references users(id) on delete cascade,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+
+create table if not exists text_solutions (
+	id integer primary key autoincrement,
+	question_id integer not null unique,
+	fold_case bool not null,
+	normalize_whitespace bool not null,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+
+create table if not exists accepted_answers (
+	id integer primary key autoincrement,
+	question_id integer not null,
+	answer text not null,
+	regex bool not null,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+
+create table if not exists text_answers (
+	id integer primary key autoincrement,
+	user_id integer not null,
+	question_id integer not null,
+	answer text not null,
+	foreign key (user_id) references users(id) on delete cascade,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+`
+
+const copyOldUniqueKnowledgePointQuestionTables = `
+insert into questions select id, knowledge_point_id, content_id, question_type, grading from questions_old;
+insert into choices select id, question_id, content_id, correct from choices_old;
+insert into answers select id, user_id, question_id, choice_id from answers_old;
+insert into explanations select id, question_id, content_id from explanations_old;
+insert into numeric_solutions select id, question_id, value, tolerance, relative from numeric_solutions_old;
+insert into numeric_answers select id, user_id, question_id, value from numeric_answers_old;
+insert into text_solutions select id, question_id, fold_case, normalize_whitespace from text_solutions_old;
+insert into accepted_answers select id, question_id, answer, regex from accepted_answers_old;
+insert into text_answers select id, user_id, question_id, answer from text_answers_old;
+`
+
+// Drop the tables referencing questions_old first, so dropping it doesn't cascade anywhere.
+const deleteOldUniqueKnowledgePointQuestionTables = `
+drop table choices_old;
+drop table answers_old;
+drop table explanations_old;
+drop table numeric_solutions_old;
+drop table numeric_answers_old;
+drop table text_solutions_old;
+drop table accepted_answers_old;
+drop table text_answers_old;
+drop table questions_old;
+`
+
+const addQuestionIdColumnToKnowledgePointBlocksTable = `
+alter table knowledge_point_blocks
+add column
+question_id integer references questions(id) on delete cascade;
+
+update knowledge_point_blocks
+set question_id = (
+	select q.id
+	from questions q
+	where q.knowledge_point_id = knowledge_point_blocks.knowledge_point_id
+);
+`
+
+func sharedKnowledgePointMigration(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		renameOldUniqueKnowledgePointQuestionTables,
+		createSharedKnowledgePointQuestionTables,
+		copyOldUniqueKnowledgePointQuestionTables,
+		deleteOldUniqueKnowledgePointQuestionTables,
+		addQuestionIdColumnToKnowledgePointBlocksTable,
+	} {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Choices used to be listed by id, so ordering question items were
+// inserted in their correct order. Their positions are their rank by id.
+const addPositionColumnToChoicesTableQuery = `
+alter table choices
+add column
+position integer not null default 0;
+
+update choices
+set position = (
+	select count(*)
+	from choices ch
+	where ch.question_id = choices.question_id and ch.id < choices.id
+);
+`
+
+func addPositionColumnToChoicesTable(tx *sql.Tx) error {
+	_, err := tx.Exec(addPositionColumnToChoicesTableQuery)
+	return err
+}
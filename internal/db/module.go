@@ -29,7 +29,7 @@ values(?);
 `
 
 func (c *DbClient) CreateModule(courseId int, moduleTitle string, moduleDescription string) (Module, error) {
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(c.ctx, nil)
 	defer tx.Rollback()
 	if err != nil {
 		return Module{}, err
@@ -69,7 +69,7 @@ order by m.id;
 `
 
 func (c *DbClient) GetModules(courseId int) ([]Module, error) {
-	moduleRows, err := c.db.Query(getModulesQuery, courseId)
+	moduleRows, err := c.query(getModulesQuery, courseId)
 	if err != nil {
 		return nil, err
 	}
@@ -99,11 +99,8 @@ with module_block_ids as (
 content_block_content_ids as (
 	select content_id from content_blocks where block_id in module_block_ids
 ),
-knowledge_point_ids as (
-	select knowledge_point_id from knowledge_point_blocks where block_id in module_block_ids
-),
 question_ids as (
-	select id from questions where knowledge_point_id in knowledge_point_ids
+	select question_id from knowledge_point_blocks where block_id in module_block_ids
 ),
 question_content_ids as (
 	select content_id from questions where id in question_ids
@@ -126,11 +123,8 @@ referenced_content_ids as (
 elsewhere_content_block_content_ids as (
 	select content_id from content_blocks where block_id not in module_block_ids
 ),
-elsewhere_knowledge_point_ids as (
-	select knowledge_point_id from knowledge_point_blocks where block_id not in module_block_ids
-),
 elsewhere_question_ids as (
-	select id from questions where knowledge_point_id in elsewhere_knowledge_point_ids
+	select question_id from knowledge_point_blocks where block_id not in module_block_ids
 ),
 elsewhere_question_content_ids as (
 	select content_id from questions where id in elsewhere_question_ids
@@ -190,8 +184,25 @@ func (c *DbClient) GetModule(courseId int, moduleId int) (Module, error) {
 }
 
 func (c *DbClient) DeleteModule(moduleId int) error {
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(c.ctx, nil)
 	defer tx.Rollback()
+	questionIds, err := getQuestionIdsForModule(tx, moduleId)
+	if err != nil {
+		return err
+	}
+	knowledgePointIds, err := getKnowledgePointIdsForModule(tx, moduleId)
+	if err != nil {
+		return err
+	}
+	var courseId int
+	err = tx.QueryRow("select course_id from modules where id = ?;", moduleId).Scan(&courseId)
+	if err != nil {
+		return err
+	}
+	assetHashes, err := getBlockAssetReferences(tx, moduleBlockIdsQuery, moduleId)
+	if err != nil {
+		return err
+	}
 	err = DeleteContentForModule(tx, moduleId)
 	if err != nil {
 		return err
@@ -200,6 +211,14 @@ func (c *DbClient) DeleteModule(moduleId int) error {
 	if err != nil {
 		return err
 	}
+	err = deleteOrphanedQuestions(tx, questionIds, knowledgePointIds)
+	if err != nil {
+		return err
+	}
+	err = deleteUnusedCourseAssets(tx, courseId, assetHashes)
+	if err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -0,0 +1,508 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Everything in a module version, for pages that show, grade or export
+// a whole module. It's loaded a table at a time, so it takes the same
+// number of queries however many blocks the module has.
+type ModuleContents struct {
+	// In order of block index
+	Blocks []BlockContents
+}
+
+type BlockContents struct {
+	Block Block
+	// Only for content blocks
+	Content Content
+	// Only for knowledge point blocks
+	KnowledgePoint KnowledgePoint
+	Question       QuestionContents
+}
+
+type QuestionContents struct {
+	Question Question
+	Content  Content
+	// Id -1 if the question doesn't have one
+	Explanation Content
+	// In order of position, which for ordering questions is the correct order
+	Choices        []Choice
+	ChoiceContents []Content
+	// Only for numeric questions
+	NumericSolution NumericSolution
+	// Only for text questions
+	TextSolution    TextSolution
+	AcceptedAnswers []AcceptedAnswer
+	// The user's answer, when loaded for a user. Which one is set
+	// depends on the question type.
+	Answered        bool
+	ChosenChoiceIds []int
+	NumericAnswer   float64
+	TextAnswer      string
+}
+
+// Returns the block at a block index, if the module has it.
+func (m ModuleContents) Block(blockIdx int) (BlockContents, bool) {
+	for _, block := range m.Blocks {
+		if block.Block.BlockIndex == blockIdx {
+			return block, true
+		}
+	}
+	return BlockContents{}, false
+}
+
+func (c *DbClient) GetModuleContents(moduleVersionId int64) (ModuleContents, error) {
+	modules, err := c.GetModulesContents([]int64{moduleVersionId})
+	if err != nil {
+		return ModuleContents{}, err
+	}
+	return modules[moduleVersionId], nil
+}
+
+// Like GetModuleContents for several module versions at once, e.g. every
+// module in a course, taking the same number of queries however many there are.
+func (c *DbClient) GetModulesContents(moduleVersionIds []int64) (map[int64]ModuleContents, error) {
+	modules := make(map[int64]ModuleContents)
+	if len(moduleVersionIds) == 0 {
+		return modules, nil
+	}
+	blocks, err := c.getModulesBlocks(moduleVersionIds)
+	if err != nil {
+		return nil, err
+	}
+	contents := newModuleContentsBuilder(blocks)
+	for _, load := range []func([]int64, moduleContentsBuilder) error{
+		c.getModuleContentBlocks,
+		c.getModuleQuestions,
+		c.getModuleExplanations,
+		c.getModuleChoices,
+		c.getModuleNumericSolutions,
+		c.getModuleTextSolutions,
+		c.getModuleAcceptedAnswers,
+	} {
+		err = load(moduleVersionIds, contents)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, moduleVersionId := range moduleVersionIds {
+		modules[moduleVersionId] = ModuleContents{[]BlockContents{}}
+	}
+	for _, block := range contents.blocks {
+		if block.Block.BlockType == ContentBlockType && block.Content.Id == 0 {
+			return nil, fmt.Errorf("content block %d has no content", block.Block.Id)
+		}
+		if block.Block.BlockType == KnowledgePointBlockType && block.Question.Question.Id == 0 {
+			return nil, fmt.Errorf("knowledge point block %d has no question", block.Block.Id)
+		}
+		module := modules[block.Block.ModuleVersionId]
+		module.Blocks = append(module.Blocks, block)
+		modules[block.Block.ModuleVersionId] = module
+	}
+	return modules, nil
+}
+
+// Like GetModuleContents, along with the user's answers to its questions.
+func (c *DbClient) GetModuleContentsForUser(moduleVersionId int64, userId int64) (ModuleContents, error) {
+	module, err := c.GetModuleContents(moduleVersionId)
+	if err != nil {
+		return ModuleContents{}, err
+	}
+	contents := moduleContentsBuilder{blocks: module.Blocks, questions: make(map[int]int)}
+	for i, block := range module.Blocks {
+		if block.Block.BlockType == KnowledgePointBlockType {
+			contents.questions[block.Question.Question.Id] = i
+		}
+	}
+	moduleVersionIds := []int64{moduleVersionId}
+	for _, load := range []func([]int64, int64, moduleContentsBuilder) error{
+		c.getModuleAnswers,
+		c.getModuleNumericAnswers,
+		c.getModuleTextAnswers,
+	} {
+		err = load(moduleVersionIds, userId, contents)
+		if err != nil {
+			return ModuleContents{}, err
+		}
+	}
+	return module, nil
+}
+
+type moduleContentsBuilder struct {
+	// In order of module version, then block index
+	blocks []BlockContents
+	// blockId -> index in blocks
+	blockIds map[int]int
+	// questionId -> index in blocks of the block asking it, since questions belong to a single block
+	questions map[int]int
+}
+
+func newModuleContentsBuilder(blocks []Block) moduleContentsBuilder {
+	contents := moduleContentsBuilder{make([]BlockContents, len(blocks)), make(map[int]int), make(map[int]int)}
+	for i, block := range blocks {
+		contents.blocks[i] = BlockContents{Block: block}
+		contents.blockIds[block.Id] = i
+	}
+	return contents
+}
+
+func (b moduleContentsBuilder) block(blockId int) *BlockContents {
+	i, ok := b.blockIds[blockId]
+	if !ok {
+		return nil
+	}
+	return &b.blocks[i]
+}
+
+func (b moduleContentsBuilder) question(questionId int) *QuestionContents {
+	i, ok := b.questions[questionId]
+	if !ok {
+		return nil
+	}
+	return &b.blocks[i].Question
+}
+
+// Scans every row, closing them once done.
+func eachRow(rows *sql.Rows, scan func() error) error {
+	defer rows.Close()
+	for rows.Next() {
+		err := scan()
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Fills a query's "in (%s)" with a placeholder for each of n values.
+func inQuery(query string, n int) string {
+	return fmt.Sprintf(query, strings.TrimSuffix(strings.Repeat("?, ", n), ", "))
+}
+
+func inArgs[T any](values []T) []any {
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+const getModulesBlocksQuery = `
+select b.id, b.module_version_id, b.block_index, b.block_type
+from blocks b
+where b.module_version_id in (%s)
+order by b.module_version_id, b.block_index;
+`
+
+func (c *DbClient) getModulesBlocks(moduleVersionIds []int64) ([]Block, error) {
+	rows, err := c.query(inQuery(getModulesBlocksQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return nil, err
+	}
+	blocks := []Block{}
+	err = eachRow(rows, func() error {
+		var block Block
+		err := rows.Scan(&block.Id, &block.ModuleVersionId, &block.BlockIndex, &block.BlockType)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// Ids of the questions the module versions' blocks ask.
+const moduleQuestionIdsQuery = `
+select kb.question_id
+from knowledge_point_blocks kb
+join blocks b on kb.block_id = b.id
+where b.module_version_id in (%s)
+`
+
+const getModuleContentBlocksQuery = `
+select cb.block_id, c.id, c.content
+from content_blocks cb
+join content c on cb.content_id = c.id
+join blocks b on cb.block_id = b.id
+where b.module_version_id in (%s);
+`
+
+func (c *DbClient) getModuleContentBlocks(moduleVersionIds []int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleContentBlocksQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		var blockId int
+		content := Content{}
+		err := rows.Scan(&blockId, &content.Id, &content.Content)
+		if err != nil {
+			return err
+		}
+		if block := contents.block(blockId); block != nil {
+			block.Content = content
+		}
+		return nil
+	})
+}
+
+const getModuleQuestionsQuery = `
+select kb.block_id, k.id, k.course_id, k.name, q.id, q.knowledge_point_id, q.content_id, q.question_type, q.grading, c.id, c.content
+from knowledge_point_blocks kb
+join knowledge_points k on kb.knowledge_point_id = k.id
+join questions q on kb.question_id = q.id
+join content c on q.content_id = c.id
+join blocks b on kb.block_id = b.id
+where b.module_version_id in (%s);
+`
+
+func (c *DbClient) getModuleQuestions(moduleVersionIds []int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleQuestionsQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		var blockId int
+		knowledgePoint := KnowledgePoint{}
+		question := Question{}
+		content := Content{}
+		err := rows.Scan(&blockId, &knowledgePoint.Id, &knowledgePoint.CourseId, &knowledgePoint.Name,
+			&question.Id, &question.KnowledgePoint, &question.ContentId, &question.QuestionType, &question.Grading,
+			&content.Id, &content.Content)
+		if err != nil {
+			return err
+		}
+		i, ok := contents.blockIds[blockId]
+		if !ok {
+			return nil
+		}
+		block := &contents.blocks[i]
+		block.KnowledgePoint = knowledgePoint
+		block.Question = QuestionContents{
+			Question:        question,
+			Content:         content,
+			Explanation:     Content{-1, ""},
+			Choices:         []Choice{},
+			ChoiceContents:  []Content{},
+			AcceptedAnswers: []AcceptedAnswer{},
+			ChosenChoiceIds: []int{},
+		}
+		contents.questions[question.Id] = i
+		return nil
+	})
+}
+
+const getModuleExplanationsQuery = `
+select e.question_id, c.id, c.content
+from explanations e
+join content c on e.content_id = c.id
+where e.question_id in (` + moduleQuestionIdsQuery + `);
+`
+
+func (c *DbClient) getModuleExplanations(moduleVersionIds []int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleExplanationsQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		var questionId int
+		content := Content{}
+		err := rows.Scan(&questionId, &content.Id, &content.Content)
+		if err != nil {
+			return err
+		}
+		if question := contents.question(questionId); question != nil {
+			question.Explanation = content
+		}
+		return nil
+	})
+}
+
+const getModuleChoicesQuery = `
+select ch.id, ch.question_id, ch.content_id, ch.correct, c.id, c.content
+from choices ch
+join content c on ch.content_id = c.id
+where ch.question_id in (` + moduleQuestionIdsQuery + `)
+order by ch.position, ch.id;
+`
+
+func (c *DbClient) getModuleChoices(moduleVersionIds []int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleChoicesQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		choice := Choice{}
+		content := Content{}
+		err := rows.Scan(&choice.Id, &choice.QuestionId, &choice.ContentId, &choice.Correct, &content.Id, &content.Content)
+		if err != nil {
+			return err
+		}
+		if question := contents.question(choice.QuestionId); question != nil {
+			question.Choices = append(question.Choices, choice)
+			question.ChoiceContents = append(question.ChoiceContents, content)
+		}
+		return nil
+	})
+}
+
+const getModuleNumericSolutionsQuery = `
+select s.id, s.question_id, s.value, s.tolerance, s.relative
+from numeric_solutions s
+where s.question_id in (` + moduleQuestionIdsQuery + `);
+`
+
+func (c *DbClient) getModuleNumericSolutions(moduleVersionIds []int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleNumericSolutionsQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		solution := NumericSolution{}
+		err := rows.Scan(&solution.Id, &solution.QuestionId, &solution.Value, &solution.Tolerance, &solution.Relative)
+		if err != nil {
+			return err
+		}
+		if question := contents.question(solution.QuestionId); question != nil {
+			question.NumericSolution = solution
+		}
+		return nil
+	})
+}
+
+const getModuleTextSolutionsQuery = `
+select s.id, s.question_id, s.fold_case, s.normalize_whitespace
+from text_solutions s
+where s.question_id in (` + moduleQuestionIdsQuery + `);
+`
+
+func (c *DbClient) getModuleTextSolutions(moduleVersionIds []int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleTextSolutionsQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		solution := TextSolution{}
+		err := rows.Scan(&solution.Id, &solution.QuestionId, &solution.FoldCase, &solution.NormalizeWhitespace)
+		if err != nil {
+			return err
+		}
+		if question := contents.question(solution.QuestionId); question != nil {
+			question.TextSolution = solution
+		}
+		return nil
+	})
+}
+
+const getModuleAcceptedAnswersQuery = `
+select a.id, a.question_id, a.answer, a.regex
+from accepted_answers a
+where a.question_id in (` + moduleQuestionIdsQuery + `)
+order by a.id;
+`
+
+func (c *DbClient) getModuleAcceptedAnswers(moduleVersionIds []int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleAcceptedAnswersQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		answer := AcceptedAnswer{}
+		err := rows.Scan(&answer.Id, &answer.QuestionId, &answer.Answer, &answer.Regex)
+		if err != nil {
+			return err
+		}
+		if question := contents.question(answer.QuestionId); question != nil {
+			question.AcceptedAnswers = append(question.AcceptedAnswers, answer)
+		}
+		return nil
+	})
+}
+
+const getModuleAnswersQuery = `
+select a.question_id, a.choice_id
+from answers a
+where a.user_id = ? and a.question_id in (` + moduleQuestionIdsQuery + `)
+order by a.id;
+`
+
+func (c *DbClient) getModuleAnswers(moduleVersionIds []int64, userId int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleAnswersQuery, len(moduleVersionIds)), append([]any{userId}, inArgs(moduleVersionIds)...)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		var questionId int
+		var choiceId int
+		err := rows.Scan(&questionId, &choiceId)
+		if err != nil {
+			return err
+		}
+		if question := contents.question(questionId); question != nil {
+			question.ChosenChoiceIds = append(question.ChosenChoiceIds, choiceId)
+			question.Answered = true
+		}
+		return nil
+	})
+}
+
+const getModuleNumericAnswersQuery = `
+select a.question_id, a.value
+from numeric_answers a
+where a.user_id = ? and a.question_id in (` + moduleQuestionIdsQuery + `);
+`
+
+func (c *DbClient) getModuleNumericAnswers(moduleVersionIds []int64, userId int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleNumericAnswersQuery, len(moduleVersionIds)), append([]any{userId}, inArgs(moduleVersionIds)...)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		var questionId int
+		var value float64
+		err := rows.Scan(&questionId, &value)
+		if err != nil {
+			return err
+		}
+		if question := contents.question(questionId); question != nil {
+			question.NumericAnswer = value
+			question.Answered = true
+		}
+		return nil
+	})
+}
+
+const getModuleTextAnswersQuery = `
+select a.question_id, a.answer
+from text_answers a
+where a.user_id = ? and a.question_id in (` + moduleQuestionIdsQuery + `);
+`
+
+func (c *DbClient) getModuleTextAnswers(moduleVersionIds []int64, userId int64, contents moduleContentsBuilder) error {
+	rows, err := c.query(inQuery(getModuleTextAnswersQuery, len(moduleVersionIds)), append([]any{userId}, inArgs(moduleVersionIds)...)...)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		var questionId int
+		var answer string
+		err := rows.Scan(&questionId, &answer)
+		if err != nil {
+			return err
+		}
+		if question := contents.question(questionId); question != nil {
+			question.TextAnswer = answer
+			question.Answered = true
+		}
+		return nil
+	})
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Authors and tags are stored as JSON lists.
+const createModuleMetadataTable = `
+create table if not exists module_metadata (
+	id integer primary key autoincrement,
+	module_version_id integer not null unique,
+	authors text not null,
+	tags text not null,
+	license text not null,
+	estimated_minutes integer not null,
+	language text not null,
+	protocol_version integer not null,
+	extra text not null,
+	foreign key (module_version_id) references module_versions(id) on delete cascade
+);
+`
+
+// Optional metadata about a module version, from the frontmatter of
+// the module's source. Versions without any just don't have a row.
+type ModuleMetadata struct {
+	Authors          []string
+	Tags             []string
+	License          string
+	EstimatedMinutes int
+	Language         string
+	ProtocolVersion  int
+	// Frontmatter keys the protocol doesn't know, as YAML, so they export as they were
+	Extra string
+}
+
+func NewModuleMetadata(authors []string, tags []string, license string, estimatedMinutes int, language string, protocolVersion int, extra string) ModuleMetadata {
+	return ModuleMetadata{authors, tags, license, estimatedMinutes, language, protocolVersion, extra}
+}
+
+const insertModuleMetadataQuery = `
+insert into module_metadata(module_version_id, authors, tags, license, estimated_minutes, language, protocol_version, extra)
+values(?, ?, ?, ?, ?, ?, ?, ?);
+`
+
+func InsertModuleMetadata(tx *sql.Tx, moduleVersionId int64, metadata ModuleMetadata) error {
+	authors, err := json.Marshal(metadata.Authors)
+	if err != nil {
+		return err
+	}
+	tags, err := json.Marshal(metadata.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(insertModuleMetadataQuery, moduleVersionId, string(authors), string(tags), metadata.License,
+		metadata.EstimatedMinutes, metadata.Language, metadata.ProtocolVersion, metadata.Extra)
+	return err
+}
+
+const getModuleMetadataQuery = `
+select m.authors, m.tags, m.license, m.estimated_minutes, m.language, m.protocol_version, m.extra
+from module_metadata m
+where m.module_version_id = ?;
+`
+
+// Returns empty metadata for versions that don't have any.
+func GetModuleMetadata(tx *sql.Tx, moduleVersionId int64) (ModuleMetadata, error) {
+	return rowToModuleMetadata(tx.QueryRow(getModuleMetadataQuery, moduleVersionId))
+}
+
+func (c *DbClient) GetModuleMetadata(moduleVersionId int64) (ModuleMetadata, error) {
+	return rowToModuleMetadata(c.queryRow(getModuleMetadataQuery, moduleVersionId))
+}
+
+const getModulesMetadataQuery = `
+select m.module_version_id, m.authors, m.tags, m.license, m.estimated_minutes, m.language, m.protocol_version, m.extra
+from module_metadata m
+where m.module_version_id in (%s);
+`
+
+// Returns the metadata of every one of the module versions, in one query.
+// Versions without any get empty metadata like GetModuleMetadata.
+func (c *DbClient) GetModulesMetadata(moduleVersionIds []int64) (map[int64]ModuleMetadata, error) {
+	modules := make(map[int64]ModuleMetadata)
+	if len(moduleVersionIds) == 0 {
+		return modules, nil
+	}
+	for _, moduleVersionId := range moduleVersionIds {
+		modules[moduleVersionId] = ModuleMetadata{}
+	}
+	rows, err := c.query(inQuery(getModulesMetadataQuery, len(moduleVersionIds)), inArgs(moduleVersionIds)...)
+	if err != nil {
+		return nil, err
+	}
+	err = eachRow(rows, func() error {
+		var moduleVersionId int64
+		var authors string
+		var tags string
+		metadata := ModuleMetadata{}
+		err := rows.Scan(&moduleVersionId, &authors, &tags, &metadata.License, &metadata.EstimatedMinutes,
+			&metadata.Language, &metadata.ProtocolVersion, &metadata.Extra)
+		if err != nil {
+			return err
+		}
+		modules[moduleVersionId], err = unmarshalModuleMetadata(metadata, authors, tags)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return modules, nil
+}
+
+func rowToModuleMetadata(row *sql.Row) (ModuleMetadata, error) {
+	metadata := ModuleMetadata{}
+	var authors string
+	var tags string
+	err := row.Scan(&authors, &tags, &metadata.License, &metadata.EstimatedMinutes, &metadata.Language, &metadata.ProtocolVersion, &metadata.Extra)
+	if err == sql.ErrNoRows {
+		return ModuleMetadata{}, nil
+	}
+	if err != nil {
+		return ModuleMetadata{}, err
+	}
+	return unmarshalModuleMetadata(metadata, authors, tags)
+}
+
+func unmarshalModuleMetadata(metadata ModuleMetadata, authors string, tags string) (ModuleMetadata, error) {
+	err := json.Unmarshal([]byte(authors), &metadata.Authors)
+	if err != nil {
+		return ModuleMetadata{}, err
+	}
+	err = json.Unmarshal([]byte(tags), &metadata.Tags)
+	if err != nil {
+		return ModuleMetadata{}, err
+	}
+	return metadata, nil
+}
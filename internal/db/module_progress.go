@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The latest version of a module, with how many blocks it has, for
+// listing a course's modules.
+type ModuleSummary struct {
+	CourseId   int
+	Version    ModuleVersion
+	BlockCount int
+}
+
+const getLatestModuleVersionsQuery = `
+select m.course_id, mv.id, mv.module_id, mv.version_number, mv.title, mv.description,
+	(select count(*) from blocks b where b.module_version_id = mv.id)
+from modules m
+join module_versions mv on mv.id = (
+	select id from module_versions where module_id = m.id order by version_number desc limit 1
+)
+where m.course_id in (%s)
+order by m.course_id, m.id;
+`
+
+// Returns the latest version of every module in the courses, in one query.
+func (c *DbClient) GetLatestModuleVersions(courseIds []int) ([]ModuleSummary, error) {
+	summaries := []ModuleSummary{}
+	if len(courseIds) == 0 {
+		return summaries, nil
+	}
+	rows, err := c.query(inQuery(getLatestModuleVersionsQuery, len(courseIds)), inArgs(courseIds)...)
+	if err != nil {
+		return nil, err
+	}
+	err = eachRow(rows, func() error {
+		summary := ModuleSummary{}
+		version := &summary.Version
+		err := rows.Scan(&summary.CourseId, &version.Id, &version.ModuleId, &version.VersionNumber,
+			&version.Title, &version.Description, &summary.BlockCount)
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, summary)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// A module of a course as a student sees it: the version they started, or
+// the latest if they haven't, and how far through it they are.
+type ModuleProgress struct {
+	ModuleId   int
+	Version    ModuleVersion
+	BlockCount int
+	// Whether they've started it, otherwise Visit is empty
+	Started bool
+	Visit   Visit
+	// Whether they've been awarded points for completing it, otherwise Point is empty
+	Awarded bool
+	Point   Point
+}
+
+func (p ModuleProgress) Completed() bool {
+	return p.Started && p.Visit.BlockIndex == p.BlockCount
+}
+
+const getModuleProgressQuery = `
+select m.id, mv.id, mv.module_id, mv.version_number, mv.title, mv.description,
+	(select count(*) from blocks b where b.module_version_id = mv.id),
+	v.id, v.block_index, p.id, p.count, p.created_at
+from modules m
+left join visits v on v.id = (
+	select v2.id from visits v2
+	join module_versions mv2 on v2.module_version_id = mv2.id
+	where v2.user_id = ? and mv2.module_id = m.id
+	limit 1
+)
+join module_versions mv on mv.id = coalesce(v.module_version_id, (
+	select id from module_versions where module_id = m.id order by version_number desc limit 1
+))
+left join points p on p.user_id = ? and p.module_id = m.id
+where m.course_id = ?
+order by m.id;
+`
+
+// Returns the user's progress through every module in the course, in one query.
+func (c *DbClient) GetModuleProgress(userId int64, courseId int) ([]ModuleProgress, error) {
+	rows, err := c.query(getModuleProgressQuery, userId, userId, courseId)
+	if err != nil {
+		return nil, err
+	}
+	progress := []ModuleProgress{}
+	err = eachRow(rows, func() error {
+		moduleProgress := ModuleProgress{}
+		version := &moduleProgress.Version
+		var visitId sql.NullInt64
+		var blockIdx sql.NullInt64
+		var pointId sql.NullInt64
+		var pointCount sql.NullInt64
+		var createdAt sql.NullTime
+		err := rows.Scan(&moduleProgress.ModuleId, &version.Id, &version.ModuleId, &version.VersionNumber,
+			&version.Title, &version.Description, &moduleProgress.BlockCount,
+			&visitId, &blockIdx, &pointId, &pointCount, &createdAt)
+		if err != nil {
+			return err
+		}
+		if visitId.Valid {
+			moduleProgress.Started = true
+			moduleProgress.Visit = NewVisit(visitId.Int64, userId, version.Id, int(blockIdx.Int64))
+		}
+		if pointId.Valid {
+			moduleProgress.Awarded = true
+			moduleProgress.Point = NewPoint(int(pointId.Int64), userId, moduleProgress.ModuleId, int(pointCount.Int64), createdAt.Time)
+		}
+		progress = append(progress, moduleProgress)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
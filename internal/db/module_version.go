@@ -37,7 +37,7 @@ where mv.id = ?;
 `
 
 func (c *DbClient) GetModuleVersion(moduleVersionId int64) (ModuleVersion, error) {
-	row := c.db.QueryRow(getModuleVersionQuery, moduleVersionId)
+	row := c.queryRow(getModuleVersionQuery, moduleVersionId)
 	var version ModuleVersion
 	err := row.Scan(&version.Id, &version.ModuleId, &version.VersionNumber, &version.Title, &version.Description)
 	if err != nil {
@@ -68,7 +68,7 @@ func GetLatestModuleVersion(tx *sql.Tx, moduleId int) (ModuleVersion, error) {
 }
 
 func (c *DbClient) GetLatestModuleVersion(moduleId int) (ModuleVersion, error) {
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(c.ctx, nil)
 	if err != nil {
 		return ModuleVersion{}, err
 	}
@@ -133,11 +133,8 @@ with module_version_block_ids as (
 content_block_content_ids as (
 	select content_id from content_blocks where block_id in module_version_block_ids
 ),
-knowledge_point_ids as (
-	select knowledge_point_id from knowledge_point_blocks where block_id in module_version_block_ids
-),
 question_ids as (
-	select id from questions where knowledge_point_id in knowledge_point_ids
+	select question_id from knowledge_point_blocks where block_id in module_version_block_ids
 ),
 question_content_ids as (
 	select content_id from questions where id in question_ids
@@ -160,11 +157,8 @@ referenced_content_ids as (
 elsewhere_content_block_content_ids as (
 	select content_id from content_blocks where block_id not in module_version_block_ids
 ),
-elsewhere_knowledge_point_ids as (
-	select knowledge_point_id from knowledge_point_blocks where block_id not in module_version_block_ids
-),
 elsewhere_question_ids as (
-	select id from questions where knowledge_point_id in elsewhere_knowledge_point_ids
+	select question_id from knowledge_point_blocks where block_id not in module_version_block_ids
 ),
 elsewhere_question_content_ids as (
 	select content_id from questions where id in elsewhere_question_ids
@@ -199,10 +193,34 @@ func DeleteContentForModuleVersion(tx *sql.Tx, moduleId int, versionNumber int64
 }
 
 func DeleteModuleVersion(tx *sql.Tx, moduleId int, versionNumber int64) error {
-	err := DeleteContentForModuleVersion(tx, moduleId, versionNumber)
+	questionIds, err := getQuestionIdsForModuleVersion(tx, moduleId, versionNumber)
+	if err != nil {
+		return err
+	}
+	knowledgePointIds, err := getKnowledgePointIdsForModuleVersion(tx, moduleId, versionNumber)
+	if err != nil {
+		return err
+	}
+	var courseId int
+	err = tx.QueryRow("select course_id from modules where id = ?;", moduleId).Scan(&courseId)
+	if err != nil {
+		return err
+	}
+	assetHashes, err := getBlockAssetReferences(tx, moduleVersionBlockIdsQuery, moduleId, versionNumber)
+	if err != nil {
+		return err
+	}
+	err = DeleteContentForModuleVersion(tx, moduleId, versionNumber)
 	if err != nil {
 		return err
 	}
 	_, err = tx.Exec("delete from module_versions where module_id = ? and version_number = ?;", moduleId, versionNumber)
-	return err
+	if err != nil {
+		return err
+	}
+	err = deleteOrphanedQuestions(tx, questionIds, knowledgePointIds)
+	if err != nil {
+		return err
+	}
+	return deleteUnusedCourseAssets(tx, courseId, assetHashes)
 }
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createNumericAnswerTable = `
+create table if not exists numeric_answers (
+	id integer primary key autoincrement,
+	user_id integer not null,
+	question_id integer not null,
+	value real not null,
+	foreign key (user_id) references users(id) on delete cascade,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+`
+
+const storeNumericAnswerQuery = `
+update numeric_answers
+set value = ?
+where user_id = ? and question_id = ?;
+
+insert into numeric_answers(user_id, question_id, value)
+select ?, ?, ?
+where not exists (select 1 from numeric_answers where user_id = ? and question_id = ?);
+`
+
+func (c *DbClient) StoreNumericAnswer(userId int64, questionId int, value float64) error {
+	_, err := c.exec(storeNumericAnswerQuery, value, userId, questionId, userId, questionId, value, userId, questionId)
+	return err
+}
+
+const getNumericAnswerQuery = `
+select a.value
+from numeric_answers a
+where a.user_id = ? and a.question_id = ?;
+`
+
+// Returns the student's answer to a numeric question, and whether they've answered it.
+func GetNumericAnswer(tx *sql.Tx, userId int64, questionId int) (float64, bool, error) {
+	row := tx.QueryRow(getNumericAnswerQuery, userId, questionId)
+	var value float64
+	err := row.Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+func (c *DbClient) GetNumericAnswer(userId int64, questionId int) (float64, bool, error) {
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	defer tx.Rollback()
+	if err != nil {
+		return 0, false, err
+	}
+	value, answered, err := GetNumericAnswer(tx, userId, questionId)
+	if err != nil {
+		return 0, false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, false, err
+	}
+	return value, answered, nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createNumericSolutionTable = `
+create table if not exists numeric_solutions (
+	id integer primary key autoincrement,
+	question_id integer not null unique,
+	value real not null,
+	tolerance real not null,
+	relative bool not null,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+`
+
+// The expected answer to a numeric question. Relative tolerances
+// are a percentage of the value.
+type NumericSolution struct {
+	Id         int
+	QuestionId int
+	Value      float64
+	Tolerance  float64
+	Relative   bool
+}
+
+func NewNumericSolution(id int, questionId int, value float64, tolerance float64, relative bool) NumericSolution {
+	return NumericSolution{id, questionId, value, tolerance, relative}
+}
+
+const insertNumericSolutionQuery = `
+insert into numeric_solutions(question_id, value, tolerance, relative)
+values(?, ?, ?, ?);
+`
+
+func InsertNumericSolution(tx *sql.Tx, questionId int64, solution NumericSolution) error {
+	_, err := tx.Exec(insertNumericSolutionQuery, questionId, solution.Value, solution.Tolerance, solution.Relative)
+	return err
+}
+
+const getNumericSolutionQuery = `
+select s.id, s.question_id, s.value, s.tolerance, s.relative
+from numeric_solutions s
+where s.question_id = ?;
+`
+
+func (c *DbClient) GetNumericSolution(questionId int) (NumericSolution, error) {
+	row := c.queryRow(getNumericSolutionQuery, questionId)
+	solution := NumericSolution{}
+	err := row.Scan(&solution.Id, &solution.QuestionId, &solution.Value, &solution.Tolerance, &solution.Relative)
+	if err != nil {
+		return NumericSolution{}, err
+	}
+	return solution, nil
+}
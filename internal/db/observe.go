@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Opens sqlite connections that time every statement they run, whether
+// it's run through a DbClient or a transaction, since most writes happen
+// in transactions.
+type observedConnector struct {
+	dsn      string
+	driver   *sqlite3.SQLiteDriver
+	observer *atomic.Pointer[QueryObserver]
+}
+
+func (c observedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &observedConn{conn.(*sqlite3.SQLiteConn), c.observer}, nil
+}
+
+func (c observedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Everything else, like preparing and beginning transactions, is left to the sqlite connection.
+type observedConn struct {
+	*sqlite3.SQLiteConn
+	observer *atomic.Pointer[QueryObserver]
+}
+
+func (c *observedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	defer c.observe(time.Now())
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *observedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer c.observe(time.Now())
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+// Tells the observer how long the statement took, and the function that ran it.
+func (c *observedConn) observe(start time.Time) {
+	observer := c.observer.Load()
+	if observer == nil {
+		return
+	}
+	(*observer)(statementCaller(), time.Since(start))
+}
+
+// Frames that run statements on behalf of their caller
+var statementHelperPackages = []string{"runtime.", "database/sql.", "noobular/internal/db.(*observedConn)."}
+var statementHelpers = map[string]bool{
+	"noobular/internal/db.(*DbClient).queryRow": true,
+	"noobular/internal/db.(*DbClient).query":    true,
+	"noobular/internal/db.(*DbClient).exec":     true,
+}
+
+// Returns the name of the function running the current statement, e.g.
+// "GetCourse" for DbClient.GetCourse or "InsertModuleVersion" for a
+// transaction's statement, or "unknown" if there isn't one.
+func statementCaller() string {
+	pcs := make([]uintptr, 32)
+	// Skip runtime.Callers, this and observe
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		helper := frame.Function == "" || statementHelpers[frame.Function]
+		for _, prefix := range statementHelperPackages {
+			helper = helper || strings.HasPrefix(frame.Function, prefix)
+		}
+		if !helper {
+			return functionName(frame.Function)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// Returns e.g. "GetCourse" for "noobular/internal/db.(*DbClient).GetCourse.func1".
+func functionName(function string) string {
+	name := function[strings.LastIndex(function, "/")+1:]
+	parts := strings.Split(name, ".")
+	// Skip the package and the receiver, if there is one
+	if len(parts) > 2 && strings.HasPrefix(parts[1], "(") {
+		return parts[2]
+	}
+	if len(parts) > 1 {
+		return parts[1]
+	}
+	return name
+}
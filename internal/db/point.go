@@ -57,7 +57,7 @@ where p.user_id = ? and p.module_id = ?;
 `
 
 func (c *DbClient) GetPoint(userId int64, moduleId int) (Point, error) {
-	row := c.db.QueryRow(getPoint, userId, moduleId)
+	row := c.queryRow(getPoint, userId, moduleId)
 	var point Point
 	var createdAt string
 	err := row.Scan(&point.Id, &point.UserId, &point.ModuleId, &point.Count, &createdAt)
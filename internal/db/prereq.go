@@ -49,10 +49,31 @@ where p.module_id = ?;
 `
 
 func (c *DbClient) GetPrereqs(moduleId int) ([]Prereq, error) {
-	rows, err := c.db.Query(getPrereqsQuery, moduleId)
+	rows, err := c.query(getPrereqsQuery, moduleId)
 	if err != nil {
 		return nil, err
 	}
+	return rowsToPrereqs(rows)
+}
+
+const getCoursePrereqsQuery = `
+select p.id, p.module_id, p.prereq_module_id
+from prereqs p
+join modules m on p.module_id = m.id
+where m.course_id = ?
+order by m.id, p.id;
+`
+
+// Returns the prereqs of every module in the course, in order of module.
+func (c *DbClient) GetCoursePrereqs(courseId int) ([]Prereq, error) {
+	rows, err := c.query(getCoursePrereqsQuery, courseId)
+	if err != nil {
+		return nil, err
+	}
+	return rowsToPrereqs(rows)
+}
+
+func rowsToPrereqs(rows *sql.Rows) ([]Prereq, error) {
 	defer rows.Close()
 	var prereqs []Prereq
 	for rows.Next() {
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"math/rand/v2"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -9,74 +10,174 @@ import (
 const createQuestionTable = `
 create table if not exists questions (
 	id integer primary key autoincrement,
-	knowledge_point_id integer not null unique,
+	knowledge_point_id integer not null,
 	content_id integer not null,
+	question_type text not null default 'multiple_choice',
+	grading text not null default 'all_or_nothing',
 	foreign key (knowledge_point_id) references knowledge_points(id) on delete cascade,
 	foreign key (content_id) references content(id) on delete cascade
 );
 `
 
+type QuestionType string
+
+const (
+	MultipleChoiceQuestionType QuestionType = "multiple_choice"
+	MultiSelectQuestionType    QuestionType = "multi_select"
+	NumericQuestionType        QuestionType = "numeric"
+	TextQuestionType           QuestionType = "text"
+	OrderingQuestionType       QuestionType = "ordering"
+)
+
+type Grading string
+
+const (
+	AllOrNothingGrading  Grading = "all_or_nothing"
+	PartialCreditGrading Grading = "partial"
+)
+
 type Question struct {
 	Id             int
 	KnowledgePoint int64
 	ContentId      int
+	QuestionType   QuestionType
+	Grading        Grading
 }
 
-func NewQuestion(id int, knowledgePointId int64, contentId int) Question {
-	return Question{id, knowledgePointId, contentId}
+func NewQuestion(id int, knowledgePointId int64, contentId int, questionType QuestionType, grading Grading) Question {
+	return Question{id, knowledgePointId, contentId, questionType, grading}
 }
 
 const insertQuestionQuery = `
-insert into questions(knowledge_point_id, content_id)
-values(?, ?);
+insert into questions(knowledge_point_id, content_id, question_type, grading)
+values(?, ?, ?, ?);
 `
 
-// Need to rollback tx upon error one level up the stack because this function will not do that.
-func InsertQuestion(tx *sql.Tx, knowledgePointId int64, question string, choices []string, correctChoiceIdx int, explanation string) error {
+// Inserts the parts common to every question type, returning the question id.
+func insertQuestion(tx *sql.Tx, knowledgePointId int64, questionType QuestionType, grading Grading, question string, explanation string) (int64, error) {
 	questionContentId, err := InsertContent(tx, question)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	res, err := tx.Exec(insertQuestionQuery, knowledgePointId, questionContentId)
+	res, err := tx.Exec(insertQuestionQuery, knowledgePointId, questionContentId, questionType, grading)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	questionId, err := res.LastInsertId()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	explanationContentId, err := InsertContent(tx, explanation)
+	if err != nil {
+		return 0, err
+	}
+	err = InsertExplanation(tx, int(questionId), int(explanationContentId))
+	if err != nil {
+		return 0, err
+	}
+	return questionId, nil
+}
+
+// Need to rollback tx upon error one level up the stack because this function will not do that.
+func InsertQuestion(tx *sql.Tx, knowledgePointId int64, question string, choices []string, correctChoiceIdx int, explanation string) (int64, error) {
+	questionId, err := insertQuestion(tx, knowledgePointId, MultipleChoiceQuestionType, AllOrNothingGrading, question, explanation)
+	if err != nil {
+		return 0, err
 	}
 	for choiceIdx, choice := range choices {
-		_, err = InsertChoice(tx, questionId, choice, choiceIdx == correctChoiceIdx)
+		_, err = InsertChoice(tx, questionId, choice, choiceIdx == correctChoiceIdx, choiceIdx)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
-	explanationContentId, err := InsertContent(tx, explanation)
+	return questionId, nil
+}
+
+// Need to rollback tx upon error one level up the stack because this function will not do that.
+func InsertMultiSelectQuestion(tx *sql.Tx, knowledgePointId int64, question string, choices []string, correctChoiceIdxs []int, grading Grading, explanation string) (int64, error) {
+	questionId, err := insertQuestion(tx, knowledgePointId, MultiSelectQuestionType, grading, question, explanation)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	err = InsertExplanation(tx, int(questionId), int(explanationContentId))
+	correct := make(map[int]bool)
+	for _, idx := range correctChoiceIdxs {
+		correct[idx] = true
+	}
+	for choiceIdx, choice := range choices {
+		_, err = InsertChoice(tx, questionId, choice, correct[choiceIdx], choiceIdx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return questionId, nil
+}
+
+// Ordering question items are stored as choices positioned in their correct
+// order, but inserted shuffled so their ids don't give the order away.
+// Need to rollback tx upon error one level up the stack because this function will not do that.
+func InsertOrderingQuestion(tx *sql.Tx, knowledgePointId int64, question string, items []string, grading Grading, explanation string) (int64, error) {
+	questionId, err := insertQuestion(tx, knowledgePointId, OrderingQuestionType, grading, question, explanation)
+	if err != nil {
+		return 0, err
+	}
+	for _, position := range rand.Perm(len(items)) {
+		_, err = InsertChoice(tx, questionId, items[position], true, position)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return questionId, nil
+}
+
+// Need to rollback tx upon error one level up the stack because this function will not do that.
+func InsertNumericQuestion(tx *sql.Tx, knowledgePointId int64, question string, solution NumericSolution, explanation string) (int64, error) {
+	questionId, err := insertQuestion(tx, knowledgePointId, NumericQuestionType, AllOrNothingGrading, question, explanation)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	err = InsertNumericSolution(tx, questionId, solution)
+	if err != nil {
+		return 0, err
+	}
+	return questionId, nil
+}
+
+// Need to rollback tx upon error one level up the stack because this function will not do that.
+func InsertTextQuestion(tx *sql.Tx, knowledgePointId int64, question string, solution TextSolution, accepted []AcceptedAnswer, explanation string) (int64, error) {
+	questionId, err := insertQuestion(tx, knowledgePointId, TextQuestionType, AllOrNothingGrading, question, explanation)
+	if err != nil {
+		return 0, err
+	}
+	err = InsertTextSolution(tx, questionId, solution)
+	if err != nil {
+		return 0, err
+	}
+	for _, answer := range accepted {
+		err = InsertAcceptedAnswer(tx, questionId, answer.Answer, answer.Regex)
+		if err != nil {
+			return 0, err
+		}
 	}
-	return nil
+	return questionId, nil
 }
 
-const getQuestionFromKnowledgePointQuery = `
-select q.id, q.knowledge_point_id, q.content_id
+const getQuestionFromBlockQuery = `
+select q.id, q.knowledge_point_id, q.content_id, q.question_type, q.grading
 from questions q
-join content c on q.content_id = c.id
-where q.knowledge_point_id = ?;
+join knowledge_point_blocks kb on q.id = kb.question_id
+where kb.block_id = ?;
 `
 
-// TODO: handle multiple questions per knowledge point
-func (c *DbClient) GetQuestionFromKnowledgePoint(knowledgePointId int64) (Question, error) {
-	questionRow := c.db.QueryRow(getQuestionFromKnowledgePointQuery, knowledgePointId)
+func (c *DbClient) GetQuestionFromBlock(blockId int) (Question, error) {
+	questionRow := c.queryRow(getQuestionFromBlockQuery, blockId)
 	id := 0
+	var knowledgePointId int64
 	contentId := 0
-	err := questionRow.Scan(&id, &knowledgePointId, &contentId)
+	questionType := MultipleChoiceQuestionType
+	grading := AllOrNothingGrading
+	err := questionRow.Scan(&id, &knowledgePointId, &contentId, &questionType, &grading)
 	if err != nil {
 		return Question{}, err
 	}
-	return NewQuestion(id, knowledgePointId, contentId), nil
+	return NewQuestion(id, knowledgePointId, contentId, questionType, grading), nil
 }
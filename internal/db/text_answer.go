@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createTextAnswerTable = `
+create table if not exists text_answers (
+	id integer primary key autoincrement,
+	user_id integer not null,
+	question_id integer not null,
+	answer text not null,
+	foreign key (user_id) references users(id) on delete cascade,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+`
+
+const storeTextAnswerQuery = `
+update text_answers
+set answer = ?
+where user_id = ? and question_id = ?;
+
+insert into text_answers(user_id, question_id, answer)
+select ?, ?, ?
+where not exists (select 1 from text_answers where user_id = ? and question_id = ?);
+`
+
+func (c *DbClient) StoreTextAnswer(userId int64, questionId int, answer string) error {
+	_, err := c.exec(storeTextAnswerQuery, answer, userId, questionId, userId, questionId, answer, userId, questionId)
+	return err
+}
+
+const getTextAnswerQuery = `
+select a.answer
+from text_answers a
+where a.user_id = ? and a.question_id = ?;
+`
+
+// Returns the student's answer to a text question, and whether they've answered it.
+func GetTextAnswer(tx *sql.Tx, userId int64, questionId int) (string, bool, error) {
+	row := tx.QueryRow(getTextAnswerQuery, userId, questionId)
+	var answer string
+	err := row.Scan(&answer)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return answer, true, nil
+}
+
+func (c *DbClient) GetTextAnswer(userId int64, questionId int) (string, bool, error) {
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	defer tx.Rollback()
+	if err != nil {
+		return "", false, err
+	}
+	answer, answered, err := GetTextAnswer(tx, userId, questionId)
+	if err != nil {
+		return "", false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return "", false, err
+	}
+	return answer, answered, nil
+}
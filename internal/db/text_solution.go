@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createTextSolutionTable = `
+create table if not exists text_solutions (
+	id integer primary key autoincrement,
+	question_id integer not null unique,
+	fold_case bool not null,
+	normalize_whitespace bool not null,
+	foreign key (question_id) references questions(id) on delete cascade
+);
+`
+
+// How answers to a text question are compared against its accepted answers.
+type TextSolution struct {
+	Id                  int
+	QuestionId          int
+	FoldCase            bool
+	NormalizeWhitespace bool
+}
+
+func NewTextSolution(id int, questionId int, foldCase bool, normalizeWhitespace bool) TextSolution {
+	return TextSolution{id, questionId, foldCase, normalizeWhitespace}
+}
+
+const insertTextSolutionQuery = `
+insert into text_solutions(question_id, fold_case, normalize_whitespace)
+values(?, ?, ?);
+`
+
+func InsertTextSolution(tx *sql.Tx, questionId int64, solution TextSolution) error {
+	_, err := tx.Exec(insertTextSolutionQuery, questionId, solution.FoldCase, solution.NormalizeWhitespace)
+	return err
+}
+
+const getTextSolutionQuery = `
+select s.id, s.question_id, s.fold_case, s.normalize_whitespace
+from text_solutions s
+where s.question_id = ?;
+`
+
+func (c *DbClient) GetTextSolution(questionId int) (TextSolution, error) {
+	row := c.queryRow(getTextSolutionQuery, questionId)
+	solution := TextSolution{}
+	err := row.Scan(&solution.Id, &solution.QuestionId, &solution.FoldCase, &solution.NormalizeWhitespace)
+	if err != nil {
+		return TextSolution{}, err
+	}
+	return solution, nil
+}
@@ -23,7 +23,7 @@ values(?);
 `
 
 func (c *DbClient) CreateUser(username string) (User, error) {
-	res, err := c.db.Exec(insertUserQuery, username)
+	res, err := c.exec(insertUserQuery, username)
 	if err != nil {
 		return User{}, err
 	}
@@ -35,7 +35,7 @@ func (c *DbClient) CreateUser(username string) (User, error) {
 }
 
 func (c *DbClient) GetUser(userId int64) (User, error) {
-	row := c.db.QueryRow("select id, username from users where id = ?;", userId)
+	row := c.queryRow("select id, username from users where id = ?;", userId)
 	var user User
 	err := row.Scan(&user.Id, &user.Username)
 	if err != nil {
@@ -45,7 +45,7 @@ func (c *DbClient) GetUser(userId int64) (User, error) {
 }
 
 func (c *DbClient) GetUserByUsername(username string) (User, error) {
-	row := c.db.QueryRow("select id, username from users where username = ?;", username)
+	row := c.queryRow("select id, username from users where username = ?;", username)
 	var user User
 	err := row.Scan(&user.Id, &user.Username)
 	if err != nil {
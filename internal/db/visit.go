@@ -72,7 +72,7 @@ where v.user_id = ? and mv.module_id = ?;
 `
 
 func (c *DbClient) GetVisit(userId int64, moduleId int) (Visit, error) {
-	row := c.db.QueryRow(getVisitForModuleQuery, userId, moduleId)
+	row := c.queryRow(getVisitForModuleQuery, userId, moduleId)
 	var visit Visit
 	err := row.Scan(&visit.Id, &visit.UserId, &visit.ModuleVersionId, &visit.BlockIndex)
 	if err != nil {
@@ -82,7 +82,7 @@ func (c *DbClient) GetVisit(userId int64, moduleId int) (Visit, error) {
 }
 
 func (c *DbClient) CreateVisit(userId int64, moduleId int) (Visit, error) {
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(c.ctx, nil)
 	if err != nil {
 		return Visit{}, err
 	}
@@ -113,7 +113,7 @@ func UpdateVisit(tx *sql.Tx, userId int64, moduleVersionId int64, blockIdx int)
 }
 
 func (c *DbClient) UpdateVisit(userId int64, moduleVersionId int64, blockIdx int) error {
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(c.ctx, nil)
 	defer tx.Rollback()
 	if err != nil {
 		return err
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Handlers return these to say what went wrong in a way HandlerMap can turn
+// into a status code and a message for the user. Any other error is internal,
+// so its details are logged but never shown, since they can be things like
+// raw SQL errors.
+
+type ErrorKind string
+
+const (
+	InternalErrorKind     ErrorKind = "internal"
+	BadRequestErrorKind   ErrorKind = "bad_request"
+	UnauthorizedErrorKind ErrorKind = "unauthorized"
+	ForbiddenErrorKind    ErrorKind = "forbidden"
+	NotFoundErrorKind     ErrorKind = "not_found"
+	// The route exists, but not for the request's method
+	MethodNotAllowedErrorKind ErrorKind = "method_not_allowed"
+	ConflictErrorKind         ErrorKind = "conflict"
+)
+
+var errorKindStatuses = map[ErrorKind]int{
+	InternalErrorKind:         http.StatusInternalServerError,
+	BadRequestErrorKind:       http.StatusBadRequest,
+	UnauthorizedErrorKind:     http.StatusUnauthorized,
+	ForbiddenErrorKind:        http.StatusForbidden,
+	NotFoundErrorKind:         http.StatusNotFound,
+	MethodNotAllowedErrorKind: http.StatusMethodNotAllowed,
+	ConflictErrorKind:         http.StatusConflict,
+}
+
+type HandlerError struct {
+	Kind ErrorKind
+	// Shown to the user
+	Message string
+	// What caused it, if anything, which is only logged
+	Err error
+}
+
+func NewHandlerError(kind ErrorKind, message string, err error) HandlerError {
+	return HandlerError{kind, message, err}
+}
+
+func (e HandlerError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e HandlerError) Unwrap() error {
+	return e.Err
+}
+
+func (e HandlerError) Status() int {
+	return errorKindStatuses[e.Kind]
+}
+
+// The message is shown as is, so it shouldn't include internal errors.
+func newHandlerErrorf(kind ErrorKind, format string, a ...any) HandlerError {
+	return NewHandlerError(kind, fmt.Sprintf(format, a...), nil)
+}
+
+func badRequestErrorf(format string, a ...any) error {
+	return newHandlerErrorf(BadRequestErrorKind, format, a...)
+}
+
+func unauthorizedErrorf(format string, a ...any) error {
+	return newHandlerErrorf(UnauthorizedErrorKind, format, a...)
+}
+
+func forbiddenErrorf(format string, a ...any) error {
+	return newHandlerErrorf(ForbiddenErrorKind, format, a...)
+}
+
+func notFoundErrorf(format string, a ...any) error {
+	return newHandlerErrorf(NotFoundErrorKind, format, a...)
+}
+
+func conflictErrorf(format string, a ...any) error {
+	return newHandlerErrorf(ConflictErrorKind, format, a...)
+}
+
+// Returns what kind of error err is. Besides HandlerErrors, this recognizes
+// errors handlers commonly pass straight through: sql.ErrNoRows means
+// something doesn't exist, or isn't theirs, and a *strconv.NumError means a
+// malformed id or number.
+func classifyError(err error) HandlerError {
+	var handlerErr HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return NewHandlerError(NotFoundErrorKind, "Not found", err)
+	}
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		return NewHandlerError(BadRequestErrorKind, fmt.Sprintf("Invalid number %q", numErr.Num), err)
+	}
+	return NewHandlerError(InternalErrorKind, "Something went wrong", err)
+}
+
+// Responds with what went wrong: an error object for the API, a fragment htmx
+// adds to the page for htmx requests, an error page for browsers, and plain
+// text for everything else, e.g. the CLI.
+func (hm HandlerMap) writeError(w http.ResponseWriter, r *http.Request, requestId uuid.UUID, err error) {
+	handlerErr := classifyError(err)
+	// Only internal errors are the server's problem
+	level := slog.LevelInfo
+	if handlerErr.Kind == InternalErrorKind {
+		level = slog.LevelError
+	}
+	slog.Log(r.Context(), level, "Handler error", "kind", handlerErr.Kind, "error", err)
+	status := handlerErr.Status()
+	uiError := UiError{http.StatusText(status), handlerErr.Message, ""}
+	if handlerErr.Kind == InternalErrorKind {
+		// So they can tell us which one it was
+		uiError.RequestId = requestId.String()
+	}
+	if isApiRequest(r) {
+		err = writeJSON(w, status, NewApiErrorResponse(handlerErr, uiError.RequestId))
+	} else if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("HX-Retarget", "body")
+		w.Header().Set("HX-Reswap", "beforeend")
+		w.WriteHeader(status)
+		err = hm.ctx.renderer.RenderErrorFragment(w, uiError)
+	} else if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		_, cookieErr := checkCookie(r, hm.ctx.jwtSecret)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		err = hm.ctx.renderer.RenderErrorPage(w, cookieErr == nil, uiError)
+	} else {
+		message := uiError.Message
+		if uiError.RequestId != "" {
+			message += " (request " + uiError.RequestId + ")"
+		}
+		http.Error(w, message, status)
+		err = nil
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering error", "error", err)
+	}
+}
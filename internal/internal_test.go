@@ -1,16 +1,31 @@
 package internal_test
 
 import (
+	"archive/zip"
 	"bufio"
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"noobular/internal"
 	noob_client "noobular/internal/client"
 	"noobular/internal/db"
+	"noobular/internal/protocol"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -500,7 +515,7 @@ func TestDeleteModuleSharedContent(t *testing.T) {
 	client.createCourse(course, modules)
 
 	courseId2 := 2
-	moduleId2 := 2
+	moduleId2 := 3
 
 	newModuleVersion2 := db.NewModuleVersion(2, moduleId2, 1, "new title", "new description")
 	blocks = []blockInput{
@@ -978,6 +993,1724 @@ func TestFormat(t *testing.T) {
 	require.Equal(t, strings.TrimSpace(testModule), strings.TrimSpace(body))
 }
 
+func TestUploadModuleSource(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	client.createCourse(course, modules)
+	courseId := int64(1)
+	moduleId := int64(1)
+
+	resp := client.noobClient().UploadModule(courseId, moduleId, testModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	body := client.getPageBody(exportModuleRoute(int(courseId), int(moduleId)))
+	require.Equal(t, strings.TrimSpace(testModule), strings.TrimSpace(body))
+
+	// Upload what we exported and we should get the same thing back
+	resp = client.noobClient().UploadModule(courseId, moduleId, body)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, body, client.getPageBody(exportModuleRoute(int(courseId), int(moduleId))))
+
+	// Source must be markdown
+	resp = client.put(noob_client.ModuleSourceRoute(courseId, moduleId), testModule)
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	// Invalid modules are rejected
+	noMetadata := "[//]: # (content)\nhello"
+	noCorrectChoice := "---\ntitle: t\ndescription: d\n---\n[//]: # (question)\nq\n[//]: # (choice)\nc"
+	questionWithoutChoice := "---\ntitle: t\ndescription: d\n---\n[//]: # (question)\nq\n[//]: # (content)\nc"
+	noTitle := "---\ndescription: d\n---\n[//]: # (content)\nc"
+	for _, module := range []string{noMetadata, noCorrectChoice, questionWithoutChoice, noTitle} {
+		resp = client.noobClient().UploadModule(courseId, moduleId, module)
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+
+	// Other users cannot upload to this module
+	user2 := ctx.createUser()
+	client2 := newTestClient(t).login(user2.Id)
+	resp = client2.noobClient().UploadModule(courseId, moduleId, testModule)
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	// Nor can it be uploaded to through another of the teacher's courses
+	client.createCourse(course, nil)
+	resp = client.noobClient().UploadModule(courseId+1, moduleId, testModule)
+	require.Equal(t, 404, resp.StatusCode)
+}
+
+const metadataTestModule = `---
+title: 'Modular arithmetic: inverses'
+description: |
+  Finding inverses.
+  Two lines.
+authors:
+  - Alec
+  - Bob
+tags:
+  - number theory
+license: CC-BY-4.0
+estimated_minutes: 15
+language: en
+protocol_version: 1
+x-source:
+  repo: example/notes # kept as is
+---
+
+
+[//]: # (content)
+Hello`
+
+func TestModuleMetadata(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := ctx.createUser()
+	teacherClient := newTestClient(t).login(teacher.Id)
+	course, modules := sampleCreateCourseInput()
+	teacherClient.createCourse(course, modules)
+	courseId := int64(1)
+	moduleId := int64(1)
+
+	// Metadata, including keys we don't know, is exported as it was uploaded
+	resp := teacherClient.noobClient().UploadModule(courseId, moduleId, metadataTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+	body := teacherClient.getPageBody(exportModuleRoute(int(courseId), int(moduleId)))
+	require.Equal(t, metadataTestModule, strings.TrimSpace(body))
+	module, err := protocol.Parse(body)
+	require.Nil(t, err)
+	require.Equal(t, []string{"Alec", "Bob"}, module.Metadata.Authors)
+	require.Equal(t, 15, module.Metadata.EstimatedMinutes)
+	require.Equal(t, "Finding inverses.\nTwo lines.\n", module.Description)
+
+	// Editing through the form keeps it
+	version, err := ctx.db.GetLatestModuleVersion(int(moduleId))
+	require.Nil(t, err)
+	teacherClient.editModule(courseId, version, []blockInput{newContentBlockInput("Edited")})
+	body = teacherClient.getPageBody(exportModuleRoute(int(courseId), int(moduleId)))
+	require.Contains(t, body, "x-source:\n  repo: example/notes # kept as is\n")
+	require.Contains(t, body, "Edited")
+
+	// Lists can be a single string
+	single := "---\ntitle: t\ndescription: d\nauthors: Alec\n---\n[//]: # (content)\nc"
+	module, err = protocol.Parse(single)
+	require.Nil(t, err)
+	require.Equal(t, []string{"Alec"}, module.Metadata.Authors)
+
+	// Modules from before the metadata was YAML, with unquoted colons, still import
+	// the way they did, and are exported quoted
+	legacy := "---\ntitle: Ratios: a primer\ndescription: Parts: and wholes\nauthors: [Alec\n---\n[//]: # (content)\nc"
+	resp = teacherClient.noobClient().UploadModule(courseId, moduleId, legacy)
+	require.Equal(t, 200, resp.StatusCode)
+	body = teacherClient.getPageBody(exportModuleRoute(int(courseId), int(moduleId)))
+	require.Contains(t, body, "title: 'Ratios: a primer'\ndescription: 'Parts: and wholes'\n---\n")
+	resp = teacherClient.noobClient().UploadModule(courseId, moduleId, body)
+	require.Equal(t, 200, resp.StatusCode)
+	version, err = ctx.db.GetLatestModuleVersion(int(moduleId))
+	require.Nil(t, err)
+	require.Equal(t, "Ratios: a primer", version.Title)
+	require.Equal(t, "Parts: and wholes", version.Description)
+	lines := []string{}
+	for _, diagnostic := range internal.LintModule(legacy, internal.DefaultLimits()) {
+		lines = append(lines, fmt.Sprintf("%d:%d: %s", diagnostic.Line, diagnostic.Column, diagnostic.Message))
+	}
+	require.Equal(t, []string{
+		`2:1: metadata isn't valid YAML, so each line is read as "key: value"; quote values containing ": "`,
+		"4:1: authors is ignored since the metadata isn't valid YAML",
+	}, lines)
+
+	invalid := []string{
+		"---\ntitle: t\ndescription: d\n[broken\n---\n[//]: # (content)\nc",
+		"---\ntitle: t\ndescription: d\nprotocol_version: 99\n---\n[//]: # (content)\nc",
+		"---\ntitle: t\ndescription: d\nestimated_minutes: soon\n---\n[//]: # (content)\nc",
+		"---\ntitle: t\ndescription: d\ntags: [" + strings.Repeat("a, ", internal.MaxMetadataListLength) + "a]\n---\n[//]: # (content)\nc",
+		"---\n- not\n- key value\n---\n[//]: # (content)\nc",
+	}
+	for _, module := range invalid {
+		resp = teacherClient.noobClient().UploadModule(courseId, moduleId, module)
+		require.NotEqual(t, 200, resp.StatusCode, module)
+	}
+	_, err = protocol.Parse(invalid[0])
+	require.ErrorContains(t, err, "line 4, column 1: invalid metadata")
+}
+
+const jsonTestModule = `---
+title: Everything
+description: One of each kind of block.
+authors:
+  - Alec
+tags:
+  - testing
+estimated_minutes: 5
+protocol_version: 1
+x-source:
+  repo: example/notes
+  stars: 3
+  since: "2024"
+---
+
+[//]: # (content)
+Some $x^2$ content.
+
+[//]: # (question kp="Squares")
+What is $2^2$?
+
+[//]: # (choice correct)
+4
+
+[//]: # (choice)
+5
+
+[//]: # (explanation)
+Because.
+
+[//]: # (question: multi_select grading=partial)
+Which are even?
+
+[//]: # (choice correct)
+2
+
+[//]: # (choice correct)
+4
+
+[//]: # (choice)
+5
+
+[//]: # (question: numeric)
+What is g?
+
+[//]: # (answer 9.81 tolerance=2%)
+
+[//]: # (question: text fold_case=false)
+Capital of France?
+
+[//]: # (accept)
+Paris
+
+[//]: # (accept regex)
+Par.s
+
+[//]: # (question: ordering grading=partial)
+Order these.
+
+[//]: # (item)
+1
+
+[//]: # (item)
+2`
+
+func TestModuleJSON(t *testing.T) {
+	module, err := protocol.Parse(jsonTestModule)
+	require.Nil(t, err)
+	data, err := module.JSON()
+	require.Nil(t, err)
+	fromJSON, err := protocol.ParseJSON(data)
+	require.Nil(t, err)
+	require.Equal(t, module.Markdown(), fromJSON.Markdown())
+	data2, err := fromJSON.JSON()
+	require.Nil(t, err)
+	require.Equal(t, string(data), string(data2))
+
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	client.createCourse(course, modules)
+	courseId := int64(1)
+	moduleId := int64(1)
+
+	resp := client.noobClient().UploadModule(courseId, moduleId, jsonTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+	markdown := client.getPageBody(exportModuleRoute(int(courseId), int(moduleId)))
+
+	resp = client.get(exportModuleRoute(int(courseId), int(moduleId)) + "?format=json")
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	exported := bodyText(t, resp)
+	require.Equal(t, string(data), exported)
+
+	// Only known formats export, and only through the module's own course
+	resp = client.get(exportModuleRoute(int(courseId), int(moduleId)) + "?format=jsn")
+	require.Equal(t, 400, resp.StatusCode)
+	client.createCourse(course, nil)
+	resp = client.get(exportModuleRoute(int(courseId)+1, int(moduleId)) + "?format=json")
+	require.Equal(t, 404, resp.StatusCode)
+
+	// Uploading the JSON gives back the same module
+	resp = client.noobClient().UploadModuleJSON(courseId, moduleId, exported)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, markdown, client.getPageBody(exportModuleRoute(int(courseId), int(moduleId))))
+
+	// Malformed JSON is rejected
+	wrongVersion := strings.Replace(exported, `"version": 1`, `"version": 2`, 1)
+	unknownField := strings.Replace(exported, `"version": 1`, `"version": 1, "color": "red"`, 1)
+	unknownBlockType := strings.Replace(exported, `"type": "content"`, `"type": "video"`, 1)
+	unknownQuestionType := strings.Replace(exported, `"type": "numeric"`, `"type": "essay"`, 1)
+	answerlessNumeric := `{"version": 1, "title": "t", "description": "d", "metadata": {}, "blocks": [{"type": "question", "question": {"type": "numeric", "text": "q"}}]}`
+	for _, module := range []string{"not json", wrongVersion, unknownField, unknownBlockType, unknownQuestionType, answerlessNumeric} {
+		resp = client.noobClient().UploadModuleJSON(courseId, moduleId, module)
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+}
+
+const giftTestQuestions = `// A comment
+$CATEGORY: $course$/top/Geography
+
+::Q1:: What is the capital of France? {
+	=Paris#Right!
+	~London
+	~Berlin#That's Germany
+	####Paris has been the capital since 987.
+}
+
+::Q2:: The Seine flows through Paris. {T}
+
+Name a country in Europe. {=France =Germany =*land}
+
+What is $\frac\{1\}\{2\}$ as a decimal? {#0.5:0.01}
+
+Two plus two equals {#4} apples.
+
+Match the capitals. {=France -> Paris =Germany -> Berlin}
+
+Write about Paris. {}
+
+Which are colors? {~%50%red ~%50%blue ~%-100%dog}
+
+Just some text.`
+
+const csvTestQuestions = `type,question,choice 1,choice 2,choice 3,answer,tolerance,knowledge_point,explanation
+multiple_choice,What is 2+2?,3,4,5,4,,Addition,Count it out.
+multi_select,Which are even?,2,3,4,2|4,,,
+true_false,2 is prime.,,,,true,,,
+numeric,What is g?,,,,9.81,2%,,
+text,"Capital of France, in English?",,,,Paris|Paree,,,
+ordering,Order these.,1,2,3,,,,
+multiple_choice,What is 3+3?,5,7,8,6,,,
+essay,Write something.,,,,,,,`
+
+func TestImportQuestions(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInputN(2)
+	client.createCourse(course, modules)
+	courseId := int64(1)
+
+	// GIFT questions are added after what's already in the module
+	moduleId := int64(1)
+	resp := client.noobClient().UploadModule(courseId, moduleId, "---\ntitle: t\ndescription: d\n---\n[//]: # (content)\nIntro")
+	require.Equal(t, 200, resp.StatusCode)
+	resp = client.noobClient().ImportQuestions(courseId, moduleId, "gift", giftTestQuestions)
+	require.Equal(t, 200, resp.StatusCode)
+	body := bodyText(t, resp)
+	require.Contains(t, body, "Imported 7 blocks")
+	require.Contains(t, body, "line 19, column 1: matching questions are not supported")
+	require.Contains(t, body, "line 21, column 1: essay questions are not supported")
+
+	exported := client.getPageBody(exportModuleRoute(int(courseId), int(moduleId)))
+	module, err := protocol.Parse(exported)
+	require.Nil(t, err)
+	require.Len(t, module.Blocks, 8)
+	require.Equal(t, protocol.NewContentBlock("Intro"), module.Blocks[0])
+	capital := module.Blocks[1].Question
+	require.Equal(t, "What is the capital of France?", capital.Text)
+	require.Equal(t, []protocol.Choice{protocol.NewChoice("Paris", true), protocol.NewChoice("London", false), protocol.NewChoice("Berlin", false)}, capital.Choices)
+	require.Equal(t, "Paris has been the capital since 987.\n\n- Paris: Right!\n- Berlin: That's Germany", capital.Explanation)
+	require.Equal(t, "Geography", capital.KnowledgePoint)
+	require.Equal(t, []protocol.Choice{protocol.NewChoice("True", true), protocol.NewChoice("False", false)}, module.Blocks[2].Question.Choices)
+	country := module.Blocks[3].Question
+	require.Equal(t, protocol.TextQuestionType, country.QuestionType)
+	require.True(t, country.TextAnswer.Accepts("germany"))
+	require.True(t, country.TextAnswer.Accepts("Poland"))
+	require.False(t, country.TextAnswer.Accepts("Spain"))
+	decimal := module.Blocks[4].Question
+	require.Equal(t, "What is $\\frac{1}{2}$ as a decimal?", decimal.Text)
+	require.Equal(t, protocol.NewNumericAnswer(0.5, 0.01, false), decimal.Numeric)
+	require.Equal(t, "Two plus two equals _____ apples.", module.Blocks[5].Question.Text)
+	colors := module.Blocks[6].Question
+	require.Equal(t, protocol.MultiSelectQuestionType, colors.QuestionType)
+	require.Equal(t, protocol.PartialCreditGrading, colors.Grading)
+	require.Equal(t, []protocol.Choice{protocol.NewChoice("red", true), protocol.NewChoice("blue", true), protocol.NewChoice("dog", false)}, colors.Choices)
+	require.Equal(t, protocol.NewContentBlock("Just some text."), module.Blocks[7])
+	knowledgePoints, err := ctx.db.GetKnowledgePoints(courseId)
+	require.Nil(t, err)
+	require.True(t, slices.ContainsFunc(knowledgePoints, func(kp db.KnowledgePoint) bool { return kp.Name == "Geography" }))
+
+	// CSV questions
+	moduleId = 2
+	resp = client.noobClient().ImportQuestions(courseId, moduleId, "csv", csvTestQuestions)
+	require.Equal(t, 200, resp.StatusCode)
+	body = bodyText(t, resp)
+	require.Contains(t, body, "Imported 6 blocks")
+	require.Contains(t, body, "line 8, column 1: answer 6 is not one of the choices")
+	require.Contains(t, body, "line 9, column 1: unknown question type: essay")
+	exported = client.getPageBody(exportModuleRoute(int(courseId), int(moduleId)))
+	module, err = protocol.Parse(exported)
+	require.Nil(t, err)
+	require.Len(t, module.Blocks, 6)
+	require.Equal(t, []protocol.Choice{protocol.NewChoice("3", false), protocol.NewChoice("4", true), protocol.NewChoice("5", false)}, module.Blocks[0].Question.Choices)
+	require.Equal(t, "Addition", module.Blocks[0].Question.KnowledgePoint)
+	require.Equal(t, "Count it out.", module.Blocks[0].Question.Explanation)
+	require.Equal(t, protocol.MultiSelectQuestionType, module.Blocks[1].Question.QuestionType)
+	require.Equal(t, protocol.NewNumericAnswer(9.81, 2, true), module.Blocks[3].Question.Numeric)
+	require.Equal(t, "Capital of France, in English?", module.Blocks[4].Question.Text)
+	require.Equal(t, []string{"1", "2", "3"}, module.Blocks[5].Question.Items)
+
+	// Nothing importable, unknown formats, and other teachers' modules are rejected
+	resp = client.noobClient().ImportQuestions(courseId, moduleId, "gift", "Write about Paris. {}")
+	require.NotEqual(t, 200, resp.StatusCode)
+	resp = client.noobClient().ImportQuestions(courseId, moduleId, "xml", giftTestQuestions)
+	require.Equal(t, 400, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "unknown import format: xml")
+	user2 := ctx.createUser()
+	client2 := newTestClient(t).login(user2.Id)
+	resp = client2.noobClient().ImportQuestions(courseId, moduleId, "gift", giftTestQuestions)
+	require.NotEqual(t, 200, resp.StatusCode)
+}
+
+func TestExportSite(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	client.createCourse(course, modules)
+	courseId := int64(1)
+
+	png := []byte("\x89PNG\r\n\x1a\n not really an image")
+	resp := client.noobClient().UploadAsset(courseId, png)
+	require.Equal(t, 200, resp.StatusCode)
+	asset := protocol.NewAsset("image/png", png)
+	resp = client.noobClient().UploadModule(courseId, 1, jsonTestModule+"\n\n[//]: # (content)\n![diagram]("+asset.Reference()+")")
+	require.Equal(t, 200, resp.StatusCode)
+	resp = client.noobClient().UploadModule(courseId, 2, "---\ntitle: Second\ndescription: d\n---\n[//]: # (content)\nThe end")
+	require.Equal(t, 200, resp.StatusCode)
+
+	dir := t.TempDir()
+	err := internal.ExportSite(ctx.db, internal.NewRenderer(".."), int(courseId), dir)
+	require.Nil(t, err)
+	readFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.Nil(t, err)
+		return string(data)
+	}
+
+	index := readFile("index.html")
+	require.Contains(t, index, course.Title)
+	require.Contains(t, index, `href="module-001.html"`)
+	require.Contains(t, index, `href="module-002.html"`)
+
+	page := readFile("module-001.html")
+	require.Contains(t, page, "Everything")
+	require.Contains(t, page, `class="katex"`)
+	require.Contains(t, page, `type="radio"`)
+	require.Contains(t, page, `type="checkbox"`)
+	require.Contains(t, page, "<summary>Show answer</summary>")
+	require.Contains(t, page, "9.81 ± 2%")
+	require.Contains(t, page, "Because.")
+	require.Contains(t, page, `href="module-002.html"`)
+	require.Contains(t, page, `src="`+asset.Filename()+`"`)
+	require.Equal(t, string(png), readFile(asset.Filename()))
+
+	// Everything is linked relatively, so it works from disk
+	for _, name := range []string{"index.html", "module-001.html", "module-002.html"} {
+		require.NotRegexp(t, `(href|src)="/`, readFile(name))
+	}
+	require.Contains(t, readFile("style/katex.min.css"), "fonts/KaTeX_Main-Regular.woff2")
+	_, err = os.Stat(filepath.Join(dir, "style/fonts/KaTeX_Main-Regular.woff2"))
+	require.Nil(t, err)
+
+	err = internal.ExportSite(ctx.db, internal.NewRenderer(".."), 100, t.TempDir())
+	require.NotNil(t, err)
+}
+
+func TestErrors(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	client.createCourse(course, modules)
+
+	// Tools get the message as plain text
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		status  int
+		message string
+	}{
+		{"unknown page", "GET", "/nope", "", 404, "Page /nope not found"},
+		{"missing course", "GET", "/teacher/course/100/module/1/preview", "", 404, "Not found"},
+		{"malformed id", "GET", "/teacher/course/abc/module/1/preview", "", 400, `Invalid number "abc"`},
+		{"missing asset", "GET", "/asset/" + protocol.AssetHash([]byte("missing")), "", 404, "not found"},
+		{"validation", "POST", "/teacher/course/1/knowledge-point", "name=", 400, "Name cannot be empty"},
+		{"first", "POST", "/teacher/course/1/knowledge-point", "name=Loops", 200, ""},
+		{"duplicate", "POST", "/teacher/course/1/knowledge-point", "name=Loops", 409, "Knowledge point Loops already exists"},
+		{"method not allowed", "PUT", "/account/token", "", 405, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := client.request(test.method, test.path, test.body)
+			require.Equal(t, test.status, resp.StatusCode)
+			body := bodyText(t, resp)
+			require.Contains(t, body, test.message)
+			require.NotContains(t, body, "sql")
+		})
+	}
+
+	// Browsers get an error page, and htmx a fragment to add to the page
+	resp := client.requestWithHeaders("GET", "/nope", "", map[string]string{"Accept": "text/html"})
+	require.Equal(t, 404, resp.StatusCode)
+	page := bodyText(t, resp)
+	require.Contains(t, page, "<h1>Not Found</h1>")
+	require.Contains(t, page, "Page /nope not found")
+	require.Contains(t, page, `href="/logout"`)
+	resp = client.requestWithHeaders("POST", "/teacher/course/1/knowledge-point", "name=Loops", map[string]string{"HX-Request": "true"})
+	require.Equal(t, 409, resp.StatusCode)
+	require.Equal(t, "body", resp.Header.Get("HX-Retarget"))
+	require.Equal(t, "beforeend", resp.Header.Get("HX-Reswap"))
+	fragment := bodyText(t, resp)
+	require.Contains(t, fragment, `class="error-toast"`)
+	require.Contains(t, fragment, "Knowledge point Loops already exists")
+	require.NotContains(t, fragment, "<html>")
+
+	// Tokens without the scope are forbidden, and unknown ones unauthorized
+	_, secret, err := ctx.db.CreateAccessToken(user.Id, "read", db.CourseReadTokenScope)
+	require.Nil(t, err)
+	resp = noob_client.NewTokenClient(testUrl, secret).CreateModule(1, testModule)
+	require.Equal(t, 403, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "course:write")
+	resp = noob_client.NewTokenClient(testUrl, db.AccessTokenPrefix+"nope").CreateModule(1, testModule)
+	require.Equal(t, 401, resp.StatusCode)
+}
+
+func TestApi(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacherUser := ctx.createUser()
+	teacher := newTestClient(t).login(teacherUser.Id)
+	studentUser := ctx.createUser()
+	student := newTestClient(t).login(studentUser.Id)
+
+	apiRequest := func(client testClient, method string, path string, body string, headers map[string]string) (int, map[string]interface{}) {
+		headers["Content-Type"] = "application/json"
+		resp := client.requestWithHeaders(method, internal.ApiPrefix+path, body, headers)
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var out map[string]interface{}
+		require.Nil(t, json.Unmarshal([]byte(bodyText(t, resp)), &out))
+		return resp.StatusCode, out
+	}
+	get := func(client testClient, path string) (int, map[string]interface{}) {
+		return apiRequest(client, "GET", path, "", map[string]string{})
+	}
+	post := func(client testClient, path string, body string) (int, map[string]interface{}) {
+		return apiRequest(client, "POST", path, body, map[string]string{})
+	}
+	errorCode := func(out map[string]interface{}) string {
+		return out["error"].(map[string]interface{})["code"].(string)
+	}
+
+	// Errors are JSON objects, even for paths that don't exist
+	status, out := get(newTestClient(t), "/courses")
+	require.Equal(t, 401, status)
+	require.Equal(t, "unauthorized", errorCode(out))
+	status, out = get(teacher, "/nope")
+	require.Equal(t, 404, status)
+	require.Equal(t, "not_found", errorCode(out))
+	status, out = post(teacher, "/courses", `{"title": "Course", "unknown": 1}`)
+	require.Equal(t, 400, status)
+	require.Equal(t, "bad_request", errorCode(out))
+	status, out = post(teacher, "/courses", `{"title": ""}`)
+	require.Equal(t, 400, status)
+	require.Equal(t, "bad_request", errorCode(out))
+
+	// Create courses, one private
+	for i := 0; i < 3; i++ {
+		status, out = post(teacher, "/courses", fmt.Sprintf(`{"title": "Course %d", "description": "Desc", "public": true, "modules": [{"title": "Module 1", "description": "First"}, {"title": "Module 2", "description": "Second"}]}`, i))
+		require.Equal(t, 201, status)
+		require.Equal(t, fmt.Sprintf("Course %d", i), out["title"])
+	}
+	status, out = post(teacher, "/courses", `{"title": "Private", "description": "Desc", "public": false}`)
+	require.Equal(t, 201, status)
+	privateId := int(out["id"].(float64))
+
+	// Lists are paginated
+	status, out = get(student, "/courses?perPage=2&page=2")
+	require.Equal(t, 200, status)
+	require.Equal(t, float64(3), out["total"])
+	require.Equal(t, float64(2), out["page"])
+	require.Len(t, out["items"], 1)
+	status, out = get(student, "/courses?perPage=1000")
+	require.Equal(t, 400, status)
+	_, out = get(teacher, "/teacher/courses")
+	require.Equal(t, float64(4), out["total"])
+
+	// Private courses aren't found by students
+	status, out = get(student, fmt.Sprintf("/courses/%d", privateId))
+	require.Equal(t, 404, status)
+	status, _ = get(teacher, fmt.Sprintf("/courses/%d", privateId))
+	require.Equal(t, 200, status)
+	status, out = post(student, fmt.Sprintf("/courses/%d/enrollment", privateId), "")
+	require.Equal(t, 404, status)
+
+	// Students only see a module's blocks by taking it
+	teacher.editModule(1, db.NewModuleVersion(1, 1, 1, "Module 1", "First"), []blockInput{newContentBlockInput("Hello")})
+	status, out = get(teacher, "/courses/1/modules/1/version")
+	require.Equal(t, 200, status)
+	require.Equal(t, float64(2), out["versionNumber"])
+	require.Equal(t, float64(1), out["blockCount"])
+	require.Contains(t, out, "module")
+	_, out = get(student, "/courses/1/modules/1/version")
+	require.NotContains(t, out, "module")
+	_, out = get(student, "/courses/1/modules?perPage=1")
+	require.Equal(t, float64(2), out["total"])
+	require.Equal(t, "Module 1", out["items"].([]interface{})[0].(map[string]interface{})["title"])
+
+	// Enroll and see progress, with an access token
+	_, secret, err := ctx.db.CreateAccessToken(studentUser.Id, "app", db.CourseWriteTokenScope)
+	require.Nil(t, err)
+	tokenClient := newTestClient(t)
+	auth := map[string]string{"Authorization": "Bearer " + secret}
+	status, out = apiRequest(tokenClient, "GET", "/courses/1/progress", "", auth)
+	require.Equal(t, 404, status)
+	status, _ = apiRequest(tokenClient, "POST", "/courses/1/enrollment", "", auth)
+	require.Equal(t, 201, status)
+	status, out = apiRequest(tokenClient, "POST", "/courses/1/enrollment", "", auth)
+	require.Equal(t, 409, status)
+	require.Equal(t, "conflict", errorCode(out))
+	student.getPageBody(takeModulePageRoute(1, 1))
+	student.completeModule(1, 1)
+	status, out = apiRequest(tokenClient, "GET", "/courses/1/progress", "", auth)
+	require.Equal(t, 200, status)
+	moduleProgress := out["modules"].([]interface{})
+	require.Len(t, moduleProgress, 2)
+	first := moduleProgress[0].(map[string]interface{})
+	require.Equal(t, true, first["completed"])
+	require.Equal(t, float64(1), first["blockCount"])
+	require.Equal(t, false, moduleProgress[1].(map[string]interface{})["started"])
+	_, out = apiRequest(tokenClient, "GET", "/student/courses", "", auth)
+	require.Equal(t, float64(1), out["total"])
+
+	// Every public course can be paged through, not just the first few
+	for i := 3; i < 40; i++ {
+		status, _ = post(teacher, "/courses", fmt.Sprintf(`{"title": "Course %d", "description": "Desc", "public": true}`, i))
+		require.Equal(t, 201, status)
+	}
+	status, out = get(student, "/courses?perPage=15&page=3")
+	require.Equal(t, 200, status)
+	require.Equal(t, float64(40), out["total"])
+	items := out["items"].([]interface{})
+	require.Len(t, items, 10)
+	require.Equal(t, "Course 39", items[9].(map[string]interface{})["title"])
+}
+
+func TestPreviewModule(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "module.md")
+	png := []byte("\x89PNG\r\n\x1a\n not really an image")
+	asset := protocol.NewAsset("image/png", png)
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "assets"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, asset.Filename()), png, 0644))
+	module := jsonTestModule + "\n\n[//]: # (content)\n![diagram](" + asset.Reference() + ")"
+	require.Nil(t, os.WriteFile(path, []byte(module), 0644))
+
+	server := httptest.NewServer(internal.NewPreviewHandler(path, internal.NewRenderer(".."), internal.DefaultLimits()))
+	defer server.Close()
+	get := func(route string) (int, string) {
+		resp, err := http.Get(server.URL + route)
+		require.Nil(t, err)
+		return resp.StatusCode, bodyText(t, resp)
+	}
+	versionRegex := regexp.MustCompile(`/events\?version=([0-9-]+)`)
+
+	// Renders like the preview page, without needing to sign in
+	status, page := get("/")
+	require.Equal(t, 200, status)
+	require.Contains(t, page, "Everything")
+	require.Contains(t, page, `class="katex"`)
+	require.Contains(t, page, "9.81")
+	require.Contains(t, page, "Because.")
+	require.Contains(t, page, `src="/asset/`+asset.Hash()+`"`)
+	require.NotContains(t, page, "Problems")
+	require.NotContains(t, page, `href="/teacher"`)
+	status, body := get("/asset/" + asset.Hash())
+	require.Equal(t, 200, status)
+	require.Equal(t, string(png), body)
+	status, _ = get("/asset/" + protocol.AssetHash([]byte("missing")))
+	require.Equal(t, 404, status)
+	status, _ = get("/module.md")
+	require.Equal(t, 404, status)
+
+	// Saving the file tells the page to reload
+	version := versionRegex.FindStringSubmatch(page)[1]
+	go func() {
+		time.Sleep(2 * internal.PreviewPollInterval)
+		module += "\n[//]: # (question)\nNo choices"
+		if err := os.WriteFile(path, []byte(module), 0644); err != nil {
+			t.Error(err)
+		}
+	}()
+	status, body = get("/events?version=" + version)
+	require.Equal(t, 200, status)
+	require.Contains(t, body, "event: reload")
+	status, body = get("/events?version=" + version)
+	require.Equal(t, 200, status)
+	require.Contains(t, body, "event: reload")
+
+	// Problems show alongside what did parse
+	status, page = get("/")
+	require.Equal(t, 200, status)
+	require.NotEqual(t, version, versionRegex.FindStringSubmatch(page)[1])
+	require.Contains(t, page, "Problems")
+	require.Contains(t, page, "question must be followed by choice")
+	require.Contains(t, page, "Everything")
+
+	require.Nil(t, os.Remove(path))
+	status, _ = get("/")
+	require.Equal(t, 404, status)
+}
+
+func TestSyncCourse(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	client.createCourse(course, modules)
+	courseId := int64(1)
+
+	getSummaries := func(client testClient) []protocol.ModuleSummary {
+		resp := client.noobClient().GetModuleSummaries(courseId)
+		require.Equal(t, 200, resp.StatusCode)
+		var summaries []protocol.ModuleSummary
+		require.Nil(t, json.Unmarshal([]byte(bodyText(t, resp)), &summaries))
+		return summaries
+	}
+
+	// The server's hash of an uploaded module is the hash of the file it came from
+	resp := client.noobClient().UploadModule(courseId, 1, jsonTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+	synced, err := protocol.Parse(jsonTestModule)
+	require.Nil(t, err)
+	summaries := getSummaries(client)
+	require.Len(t, summaries, 2)
+	require.Equal(t, protocol.ModuleSummary{Id: 1, Title: synced.Title, Hash: synced.Hash()}, summaries[0])
+	require.NotEqual(t, synced.Hash(), summaries[1].Hash)
+
+	newSource := "---\ntitle: New\ndescription: A new module\n---\nSome content"
+	newModule, err := protocol.Parse(newSource)
+	require.Nil(t, err)
+	manifest, err := protocol.ParseSyncManifest([]byte(`{"courseId": 1, "modules": [{"file": "a.md", "id": 1}, {"file": "b.md"}]}`))
+	require.Nil(t, err)
+	localModules := []protocol.Module{synced, newModule}
+
+	actions, err := protocol.PlanSync(manifest, localModules, summaries, false)
+	require.Nil(t, err)
+	require.Equal(t, []protocol.SyncAction{
+		protocol.NewSyncAction(protocol.SyncUnchanged, "a.md", 1, synced.Title),
+		protocol.NewSyncAction(protocol.SyncCreate, "b.md", 0, "New"),
+		protocol.NewSyncAction(protocol.SyncRemoved, "", 2, modules[1].Title),
+	}, actions)
+	actions, err = protocol.PlanSync(manifest, localModules, summaries, true)
+	require.Nil(t, err)
+	require.Equal(t, protocol.SyncDelete, actions[2].ActionType)
+
+	// Any change to what would be exported is an update
+	changed := synced
+	changed.Description = "Changed"
+	actions, err = protocol.PlanSync(manifest, []protocol.Module{changed, newModule}, summaries, false)
+	require.Nil(t, err)
+	require.Equal(t, protocol.SyncUpdate, actions[0].ActionType)
+
+	// Modules in the manifest have to be in the course
+	manifest.Modules[0].Id = 3
+	_, err = protocol.PlanSync(manifest, localModules, summaries, false)
+	require.NotNil(t, err)
+
+	for _, invalid := range []string{
+		`not json`,
+		`{"courseId": 1, "modules": [{"file": "a.md"}, {"file": "a.md"}]}`,
+		`{"courseId": 1, "modules": [{"file": "a.md", "id": 1}, {"file": "b.md", "id": 1}]}`,
+		`{"courseId": 1, "modules": [{"id": 1}]}`,
+	} {
+		_, err = protocol.ParseSyncManifest([]byte(invalid))
+		require.NotNil(t, err)
+	}
+
+	// Creating a module responds with its id, and deleting it removes it
+	resp = client.noobClient().CreateModule(courseId, newSource)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "3", bodyText(t, resp))
+	summaries = getSummaries(client)
+	require.Len(t, summaries, 3)
+	require.Equal(t, protocol.ModuleSummary{Id: 3, Title: "New", Hash: newModule.Hash()}, summaries[2])
+	resp = client.noobClient().CreateModule(courseId, "not a module")
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	resp = client.noobClient().DeleteModule(courseId, 2)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Len(t, getSummaries(client), 2)
+
+	// Other teachers can't see or add to the course
+	other := newTestClient(t).login(ctx.createUser().Id)
+	resp = other.noobClient().GetModuleSummaries(courseId)
+	require.NotEqual(t, 200, resp.StatusCode)
+	resp = other.noobClient().CreateModule(courseId, newSource)
+	require.NotEqual(t, 200, resp.StatusCode)
+	require.Len(t, getSummaries(client), 2)
+}
+
+func TestCli(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	client.createCourse(course, modules)
+	remote := cliRemoteFlags(t, user.Id)
+	remoteCli := func(command string, args ...string) cliResult {
+		return runCli(t, append(append([]string{command}, remote...), args...)...)
+	}
+	dir := t.TempDir()
+	writeFile := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	// Commands and their arguments are checked before anything runs
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"upload", "1", "1"},
+		{"upload", "course", "1", "m.md"},
+		{"export", "-format", "pdf", "1", "1"},
+		{"lint"},
+		{"lint", "-unknown", "m.md"},
+		{"lint", "-format", "xml", "m.md"},
+		{"sync", "a", "b"},
+	} {
+		result := runCli(t, args...)
+		require.Equal(t, 2, result.exitCode, args)
+		require.Empty(t, result.stdout, args)
+	}
+	result := runCli(t, "upload", "course", "1", "m.md")
+	require.Contains(t, result.stderr, "course_id must be an integer")
+	require.Contains(t, result.stderr, "Usage: noobular upload")
+	result = runCli(t, "help")
+	require.Equal(t, 0, result.exitCode)
+	require.Contains(t, result.stdout, "sync")
+	result = runCli(t, "help", "export")
+	require.Equal(t, 0, result.exitCode)
+	require.Contains(t, result.stderr, "-format")
+
+	// Modules are uploaded as markdown or JSON, by their extension, and exported back
+	markdownPath := writeFile("m.md", testModule)
+	result = remoteCli("upload", "1", "1", markdownPath)
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	result = remoteCli("export", "1", "1")
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	require.Equal(t, strings.TrimSpace(testModule), strings.TrimSpace(result.stdout))
+	jsonModule, err := protocol.Parse(jsonTestModule)
+	require.Nil(t, err)
+	jsonSource, err := jsonModule.JSON()
+	require.Nil(t, err)
+	jsonPath := writeFile("m.json", string(jsonSource))
+	result = remoteCli("upload", "1", "2", jsonPath)
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	result = remoteCli("export", "-format", "json", "1", "2")
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	exported, err := protocol.ParseJSON([]byte(result.stdout))
+	require.Nil(t, err)
+	require.Equal(t, jsonModule.Hash(), exported.Hash())
+
+	// What the server rejects is a failure
+	result = remoteCli("upload", "1", "99", markdownPath)
+	require.Equal(t, 1, result.exitCode)
+	require.Contains(t, result.stderr, "upload failed")
+
+	// Lint reports each problem with where it is
+	result = runCli(t, "lint", markdownPath)
+	require.Equal(t, 0, result.exitCode, result.stdout)
+	require.Empty(t, result.stdout)
+	noTitlePath := writeFile("no_title.md", "---\ndescription: d\n---\n[//]: # (content)\nc")
+	result = runCli(t, "lint", markdownPath, noTitlePath)
+	require.Equal(t, 1, result.exitCode)
+	require.True(t, strings.HasPrefix(result.stdout, noTitlePath+":"), result.stdout)
+	result = runCli(t, "lint", "-format", "json", noTitlePath)
+	require.Equal(t, 1, result.exitCode)
+	var problems []map[string]any
+	require.Nil(t, json.Unmarshal([]byte(result.stdout), &problems))
+	require.Len(t, problems, 1)
+	require.Equal(t, noTitlePath, problems[0]["file"])
+
+	// Fmt rewrites files in canonical form
+	result = runCli(t, "fmt", markdownPath)
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	formatted, err := internal.FormatModule(testModule)
+	require.Nil(t, err)
+	data, err := os.ReadFile(markdownPath)
+	require.Nil(t, err)
+	require.Equal(t, formatted, string(data))
+
+	// Import prints a question bank as a module named after the file
+	result = runCli(t, "import", writeFile("geography.gift", giftTestQuestions))
+	imported, err := protocol.Parse(result.stdout)
+	require.Nil(t, err, result.stdout)
+	require.Equal(t, "geography", imported.Title)
+
+	// Sync uploads what changed in a course directory, and records the ids of new modules
+	syncDir := filepath.Join(dir, "course")
+	require.Nil(t, os.Mkdir(syncDir, 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(syncDir, "a.md"), []byte(formatted), 0644))
+	newModule := protocol.NewModule("New", "A new module", jsonModule.Blocks)
+	newSource, err := newModule.JSON()
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(syncDir, "b.json"), newSource, 0644))
+	manifestPath := filepath.Join(syncDir, protocol.SyncManifestFilename)
+	manifest := `{"courseId": 1, "modules": [{"file": "a.md", "id": 1}, {"file": "b.json"}]}`
+	require.Nil(t, os.WriteFile(manifestPath, []byte(manifest), 0644))
+	result = remoteCli("sync", "-dry-run", syncDir)
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	require.Contains(t, result.stdout, "create    b.json")
+	require.Contains(t, result.stdout, "removed")
+	data, err = os.ReadFile(manifestPath)
+	require.Nil(t, err)
+	require.Equal(t, manifest, string(data))
+
+	result = remoteCli("sync", syncDir)
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	data, err = os.ReadFile(manifestPath)
+	require.Nil(t, err)
+	synced, err := protocol.ParseSyncManifest(data)
+	require.Nil(t, err)
+	require.Equal(t, int64(3), synced.Modules[1].Id)
+	result = remoteCli("export", "-format", "json", "1", "3")
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	exported, err = protocol.ParseJSON([]byte(result.stdout))
+	require.Nil(t, err)
+	require.Equal(t, newModule.Hash(), exported.Hash())
+
+	// Once synced, nothing has changed
+	result = remoteCli("sync", syncDir)
+	require.Equal(t, 0, result.exitCode, result.stderr)
+	require.NotContains(t, result.stdout, "create")
+	require.NotContains(t, result.stdout, "update")
+	require.Contains(t, result.stdout, "unchanged b.json")
+}
+
+func TestAccessToken(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	teacher := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	teacher.createCourse(course, modules)
+	courseId := int64(1)
+	moduleId := int64(1)
+	tokenRegex := regexp.MustCompile(db.AccessTokenPrefix + `[0-9a-f]{64}`)
+
+	createToken := func(name string, scope db.TokenScope) string {
+		form := url.Values{}
+		form.Set("name", name)
+		form.Set("scope", string(scope))
+		resp := teacher.post("/account/token", form.Encode())
+		require.Equal(t, 200, resp.StatusCode)
+		secret := tokenRegex.FindString(bodyText(t, resp))
+		require.NotEmpty(t, secret)
+		return secret
+	}
+	writeSecret := createToken("CI", db.CourseWriteTokenScope)
+	readSecret := createToken("Backups", db.CourseReadTokenScope)
+	writeClient := noob_client.NewTokenClient(teacher.baseUrl, writeSecret)
+	readClient := noob_client.NewTokenClient(teacher.baseUrl, readSecret)
+
+	// Tokens are listed without the token itself, and haven't been used yet
+	body := teacher.getPageBody("/account")
+	require.Contains(t, body, "CI")
+	require.Contains(t, body, "Backups")
+	require.NotContains(t, body, writeSecret)
+	require.Equal(t, 2, strings.Count(body, "Never"))
+
+	// Write tokens can upload, read tokens can only export
+	source := "---\ntitle: From CI\ndescription: Uploaded with a token\n---\nSome content"
+	resp := writeClient.UploadModule(courseId, moduleId, source)
+	require.Equal(t, 200, resp.StatusCode)
+	resp = readClient.ExportModule(courseId, moduleId, "markdown")
+	require.Equal(t, 200, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "From CI")
+	resp = readClient.UploadModule(courseId, moduleId, source)
+	require.Equal(t, 403, resp.StatusCode)
+	require.Equal(t, 0, strings.Count(teacher.getPageBody("/account"), "Never"))
+
+	// Invalid tokens fail rather than redirecting to sign in
+	for _, secret := range []string{"nonsense", db.AccessTokenPrefix + strings.Repeat("0", 64)} {
+		resp = noob_client.NewTokenClient(teacher.baseUrl, secret).ExportModule(courseId, moduleId, "markdown")
+		require.Equal(t, 401, resp.StatusCode)
+	}
+	// Tokens only work for the routes tools use, not e.g. making more tokens
+	req, err := http.NewRequest("GET", teacher.baseUrl+"/account", nil)
+	require.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+writeSecret)
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.NotContains(t, bodyText(t, resp), "Access Tokens")
+
+	// Tokens act as their user, so can't touch other users' courses
+	other := newTestClient(t).login(ctx.createUser().Id)
+	other.createCourse(course, modules)
+	resp = writeClient.UploadModule(2, 3, source)
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	// Only the token's user can revoke it, and revoked tokens stop working
+	tokens, err := ctx.db.GetAccessTokens(user.Id)
+	require.Nil(t, err)
+	require.Len(t, tokens, 2)
+	resp = other.delete(fmt.Sprintf("/account/token/%d", tokens[0].Id))
+	require.NotEqual(t, 200, resp.StatusCode)
+	resp = teacher.delete(fmt.Sprintf("/account/token/%d", tokens[0].Id))
+	require.Equal(t, 200, resp.StatusCode)
+	resp = writeClient.UploadModule(courseId, moduleId, source)
+	require.Equal(t, 401, resp.StatusCode)
+	resp = readClient.ExportModule(courseId, moduleId, "markdown")
+	require.Equal(t, 200, resp.StatusCode)
+
+	for _, form := range []url.Values{
+		{"name": {""}, "scope": {string(db.CourseWriteTokenScope)}},
+		{"name": {strings.Repeat("a", internal.TitleMaxLength+1)}, "scope": {string(db.CourseWriteTokenScope)}},
+		{"name": {"Admin"}, "scope": {"admin"}},
+	} {
+		resp = teacher.post("/account/token", form.Encode())
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	require.Nil(t, os.WriteFile(certPath, []byte("cert"), 0644))
+	require.Nil(t, os.WriteFile(keyPath, []byte("key"), 0644))
+	writeConfig := func(text string) string {
+		path := filepath.Join(dir, "config.yaml")
+		require.Nil(t, os.WriteFile(path, []byte(text), 0644))
+		return path
+	}
+	getenv := func(env map[string]string) func(string) string {
+		return func(name string) string { return env[name] }
+	}
+
+	// The environment overrides the file, which overrides the defaults
+	path := writeConfig(fmt.Sprintf(`env: production
+dbPath: staging.db
+addr: ":8443"
+baseUrl: https://staging.noobular.com
+certPath: %s
+keyPath: %s
+limits:
+  maxBlocks: 8
+`, certPath, keyPath))
+	config, err := internal.LoadConfig(path, getenv(map[string]string{
+		"NOOBULAR_ADDR":    "127.0.0.1:9000",
+		"NOOBULAR_ORIGINS": "https://staging.noobular.com, https://preview.noobular.com",
+		"NOOBULAR_LOG_LEVEL": "debug",
+	}))
+	require.Nil(t, err)
+	config = config.WithDefaults()
+	require.Nil(t, config.Validate())
+	require.Equal(t, internal.Production, config.Env)
+	require.Equal(t, "staging.db", config.DbPath)
+	require.Equal(t, "127.0.0.1:9000", config.Addr)
+	require.Equal(t, "staging.noobular.com", config.RPID)
+	require.Equal(t, []string{"https://staging.noobular.com", "https://preview.noobular.com"}, config.Origins)
+	require.Equal(t, 8, config.Limits.MaxBlocks)
+	require.Equal(t, internal.MaxModules, config.Limits.MaxModules)
+	require.Equal(t, "debug", config.Log.Level)
+	require.Equal(t, internal.RedactedFormLogging, config.Log.Forms)
+	// Lists from flags are split the same way, so a trailing comma doesn't make an empty origin
+	require.Equal(t, []string{"https://a.noobular.com", "https://b.noobular.com"}, internal.SplitList(" https://a.noobular.com ,https://b.noobular.com,"))
+
+	// No config at all is a local server
+	config, err = internal.LoadConfig("", getenv(nil))
+	require.Nil(t, err)
+	config = config.WithDefaults()
+	require.Nil(t, config.Validate())
+	require.Equal(t, internal.Local, config.Env)
+	require.Equal(t, ":8080", config.Addr)
+	require.Equal(t, []string{"http://localhost:8080"}, config.Origins)
+	require.Equal(t, internal.DefaultLimits(), config.Limits)
+	config, err = internal.LoadConfig(writeConfig(""), getenv(nil))
+	require.Nil(t, err)
+	require.Equal(t, internal.DefaultLimits(), config.Limits)
+
+	// Mistakes are caught before the server starts
+	_, err = internal.LoadConfig(writeConfig("prot: 8080"), getenv(nil))
+	require.NotNil(t, err)
+	_, err = internal.LoadConfig(filepath.Join(dir, "missing.yaml"), getenv(nil))
+	require.NotNil(t, err)
+	_, err = internal.LoadConfig("", getenv(map[string]string{"PORT": "http"}))
+	require.NotNil(t, err)
+	for _, text := range []string{
+		"env: staging",
+		"addr: 8080",
+		"baseUrl: noobular.com",
+		"origins: [noobular.com]",
+		"env: production",
+		fmt.Sprintf("env: production\ncertPath: %s\nkeyPath: %s", certPath, filepath.Join(dir, "missing.pem")),
+		"limits:\n  maxBlocks: 0",
+		"limits:\n  maxAssetLength: -1",
+	} {
+		config, err = internal.LoadConfig(writeConfig(text), getenv(nil))
+		require.Nil(t, err)
+		require.NotNil(t, config.WithDefaults().Validate(), text)
+	}
+
+	// Servers enforce their own limits
+	limits := internal.DefaultLimits()
+	limits.MaxBlocks = 1
+	limits.MaxModules = 2
+	ctx := startServerWithLimits(t, limits)
+	defer ctx.Close()
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	client.createCourse(course, modules)
+	oneBlock := "---\ntitle: t\ndescription: d\n---\n[//]: # (content)\nSome content"
+	twoBlocks := oneBlock + "\n[//]: # (content)\nMore content"
+	resp := client.noobClient().UploadModule(1, 1, oneBlock)
+	require.Equal(t, 200, resp.StatusCode)
+	resp = client.noobClient().UploadModule(1, 1, twoBlocks)
+	require.NotEqual(t, 200, resp.StatusCode)
+	resp = client.noobClient().CreateModule(1, oneBlock)
+	require.NotEqual(t, 200, resp.StatusCode)
+}
+
+func TestLogging(t *testing.T) {
+	logs := &syncBuffer{}
+	logger, err := internal.NewLogger(logs, internal.LogConfig{Level: "info", Format: internal.JSONLogFormat, Forms: internal.RedactedFormLogging})
+	require.Nil(t, err)
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(defaultLogger)
+
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, modules := sampleCreateCourseInput()
+	client.createCourse(course, modules)
+
+	requestId := "8c4f2c4e-52a4-4b4e-9f5e-1c2d3e4f5a6b"
+	headers := map[string]string{"X-Request-Id": requestId}
+	resp := client.requestWithHeaders("POST", "/teacher/course/1/knowledge-point", "name=Loops", headers)
+	require.Equal(t, 200, resp.StatusCode)
+	resp = client.requestWithHeaders("POST", "/teacher/course/1/knowledge-point", "name=Loops", headers)
+	require.Equal(t, 409, resp.StatusCode)
+
+	// Every line about the request has its id, route and user
+	requestLines := func() []map[string]interface{} {
+		lines := []map[string]interface{}{}
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var record map[string]interface{}
+			require.Nil(t, json.Unmarshal([]byte(line), &record), line)
+			if record["requestId"] == requestId {
+				lines = append(lines, record)
+			}
+		}
+		return lines
+	}
+	require.Eventually(t, func() bool { return len(requestLines()) == 3 }, time.Second, 10*time.Millisecond)
+	lines := requestLines()
+	for _, record := range lines {
+		require.Equal(t, "/teacher/course/{courseId}/knowledge-point", record["route"])
+		require.Equal(t, float64(user.Id), record["userId"])
+	}
+	require.Equal(t, "Request", lines[0]["msg"])
+	require.Equal(t, float64(200), lines[0]["status"])
+	require.Contains(t, lines[0], "latencyMs")
+	require.Equal(t, "Handler error", lines[1]["msg"])
+	require.Equal(t, "conflict", lines[1]["kind"])
+	require.Equal(t, float64(409), lines[2]["status"])
+
+	// Form values are redacted
+	require.Equal(t, map[string]interface{}{"name": []interface{}{"[5 bytes]"}}, lines[0]["form"])
+	require.NotContains(t, logs.String(), modules[0].Title)
+	require.NotContains(t, logs.String(), modules[0].Description)
+
+	_, err = internal.NewLogger(logs, internal.LogConfig{Level: "loud", Format: internal.JSONLogFormat, Forms: internal.RedactedFormLogging})
+	require.NotNil(t, err)
+	_, err = internal.NewLogger(logs, internal.LogConfig{Level: "info", Format: internal.JSONLogFormat, Forms: "some"})
+	require.NotNil(t, err)
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := newTestClient(t).login(ctx.createUser().Id)
+	course, modules := sampleCreateCourseInput()
+	teacher.createCourse(course, modules)
+	courseId, moduleId := 1, 1
+	module := "---\ntitle: t\ndescription: d\n---\n[//]: # (question)\nq\n[//]: # (choice correct)\nc\n[//]: # (choice)\nd"
+	resp := teacher.noobClient().UploadModule(int64(courseId), int64(moduleId), module)
+	require.Equal(t, 200, resp.StatusCode)
+
+	student := newTestClient(t).login(ctx.createUser().Id)
+	student.enrollCourse(courseId)
+	body := student.getPageBody(takeModulePageRoute(courseId, moduleId))
+	resp = student.post(answerQuestionRoute(courseId, moduleId, 0), url.Values{"choice": {choiceIds(t, body)[0]}}.Encode())
+	require.Equal(t, 200, resp.StatusCode)
+	student.completeModule(courseId, moduleId)
+	student.getPageFail("/nope")
+
+	metrics := ctx.metricsText()
+	for _, line := range []string{
+		`noobular_http_requests_total{route="/teacher/course/create",method="POST",status="200"} 1`,
+		`noobular_http_requests_total{route="/",method="GET",status="404"} 1`,
+		`noobular_http_request_duration_seconds_count{route="/student/course/{courseId}/module/{moduleId}",method="GET"} 1`,
+		`noobular_template_render_duration_seconds_count{file="take_module.html",template="page.html"} 1`,
+		`noobular_signups_total 0`,
+		`noobular_enrollments_total 1`,
+		`noobular_answers_submitted_total 1`,
+		`noobular_modules_completed_total 1`,
+		// One block, all correct, with a bonus that rounds down
+		`noobular_points_awarded_total 1`,
+	} {
+		require.Contains(t, metrics, line+"\n")
+	}
+	// Queries are labeled by the function that made them, whether a DbClient
+	// method or one run in a transaction
+	require.Regexp(t, `noobular_db_query_duration_seconds_count\{method="GetUser"\} \d+`, metrics)
+	require.Regexp(t, `noobular_db_query_duration_seconds_count\{method="InsertModuleVersion"\} \d+`, metrics)
+	require.Regexp(t, `noobular_db_query_duration_seconds_count\{method="InsertBlock"\} \d+`, metrics)
+	require.NotContains(t, metrics, `method="unknown"`)
+	require.Contains(t, metrics, `noobular_http_request_duration_seconds_bucket{route="/teacher/course/create",method="POST",le="+Inf"} 1`)
+
+	// Metrics aren't public, they go on their own server
+	resp = newTestClient(t).get("/metrics")
+	require.Equal(t, 404, resp.StatusCode)
+	metricsServer := internal.NewMetricsServer("localhost:9090", ctx.metrics)
+	go metricsServer.ListenAndServe()
+	defer metricsServer.Close()
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://localhost:9090/metrics")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode == 200
+	}, time.Second, 10*time.Millisecond)
+	config := internal.Config{MetricsAddr: "localhost:9090", Limits: internal.DefaultLimits()}.WithDefaults()
+	require.Nil(t, config.Validate())
+	config.MetricsAddr = config.Addr
+	require.NotNil(t, config.Validate())
+}
+
+func TestModuleQueryCount(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := newTestClient(t).login(ctx.createUser().Id)
+	course, modules := sampleCreateCourseInput()
+	teacher.createCourse(course, modules)
+	courseId, smallModuleId, largeModuleId := 1, 1, 2
+	smallModule := "---\ntitle: t\ndescription: d\n---\n[//]: # (content)\nc\n[//]: # (question)\nq\n[//]: # (choice correct)\nc\n[//]: # (choice)\nd"
+	resp := teacher.noobClient().UploadModule(int64(courseId), int64(smallModuleId), smallModule)
+	require.Equal(t, 200, resp.StatusCode)
+	resp = teacher.noobClient().UploadModule(int64(courseId), int64(largeModuleId), jsonTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var queries atomic.Int64
+	ctx.db.SetQueryObserver(func(method string, duration time.Duration) {
+		queries.Add(1)
+	})
+	countQueries := func(client testClient, method string, path string) int64 {
+		queries.Store(0)
+		resp := client.request(method, path, "")
+		require.Equal(t, 200, resp.StatusCode)
+		return queries.Load()
+	}
+
+	// Loading a module takes the same queries however many blocks it has,
+	// once its content is rendered and cached
+	for _, moduleId := range []int{smallModuleId, largeModuleId} {
+		teacher.getPageBody(fmt.Sprintf("/teacher/course/%d/module/%d/preview", courseId, moduleId))
+	}
+	teacherRoutes := func(moduleId int) []string {
+		return []string{
+			noob_client.EditModuleRoute(int64(courseId), int64(moduleId)),
+			fmt.Sprintf("/teacher/course/%d/module/%d/preview", courseId, moduleId),
+			exportModuleRoute(courseId, moduleId),
+			exportModuleRoute(courseId, moduleId) + "?format=json",
+		}
+	}
+	largeRoutes := teacherRoutes(largeModuleId)
+	for i, route := range teacherRoutes(smallModuleId) {
+		require.Equal(t, countQueries(teacher, "GET", route), countQueries(teacher, "GET", largeRoutes[i]), route)
+	}
+
+	student := newTestClient(t).login(ctx.createUser().Id)
+	student.enrollCourse(courseId)
+	student.getPageBody(takeModulePageRoute(courseId, smallModuleId))
+	student.getPageBody(takeModulePageRoute(courseId, largeModuleId))
+	for blockIdx := 1; blockIdx < 6; blockIdx++ {
+		student.getPageBody(takeModulePieceRoute(courseId, largeModuleId, blockIdx))
+	}
+	student.getPageBody(takeModulePieceRoute(courseId, smallModuleId, 1))
+	require.Equal(t,
+		countQueries(student, "GET", takeModulePageRoute(courseId, smallModuleId)),
+		countQueries(student, "GET", takeModulePageRoute(courseId, largeModuleId)))
+	require.Equal(t,
+		countQueries(student, "PUT", completeModuleRoute(courseId, smallModuleId)),
+		countQueries(student, "PUT", completeModuleRoute(courseId, largeModuleId)))
+
+	// And so does a course however many modules it has
+	coursePageQueries := countQueries(student, "GET", studentCoursePageRoute(courseId))
+	browseQueries := countQueries(student, "GET", "/browse")
+	course, modules = sampleCreateCourseInputN(2)
+	modules = append(modules, modules...)
+	teacher.createCourse(course, modules)
+	for moduleId := 3; moduleId < 3+len(modules); moduleId++ {
+		resp = teacher.noobClient().UploadModule(2, int64(moduleId), smallModule)
+		require.Equal(t, 200, resp.StatusCode)
+	}
+	student.enrollCourse(2)
+	require.Equal(t, coursePageQueries, countQueries(student, "GET", studentCoursePageRoute(2)))
+	require.Equal(t, browseQueries, countQueries(student, "GET", "/browse"))
+	for _, route := range []string{
+		"/teacher/course/%d/module",
+		"/teacher/course/%d/export",
+		internal.ApiPrefix + "/courses/%d/modules",
+		internal.ApiPrefix + "/courses/%d/prereqs",
+	} {
+		require.Equal(t,
+			countQueries(teacher, "GET", fmt.Sprintf(route, 1)),
+			countQueries(teacher, "GET", fmt.Sprintf(route, 2)), route)
+	}
+}
+
+func TestContentCache(t *testing.T) {
+	dbClient := db.NewMemoryDbClient()
+	defer dbClient.Close()
+	// Only stored content is stored rendered
+	storeContent := func(text string) db.Content {
+		tx, err := dbClient.Begin()
+		require.Nil(t, err)
+		id, err := db.InsertContent(tx, text)
+		require.Nil(t, err)
+		require.Nil(t, tx.Commit())
+		return db.NewContent(int(id), text)
+	}
+	content := storeContent("Some $x^2$ math")
+	uncached, err := internal.NewUiContentRendered(content)
+	require.Nil(t, err)
+	require.Contains(t, string(uncached.ContentTmpl), "katex")
+
+	metrics := internal.NewMetrics()
+	cache := internal.NewContentCache(dbClient, 2, metrics)
+	for i := 0; i < 2; i++ {
+		rendered, err := cache.Render(content)
+		require.Nil(t, err)
+		require.Equal(t, uncached.ContentTmpl, rendered.ContentTmpl)
+		require.Equal(t, content.Id, rendered.Id)
+	}
+	html, ok, err := dbClient.GetRenderedContent(db.ContentHash(content.Content), internal.RendererVersion)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, string(uncached.ContentTmpl), html)
+
+	// Once it's out of memory, it comes from the db
+	for _, text := range []string{"a", "b"} {
+		_, err = cache.Render(storeContent(text))
+		require.Nil(t, err)
+	}
+	hash := db.ContentHash(content.Content)
+	require.Nil(t, dbClient.StoreRenderedContent(hash, internal.RendererVersion, "<p>cached</p>"))
+	rendered, err := cache.Render(content)
+	require.Nil(t, err)
+	require.Equal(t, "<p>cached</p>", string(rendered.ContentTmpl))
+
+	// A request's view of the cache shares what's in memory
+	rendered, err = cache.WithContext(context.Background()).Render(content)
+	require.Nil(t, err)
+	require.Equal(t, "<p>cached</p>", string(rendered.ContentTmpl))
+
+	// What older renderer versions rendered is cleared out and rendered again
+	require.Nil(t, dbClient.StoreRenderedContent(hash, internal.RendererVersion-1, "<p>stale</p>"))
+	rendered, err = internal.NewContentCache(dbClient, 2, metrics).Render(content)
+	require.Nil(t, err)
+	require.Equal(t, uncached.ContentTmpl, rendered.ContentTmpl)
+
+	var buf bytes.Buffer
+	require.Nil(t, metrics.WriteText(&buf))
+	for _, line := range []string{
+		`noobular_content_cache_lookups_total{result="db"} 1`,
+		`noobular_content_cache_lookups_total{result="memory"} 2`,
+		`noobular_content_cache_lookups_total{result="miss"} 4`,
+	} {
+		require.Contains(t, buf.String(), line+"\n")
+	}
+
+	// Module pages render each content once
+	ctx := startServer(t)
+	defer ctx.Close()
+	teacher := newTestClient(t).login(ctx.createUser().Id)
+	course, modules := sampleCreateCourseInput()
+	teacher.createCourse(course, modules)
+	resp := teacher.noobClient().UploadModule(1, 1, jsonTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+	preview := fmt.Sprintf("/teacher/course/%d/module/%d/preview", 1, 1)
+	teacher.getPageBody(preview)
+	body := ctx.metricsText()
+	misses := regexp.MustCompile(`noobular_content_cache_lookups_total\{result="miss"\} (\d+)`).FindStringSubmatch(body)
+	require.NotNil(t, misses)
+	teacher.getPageBody(preview)
+	body = ctx.metricsText()
+	require.Contains(t, body, `noobular_content_cache_lookups_total{result="miss"} `+misses[1]+"\n")
+	require.Regexp(t, `noobular_content_cache_lookups_total\{result="memory"\} \d+`, body)
+}
+
+func TestLintModule(t *testing.T) {
+	module := `---
+title: t
+description: d
+---
+stray text
+[//]: # (content)
+Some $\frac{1}{$ math
+
+[//]: # (question)
+No correct choice
+[//]: # (choice)
+c
+
+[//]: # (note to self)
+[//]: # (question: bogus)
+Unknown type
+[//]: # (choice correct)
+c
+
+[//]: # (question)
+` + strings.Repeat("a", internal.MaxQuestionLength+1) + `
+[//]: # (choice correct)
+c`
+	diagnostics := internal.LintModule(module, internal.DefaultLimits())
+	lines := []string{}
+	for _, diagnostic := range diagnostics {
+		lines = append(lines, fmt.Sprintf("%d:%d: %s", diagnostic.Line, diagnostic.Column, diagnostic.Message))
+	}
+	require.Equal(t, []string{
+		"5:1: text before the first marker is ignored",
+		"7:7: KaTeX parse error: Expected '}', got 'EOF' at end of input: \\frac{1}{",
+		"9:1: Each question must have a correct choice",
+		"14:1: unknown marker [//]: # (note to self) is ignored",
+		"15:1: unknown question type: bogus",
+		"20:1: Questions cannot be longer than 2048 characters",
+	}, lines)
+
+	// Modules that can be uploaded are clean, and so is their canonical form
+	require.Empty(t, internal.LintModule(testModule, internal.DefaultLimits()))
+	formatted, err := internal.FormatModule(testModule)
+	require.Nil(t, err)
+	require.Empty(t, internal.LintModule(formatted, internal.DefaultLimits()))
+
+	// Modules are checked against the configured limits
+	limits := internal.DefaultLimits()
+	limits.MaxBlocks = 1
+	limits.MaxQuestionLength = 4
+	diagnostics = internal.LintModule(testModule, limits)
+	for _, message := range []string{"Cannot have more than 1 blocks", "Questions cannot be longer than 4 characters"} {
+		require.True(t, slices.ContainsFunc(diagnostics, func(d protocol.Diagnostic) bool { return d.Message == message }), message)
+	}
+
+	// Upload errors say where the problem is too
+	_, err = protocol.Parse(module)
+	require.ErrorContains(t, err, "line 15, column 1: unknown question type: bogus")
+}
+
+func TestFormatModule(t *testing.T) {
+	module := "---\ntitle: t\ndescription: d\n---\n[//]: # (question)\nq\n[//]: # (choice correct)\n1\n[//]: # (choice correct)\n2"
+	formatted, err := internal.FormatModule(module)
+	require.Nil(t, err)
+	require.Equal(t, "---\ntitle: t\ndescription: d\n---\n\n\n[//]: # (question: multi_select)\nq\n\n[//]: # (choice correct)\n1\n\n[//]: # (choice correct)\n2\n", formatted)
+
+	// Formatting is idempotent
+	again, err := internal.FormatModule(formatted)
+	require.Nil(t, err)
+	require.Equal(t, formatted, again)
+
+	_, err = internal.FormatModule("[//]: # (content)\nno metadata")
+	require.NotNil(t, err)
+}
+
+func TestExportImportCourse(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, _, _ := client.initTestCourse()
+	client.setPrereqs(course.Id, 2, []int{1})
+	resp := client.noobClient().CreateKnowledgePoint(int64(course.Id), "standalone kp")
+	require.Equal(t, 200, resp.StatusCode)
+
+	resp = client.noobClient().ExportCourse(int64(course.Id))
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	bundleBytes := []byte(bodyText(t, resp))
+	bundle := readBundle(t, bundleBytes)
+	require.Equal(t, course.Title, bundle.Title)
+	require.Equal(t, course.Description, bundle.Description)
+	require.True(t, bundle.Public)
+	require.Len(t, bundle.Modules, 2)
+	require.Equal(t, []protocol.Prereq{protocol.NewPrereq(1, 0)}, bundle.Prereqs)
+	require.Contains(t, bundle.KnowledgePoints, "standalone kp")
+	for i, module := range bundle.Modules {
+		exported := client.getPageBody(exportModuleRoute(course.Id, i+1))
+		require.Equal(t, strings.TrimSpace(exported), module.Markdown())
+	}
+
+	// Import as another user, and the new course should export the same bundle
+	user2 := ctx.createUser()
+	client2 := newTestClient(t).login(user2.Id)
+	resp = client2.noobClient().ImportCourse(bundleBytes)
+	require.Equal(t, 200, resp.StatusCode)
+	newCourseId := int64(2)
+	body := client2.getPageBody("/teacher")
+	require.Contains(t, body, course.Title)
+	resp = client2.noobClient().ExportCourse(newCourseId)
+	require.Equal(t, 200, resp.StatusCode)
+	bundle2 := readBundle(t, []byte(bodyText(t, resp)))
+	require.Equal(t, bundle, bundle2)
+	knowledgePoints, err := ctx.db.GetKnowledgePoints(newCourseId)
+	require.Nil(t, err)
+	require.Equal(t, len(bundle.KnowledgePoints), len(knowledgePoints))
+
+	// Can't export someone else's course
+	resp = client2.noobClient().ExportCourse(int64(course.Id))
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	// Bad bundles are rejected as a whole
+	resp = client2.noobClient().ImportCourse([]byte("not a zip"))
+	require.NotEqual(t, 200, resp.StatusCode)
+	cyclic := bundle
+	cyclic.Prereqs = []protocol.Prereq{protocol.NewPrereq(0, 1), protocol.NewPrereq(1, 0)}
+	invalidModule := bundle
+	invalidModule.Modules = []protocol.Module{protocol.NewModule("", "description", nil)}
+	invalidModule.Prereqs = nil
+	for _, badBundle := range []protocol.Course{cyclic, invalidModule} {
+		var buf bytes.Buffer
+		require.Nil(t, protocol.WriteBundle(&buf, badBundle))
+		resp = client2.noobClient().ImportCourse(buf.Bytes())
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+
+	// Files that decompress to more than the limits are rejected before they're read
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	manifestWriter, err := zw.Create(protocol.ManifestFilename)
+	require.Nil(t, err)
+	_, err = manifestWriter.Write([]byte(`{"title":"bomb","description":"d","modules":["modules/001.md"]}`))
+	require.Nil(t, err)
+	moduleWriter, err := zw.Create("modules/001.md")
+	require.Nil(t, err)
+	_, err = moduleWriter.Write(bytes.Repeat([]byte("a"), internal.DefaultLimits().MaxModuleSourceLength+1))
+	require.Nil(t, err)
+	require.Nil(t, zw.Close())
+	resp = client2.noobClient().ImportCourse(buf.Bytes())
+	require.Equal(t, 400, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "modules/001.md is longer than")
+
+	// So are manifests listing more than the limits allow, or a file more than once
+	limits := internal.DefaultLimits()
+	manifestBundle := func(manifest string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		manifestWriter, err := zw.Create(protocol.ManifestFilename)
+		require.Nil(t, err)
+		_, err = manifestWriter.Write([]byte(manifest))
+		require.Nil(t, err)
+		moduleWriter, err := zw.Create("modules/001.md")
+		require.Nil(t, err)
+		_, err = moduleWriter.Write([]byte(bundle.Modules[0].Markdown()))
+		require.Nil(t, err)
+		require.Nil(t, zw.Close())
+		return buf.Bytes()
+	}
+	tooManyModules := strings.Repeat(`"modules/001.md",`, limits.MaxModules) + `"modules/001.md"`
+	tooManyAssets := strings.Repeat(`{"file":"a.png","contentType":"image/png"},`, limits.MaxAssets) + `{"file":"a.png","contentType":"image/png"}`
+	kpNames := make([]string, limits.MaxKnowledgePoints()+1)
+	for i := range kpNames {
+		kpNames[i] = strconv.Quote(fmt.Sprintf("kp %d", i))
+	}
+	for _, bad := range []struct{ manifest, message string }{
+		{`{"title":"t","description":"d","modules":[` + tooManyModules + `]}`, "more than 128 modules"},
+		{`{"title":"t","description":"d","modules":[],"assets":[` + tooManyAssets + `]}`, "more than 64 assets"},
+		{`{"title":"t","description":"d","modules":["modules/001.md","modules/001.md"]}`, "listed more than once"},
+		{`{"title":"t","description":"d","modules":["modules/001.md"],"assets":[{"file":"modules/001.md","contentType":"image/png"}]}`, "listed more than once"},
+		{`{"title":"t","description":"d","modules":[],"knowledgePoints":[` + strings.Join(kpNames, ",") + `]}`, "knowledge points"},
+		{`{"title":"t","description":"d","modules":[],"knowledgePoints":["` + strings.Repeat("a", limits.MaxTitleLength+1) + `"]}`, "Knowledge point names cannot be longer"},
+	} {
+		resp = client2.noobClient().ImportCourse(manifestBundle(bad.manifest))
+		require.Equal(t, 400, resp.StatusCode)
+		require.Contains(t, bodyText(t, resp), bad.message)
+	}
+
+	courses, err := ctx.db.GetTeacherCourses(user2.Id)
+	require.Nil(t, err)
+	require.Len(t, courses, 1)
+}
+
+func TestAsset(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	user := ctx.createUser()
+	client := newTestClient(t).login(user.Id)
+	course, _, _ := client.initTestCourse()
+	courseId := int64(course.Id)
+
+	png := []byte("\x89PNG\r\n\x1a\n not really an image")
+	resp := client.noobClient().UploadAsset(courseId, png)
+	require.Equal(t, 200, resp.StatusCode)
+	asset := protocol.NewAsset("image/png", png)
+	require.Equal(t, asset.Reference(), bodyText(t, resp))
+
+	// Uploading the same file again gives the same reference
+	resp = client.noobClient().UploadAsset(courseId, png)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, asset.Reference(), bodyText(t, resp))
+
+	// Anyone can get it, and it can be cached forever
+	resp = newTestClient(t).get(noob_client.AssetRoute(asset.Hash()))
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	require.Contains(t, resp.Header.Get("Cache-Control"), "immutable")
+	require.Equal(t, string(png), bodyText(t, resp))
+	resp = newTestClient(t).get(noob_client.AssetRoute(protocol.AssetHash([]byte("missing"))))
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	// Modules reference it by hash, which renders as where it's served
+	moduleId := int64(1)
+	module := "---\ntitle: t\ndescription: d\n---\n[//]: # (content)\n![diagram](" + asset.Reference() + ")"
+	resp = client.noobClient().UploadModule(courseId, moduleId, module)
+	require.Equal(t, 200, resp.StatusCode)
+	body := client.getPageBody(fmt.Sprintf("/teacher/course/%d/module/%d/preview", courseId, moduleId))
+	require.Contains(t, body, noob_client.AssetRoute(asset.Hash()))
+
+	// Exporting the module as a zip includes its assets
+	resp = client.get(exportModuleRoute(int(courseId), int(moduleId)) + "?format=zip")
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	archive := []byte(bodyText(t, resp))
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	require.Nil(t, err)
+	files := []string{}
+	for _, file := range zr.File {
+		files = append(files, file.Name)
+	}
+	require.ElementsMatch(t, []string{protocol.ModuleArchiveFilename, asset.Filename()}, files)
+
+	// Exporting and importing the course carries its assets
+	resp = client.noobClient().ExportCourse(courseId)
+	require.Equal(t, 200, resp.StatusCode)
+	bundleBytes := []byte(bodyText(t, resp))
+	bundle := readBundle(t, bundleBytes)
+	require.Equal(t, []protocol.Asset{asset}, bundle.Assets)
+	user2 := ctx.createUser()
+	client2 := newTestClient(t).login(user2.Id)
+	resp = client2.noobClient().ImportCourse(bundleBytes)
+	require.Equal(t, 200, resp.StatusCode)
+	resp = client2.noobClient().ExportCourse(courseId + 1)
+	require.Equal(t, 200, resp.StatusCode)
+	bundle2 := readBundle(t, []byte(bodyText(t, resp)))
+	require.Equal(t, bundle.Assets, bundle2.Assets)
+
+	// Only the course's teacher can upload to it
+	resp = client2.noobClient().UploadAsset(courseId, png)
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	// Modules can only reference their own course's assets, so another
+	// course can't rely on one that's deleted once this course stops using it
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+	svgReference := protocol.NewAsset("image/svg+xml", svg).Reference()
+	otherCourseModule := "---\ntitle: t\ndescription: d\n---\n[//]: # (content)\n![diagram](" + svgReference + ")"
+	client2.createCourse(titleDescInput{"other", "course"}, []titleDescInput{{"other", "module"}})
+	otherCourseId := courseId + 2
+	resp = client.noobClient().UploadAsset(courseId, svg)
+	require.Equal(t, 200, resp.StatusCode)
+	otherModules, err := ctx.db.GetModules(int(otherCourseId))
+	require.Nil(t, err)
+	resp = client2.noobClient().UploadModule(otherCourseId, int64(otherModules[0].Id), otherCourseModule)
+	require.Equal(t, 400, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "isn't one of the course's assets")
+	noAssets := bundle
+	noAssets.Assets = nil
+	noAssets.Prereqs = nil
+	noAssets.Modules = []protocol.Module{protocol.NewModule("t", "d", []protocol.Block{protocol.NewContentBlock("![diagram](" + svgReference + ")")})}
+	var noAssetsBytes bytes.Buffer
+	require.Nil(t, protocol.WriteBundle(&noAssetsBytes, noAssets))
+	resp = client2.noobClient().ImportCourse(noAssetsBytes.Bytes())
+	require.Equal(t, 400, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "isn't one of the course's assets")
+
+	// Only some types of files can be uploaded
+	for _, data := range [][]byte{[]byte("plain text"), []byte("<html><script>alert(1)</script></html>"), {}} {
+		resp = client.noobClient().UploadAsset(courseId, data)
+		require.Equal(t, 400, resp.StatusCode)
+		require.Contains(t, bodyText(t, resp), "Assets must be")
+	}
+	resp = newTestClient(t).get(noob_client.AssetRoute(protocol.AssetHash(svg)))
+	require.Equal(t, "image/svg+xml", resp.Header.Get("Content-Type"))
+
+	// Replacing the content that referenced an asset removes it from the course,
+	// but uploads nothing references yet are kept
+	courseAssetHashes := func(courseId int) []string {
+		assets, err := ctx.db.GetCourseAssets(courseId)
+		require.Nil(t, err)
+		hashes := []string{}
+		for _, asset := range assets {
+			hashes = append(hashes, asset.Hash)
+		}
+		return hashes
+	}
+	require.ElementsMatch(t, []string{asset.Hash(), protocol.AssetHash(svg)}, courseAssetHashes(int(courseId)))
+	module = "---\ntitle: t\ndescription: d\n---\n[//]: # (content)\nno diagram"
+	resp = client.noobClient().UploadModule(courseId, moduleId, module)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, []string{protocol.AssetHash(svg)}, courseAssetHashes(int(courseId)))
+
+	// It's still served while another course has it, and deleted once none do
+	resp = newTestClient(t).get(noob_client.AssetRoute(asset.Hash()))
+	require.Equal(t, 200, resp.StatusCode)
+	resp = client2.delete(fmt.Sprintf("/teacher/course/%d", courseId+1))
+	require.Equal(t, 200, resp.StatusCode)
+	resp = newTestClient(t).get(noob_client.AssetRoute(asset.Hash()))
+	require.Equal(t, 404, resp.StatusCode)
+
+	// Deleting a course deletes the assets only it had
+	resp = client.delete(fmt.Sprintf("/teacher/course/%d", courseId))
+	require.Equal(t, 200, resp.StatusCode)
+	resp = newTestClient(t).get(noob_client.AssetRoute(protocol.AssetHash(svg)))
+	require.Equal(t, 404, resp.StatusCode)
+}
+
 func TestKnowledgePoint(t *testing.T) {
 	ctx := startServer(t)
 	defer ctx.Close()
@@ -992,4 +2725,754 @@ func TestKnowledgePoint(t *testing.T) {
 
 	resp := client.CreateKnowledgePoint(courseId, "kp1")
 	require.Equal(t, 200, resp.StatusCode)
+	knowledgePoints, err := ctx.db.GetKnowledgePoints(courseId)
+	require.Nil(t, err)
+	require.Len(t, knowledgePoints, 1)
+	require.Equal(t, "kp1", knowledgePoints[0].Name)
+
+	// Only the course's teacher can add knowledge points to it
+	other := ctx.createUser()
+	otherClient := newTestClient(t).login(other.Id)
+	resp = otherClient.noobClient().CreateKnowledgePoint(courseId, "kp2")
+	require.NotEqual(t, 200, resp.StatusCode)
+	knowledgePoints, err = ctx.db.GetKnowledgePoints(courseId)
+	require.Nil(t, err)
+	require.Len(t, knowledgePoints, 1)
+}
+
+const knowledgePointTestModule = `---
+title: inverses
+description: modular arithmetic
+---
+
+
+[//]: # (question kp="Modular inverses")
+What is the inverse of 3 mod 7?
+
+[//]: # (choice correct)
+5
+
+[//]: # (choice)
+4
+
+[//]: # (question: numeric kp="Modular inverses")
+What is the inverse of 2 mod 5?
+
+[//]: # (answer 3)
+
+[//]: # (question)
+Is 7 prime?
+
+[//]: # (choice correct)
+yes`
+
+func TestKnowledgePointByName(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := ctx.createUser()
+	teacherClient := newTestClient(t).login(teacher.Id)
+	course, modules := sampleCreateCourseInput()
+	teacherClient.createCourse(course, modules)
+	courseId := 1
+	moduleId := 1
+
+	// Names identify knowledge points within a course
+	resp := teacherClient.noobClient().CreateKnowledgePoint(int64(courseId), "Modular inverses")
+	require.Equal(t, 200, resp.StatusCode)
+	resp = teacherClient.noobClient().CreateKnowledgePoint(int64(courseId), "Modular inverses")
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	knowledgePointNames := func() []string {
+		knowledgePoints, err := ctx.db.GetKnowledgePoints(int64(courseId))
+		require.Nil(t, err)
+		names := []string{}
+		for _, knowledgePoint := range knowledgePoints {
+			names = append(names, knowledgePoint.Name)
+		}
+		return names
+	}
+
+	// Both questions resolve to the existing knowledge point, and a version
+	// uploaded again doesn't create it again
+	for i := 0; i < 2; i++ {
+		resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), knowledgePointTestModule)
+		require.Equal(t, 200, resp.StatusCode)
+	}
+	names := knowledgePointNames()
+	require.Equal(t, 1, strings.Count(strings.Join(names, "\n"), "Modular inverses"))
+
+	// Export writes the name back, but not for questions with their own knowledge point
+	body := teacherClient.getPageBody(exportModuleRoute(courseId, moduleId))
+	require.Equal(t, knowledgePointTestModule, strings.TrimSpace(body))
+	body = teacherClient.getPageBody(fmt.Sprintf("/teacher/course/%d/module/%d", courseId, moduleId))
+	require.Contains(t, body, `value="Modular inverses"`)
+
+	// Names that don't exist yet are created, including from another module
+	otherModule := "---\ntitle: t\ndescription: d\n---\n[//]: # (question kp=\"Chinese remainder theorem\")\nq\n[//]: # (choice correct)\nc"
+	resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId+1), otherModule)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Contains(t, knowledgePointNames(), "Chinese remainder theorem")
+
+	emptyName := "---\ntitle: t\ndescription: d\n---\n[//]: # (question kp=\"\")\nq\n[//]: # (choice correct)\nc"
+	unterminated := "---\ntitle: t\ndescription: d\n---\n[//]: # (question kp=\"Modular inverses)\nq\n[//]: # (choice correct)\nc"
+	for _, module := range []string{emptyName, unterminated} {
+		resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), module)
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+
+	// Questions sharing a knowledge point are still answered separately
+	student := ctx.createUser()
+	client := newTestClient(t).login(student.Id)
+	client.enrollCourse(courseId)
+	body = client.getPageBody(takeModulePageRoute(courseId, moduleId))
+	require.Contains(t, body, "inverse of 3 mod 7")
+	resp = client.post(answerQuestionRoute(courseId, moduleId, 0), url.Values{"choice": {choiceIds(t, body)[0]}}.Encode())
+	require.Equal(t, 200, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "Correct!")
+	body = client.getPageBody(nextModulePieceRoute(courseId, moduleId, 1))
+	require.Contains(t, body, "inverse of 2 mod 5")
+	resp = client.post(answerQuestionRoute(courseId, moduleId, 1), url.Values{"numeric-answer": {"4"}}.Encode())
+	require.Equal(t, 200, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "Incorrect.")
+}
+
+func TestStableKnowledgePoint(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := ctx.createUser()
+	teacherClient := newTestClient(t).login(teacher.Id)
+	course, modules := sampleCreateCourseInput()
+	teacherClient.createCourse(course, modules)
+	courseId := int64(1)
+	moduleId := int64(1)
+
+	knowledgePoints := func() map[string]int64 {
+		knowledgePoints, err := ctx.db.GetKnowledgePoints(courseId)
+		require.Nil(t, err)
+		ids := map[string]int64{}
+		for _, knowledgePoint := range knowledgePoints {
+			ids[knowledgePoint.Name] = knowledgePoint.Id
+		}
+		return ids
+	}
+	editQuestion := func(moduleId int64, question string, knowledgePointId string) {
+		form := url.Values{}
+		form.Set("title", "t")
+		form.Set("description", "d")
+		form.Add("block-type[]", "knowledge_point")
+		form.Add("question-title[]", question)
+		form.Add("question-idx[]", "1")
+		form.Add("question-explanation[]", "")
+		form.Set("question-kp-id-1", knowledgePointId)
+		form.Add("choice-title[]", "4")
+		form.Add("choice-idx[]", "2")
+		form.Set("correct-choice-1", "2")
+		form.Add("choice-title[]", "end-choice")
+		form.Add("choice-idx[]", "end-choice")
+		resp := teacherClient.put(noob_client.EditModuleRoute(courseId, moduleId), form.Encode())
+		require.Equal(t, 200, resp.StatusCode)
+	}
+
+	// Uploading the same question again keeps its knowledge point
+	module := "---\ntitle: t\ndescription: d\n---\n[//]: # (question)\nWhat is 2+2?\n[//]: # (choice correct)\n4"
+	resp := teacherClient.noobClient().UploadModule(courseId, moduleId, module)
+	require.Equal(t, 200, resp.StatusCode)
+	before := knowledgePoints()
+	knowledgePointId, ok := before["knowledge point: What is 2+2?"]
+	require.True(t, ok)
+	resp = teacherClient.noobClient().UploadModule(courseId, moduleId, module)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, before, knowledgePoints())
+
+	// The edit page remembers the knowledge point, so light edits keep it too
+	body := teacherClient.getPageBody(noob_client.EditModuleRoute(courseId, moduleId))
+	require.Contains(t, body, fmt.Sprintf(`value="%d"`, knowledgePointId))
+	editQuestion(moduleId, "What is 2 + 2?", strconv.FormatInt(knowledgePointId, 10))
+	after := knowledgePoints()
+	require.Equal(t, len(before), len(after))
+	require.Equal(t, knowledgePointId, after["knowledge point: What is 2 + 2?"])
+
+	// Ids of knowledge points outside the course are ignored, so a new question gets
+	// its own knowledge point, and the old one is cleaned up with the old version
+	editQuestion(moduleId, "What is 3+3?", "999999")
+	after = knowledgePoints()
+	require.Equal(t, len(before), len(after))
+	require.NotContains(t, after, "knowledge point: What is 2 + 2?")
+	require.NotEqual(t, knowledgePointId, after["knowledge point: What is 3+3?"])
+
+	// A knowledge point other modules' questions test isn't renamed by an edit,
+	// the edited question gets its own instead
+	otherModule := "---\ntitle: t\ndescription: d\n---\n[//]: # (question)\nWhat is 3+3?\n[//]: # (choice correct)\n6"
+	resp = teacherClient.noobClient().UploadModule(courseId, 2, otherModule)
+	require.Equal(t, 200, resp.StatusCode)
+	sharedId := knowledgePoints()["knowledge point: What is 3+3?"]
+	require.Equal(t, sharedId, after["knowledge point: What is 3+3?"])
+	editQuestion(moduleId, "What is 3 + 3?", strconv.FormatInt(sharedId, 10))
+	after = knowledgePoints()
+	require.Equal(t, sharedId, after["knowledge point: What is 3+3?"])
+	require.Contains(t, after, "knowledge point: What is 3 + 3?")
+	require.NotEqual(t, sharedId, after["knowledge point: What is 3 + 3?"])
+
+	// Named knowledge points are kept when they're no longer used, but orphaned
+	// default ones go with the module
+	resp = teacherClient.noobClient().CreateKnowledgePoint(courseId, "Addition")
+	require.Equal(t, 200, resp.StatusCode)
+	// Only the module's own questions are cleaned up, not ones no block asks elsewhere
+	additionId := knowledgePoints()["Addition"]
+	tx, err := ctx.db.Begin()
+	require.Nil(t, err)
+	unaskedQuestionId, err := db.InsertQuestion(tx, additionId, "What is 1+1?", []string{"2"}, 0, "")
+	require.Nil(t, err)
+	require.Nil(t, tx.Commit())
+	teacherClient.deleteModule(int(courseId), int(moduleId))
+	after = knowledgePoints()
+	require.Contains(t, after, "Addition")
+	require.Contains(t, after, "knowledge point: What is 3+3?")
+	require.NotContains(t, after, "knowledge point: What is 3 + 3?")
+	choices, err := ctx.db.GetChoicesForQuestion(int(unaskedQuestionId))
+	require.Nil(t, err)
+	require.Len(t, choices, 1)
+}
+
+const numericTestModule = `---
+title: numeric
+description: compute things
+---
+
+
+[//]: # (question: numeric)
+What is $5^3$?
+
+[//]: # (answer 125 tolerance=0.5)
+
+[//]: # (explanation)
+$5 * 5 * 5 = 125$
+
+[//]: # (question: numeric)
+What is $g$ in $m/s^2$?
+
+[//]: # (answer 9.81 tolerance=2%)`
+
+func TestNumericQuestion(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := ctx.createUser()
+	teacherClient := newTestClient(t).login(teacher.Id)
+	course, modules := sampleCreateCourseInput()
+	teacherClient.createCourse(course, modules)
+	courseId := 1
+	moduleId := 1
+
+	resp := teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), numericTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Round trips through export
+	body := teacherClient.getPageBody(exportModuleRoute(courseId, moduleId))
+	require.Equal(t, numericTestModule, strings.TrimSpace(body))
+
+	// Edit page shows the answer and tolerance, and resubmitting the form keeps them
+	body = teacherClient.getPageBody(fmt.Sprintf("/teacher/course/%d/module/%d", courseId, moduleId))
+	require.Contains(t, body, `value="125"`)
+	require.Contains(t, body, `value="2%"`)
+
+	form := url.Values{
+		"title":                  {"numeric"},
+		"description":            {"compute things"},
+		"block-type[]":           {"knowledge_point"},
+		"question-title[]":       {"What is $5^3$?"},
+		"question-idx[]":         {"7"},
+		"question-type[]":        {"numeric"},
+		"numeric-value-7":        {"125"},
+		"numeric-tolerance-7":    {"1%"},
+		"choice-title[]":         {"end-choice"},
+		"choice-idx[]":           {"end-choice"},
+		"question-explanation[]": {""},
+	}
+	resp = teacherClient.put(noob_client.EditModuleRoute(int64(courseId), int64(moduleId)), form.Encode())
+	require.Equal(t, 200, resp.StatusCode)
+	body = teacherClient.getPageBody(exportModuleRoute(courseId, moduleId))
+	require.Contains(t, body, "[//]: # (answer 125 tolerance=1%)")
+
+	resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), numericTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Invalid numeric questions are rejected
+	noAnswer := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: numeric)\nq\n[//]: # (explanation)\ne"
+	notANumber := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: numeric)\nq\n[//]: # (answer abc)"
+	badTolerance := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: numeric)\nq\n[//]: # (answer 1 tolerance=-1)"
+	withChoice := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: numeric)\nq\n[//]: # (choice correct)\nc"
+	for _, module := range []string{noAnswer, notANumber, badTolerance, withChoice} {
+		resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), module)
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+
+	takeModule := func(answers []string) int {
+		student := ctx.createUser()
+		client := newTestClient(t).login(student.Id)
+		client.enrollCourse(courseId)
+		body := client.getPageBody(takeModulePageRoute(courseId, moduleId))
+		require.Contains(t, body, `name="numeric-answer"`)
+		for i, answer := range answers {
+			if i > 0 {
+				client.getPageBody(nextModulePieceRoute(courseId, moduleId, i))
+			}
+			resp := client.post(answerQuestionRoute(courseId, moduleId, i), url.Values{"numeric-answer": {answer}}.Encode())
+			require.Equal(t, 200, resp.StatusCode)
+			body := bodyText(t, resp)
+			require.Contains(t, body, "Your answer: ")
+		}
+		client.completeModule(courseId, moduleId)
+		point, err := ctx.db.GetPoint(student.Id, moduleId)
+		require.Nil(t, err)
+		return point.Count
+	}
+
+	// Within tolerance, absolute and relative
+	require.Equal(t, 2, takeModule([]string{"125.4", " 9.9 "}))
+	// One wrong answer is free
+	require.Equal(t, 2, takeModule([]string{"124", "9.81"}))
+	// All wrong gets nothing
+	require.Equal(t, 0, takeModule([]string{"124.4", "10.1"}))
+
+	// Answers must be numbers
+	student := ctx.createUser()
+	client := newTestClient(t).login(student.Id)
+	client.enrollCourse(courseId)
+	client.getPageBody(takeModulePageRoute(courseId, moduleId))
+	for _, answer := range []string{"", "abc", "NaN", "Inf"} {
+		resp = client.post(answerQuestionRoute(courseId, moduleId, 0), url.Values{"numeric-answer": {answer}}.Encode())
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+}
+
+const multiSelectTestModule = `---
+title: multi
+description: select things
+---
+
+[//]: # (question)
+Which are prime?
+
+[//]: # (choice correct)
+2
+
+[//]: # (choice correct)
+3
+
+[//]: # (choice)
+4
+
+[//]: # (question: multi_select grading=partial)
+Which are even?
+
+[//]: # (choice correct)
+2
+
+[//]: # (choice correct)
+4
+
+[//]: # (choice)
+5
+
+[//]: # (content)
+one
+
+[//]: # (content)
+two
+
+[//]: # (content)
+three`
+
+var choiceIdRegex = regexp.MustCompile(`name="choice" value="(\d+)"`)
+
+func choiceIds(t *testing.T, body string) []string {
+	matches := choiceIdRegex.FindAllStringSubmatch(body, -1)
+	require.NotEmpty(t, matches)
+	ids := make([]string, len(matches))
+	for i, match := range matches {
+		ids[i] = match[1]
+	}
+	return ids
+}
+
+func TestMultiSelectQuestion(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := ctx.createUser()
+	teacherClient := newTestClient(t).login(teacher.Id)
+	course, modules := sampleCreateCourseInput()
+	teacherClient.createCourse(course, modules)
+	courseId := 1
+	moduleId := 1
+
+	resp := teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), multiSelectTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Several correct choices make a question multi select
+	body := teacherClient.getPageBody(exportModuleRoute(courseId, moduleId))
+	require.Contains(t, body, "[//]: # (question: multi_select)\nWhich are prime?")
+	require.Contains(t, body, "[//]: # (question: multi_select grading=partial)\nWhich are even?")
+	resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), body)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, body, teacherClient.getPageBody(exportModuleRoute(courseId, moduleId)))
+
+	// Edit page uses checkboxes and keeps the grading
+	body = teacherClient.getPageBody(fmt.Sprintf("/teacher/course/%d/module/%d", courseId, moduleId))
+	require.Contains(t, body, `type="checkbox" name="correct-choice-`)
+	require.Contains(t, body, `<option value="partial" selected>`)
+
+	// Multiple choice questions still can only have one correct choice
+	twoCorrect := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: multiple_choice)\nq\n[//]: # (choice correct)\na\n[//]: # (choice correct)\nb"
+	badGrading := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: multi_select grading=some)\nq\n[//]: # (choice correct)\na"
+	for _, module := range []string{twoCorrect, badGrading} {
+		resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), module)
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+
+	// Choose by index into each question's choices
+	takeModule := func(chosen [][]int) int {
+		student := ctx.createUser()
+		client := newTestClient(t).login(student.Id)
+		client.enrollCourse(courseId)
+		body := client.getPageBody(takeModulePageRoute(courseId, moduleId))
+		for i, choiceIdxs := range chosen {
+			if i > 0 {
+				body = client.getPageBody(nextModulePieceRoute(courseId, moduleId, i))
+			}
+			require.Contains(t, body, `type="checkbox"`)
+			ids := choiceIds(t, body)
+			form := url.Values{}
+			for _, idx := range choiceIdxs {
+				form.Add("choice", ids[idx])
+			}
+			resp := client.post(answerQuestionRoute(courseId, moduleId, i), form.Encode())
+			require.Equal(t, 200, resp.StatusCode)
+		}
+		for i := len(chosen); i < 5; i++ {
+			client.getPageBody(nextModulePieceRoute(courseId, moduleId, i))
+		}
+		client.completeModule(courseId, moduleId)
+		point, err := ctx.db.GetPoint(student.Id, moduleId)
+		require.Nil(t, err)
+		return point.Count
+	}
+
+	// All correct, 5 blocks plus bonus
+	require.Equal(t, 6, takeModule([][]int{{0, 1}, {0, 1}}))
+	// All or nothing gets nothing for half right, partial credit gets half
+	require.Equal(t, 1, takeModule([][]int{{0}, {0}}))
+	// Partial credit takes away a point for each wrong choice
+	require.Equal(t, 0, takeModule([][]int{{0}, {0, 2}}))
+
+	// Must choose something, and only choices for this question
+	student := ctx.createUser()
+	client := newTestClient(t).login(student.Id)
+	client.enrollCourse(courseId)
+	body = client.getPageBody(takeModulePageRoute(courseId, moduleId))
+	ids := choiceIds(t, body)
+	resp = client.post(answerQuestionRoute(courseId, moduleId, 0), "")
+	require.NotEqual(t, 200, resp.StatusCode)
+	resp = client.post(answerQuestionRoute(courseId, moduleId, 0), url.Values{"choice": {ids[0], "100000"}}.Encode())
+	require.NotEqual(t, 200, resp.StatusCode)
+	resp = client.post(answerQuestionRoute(courseId, moduleId, 0), url.Values{"choice": {ids[0]}}.Encode())
+	require.Equal(t, 200, resp.StatusCode)
+	require.Contains(t, bodyText(t, resp), "Incorrect.")
+}
+
+const textTestModule = `---
+title: text
+description: write things
+---
+
+
+[//]: # (question: text)
+What is the capital of France?
+
+[//]: # (accept)
+Paris
+
+[//]: # (accept)
+Paris, France
+
+[//]: # (explanation)
+It's Paris.
+
+[//]: # (question: text fold_case=false)
+Name a noble gas symbol.
+
+[//]: # (accept regex)
+He|Ne|Ar|Kr|Xe|Rn`
+
+func TestTextQuestion(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := ctx.createUser()
+	teacherClient := newTestClient(t).login(teacher.Id)
+	course, modules := sampleCreateCourseInput()
+	teacherClient.createCourse(course, modules)
+	courseId := 1
+	moduleId := 1
+
+	resp := teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), textTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Round trips through export
+	body := teacherClient.getPageBody(exportModuleRoute(courseId, moduleId))
+	require.Equal(t, textTestModule, strings.TrimSpace(body))
+
+	// Edit page shows accepted answers one per line, and resubmitting the form keeps them
+	body = teacherClient.getPageBody(fmt.Sprintf("/teacher/course/%d/module/%d", courseId, moduleId))
+	require.Contains(t, body, "Paris\nParis, France")
+	require.Contains(t, body, "/He|Ne|Ar|Kr|Xe|Rn/")
+
+	form := url.Values{
+		"title":                  {"text"},
+		"description":            {"write things"},
+		"block-type[]":           {"knowledge_point"},
+		"question-title[]":       {"Name a noble gas symbol."},
+		"question-idx[]":         {"7"},
+		"question-type[]":        {"text"},
+		"text-accept-7":          {"/He|Ne/\r\n\r\nArgon\r\n"},
+		"text-fold-case-7":       {"true"},
+		"choice-title[]":         {"end-choice"},
+		"choice-idx[]":           {"end-choice"},
+		"question-explanation[]": {""},
+	}
+	resp = teacherClient.put(noob_client.EditModuleRoute(int64(courseId), int64(moduleId)), form.Encode())
+	require.Equal(t, 200, resp.StatusCode)
+	body = teacherClient.getPageBody(exportModuleRoute(courseId, moduleId))
+	require.Contains(t, body, "[//]: # (question: text normalize_whitespace=false)")
+	require.Contains(t, body, "[//]: # (accept regex)\nHe|Ne")
+	require.Contains(t, body, "[//]: # (accept)\nArgon")
+
+	// Edit form needs at least one valid accepted answer
+	form.Set("text-accept-7", "/(/")
+	resp = teacherClient.put(noob_client.EditModuleRoute(int64(courseId), int64(moduleId)), form.Encode())
+	require.NotEqual(t, 200, resp.StatusCode)
+	form.Set("text-accept-7", " \n ")
+	resp = teacherClient.put(noob_client.EditModuleRoute(int64(courseId), int64(moduleId)), form.Encode())
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), textTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Invalid text questions are rejected
+	noAccept := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: text)\nq\n[//]: # (explanation)\ne"
+	emptyAccept := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: text)\nq\n[//]: # (accept)\n"
+	badRegex := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: text)\nq\n[//]: # (accept regex)\n(a"
+	withChoice := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: text)\nq\n[//]: # (choice correct)\nc"
+	for _, module := range []string{noAccept, emptyAccept, badRegex, withChoice} {
+		resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), module)
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+
+	takeModule := func(answers []string) int {
+		student := ctx.createUser()
+		client := newTestClient(t).login(student.Id)
+		client.enrollCourse(courseId)
+		body := client.getPageBody(takeModulePageRoute(courseId, moduleId))
+		require.Contains(t, body, `name="text-answer"`)
+		for i, answer := range answers {
+			if i > 0 {
+				client.getPageBody(nextModulePieceRoute(courseId, moduleId, i))
+			}
+			resp := client.post(answerQuestionRoute(courseId, moduleId, i), url.Values{"text-answer": {answer}}.Encode())
+			require.Equal(t, 200, resp.StatusCode)
+			body := bodyText(t, resp)
+			require.Contains(t, body, "Your answer: ")
+		}
+		client.completeModule(courseId, moduleId)
+		point, err := ctx.db.GetPoint(student.Id, moduleId)
+		require.Nil(t, err)
+		return point.Count
+	}
+
+	// Case and whitespace are ignored by default, regexes match the whole answer
+	require.Equal(t, 2, takeModule([]string{"  paris,   FRANCE ", "Xe"}))
+	// Case matters when fold_case=false
+	require.Equal(t, 0, takeModule([]string{"London", "xe"}))
+	// Regexes are anchored
+	require.Equal(t, 0, takeModule([]string{"Pariss", "Xenon"}))
+
+	// Empty answers are rejected
+	student := ctx.createUser()
+	client := newTestClient(t).login(student.Id)
+	client.enrollCourse(courseId)
+	client.getPageBody(takeModulePageRoute(courseId, moduleId))
+	resp = client.post(answerQuestionRoute(courseId, moduleId, 0), url.Values{"text-answer": {"   "}}.Encode())
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	// Surrounding whitespace is only ignored along with the rest of it
+	accepted := []protocol.AcceptedAnswer{protocol.NewAcceptedAnswer("Paris", false), protocol.NewAcceptedAnswer("Lyon|Nice", true)}
+	require.True(t, protocol.NewTextAnswer(accepted, false, true).Accepts(" Paris\n"))
+	require.True(t, protocol.NewTextAnswer(accepted, false, true).Accepts("Nice "))
+	require.False(t, protocol.NewTextAnswer(accepted, false, false).Accepts(" Paris\n"))
+	require.False(t, protocol.NewTextAnswer(accepted, false, false).Accepts("Nice "))
+	require.True(t, protocol.NewTextAnswer(accepted, false, false).Accepts("Nice"))
+}
+
+const orderingTestModule = `---
+title: ordering
+description: put things in order
+---
+
+
+[//]: # (question: ordering)
+Order these from smallest to largest.
+
+[//]: # (item)
+one
+
+[//]: # (item)
+two
+
+[//]: # (item)
+three
+
+[//]: # (explanation)
+Counting up.
+
+[//]: # (question: ordering grading=partial)
+Order the steps.
+
+[//]: # (item)
+first
+
+[//]: # (item)
+second
+
+[//]: # (item)
+third`
+
+var positionRegex = regexp.MustCompile(`name="position-(\w+)">`)
+var orderingItemRegex = regexp.MustCompile(`(?s)name="position-(\w+)">.*?<label for="item-\w+"><p>(\w+)</p>`)
+var orderingNonceRegex = regexp.MustCompile(`name="ordering-nonce" value="(\w+)"`)
+
+func TestOrderingQuestion(t *testing.T) {
+	ctx := startServer(t)
+	defer ctx.Close()
+
+	teacher := ctx.createUser()
+	teacherClient := newTestClient(t).login(teacher.Id)
+	course, modules := sampleCreateCourseInput()
+	teacherClient.createCourse(course, modules)
+	courseId := 1
+	moduleId := 1
+
+	resp := teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), orderingTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Round trips through export
+	body := teacherClient.getPageBody(exportModuleRoute(courseId, moduleId))
+	require.Equal(t, orderingTestModule, strings.TrimSpace(body))
+
+	// Items from the edit form are kept in the order they're given
+	form := url.Values{
+		"title":                  {"ordering"},
+		"description":            {"put things in order"},
+		"block-type[]":           {"knowledge_point"},
+		"question-title[]":       {"Order the steps."},
+		"question-idx[]":         {"7"},
+		"question-type[]":        {"ordering"},
+		"question-grading-7":     {"partial"},
+		"choice-title[]":         {"b", "a", "end-choice"},
+		"choice-idx[]":           {"1", "2", "end-choice"},
+		"question-explanation[]": {""},
+	}
+	resp = teacherClient.put(noob_client.EditModuleRoute(int64(courseId), int64(moduleId)), form.Encode())
+	require.Equal(t, 200, resp.StatusCode)
+	body = teacherClient.getPageBody(exportModuleRoute(courseId, moduleId))
+	require.Contains(t, body, "[//]: # (question: ordering grading=partial)\nOrder the steps.\n\n[//]: # (item)\nb\n\n[//]: # (item)\na")
+
+	// Ordering questions need at least two items
+	form["choice-title[]"] = []string{"b", "end-choice"}
+	form["choice-idx[]"] = []string{"1", "end-choice"}
+	resp = teacherClient.put(noob_client.EditModuleRoute(int64(courseId), int64(moduleId)), form.Encode())
+	require.NotEqual(t, 200, resp.StatusCode)
+
+	resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), orderingTestModule)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// Invalid ordering questions are rejected
+	noItems := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: ordering)\nq\n[//]: # (explanation)\ne"
+	oneItem := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: ordering)\nq\n[//]: # (item)\na"
+	withChoice := "---\ntitle: t\ndescription: d\n---\n[//]: # (question: ordering)\nq\n[//]: # (choice correct)\nc"
+	itemInChoiceQuestion := "---\ntitle: t\ndescription: d\n---\n[//]: # (question)\nq\n[//]: # (choice correct)\nc\n[//]: # (item)\na"
+	for _, module := range []string{noItems, oneItem, withChoice, itemInChoiceQuestion} {
+		resp = teacherClient.noobClient().UploadModule(int64(courseId), int64(moduleId), module)
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+
+	// Answers give each item a position, posted by the token the item
+	// was rendered with, where order is the position of each item in
+	// its correct order.
+	answer := func(client testClient, blockIdx int, body string, items []string, order []int) string {
+		tokens := map[string]string{}
+		for _, match := range orderingItemRegex.FindAllStringSubmatch(body, -1) {
+			tokens[match[2]] = match[1]
+		}
+		require.Len(t, tokens, len(order))
+		nonce := orderingNonceRegex.FindStringSubmatch(body)
+		require.NotNil(t, nonce)
+		form := url.Values{"ordering-nonce": {nonce[1]}}
+		for i, position := range order {
+			form.Set("position-"+tokens[items[i]], strconv.Itoa(position))
+		}
+		resp := client.post(answerQuestionRoute(courseId, moduleId, blockIdx), form.Encode())
+		require.Equal(t, 200, resp.StatusCode)
+		return bodyText(t, resp)
+	}
+	takeModule := func(first []int, second []int, firstResult string, secondResult string) int {
+		student := ctx.createUser()
+		client := newTestClient(t).login(student.Id)
+		client.enrollCourse(courseId)
+		body := client.getPageBody(takeModulePageRoute(courseId, moduleId))
+		require.Contains(t, answer(client, 0, body, []string{"one", "two", "three"}, first), firstResult)
+		body = client.getPageBody(nextModulePieceRoute(courseId, moduleId, 1))
+		require.Contains(t, answer(client, 1, body, []string{"first", "second", "third"}, second), secondResult)
+		client.completeModule(courseId, moduleId)
+		point, err := ctx.db.GetPoint(student.Id, moduleId)
+		require.Nil(t, err)
+		return point.Count
+	}
+
+	require.Equal(t, 2, takeModule([]int{1, 2, 3}, []int{1, 2, 3}, "Correct!", "Correct!"))
+	// One swapped pair is one of three pairs out of order
+	require.Equal(t, 2, takeModule([]int{1, 2, 3}, []int{2, 1, 3}, "Correct!", "Partially correct."))
+	// Exact grading gives nothing for being close, and reversing everything gets nothing
+	require.Equal(t, 0, takeModule([]int{1, 3, 2}, []int{3, 2, 1}, "Incorrect.", "Incorrect."))
+
+	// Every item needs its own position
+	student := ctx.createUser()
+	client := newTestClient(t).login(student.Id)
+	client.enrollCourse(courseId)
+	body = client.getPageBody(takeModulePageRoute(courseId, moduleId))
+	tokens := positionRegex.FindAllStringSubmatch(body, -1)
+	nonce := orderingNonceRegex.FindStringSubmatch(body)[1]
+	for _, positions := range [][]string{{"1", "1", "2"}, {"1", "2", "4"}, {"1", "2", ""}} {
+		form := url.Values{"ordering-nonce": {nonce}}
+		for i, match := range tokens {
+			form.Set("position-"+match[1], positions[i])
+		}
+		resp = client.post(answerQuestionRoute(courseId, moduleId, 0), form.Encode())
+		require.NotEqual(t, 200, resp.StatusCode)
+	}
+
+	// Items aren't posted by their choice ids, and tokens change every render
+	version, err := ctx.db.GetLatestModuleVersion(moduleId)
+	require.Nil(t, err)
+	contents, err := ctx.db.GetModuleContents(version.Id)
+	require.Nil(t, err)
+	for _, choice := range contents.Blocks[0].Question.Choices {
+		require.NotContains(t, body, fmt.Sprintf(`name="position-%d"`, choice.Id))
+	}
+	body = client.getPageBody(takeModulePageRoute(courseId, moduleId))
+	for _, match := range tokens {
+		require.NotContains(t, body, match[1])
+	}
 }
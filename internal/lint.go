@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"noobular/internal/protocol"
+)
+
+// Checks a module's source for everything that would stop it from being uploaded,
+// plus things that are probably mistakes, like unknown markers or math that KaTeX
+// can't render. Problems are sorted by where they are in the source. Modules are
+// checked against the limits of the server they're for.
+func LintModule(source string, limits Limits) []protocol.Diagnostic {
+	module, moduleSource, diagnostics := protocol.ParseSource(source)
+	diagnostics = append(diagnostics, moduleSource.Ignored...)
+
+	// Check the metadata and each block on their own, using the same validation
+	// as uploads, so we can say where each problem is.
+	metadata := protocol.NewModule(module.Title, module.Description, []protocol.Block{})
+	metadata.Metadata = module.Metadata
+	if err := validateModule(limits, metadata); err != nil {
+		diagnostics = append(diagnostics, protocol.NewDiagnostic(max(moduleSource.TitleLine, 1), 1, err.Error()))
+	}
+	if maxBlocks := limits.MaxBlocks; len(module.Blocks) > maxBlocks {
+		line := moduleSource.BlockLines[maxBlocks]
+		diagnostics = append(diagnostics, protocol.NewDiagnostic(line, 1, fmt.Sprintf("Cannot have more than %d blocks", maxBlocks)))
+	}
+	for i, block := range module.Blocks {
+		line := moduleSource.BlockLines[i]
+		err := validateModule(limits, protocol.NewModule("title", "description", []protocol.Block{block}))
+		if err != nil {
+			diagnostics = append(diagnostics, protocol.NewDiagnostic(line, 1, err.Error()))
+		}
+		for _, text := range blockTexts(block) {
+			diagnostics = append(diagnostics, lintMath(source, line, text)...)
+		}
+	}
+	slices.SortStableFunc(diagnostics, func(a, b protocol.Diagnostic) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	return diagnostics
+}
+
+func validateModule(limits Limits, module protocol.Module) error {
+	req, err := newEditModuleRequest(-1, -1, module)
+	if err != nil {
+		return err
+	}
+	return validateEditModuleRequest(limits, req)
+}
+
+// Returns the text of a block that gets rendered as markdown.
+func blockTexts(block protocol.Block) []string {
+	if block.BlockType == protocol.ContentBlockType {
+		return []string{block.Content}
+	}
+	question := block.Question
+	texts := []string{question.Text}
+	for _, choice := range question.Choices {
+		texts = append(texts, choice.Text)
+	}
+	texts = append(texts, question.Items...)
+	return append(texts, question.Explanation)
+}
+
+// KaTeX renders math it can't parse as an error span instead of failing,
+// with the error in the title and the math it couldn't parse inside.
+var katexErrorRegex = regexp.MustCompile(`(?s)<span class="katex-error" title="([^"]*)"[^>]*>(.*?)</span>`)
+
+var katexPositionRegex = regexp.MustCompile(` at position (\d+)`)
+
+// Returns a problem for each bit of math in text that KaTeX can't render,
+// located by finding the math in the source after the block's marker line.
+func lintMath(source string, blockLine int, text string) []protocol.Diagnostic {
+	var buf bytes.Buffer
+	if err := newMd().Convert([]byte(text), &buf); err != nil {
+		return []protocol.Diagnostic{protocol.NewDiagnostic(blockLine, 1, err.Error())}
+	}
+	diagnostics := []protocol.Diagnostic{}
+	for _, match := range katexErrorRegex.FindAllStringSubmatch(buf.String(), -1) {
+		message := strings.TrimPrefix(html.UnescapeString(match[1]), "ParseError: ")
+		math := html.UnescapeString(match[2])
+		line, column, found := findInSource(source, blockLine, math)
+		if position := katexPositionRegex.FindStringSubmatchIndex(message); position != nil {
+			// Positions are 1-indexed characters into the math
+			offset, _ := strconv.Atoi(message[position[2]:position[3]])
+			if found && offset > 0 && offset <= len([]rune(math)) {
+				column += len(string([]rune(math)[:offset-1]))
+			}
+			message = message[:position[0]]
+		}
+		diagnostics = append(diagnostics, protocol.NewDiagnostic(line, column, message))
+	}
+	return diagnostics
+}
+
+// Returns the 1-indexed line and column of the first occurrence of text
+// at or after the given line, or the start of that line if there is none.
+func findInSource(source string, fromLine int, text string) (int, int, bool) {
+	lines := strings.Split(source, "\n")
+	for i := fromLine - 1; i < len(lines); i++ {
+		if column := strings.Index(lines[i], text); column != -1 {
+			return i + 1, column + 1, true
+		}
+	}
+	return fromLine, 1, false
+}
+
+// Rewrites a module's source in the canonical form modules are exported in.
+func FormatModule(source string) (string, error) {
+	module, err := protocol.Parse(source)
+	if err != nil {
+		return "", err
+	}
+	return module.Markdown() + "\n", nil
+}
@@ -1,45 +1,141 @@
 package internal
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/url"
 
 	"github.com/google/uuid"
 )
 
-type Logger struct {
-	requestId *uuid.UUID
+// The server logs with log/slog. Anything logged with a request's context
+// gets the request's id, route and, once they're signed in, user, so every
+// line about a request can be found by its id, e.g.
+//
+//	{"level":"INFO","msg":"Request","method":"POST","status":200,"requestId":"...","route":"POST /teacher/course/{courseId}/knowledge-point","userId":1}
+
+type LogFormat string
+
+const (
+	JSONLogFormat LogFormat = "json"
+	TextLogFormat LogFormat = "text"
+)
+
+// How much of requests' forms to log. Forms can have whole modules in them,
+// so by default only which fields were sent, and how long they were, is.
+type FormLogging string
+
+const (
+	NoFormLogging       FormLogging = "none"
+	RedactedFormLogging FormLogging = "redacted"
+	FullFormLogging     FormLogging = "full"
+)
+
+type LogConfig struct {
+	// debug, info, warn or error
+	Level  string      `yaml:"level"`
+	Format LogFormat   `yaml:"format"`
+	Forms  FormLogging `yaml:"forms"`
+}
+
+func (c LogConfig) Validate() error {
+	_, err := c.level()
+	if err != nil {
+		return fmt.Errorf("invalid log.level %q, must be debug, info, warn or error", c.Level)
+	}
+	if c.Format != JSONLogFormat && c.Format != TextLogFormat {
+		return fmt.Errorf("log.format must be %s or %s, not %q", JSONLogFormat, TextLogFormat, c.Format)
+	}
+	if c.Forms != NoFormLogging && c.Forms != RedactedFormLogging && c.Forms != FullFormLogging {
+		return fmt.Errorf("log.forms must be %s, %s or %s, not %q", NoFormLogging, RedactedFormLogging, FullFormLogging, c.Forms)
+	}
+	return nil
+}
+
+func (c LogConfig) level() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.Level))
+	return level, err
 }
 
-func (l Logger) RequestId(requestId uuid.UUID) Logger {
-	l.requestId = &requestId
+// Returns a logger writing to w as configured, meant to be the default logger.
+func NewLogger(w io.Writer, config LogConfig) (*slog.Logger, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	level, _ := config.level()
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewJSONHandler(w, options)
+	if config.Format == TextLogFormat {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(requestLogHandler{handler}), nil
+}
+
+// What's known about the request being handled. Handlers fill in the user
+// once they know who it is.
+type requestLog struct {
+	id     uuid.UUID
+	route  string
+	userId int64
+}
+
+type requestLogKey struct{}
+
+func withRequestLog(ctx context.Context, l *requestLog) context.Context {
+	return context.WithValue(ctx, requestLogKey{}, l)
+}
+
+func requestLogFrom(ctx context.Context) *requestLog {
+	l, _ := ctx.Value(requestLogKey{}).(*requestLog)
 	return l
 }
 
-type Level string
+// Records who made the request, for everything logged about it from now on.
+func setRequestUser(ctx context.Context, userId int64) {
+	if l := requestLogFrom(ctx); l != nil {
+		l.userId = userId
+	}
+}
 
-const (
-	infoLevel Level = "INFO"
-	errorLevel = "ERROR"
-	debugLevel = "DEBUG"
-)
+// Adds the request's fields to records logged with its context.
+type requestLogHandler struct {
+	slog.Handler
+}
 
-func (l Logger) log(level Level, format string, v ...any) {
-	context := string(level)
-	if l.requestId != nil {
-		context += fmt.Sprintf(" %s ", l.requestId)
+func (h requestLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if l := requestLogFrom(ctx); l != nil {
+		record.AddAttrs(slog.String("requestId", l.id.String()), slog.String("route", l.route))
+		if l.userId != 0 {
+			record.AddAttrs(slog.Int64("userId", l.userId))
+		}
 	}
-	log.Printf(context + format, v)
+	return h.Handler.Handle(ctx, record)
 }
 
-func (l Logger) Info(format string, v ...any) {
-	l.log(infoLevel, format, v)
+func (h requestLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestLogHandler{h.Handler.WithAttrs(attrs)}
 }
 
-func (l Logger) Error(format string, v ...any) {
-	l.log(errorLevel, format, v)
+func (h requestLogHandler) WithGroup(name string) slog.Handler {
+	return requestLogHandler{h.Handler.WithGroup(name)}
 }
 
-func (l Logger) Debug(format string, v ...any) {
-	l.log(debugLevel, format, v)
+// Returns the form as it should be logged, where redacted values are
+// replaced by their length.
+func formLogValue(form url.Values, logging FormLogging) interface{} {
+	if logging == FullFormLogging {
+		return form
+	}
+	redacted := make(map[string][]string, len(form))
+	for field, values := range form {
+		redacted[field] = make([]string, len(values))
+		for i, value := range values {
+			redacted[field][i] = fmt.Sprintf("[%d bytes]", len(value))
+		}
+	}
+	return redacted
 }
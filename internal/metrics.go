@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics about how the server performs and is used, served at /metrics in
+// the Prometheus text format, e.g.
+//
+//	noobular_http_requests_total{route="/student/course/{courseId}",method="GET",status="200"} 12
+//
+// A nil *Metrics records nothing, for things like local preview that don't
+// serve metrics.
+
+type metricKind string
+
+const (
+	counterMetric   metricKind = "counter"
+	histogramMetric metricKind = "histogram"
+)
+
+// Upper bounds of histogram buckets, in seconds, like Prometheus' defaults.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric struct {
+	name       string
+	help       string
+	kind       metricKind
+	labelNames []string
+	// Keyed by label values, joined
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	// A counter's value, or the sum of a histogram's observations
+	sum   float64
+	count uint64
+	// How many observations fell in each bucket, not cumulative
+	buckets []uint64
+}
+
+func newMetric(name string, help string, kind metricKind, labelNames ...string) *metric {
+	return &metric{name, help, kind, labelNames, make(map[string]*metricSeries)}
+}
+
+func (m *metric) seriesFor(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	series, ok := m.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues, buckets: make([]uint64, len(durationBuckets))}
+		m.series[key] = series
+	}
+	return series
+}
+
+type Metrics struct {
+	mu              sync.Mutex
+	requests        *metric
+	requestDuration *metric
+	queryDuration   *metric
+	renderDuration  *metric
+	contentCache    *metric
+	signups         *metric
+	enrollments     *metric
+	answers         *metric
+	completions     *metric
+	points          *metric
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:        newMetric("noobular_http_requests_total", "Requests handled, by route, method and status.", counterMetric, "route", "method", "status"),
+		requestDuration: newMetric("noobular_http_request_duration_seconds", "How long requests took to handle, by route and method.", histogramMetric, "route", "method"),
+		queryDuration:   newMetric("noobular_db_query_duration_seconds", "How long database statements took, including in transactions, by the db function that ran them.", histogramMetric, "method"),
+		renderDuration:  newMetric("noobular_template_render_duration_seconds", "How long templates took to render, by file and template.", histogramMetric, "file", "template"),
+		contentCache:    newMetric("noobular_content_cache_lookups_total", "Lookups of rendered content, by where it was found: memory, db or miss.", counterMetric, "result"),
+		signups:         newMetric("noobular_signups_total", "Users who signed up.", counterMetric),
+		enrollments:     newMetric("noobular_enrollments_total", "Enrollments in courses.", counterMetric),
+		answers:         newMetric("noobular_answers_submitted_total", "Answers submitted to questions.", counterMetric),
+		completions:     newMetric("noobular_modules_completed_total", "Modules completed by students.", counterMetric),
+		points:          newMetric("noobular_points_awarded_total", "Points awarded for completing modules.", counterMetric),
+	}
+}
+
+func (m *Metrics) all() []*metric {
+	return []*metric{m.requests, m.requestDuration, m.queryDuration, m.renderDuration, m.contentCache, m.signups, m.enrollments, m.answers, m.completions, m.points}
+}
+
+func (m *Metrics) add(metric *metric, value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metric.seriesFor(labelValues).sum += value
+}
+
+func (m *Metrics) observe(metric *metric, duration time.Duration, labelValues ...string) {
+	seconds := duration.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series := metric.seriesFor(labelValues)
+	series.sum += seconds
+	series.count++
+	for i, upperBound := range durationBuckets {
+		if seconds <= upperBound {
+			series.buckets[i]++
+			break
+		}
+	}
+}
+
+func (m *Metrics) ObserveRequest(route string, method string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.add(m.requests, 1, route, method, strconv.Itoa(status))
+	m.observe(m.requestDuration, duration, route, method)
+}
+
+func (m *Metrics) ObserveQuery(method string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.observe(m.queryDuration, duration, method)
+}
+
+func (m *Metrics) ObserveRender(file string, template string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.observe(m.renderDuration, duration, file, template)
+}
+
+func (m *Metrics) CountContentCacheLookup(result string) {
+	if m == nil {
+		return
+	}
+	m.add(m.contentCache, 1, result)
+}
+
+func (m *Metrics) CountSignup() {
+	if m == nil {
+		return
+	}
+	m.add(m.signups, 1)
+}
+
+func (m *Metrics) CountEnrollment() {
+	if m == nil {
+		return
+	}
+	m.add(m.enrollments, 1)
+}
+
+func (m *Metrics) CountAnswer() {
+	if m == nil {
+		return
+	}
+	m.add(m.answers, 1)
+}
+
+func (m *Metrics) CountModuleCompleted(points int) {
+	if m == nil {
+		return
+	}
+	m.add(m.completions, 1)
+	m.add(m.points, float64(points))
+}
+
+// Writes every metric in the Prometheus text format, with series sorted so
+// the output is stable.
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+	for _, metric := range m.all() {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		keys := make([]string, 0, len(metric.series))
+		for key := range metric.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		// Counters without labels are always there, so they start at 0
+		if len(keys) == 0 && metric.kind == counterMetric && len(metric.labelNames) == 0 {
+			fmt.Fprintf(&b, "%s 0\n", metric.name)
+		}
+		for _, key := range keys {
+			series := metric.series[key]
+			labels := formatLabels(metric.labelNames, series.labelValues)
+			if metric.kind == counterMetric {
+				fmt.Fprintf(&b, "%s%s %s\n", metric.name, wrapLabels(labels), formatFloat(series.sum))
+				continue
+			}
+			cumulative := uint64(0)
+			for i, upperBound := range durationBuckets {
+				cumulative += series.buckets[i]
+				bucketLabels := append(labels, fmt.Sprintf("le=%q", formatFloat(upperBound)))
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", metric.name, wrapLabels(bucketLabels), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", metric.name, wrapLabels(append(labels, `le="+Inf"`)), series.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", metric.name, wrapLabels(labels), formatFloat(series.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", metric.name, wrapLabels(labels), series.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatLabels(names []string, values []string) []string {
+	labels := make([]string, len(names))
+	for i, name := range names {
+		// Prometheus escapes label values like Go strings, minus the unicode escapes
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		labels[i] = fmt.Sprintf(`%s="%s"`, name, value)
+	}
+	return labels
+}
+
+func wrapLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Serves only metrics, e.g. on a port that's only reachable internally.
+func NewMetricsServer(addr string, metrics *Metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := m.WriteText(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
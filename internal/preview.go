@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"noobular/internal/db"
+	"noobular/internal/protocol"
+)
+
+// Local preview renders a module file the way students see it on the
+// preview page, without a database or signing in, so authors can check how
+// things like math and tables look before uploading. The page reloads
+// itself whenever the file is saved, and lists any problems with it.
+
+// How often to check the file for changes.
+const PreviewPollInterval = 200 * time.Millisecond
+
+type previewer struct {
+	path     string
+	renderer Renderer
+	limits   Limits
+}
+
+// Returns a handler serving a preview of the module file at path. Assets
+// referenced by hash are served from an assets directory next to the file,
+// like in a course bundle. Problems are found using the limits given.
+func NewPreviewHandler(path string, renderer Renderer, limits Limits) http.Handler {
+	p := previewer{path, renderer, limits}
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	mux.Handle("/style/", http.StripPrefix("/style/", http.FileServer(http.Dir("style"))))
+	mux.HandleFunc("/{$}", p.handlePage)
+	mux.HandleFunc("/events", p.handleEvents)
+	mux.HandleFunc("/asset/{hash}", p.handleAsset)
+	return mux
+}
+
+// Identifies the version of the file on disk, changing whenever it's saved.
+func (p previewer) fileVersion() (string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+func (p previewer) handlePage(w http.ResponseWriter, r *http.Request) {
+	// Get the version first, so a save while we render still triggers a reload
+	version, err := p.fileVersion()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	source, err := os.ReadFile(p.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	page, err := newPreviewPage(string(source), version, p.limits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = p.renderer.RenderTakeModulePage(w, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Renders whatever parsed without problems, along with every problem
+// linting finds, so one mistake doesn't hide the rest of the module.
+func newPreviewPage(source string, version string, limits Limits) (UiTakeModulePage, error) {
+	module, _, _ := protocol.ParseSource(source)
+	blocks, err := uiBlocksFromModule(module)
+	if err != nil {
+		return UiTakeModulePage{}, err
+	}
+	moduleVersion := db.NewModuleVersion(-1, -1, 0, module.Title, module.Description)
+	return UiTakeModulePage{
+		Module:     NewUiModuleStudent(-1, moduleVersion, len(blocks), false, time.Now(), 0),
+		Blocks:     blocks,
+		VisitIndex: len(blocks),
+		Preview:    true,
+		Local:      &UiLocalPreview{version, LintModule(source, limits)},
+	}, nil
+}
+
+// Renders a parsed module's blocks like the preview page renders them from
+// the database. Everything gets made up ids, unique within the module.
+func uiBlocksFromModule(module protocol.Module) ([]UiBlock, error) {
+	id := 0
+	nextId := func() int {
+		id++
+		return id
+	}
+	render := func(text string) (UiContent, error) {
+		return NewUiContentRendered(db.NewContent(nextId(), text))
+	}
+	blocks := make([]UiBlock, len(module.Blocks))
+	for blockIdx, block := range module.Blocks {
+		if block.BlockType == protocol.ContentBlockType {
+			content, err := render(block.Content)
+			if err != nil {
+				return nil, fmt.Errorf("Error rendering block %d: %v", blockIdx, err)
+			}
+			blocks[blockIdx] = NewUiBlockContent(content, blockIdx)
+			continue
+		}
+		question := block.Question
+		content, err := render(question.Text)
+		if err != nil {
+			return nil, fmt.Errorf("Error rendering question in block %d: %v", blockIdx, err)
+		}
+		explanation, err := render(question.Explanation)
+		if err != nil {
+			return nil, fmt.Errorf("Error rendering explanation in block %d: %v", blockIdx, err)
+		}
+		dbQuestion := db.NewQuestion(nextId(), -1, content.Id, db.QuestionType(question.QuestionType), db.Grading(question.Grading))
+		var uiQuestion UiQuestion
+		switch question.QuestionType {
+		case protocol.NumericQuestionType:
+			answer := question.Numeric
+			solution := db.NewNumericSolution(-1, dbQuestion.Id, answer.Value, answer.Tolerance, answer.Relative)
+			uiQuestion = NewUiNumericQuestionTake(dbQuestion, content, solution, explanation)
+		case protocol.TextQuestionType:
+			answer := question.TextAnswer
+			solution := db.NewTextSolution(-1, dbQuestion.Id, answer.FoldCase, answer.NormalizeWhitespace)
+			accepted := make([]db.AcceptedAnswer, len(answer.Accepted))
+			for i, acceptedAnswer := range answer.Accepted {
+				accepted[i] = db.NewAcceptedAnswer(nextId(), dbQuestion.Id, acceptedAnswer.Text, acceptedAnswer.Regex)
+			}
+			uiQuestion = NewUiTextQuestionTake(dbQuestion, content, solution, accepted, explanation)
+		default:
+			// Ordering questions keep their items as choices in the correct order
+			choiceTexts := question.Items
+			correct := make([]bool, len(question.Items))
+			if question.QuestionType != protocol.OrderingQuestionType {
+				choiceTexts = make([]string, len(question.Choices))
+				correct = make([]bool, len(question.Choices))
+				for i, choice := range question.Choices {
+					choiceTexts[i] = choice.Text
+					correct[i] = choice.Correct
+				}
+			}
+			choices := make([]db.Choice, len(choiceTexts))
+			choiceContents := make([]UiContent, len(choiceTexts))
+			for i, text := range choiceTexts {
+				choiceContents[i], err = render(text)
+				if err != nil {
+					return nil, fmt.Errorf("Error rendering choice in block %d: %v", blockIdx, err)
+				}
+				choices[i] = db.NewChoice(nextId(), dbQuestion.Id, choiceContents[i].Id, correct[i])
+			}
+			if question.QuestionType == protocol.OrderingQuestionType {
+				uiQuestion = NewUiOrderingQuestionTake(dbQuestion, content, choices, choiceContents, explanation)
+			} else {
+				uiQuestion = NewUiQuestionTake(dbQuestion, content, choices, choiceContents, explanation)
+			}
+		}
+		uiQuestion.KnowledgePoint = question.KnowledgePoint
+		blocks[blockIdx] = NewUiBlockQuestion(uiQuestion, blockIdx)
+	}
+	return blocks, nil
+}
+
+// Streams a reload event once the file is no longer the version the page
+// was rendered from, e.g. /events?version=..., then ends.
+func (p previewer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	rendered := r.URL.Query().Get("version")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	ticker := time.NewTicker(PreviewPollInterval)
+	defer ticker.Stop()
+	for {
+		// Editors that save by replacing the file can leave it missing
+		// for a moment, so only a file that's there counts as a change.
+		version, err := p.fileVersion()
+		if err == nil && version != rendered {
+			fmt.Fprintf(w, "event: reload\ndata: %s\n\n", version)
+			flusher.Flush()
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p previewer) handleAsset(w http.ResponseWriter, r *http.Request) {
+	hash, ok := protocol.ParseAssetUrl(protocol.AssetScheme + r.PathValue("hash"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(p.path), "assets", hash+".*"))
+	if err != nil || len(matches) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, matches[0])
+}
@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Assets are files like images that modules can reference from markdown by
+// the hash of their contents, e.g. ![diagram](asset:3f2a...), so references
+// stay the same wherever the module and its assets are moved to.
+
+const AssetScheme = "asset:"
+
+// The file types assets can be, by content type, with their file extension.
+var AssetExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/svg+xml":   ".svg",
+	"application/pdf": ".pdf",
+}
+
+type Asset struct {
+	ContentType string
+	Data        []byte
+}
+
+func NewAsset(contentType string, data []byte) Asset {
+	return Asset{contentType, data}
+}
+
+// Returns the hex hash assets are referenced by. It's the same blake2b
+// hash the server dedupes stored content with.
+func AssetHash(data []byte) string {
+	hash := blake2b.Sum256(data)
+	return hex.EncodeToString(hash[:16])
+}
+
+func (a Asset) Hash() string {
+	return AssetHash(a.Data)
+}
+
+func (a Asset) Reference() string {
+	return AssetScheme + a.Hash()
+}
+
+func (a Asset) Filename() string {
+	return "assets/" + a.Hash() + AssetExtensions[a.ContentType]
+}
+
+var assetReferenceRegex = regexp.MustCompile(AssetScheme + `([0-9a-f]{32})`)
+
+// Returns the hashes of the assets the markdown references, without duplicates.
+func AssetReferences(markdown string) []string {
+	hashes := []string{}
+	seen := map[string]bool{}
+	for _, match := range assetReferenceRegex.FindAllStringSubmatch(markdown, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			hashes = append(hashes, match[1])
+		}
+	}
+	return hashes
+}
+
+// Returns the hash an asset: URL refers to, and whether it is one.
+func ParseAssetUrl(url string) (string, bool) {
+	hash, ok := strings.CutPrefix(url, AssetScheme)
+	if !ok || !assetReferenceRegex.MatchString(url) || len(hash) != 32 {
+		return "", false
+	}
+	return hash, true
+}
@@ -0,0 +1,295 @@
+package protocol
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// A course bundle is a zip file with a course.json manifest, one
+// protocol markdown file per module, and the course's assets, so a
+// whole course can be moved around in one piece.
+
+const ManifestFilename = "course.json"
+
+type Course struct {
+	Title           string
+	Description     string
+	Public          bool
+	Modules         []Module
+	Prereqs         []Prereq
+	KnowledgePoints []string
+	Assets          []Asset
+}
+
+func NewCourse(title string, description string, public bool, modules []Module, prereqs []Prereq, knowledgePoints []string, assets []Asset) Course {
+	return Course{title, description, public, modules, prereqs, knowledgePoints, assets}
+}
+
+// Prereqs reference modules by their index in Course.Modules.
+type Prereq struct {
+	ModuleIdx       int
+	PrereqModuleIdx int
+}
+
+func NewPrereq(moduleIdx int, prereqModuleIdx int) Prereq {
+	return Prereq{moduleIdx, prereqModuleIdx}
+}
+
+type manifestPrereq struct {
+	Module       string `json:"module"`
+	PrereqModule string `json:"prereq"`
+}
+
+type manifestAsset struct {
+	File        string `json:"file"`
+	ContentType string `json:"contentType"`
+}
+
+type manifest struct {
+	Title           string           `json:"title"`
+	Description     string           `json:"description"`
+	Public          bool             `json:"public"`
+	Modules         []string         `json:"modules"` // Module filenames in order
+	Prereqs         []manifestPrereq `json:"prereqs"`
+	KnowledgePoints []string         `json:"knowledgePoints"`
+	Assets          []manifestAsset  `json:"assets"`
+}
+
+func moduleFilename(idx int) string {
+	return fmt.Sprintf("modules/%03d.md", idx+1)
+}
+
+func WriteBundle(w io.Writer, course Course) error {
+	m := manifest{
+		Title:           course.Title,
+		Description:     course.Description,
+		Public:          course.Public,
+		Modules:         make([]string, len(course.Modules)),
+		Prereqs:         make([]manifestPrereq, len(course.Prereqs)),
+		KnowledgePoints: course.KnowledgePoints,
+		Assets:          make([]manifestAsset, len(course.Assets)),
+	}
+	if m.KnowledgePoints == nil {
+		m.KnowledgePoints = []string{}
+	}
+	for i, asset := range course.Assets {
+		m.Assets[i] = manifestAsset{asset.Filename(), asset.ContentType}
+	}
+	for i := range course.Modules {
+		m.Modules[i] = moduleFilename(i)
+	}
+	for i, prereq := range course.Prereqs {
+		if prereq.ModuleIdx < 0 || prereq.ModuleIdx >= len(course.Modules) ||
+			prereq.PrereqModuleIdx < 0 || prereq.PrereqModuleIdx >= len(course.Modules) {
+			return fmt.Errorf("prereq references module out of range")
+		}
+		m.Prereqs[i] = manifestPrereq{moduleFilename(prereq.ModuleIdx), moduleFilename(prereq.PrereqModuleIdx)}
+	}
+
+	zw := zip.NewWriter(w)
+	manifestWriter, err := zw.Create(ManifestFilename)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(manifestWriter)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(m)
+	if err != nil {
+		return err
+	}
+	for i, module := range course.Modules {
+		moduleWriter, err := zw.Create(m.Modules[i])
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(moduleWriter, module.Markdown())
+		if err != nil {
+			return err
+		}
+	}
+	err = writeAssets(zw, course.Assets)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeAssets(zw *zip.Writer, assets []Asset) error {
+	for _, asset := range assets {
+		// Assets are usually already compressed
+		assetWriter, err := zw.CreateHeader(&zip.FileHeader{Name: asset.Filename(), Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		_, err = assetWriter.Write(asset.Data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const ModuleArchiveFilename = "module.md"
+
+// Writes a single module with the assets it references as a zip file,
+// so it can be used without the server.
+func WriteModuleArchive(w io.Writer, module Module, assets []Asset) error {
+	zw := zip.NewWriter(w)
+	moduleWriter, err := zw.Create(ModuleArchiveFilename)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(moduleWriter, module.Markdown())
+	if err != nil {
+		return err
+	}
+	err = writeAssets(zw, assets)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Reads a file no longer than limit, checking what it actually
+// decompresses to as well as what its header says, since either can lie.
+func readZipFile(file *zip.File, limit int) ([]byte, error) {
+	if file.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%s is longer than %d bytes", file.Name, limit)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("%s is longer than %d bytes", file.Name, limit)
+	}
+	return data, nil
+}
+
+// How much a bundle can have once decompressed, so reading one can't
+// take more memory than a course is allowed to.
+type BundleLimits struct {
+	MaxModules int
+	// Also the most the manifest can be
+	MaxModuleLength int
+	MaxAssets       int
+	MaxAssetLength  int
+}
+
+// The most everything in a bundle can decompress to together.
+func (l BundleLimits) MaxLength() int {
+	return (l.MaxModules+1)*l.MaxModuleLength + l.MaxAssets*l.MaxAssetLength
+}
+
+// Reads each file of a bundle at most once, and no more in total than the limits allow.
+type bundleReader struct {
+	files     map[string]*zip.File
+	read      map[string]bool
+	maxLength int
+	// How many more bytes can be decompressed
+	remaining int
+}
+
+func (r *bundleReader) readFile(name string, limit int) ([]byte, error) {
+	file, ok := r.files[name]
+	if !ok {
+		return nil, fmt.Errorf("bundle is missing %s", name)
+	}
+	if r.read[name] {
+		return nil, fmt.Errorf("%s listed more than once", name)
+	}
+	r.read[name] = true
+	data, err := readZipFile(file, min(limit, r.remaining))
+	if err != nil && r.remaining < limit {
+		return nil, fmt.Errorf("bundle is larger than %d bytes once decompressed", r.maxLength)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.remaining -= len(data)
+	return data, nil
+}
+
+// Checks the manifest lists no more modules and assets than the limits
+// allow before decompressing any of them.
+func ReadBundle(data []byte, limits BundleLimits) (Course, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return Course{}, fmt.Errorf("bundle is not a valid zip file: %v", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range zr.File {
+		files[file.Name] = file
+	}
+	r := &bundleReader{files, make(map[string]bool), limits.MaxLength(), limits.MaxLength()}
+	manifestData, err := r.readFile(ManifestFilename, limits.MaxModuleLength)
+	if err != nil {
+		return Course{}, err
+	}
+	var m manifest
+	err = json.Unmarshal(manifestData, &m)
+	if err != nil {
+		return Course{}, fmt.Errorf("invalid %s: %v", ManifestFilename, err)
+	}
+	if len(m.Modules) > limits.MaxModules {
+		return Course{}, fmt.Errorf("bundle cannot have more than %d modules", limits.MaxModules)
+	}
+	if len(m.Assets) > limits.MaxAssets {
+		return Course{}, fmt.Errorf("bundle cannot have more than %d assets", limits.MaxAssets)
+	}
+
+	moduleIdxs := make(map[string]int)
+	modules := make([]Module, len(m.Modules))
+	for i, filename := range m.Modules {
+		moduleIdxs[filename] = i
+		moduleData, err := r.readFile(filename, limits.MaxModuleLength)
+		if err != nil {
+			return Course{}, err
+		}
+		module, err := Parse(string(moduleData))
+		if err != nil {
+			return Course{}, fmt.Errorf("%s: %v", filename, err)
+		}
+		modules[i] = module
+	}
+	prereqs := make([]Prereq, len(m.Prereqs))
+	for i, prereq := range m.Prereqs {
+		moduleIdx, ok := moduleIdxs[prereq.Module]
+		if !ok {
+			return Course{}, fmt.Errorf("prereq references unknown module %s", prereq.Module)
+		}
+		prereqModuleIdx, ok := moduleIdxs[prereq.PrereqModule]
+		if !ok {
+			return Course{}, fmt.Errorf("prereq references unknown module %s", prereq.PrereqModule)
+		}
+		prereqs[i] = NewPrereq(moduleIdx, prereqModuleIdx)
+	}
+	knowledgePoints := m.KnowledgePoints
+	if knowledgePoints == nil {
+		knowledgePoints = []string{}
+	}
+	assets := make([]Asset, len(m.Assets))
+	for i, manifestAsset := range m.Assets {
+		if _, ok := AssetExtensions[manifestAsset.ContentType]; !ok {
+			return Course{}, fmt.Errorf("asset %s has unsupported content type %s", manifestAsset.File, manifestAsset.ContentType)
+		}
+		data, err := r.readFile(manifestAsset.File, limits.MaxAssetLength)
+		if err != nil {
+			return Course{}, err
+		}
+		// Modules reference assets by hash, so the file has to actually have it.
+		assets[i] = NewAsset(manifestAsset.ContentType, data)
+		if assets[i].Filename() != manifestAsset.File {
+			return Course{}, fmt.Errorf("asset %s doesn't match its hash", manifestAsset.File)
+		}
+	}
+	return NewCourse(m.Title, m.Description, m.Public, modules, prereqs, knowledgePoints, assets), nil
+}
@@ -0,0 +1,206 @@
+package protocol
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Question banks in spreadsheets can be imported as CSV with a header row
+// naming the columns, in any order (see spec.md):
+//
+//	type,question,choice,choice,choice,answer,knowledge_point,explanation
+//	multiple_choice,What is 2+2?,3,4,5,4,Addition,Count it out.
+//
+// Each other row is a question.
+
+const (
+	csvType           = "type"
+	csvQuestion       = "question"
+	csvAnswer         = "answer"
+	csvTolerance      = "tolerance"
+	csvGrading        = "grading"
+	csvKnowledgePoint = "knowledge_point"
+	csvExplanation    = "explanation"
+	// Like a question marker without a type, but its answer is true or false
+	csvTrueFalse = "true_false"
+)
+
+// Any number of choice columns, e.g. "choice", or "choice 1" and "choice 2"
+var csvChoiceRegex = regexp.MustCompile(`^choice\s*\d*$`)
+
+// Separates answers in the answer column, for questions with more than one.
+const csvAnswerSeparator = "|"
+
+// Parses a CSV question bank into blocks, with a diagnostic for each row
+// that can't be converted.
+func ParseCSV(text string) ([]Block, []Diagnostic) {
+	blocks := []Block{}
+	diagnostics := []Diagnostic{}
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return blocks, append(diagnostics, csvDiagnostic(err))
+	}
+	columns := map[string]int{}
+	choiceColumns := []int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		line, column := reader.FieldPos(i)
+		switch {
+		case csvChoiceRegex.MatchString(name):
+			choiceColumns = append(choiceColumns, i)
+		case name == csvType || name == csvQuestion || name == csvAnswer || name == csvTolerance ||
+			name == csvGrading || name == csvKnowledgePoint || name == csvExplanation:
+			if _, ok := columns[name]; ok {
+				diagnostics = append(diagnostics, NewDiagnostic(line, column, fmt.Sprintf("duplicate column %s", name)))
+			}
+			columns[name] = i
+		default:
+			diagnostics = append(diagnostics, NewDiagnostic(line, column, fmt.Sprintf("unknown column %s is ignored", name)))
+		}
+	}
+	if _, ok := columns[csvQuestion]; !ok {
+		return blocks, append(diagnostics, NewDiagnostic(1, 1, "missing question column"))
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The reader can't find where the next row starts after a problem
+			return blocks, append(diagnostics, csvDiagnostic(err))
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		choices := []string{}
+		for _, i := range choiceColumns {
+			if i < len(record) && strings.TrimSpace(record[i]) != "" {
+				choices = append(choices, strings.TrimSpace(record[i]))
+			}
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		question, err := csvQuestionFromRow(field, choices)
+		if err != nil {
+			diagnostics = append(diagnostics, NewDiagnostic(line, 1, err.Error()))
+			continue
+		}
+		blocks = append(blocks, NewQuestionBlock(question))
+	}
+	return blocks, diagnostics
+}
+
+func csvDiagnostic(err error) Diagnostic {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return NewDiagnostic(parseErr.Line, max(parseErr.Column, 1), parseErr.Err.Error())
+	}
+	return NewDiagnostic(1, 1, err.Error())
+}
+
+func csvQuestionFromRow(field func(string) string, choices []string) (Question, error) {
+	text := field(csvQuestion)
+	if text == "" {
+		return Question{}, fmt.Errorf("question cannot be empty")
+	}
+	answer := field(csvAnswer)
+	answers := []string{}
+	for _, a := range strings.Split(answer, csvAnswerSeparator) {
+		if a = strings.TrimSpace(a); a != "" {
+			answers = append(answers, a)
+		}
+	}
+	grading := AllOrNothingGrading
+	switch g := Grading(field(csvGrading)); g {
+	case "":
+	case AllOrNothingGrading, PartialCreditGrading:
+		grading = g
+	default:
+		return Question{}, fmt.Errorf("unknown grading: %s", g)
+	}
+	explanation := field(csvExplanation)
+
+	var question Question
+	questionType := field(csvType)
+	if questionType == "" {
+		questionType = string(MultipleChoiceQuestionType)
+	}
+	switch questionType {
+	case string(MultipleChoiceQuestionType), string(MultiSelectQuestionType):
+		if len(answers) == 0 {
+			return Question{}, fmt.Errorf("question must have an answer")
+		}
+		correct := map[string]bool{}
+		for _, a := range answers {
+			correct[a] = true
+		}
+		questionChoices := []Choice{}
+		for _, choice := range choices {
+			questionChoices = append(questionChoices, NewChoice(choice, correct[choice]))
+			delete(correct, choice)
+		}
+		for _, a := range answers {
+			if correct[a] {
+				return Question{}, fmt.Errorf("answer %s is not one of the choices", a)
+			}
+		}
+		if questionType == string(MultipleChoiceQuestionType) && len(answers) == 1 {
+			question = NewQuestion(text, questionChoices, explanation)
+		} else {
+			question = NewMultiSelectQuestion(text, questionChoices, grading, explanation)
+		}
+	case csvTrueFalse:
+		var value bool
+		switch strings.ToLower(answer) {
+		case "true", "t":
+			value = true
+		case "false", "f":
+			value = false
+		default:
+			return Question{}, fmt.Errorf("answer to a true_false question must be true or false")
+		}
+		question = NewQuestion(text, []Choice{NewChoice("True", value), NewChoice("False", !value)}, explanation)
+	case string(NumericQuestionType):
+		value, err := ParseNumber(answer)
+		if err != nil {
+			return Question{}, fmt.Errorf("invalid answer: %v", err)
+		}
+		tolerance, relative, err := ParseTolerance(field(csvTolerance))
+		if err != nil {
+			return Question{}, fmt.Errorf("invalid tolerance: %v", err)
+		}
+		question = NewNumericQuestion(text, NewNumericAnswer(value, tolerance, relative), explanation)
+	case string(TextQuestionType):
+		if len(answers) == 0 {
+			return Question{}, fmt.Errorf("question must have an answer")
+		}
+		accepted := []AcceptedAnswer{}
+		for _, a := range answers {
+			accepted = append(accepted, NewAcceptedAnswer(a, false))
+		}
+		question = NewTextQuestion(text, NewTextAnswer(accepted, true, true), explanation)
+	case string(OrderingQuestionType):
+		// The choices are the items, in order
+		question = NewOrderingQuestion(text, choices, grading, explanation)
+	default:
+		return Question{}, fmt.Errorf("unknown question type: %s", questionType)
+	}
+	question.KnowledgePoint = field(csvKnowledgePoint)
+	return question, nil
+}
@@ -0,0 +1,235 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The frontmatter is YAML. Besides the title and description, modules
+// can have some optional metadata, and any keys we don't know are kept
+// as they are, so tools can add their own without us dropping them.
+
+// The latest version of the protocol, which modules can say they're written for.
+const ProtocolVersion = 1
+
+type Metadata struct {
+	Authors          []string
+	Tags             []string
+	License          string
+	EstimatedMinutes int
+	Language         string
+	// 0 if the module doesn't say which version of the protocol it's written for
+	ProtocolVersion int
+	// A YAML mapping of the keys we don't know, or empty if there are none
+	Extra string
+}
+
+func NewMetadata(authors []string, tags []string, license string, estimatedMinutes int, language string, protocolVersion int, extra string) Metadata {
+	return Metadata{authors, tags, license, estimatedMinutes, language, protocolVersion, extra}
+}
+
+// e.g. "yaml: line 2: mapping values are not allowed in this context"
+var yamlErrorRegex = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// Parses the lines between the frontmatter's "---" lines into the module,
+// where firstLine is the line number of the first of them.
+func (p *parser) parseFrontmatter(module *Module, lines []string, firstLine int) {
+	diagnose := func(line int, message string) {
+		p.diagnostics = append(p.diagnostics, NewDiagnostic(firstLine+line-1, 1, message))
+	}
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &document)
+	if err != nil && p.parseLegacyFrontmatter(module, lines, firstLine) {
+		return
+	}
+	if err != nil {
+		if matches := yamlErrorRegex.FindStringSubmatch(err.Error()); matches != nil {
+			line, _ := strconv.Atoi(matches[1])
+			diagnose(line, "invalid metadata: "+matches[2])
+		} else {
+			diagnose(1, "invalid metadata: "+strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		return
+	}
+	// Empty frontmatter has no document at all
+	if len(document.Content) == 0 {
+		return
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		diagnose(mapping.Line, "metadata not key value")
+		return
+	}
+	extra := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		var err error
+		switch key.Value {
+		case "title":
+			module.Title, err = decodeString(value)
+			p.source.TitleLine = firstLine + key.Line - 1
+		case "description":
+			module.Description, err = decodeString(value)
+			p.source.DescriptionLine = firstLine + key.Line - 1
+		case "authors":
+			module.Metadata.Authors, err = decodeStrings(value)
+		case "tags":
+			module.Metadata.Tags, err = decodeStrings(value)
+		case "license":
+			module.Metadata.License, err = decodeString(value)
+		case "estimated_minutes":
+			module.Metadata.EstimatedMinutes, err = decodeInt(value)
+			if err == nil && module.Metadata.EstimatedMinutes < 0 {
+				err = fmt.Errorf("cannot be negative")
+			}
+		case "language":
+			module.Metadata.Language, err = decodeString(value)
+		case "protocol_version":
+			module.Metadata.ProtocolVersion, err = decodeInt(value)
+			if err == nil && (module.Metadata.ProtocolVersion < 1 || module.Metadata.ProtocolVersion > ProtocolVersion) {
+				err = fmt.Errorf("unsupported version %d, the latest is %d", module.Metadata.ProtocolVersion, ProtocolVersion)
+			}
+		default:
+			extra.Content = append(extra.Content, key, value)
+			continue
+		}
+		if err != nil {
+			diagnose(value.Line, fmt.Sprintf("invalid %s: %v", key.Value, err))
+		}
+	}
+	if len(extra.Content) > 0 {
+		module.Metadata.Extra = encodeYaml(extra)
+	}
+}
+
+// Before the frontmatter was YAML, each line was just a key, ": " and the rest
+// of the line as its value, so e.g. "title: Ratios: a primer" was fine. Modules
+// written like that still parse, but only their title and description are read,
+// as they were then. Returns false if the lines aren't all like that either.
+func (p *parser) parseLegacyFrontmatter(module *Module, lines []string, firstLine int) bool {
+	keys := make([]string, len(lines))
+	values := make([]string, len(lines))
+	for i, line := range lines {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		keys[i], values[i] = parts[0], parts[1]
+	}
+	p.source.Ignored = append(p.source.Ignored, NewDiagnostic(firstLine, 1, `metadata isn't valid YAML, so each line is read as "key: value"; quote values containing ": "`))
+	for i, key := range keys {
+		switch key {
+		case "title":
+			module.Title = values[i]
+			p.source.TitleLine = firstLine + i
+		case "description":
+			module.Description = values[i]
+			p.source.DescriptionLine = firstLine + i
+		default:
+			p.source.Ignored = append(p.source.Ignored, NewDiagnostic(firstLine+i, 1, fmt.Sprintf("%s is ignored since the metadata isn't valid YAML", key)))
+		}
+	}
+	return true
+}
+
+func decodeString(node *yaml.Node) (string, error) {
+	if node.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("must be a string")
+	}
+	if node.Tag == "!!null" {
+		return "", nil
+	}
+	return node.Value, nil
+}
+
+// Lists can also be written as a single string, e.g. authors: Alec
+func decodeStrings(node *yaml.Node) ([]string, error) {
+	if node.Kind == yaml.ScalarNode {
+		value, err := decodeString(node)
+		if err != nil || value == "" {
+			return nil, err
+		}
+		return []string{value}, nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("must be a list of strings")
+	}
+	values := []string{}
+	for _, item := range node.Content {
+		if item.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("must be a list of strings")
+		}
+		values = append(values, item.Value)
+	}
+	return values, nil
+}
+
+func decodeInt(node *yaml.Node) (int, error) {
+	if node.Kind != yaml.ScalarNode {
+		return 0, fmt.Errorf("must be a whole number")
+	}
+	value, err := strconv.Atoi(node.Value)
+	if err != nil {
+		return 0, fmt.Errorf("must be a whole number")
+	}
+	return value, nil
+}
+
+func stringNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func encodeYaml(node *yaml.Node) string {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	// Nodes we built or decoded ourselves always encode
+	_ = encoder.Encode(node)
+	_ = encoder.Close()
+	return buf.String()
+}
+
+// Returns the module's frontmatter, including the "---" lines, with
+// known keys in a fixed order and then any others as they were.
+func (m Module) frontmatter() string {
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, value *yaml.Node) {
+		mapping.Content = append(mapping.Content, stringNode(key), value)
+	}
+	addStrings := func(key string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		list := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, value := range values {
+			list.Content = append(list.Content, stringNode(value))
+		}
+		add(key, list)
+	}
+	add("title", stringNode(m.Title))
+	add("description", stringNode(m.Description))
+	addStrings("authors", m.Metadata.Authors)
+	addStrings("tags", m.Metadata.Tags)
+	if m.Metadata.License != "" {
+		add("license", stringNode(m.Metadata.License))
+	}
+	if m.Metadata.EstimatedMinutes != 0 {
+		add("estimated_minutes", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(m.Metadata.EstimatedMinutes)})
+	}
+	if m.Metadata.Language != "" {
+		add("language", stringNode(m.Metadata.Language))
+	}
+	if m.Metadata.ProtocolVersion != 0 {
+		add("protocol_version", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(m.Metadata.ProtocolVersion)})
+	}
+	var extra yaml.Node
+	if yaml.Unmarshal([]byte(m.Metadata.Extra), &extra) == nil && len(extra.Content) > 0 && extra.Content[0].Kind == yaml.MappingNode {
+		mapping.Content = append(mapping.Content, extra.Content[0].Content...)
+	}
+	return "---\n" + encodeYaml(mapping) + "---\n"
+}
@@ -0,0 +1,340 @@
+package protocol
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GIFT is Moodle's text format for question banks, e.g.
+//
+//	$CATEGORY: $course$/top/Geography
+//
+//	::Q1:: What is the capital of France? {=Paris ~London ~Berlin}
+//
+// Items are separated by blank lines. Multiple choice, true/false, short
+// answer and numeric questions convert to questions, and items without
+// answers to content. Each category becomes the knowledge point of the
+// questions after it. Answer feedback goes in the question's explanation,
+// since questions here have one explanation rather than one per answer.
+
+// Parses a GIFT question bank into blocks, with a diagnostic for each item
+// that can't be converted.
+func ParseGIFT(text string) ([]Block, []Diagnostic) {
+	blocks := []Block{}
+	diagnostics := []Diagnostic{}
+	category := ""
+	item := []string{}
+	itemLine := 0
+	finishItem := func() {
+		if len(item) == 0 {
+			return
+		}
+		block, err := parseGIFTItem(strings.Join(item, "\n"), category)
+		if err != nil {
+			diagnostics = append(diagnostics, NewDiagnostic(itemLine, 1, err.Error()))
+		} else {
+			blocks = append(blocks, block)
+		}
+		item = []string{}
+	}
+	for i, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "//"):
+			continue
+		case trimmed == "":
+			finishItem()
+		case strings.HasPrefix(trimmed, "$CATEGORY:"):
+			finishItem()
+			category = giftCategoryName(strings.TrimPrefix(trimmed, "$CATEGORY:"))
+		default:
+			if len(item) == 0 {
+				itemLine = i + 1
+			}
+			item = append(item, line)
+		}
+	}
+	finishItem()
+	return blocks, diagnostics
+}
+
+// Categories are paths like $course$/top/Algebra/Linear, where only the
+// last part is the category's own name.
+func giftCategoryName(path string) string {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	name := strings.TrimSpace(parts[len(parts)-1])
+	if name == "$course$" || name == "$system$" || name == "top" {
+		return ""
+	}
+	return name
+}
+
+var giftFormatRegex = regexp.MustCompile(`^\[(html|moodle|plain|markdown)\]`)
+
+func parseGIFTItem(text string, category string) (Block, error) {
+	text = strings.TrimSpace(text)
+	// The title is just a name for the question in Moodle's question bank
+	if strings.HasPrefix(text, "::") {
+		end := indexUnescaped(text[2:], "::")
+		if end == -1 {
+			return Block{}, fmt.Errorf("question title has no closing ::")
+		}
+		text = strings.TrimSpace(text[2+end+2:])
+	}
+	text = strings.TrimSpace(giftFormatRegex.ReplaceAllString(text, ""))
+	open := indexUnescaped(text, "{")
+	if open == -1 {
+		return NewContentBlock(unescapeGIFT(text)), nil
+	}
+	length := indexUnescaped(text[open:], "}")
+	if length == -1 {
+		return Block{}, fmt.Errorf("answers have no closing }")
+	}
+	question, err := parseGIFTAnswers(text[open+1 : open+length])
+	if err != nil {
+		return Block{}, err
+	}
+	// Text after the answers makes it a fill in the blank question
+	question.Text = unescapeGIFT(strings.TrimSpace(text[:open]))
+	if after := strings.TrimSpace(text[open+length+1:]); after != "" {
+		question.Text = strings.TrimSpace(question.Text + " _____ " + unescapeGIFT(after))
+	}
+	if question.Text == "" {
+		return Block{}, fmt.Errorf("question has no text")
+	}
+	question.KnowledgePoint = category
+	return NewQuestionBlock(question), nil
+}
+
+type giftAnswer struct {
+	correct  bool
+	weighted bool
+	weight   float64
+	text     string
+	feedback string
+}
+
+func parseGIFTAnswers(answers string) (Question, error) {
+	answers = strings.TrimSpace(answers)
+	feedback := []string{}
+	if i := indexUnescaped(answers, "####"); i != -1 {
+		feedback = append(feedback, unescapeGIFT(strings.TrimSpace(answers[i+4:])))
+		answers = strings.TrimSpace(answers[:i])
+	}
+	explanation := func(answerFeedback []string) string {
+		return strings.TrimSpace(strings.Join(append(feedback, strings.Join(answerFeedback, "\n")), "\n\n"))
+	}
+
+	if answers == "" {
+		return Question{}, fmt.Errorf("essay questions are not supported")
+	}
+	if strings.HasPrefix(answers, "#") {
+		return parseGIFTNumeric(answers[1:], explanation)
+	}
+	if question, ok := parseGIFTTrueFalse(answers, explanation); ok {
+		return question, nil
+	}
+
+	parsed, err := splitGIFTAnswers(answers)
+	if err != nil {
+		return Question{}, err
+	}
+	answerFeedback := []string{}
+	shortAnswer := true
+	weighted := false
+	for _, answer := range parsed {
+		if answer.feedback != "" {
+			answerFeedback = append(answerFeedback, fmt.Sprintf("- %s: %s", answer.text, answer.feedback))
+		}
+		shortAnswer = shortAnswer && answer.correct
+		weighted = weighted || answer.weighted
+	}
+
+	if shortAnswer {
+		accepted := []AcceptedAnswer{}
+		for _, answer := range parsed {
+			if answer.weighted && answer.weight != 100 {
+				return Question{}, fmt.Errorf("short answers with partial credit are not supported")
+			}
+			accepted = append(accepted, giftAcceptedAnswer(answer.text))
+		}
+		return NewTextQuestion("", NewTextAnswer(accepted, true, true), explanation(answerFeedback)), nil
+	}
+
+	choices := []Choice{}
+	correctCount := 0
+	for _, answer := range parsed {
+		correct := answer.correct || (answer.weighted && answer.weight > 0)
+		if correct {
+			correctCount++
+		}
+		choices = append(choices, NewChoice(answer.text, correct))
+	}
+	if correctCount > 1 || weighted {
+		grading := AllOrNothingGrading
+		if weighted {
+			grading = PartialCreditGrading
+		}
+		return NewMultiSelectQuestion("", choices, grading, explanation(answerFeedback)), nil
+	}
+	return NewQuestion("", choices, explanation(answerFeedback)), nil
+}
+
+// e.g. {T}, {FALSE}, or {TRUE#feedback if wrong#feedback if right}
+func parseGIFTTrueFalse(answers string, explanation func([]string) string) (Question, bool) {
+	parts := splitUnescaped(answers, "#")
+	var answer bool
+	switch strings.TrimSpace(parts[0]) {
+	case "T", "TRUE":
+		answer = true
+	case "F", "FALSE":
+		answer = false
+	default:
+		return Question{}, false
+	}
+	answerFeedback := []string{}
+	labels := []string{"Wrong", "Right"}
+	for i, part := range parts[1:min(len(parts), 3)] {
+		if part := strings.TrimSpace(part); part != "" {
+			answerFeedback = append(answerFeedback, fmt.Sprintf("- %s: %s", labels[i], unescapeGIFT(part)))
+		}
+	}
+	choices := []Choice{NewChoice("True", answer), NewChoice("False", !answer)}
+	return NewQuestion("", choices, explanation(answerFeedback)), true
+}
+
+// e.g. {#3.14:0.01}, {#1..5}, or {#42#feedback}
+func parseGIFTNumeric(answer string, explanation func([]string) string) (Question, error) {
+	if strings.HasPrefix(strings.TrimSpace(answer), "=") {
+		return Question{}, fmt.Errorf("numeric questions with more than one answer are not supported")
+	}
+	parts := splitUnescaped(answer, "#")
+	answerFeedback := []string{}
+	if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+		answerFeedback = append(answerFeedback, unescapeGIFT(strings.TrimSpace(parts[1])))
+	}
+	value := strings.TrimSpace(parts[0])
+	if low, high, ok := strings.Cut(value, ".."); ok {
+		lowValue, err := ParseNumber(low)
+		if err != nil {
+			return Question{}, fmt.Errorf("invalid answer: %v", err)
+		}
+		highValue, err := ParseNumber(high)
+		if err != nil {
+			return Question{}, fmt.Errorf("invalid answer: %v", err)
+		}
+		if lowValue > highValue {
+			return Question{}, fmt.Errorf("invalid answer: %s is more than %s", low, high)
+		}
+		numeric := NewNumericAnswer((lowValue+highValue)/2, (highValue-lowValue)/2, false)
+		return NewNumericQuestion("", numeric, explanation(answerFeedback)), nil
+	}
+	value, tolerance, _ := strings.Cut(value, ":")
+	numericValue, err := ParseNumber(value)
+	if err != nil {
+		return Question{}, fmt.Errorf("invalid answer: %v", err)
+	}
+	numericTolerance := 0.0
+	if strings.TrimSpace(tolerance) != "" {
+		numericTolerance, err = ParseNumber(tolerance)
+		if err != nil || numericTolerance < 0 {
+			return Question{}, fmt.Errorf("invalid tolerance: %s", tolerance)
+		}
+	}
+	numeric := NewNumericAnswer(numericValue, numericTolerance, false)
+	return NewNumericQuestion("", numeric, explanation(answerFeedback)), nil
+}
+
+// Splits answers like "=right#feedback ~%-50%wrong" at each unescaped = or ~.
+func splitGIFTAnswers(answers string) ([]giftAnswer, error) {
+	parsed := []giftAnswer{}
+	starts := []int{}
+	for i := 0; i < len(answers); i++ {
+		if answers[i] == '\\' {
+			i++
+			continue
+		}
+		if answers[i] == '=' || answers[i] == '~' {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) == 0 || strings.TrimSpace(answers[:starts[0]]) != "" {
+		return nil, fmt.Errorf("answers must start with = or ~")
+	}
+	for i, start := range starts {
+		end := len(answers)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		answer := giftAnswer{correct: answers[start] == '='}
+		body := strings.TrimSpace(answers[start+1 : end])
+		if strings.HasPrefix(body, "%") {
+			weight, rest, ok := strings.Cut(body[1:], "%")
+			value, err := strconv.ParseFloat(weight, 64)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("invalid answer weight: %s", body)
+			}
+			answer.weighted = true
+			answer.weight = value
+			body = strings.TrimSpace(rest)
+		}
+		if answer.correct && indexUnescaped(body, "->") != -1 {
+			return nil, fmt.Errorf("matching questions are not supported")
+		}
+		parts := splitUnescaped(body, "#")
+		answer.text = unescapeGIFT(strings.TrimSpace(parts[0]))
+		if len(parts) > 1 {
+			answer.feedback = unescapeGIFT(strings.TrimSpace(strings.Join(parts[1:], "#")))
+		}
+		parsed = append(parsed, answer)
+	}
+	return parsed, nil
+}
+
+// Short answers can use * as a wildcard.
+func giftAcceptedAnswer(text string) AcceptedAnswer {
+	if !strings.Contains(text, "*") {
+		return NewAcceptedAnswer(text, false)
+	}
+	parts := strings.Split(text, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return NewAcceptedAnswer(strings.Join(parts, ".*"), true)
+}
+
+// Returns the index of the first sep in s that isn't escaped with a backslash, or -1.
+func indexUnescaped(s string, sep string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+func splitUnescaped(s string, sep string) []string {
+	parts := []string{}
+	for {
+		i := indexUnescaped(s, sep)
+		if i == -1 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+len(sep):]
+	}
+}
+
+// Only GIFT's own special characters are unescaped, so other backslashes,
+// like in math, are left alone. That includes \n, which would break \neq.
+var giftEscapeRegex = regexp.MustCompile(`\\([~=#{}:\\])`)
+
+func unescapeGIFT(s string) string {
+	return giftEscapeRegex.ReplaceAllString(s, "$1")
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import "fmt"
+
+// Question banks from other tools can be imported as blocks. Items that
+// can't be converted to anything in the protocol, like Moodle's matching
+// questions, are reported as problems at their line instead of dropped.
+
+type ImportFormat string
+
+const (
+	GIFTImportFormat ImportFormat = "gift"
+	CSVImportFormat  ImportFormat = "csv"
+)
+
+// Converts a question bank into blocks. The blocks are the items that
+// converted, and the diagnostics are the ones that didn't.
+func ImportBlocks(format ImportFormat, text string) ([]Block, []Diagnostic, error) {
+	switch format {
+	case GIFTImportFormat:
+		blocks, diagnostics := ParseGIFT(text)
+		return blocks, diagnostics, nil
+	case CSVImportFormat:
+		blocks, diagnostics := ParseCSV(text)
+		return blocks, diagnostics, nil
+	}
+	return nil, nil, fmt.Errorf("unknown import format: %s", format)
+}
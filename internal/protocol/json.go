@@ -0,0 +1,357 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Modules can also be written as JSON, for programs that would rather not
+// parse markdown. It holds exactly what the markdown does, so converting
+// between the two doesn't lose anything, except comments in frontmatter
+// keys we don't know, since JSON has no comments.
+
+// The version of the JSON schema, which changes whenever a change to it
+// would break programs reading it.
+const JSONSchemaVersion = 1
+
+type jsonModule struct {
+	Version     int          `json:"version"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Metadata    jsonMetadata `json:"metadata"`
+	Blocks      []jsonBlock  `json:"blocks"`
+}
+
+type jsonMetadata struct {
+	Authors          []string        `json:"authors,omitempty"`
+	Tags             []string        `json:"tags,omitempty"`
+	License          string          `json:"license,omitempty"`
+	EstimatedMinutes int             `json:"estimatedMinutes,omitempty"`
+	Language         string          `json:"language,omitempty"`
+	ProtocolVersion  int             `json:"protocolVersion,omitempty"`
+	Extra            json.RawMessage `json:"extra,omitempty"`
+}
+
+type jsonBlock struct {
+	Type     BlockType     `json:"type"`
+	Content  string        `json:"content,omitempty"`
+	Question *jsonQuestion `json:"question,omitempty"`
+}
+
+// Only the fields for the question's type are set.
+type jsonQuestion struct {
+	Type                QuestionType         `json:"type"`
+	Text                string               `json:"text"`
+	Choices             []jsonChoice         `json:"choices,omitempty"`
+	Items               []string             `json:"items,omitempty"`
+	Answer              *jsonNumericAnswer   `json:"answer,omitempty"`
+	Accept              []jsonAcceptedAnswer `json:"accept,omitempty"`
+	FoldCase            *bool                `json:"foldCase,omitempty"`
+	NormalizeWhitespace *bool                `json:"normalizeWhitespace,omitempty"`
+	Grading             Grading              `json:"grading,omitempty"`
+	KnowledgePoint      string               `json:"knowledgePoint,omitempty"`
+	Explanation         string               `json:"explanation,omitempty"`
+}
+
+type jsonChoice struct {
+	Text    string `json:"text"`
+	Correct bool   `json:"correct"`
+}
+
+type jsonNumericAnswer struct {
+	Value     float64 `json:"value"`
+	Tolerance float64 `json:"tolerance,omitempty"`
+	Relative  bool    `json:"relative,omitempty"`
+}
+
+type jsonAcceptedAnswer struct {
+	Text  string `json:"text"`
+	Regex bool   `json:"regex,omitempty"`
+}
+
+// Returns the module as indented JSON.
+func (m Module) JSON() ([]byte, error) {
+	extra, err := yamlToJSON(m.Metadata.Extra)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extra metadata: %v", err)
+	}
+	module := jsonModule{
+		Version:     JSONSchemaVersion,
+		Title:       m.Title,
+		Description: m.Description,
+		Metadata: jsonMetadata{
+			Authors:          m.Metadata.Authors,
+			Tags:             m.Metadata.Tags,
+			License:          m.Metadata.License,
+			EstimatedMinutes: m.Metadata.EstimatedMinutes,
+			Language:         m.Metadata.Language,
+			ProtocolVersion:  m.Metadata.ProtocolVersion,
+			Extra:            extra,
+		},
+		Blocks: make([]jsonBlock, len(m.Blocks)),
+	}
+	for i, block := range m.Blocks {
+		switch block.BlockType {
+		case ContentBlockType:
+			module.Blocks[i] = jsonBlock{Type: ContentBlockType, Content: block.Content}
+		case QuestionBlockType:
+			question := questionToJSON(block.Question)
+			module.Blocks[i] = jsonBlock{Type: QuestionBlockType, Question: &question}
+		}
+	}
+	return json.MarshalIndent(module, "", "  ")
+}
+
+func questionToJSON(q Question) jsonQuestion {
+	question := jsonQuestion{
+		Type:           q.QuestionType,
+		Text:           q.Text,
+		KnowledgePoint: q.KnowledgePoint,
+		Explanation:    q.Explanation,
+	}
+	switch q.QuestionType {
+	case MultipleChoiceQuestionType, MultiSelectQuestionType:
+		question.Choices = make([]jsonChoice, len(q.Choices))
+		for i, choice := range q.Choices {
+			question.Choices[i] = jsonChoice{choice.Text, choice.Correct}
+		}
+		if q.QuestionType == MultiSelectQuestionType {
+			question.Grading = q.Grading
+		}
+	case NumericQuestionType:
+		question.Answer = &jsonNumericAnswer{q.Numeric.Value, q.Numeric.Tolerance, q.Numeric.Relative}
+	case TextQuestionType:
+		question.Accept = make([]jsonAcceptedAnswer, len(q.TextAnswer.Accepted))
+		for i, accepted := range q.TextAnswer.Accepted {
+			question.Accept[i] = jsonAcceptedAnswer{accepted.Text, accepted.Regex}
+		}
+		question.FoldCase = &q.TextAnswer.FoldCase
+		question.NormalizeWhitespace = &q.TextAnswer.NormalizeWhitespace
+	case OrderingQuestionType:
+		question.Items = q.Items
+		question.Grading = q.Grading
+	}
+	return question
+}
+
+// Parses a module from JSON. Like Parse, this only checks the module is
+// well formed, not that it makes sense, e.g. that a question has a correct choice.
+func ParseJSON(data []byte) (Module, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var module jsonModule
+	err := decoder.Decode(&module)
+	if err != nil {
+		return Module{}, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if module.Version != JSONSchemaVersion {
+		return Module{}, fmt.Errorf("unsupported version %d, the latest is %d", module.Version, JSONSchemaVersion)
+	}
+	extra, err := jsonToYaml(module.Metadata.Extra)
+	if err != nil {
+		return Module{}, fmt.Errorf("invalid extra metadata: %v", err)
+	}
+	if module.Metadata.EstimatedMinutes < 0 {
+		return Module{}, fmt.Errorf("invalid estimatedMinutes: cannot be negative")
+	}
+	if module.Metadata.ProtocolVersion < 0 || module.Metadata.ProtocolVersion > ProtocolVersion {
+		return Module{}, fmt.Errorf("invalid protocolVersion: unsupported version %d, the latest is %d", module.Metadata.ProtocolVersion, ProtocolVersion)
+	}
+	metadata := NewMetadata(module.Metadata.Authors, module.Metadata.Tags, module.Metadata.License,
+		module.Metadata.EstimatedMinutes, module.Metadata.Language, module.Metadata.ProtocolVersion, extra)
+	blocks := make([]Block, len(module.Blocks))
+	for i, block := range module.Blocks {
+		switch block.Type {
+		case ContentBlockType:
+			if block.Question != nil {
+				return Module{}, fmt.Errorf("block %d: content block cannot have a question", i+1)
+			}
+			blocks[i] = NewContentBlock(block.Content)
+		case QuestionBlockType:
+			if block.Question == nil || block.Content != "" {
+				return Module{}, fmt.Errorf("block %d: question block must have a question and no content", i+1)
+			}
+			question, err := questionFromJSON(*block.Question)
+			if err != nil {
+				return Module{}, fmt.Errorf("block %d: %v", i+1, err)
+			}
+			blocks[i] = NewQuestionBlock(question)
+		default:
+			return Module{}, fmt.Errorf("block %d: unknown block type: %s", i+1, block.Type)
+		}
+	}
+	result := NewModule(module.Title, module.Description, blocks)
+	result.Metadata = metadata
+	return result, nil
+}
+
+func questionFromJSON(q jsonQuestion) (Question, error) {
+	// Same defaults as a question marker without any options
+	question := Question{QuestionType: q.Type, Text: q.Text, Choices: []Choice{}, Items: []string{}, TextAnswer: defaultTextAnswer(),
+		Grading: AllOrNothingGrading, KnowledgePoint: q.KnowledgePoint, Explanation: q.Explanation}
+	switch q.Grading {
+	case "":
+	case AllOrNothingGrading, PartialCreditGrading:
+		question.Grading = q.Grading
+	default:
+		return Question{}, fmt.Errorf("unknown grading: %s", q.Grading)
+	}
+	switch q.Type {
+	case MultipleChoiceQuestionType, MultiSelectQuestionType:
+		for _, choice := range q.Choices {
+			question.Choices = append(question.Choices, NewChoice(choice.Text, choice.Correct))
+		}
+	case NumericQuestionType:
+		if q.Answer == nil {
+			return Question{}, fmt.Errorf("numeric question must have an answer")
+		}
+		if q.Answer.Tolerance < 0 {
+			return Question{}, fmt.Errorf("invalid tolerance: tolerance cannot be negative")
+		}
+		question.Numeric = NewNumericAnswer(q.Answer.Value, q.Answer.Tolerance, q.Answer.Relative)
+	case TextQuestionType:
+		for _, accepted := range q.Accept {
+			question.TextAnswer.Accepted = append(question.TextAnswer.Accepted, NewAcceptedAnswer(accepted.Text, accepted.Regex))
+		}
+		if q.FoldCase != nil {
+			question.TextAnswer.FoldCase = *q.FoldCase
+		}
+		if q.NormalizeWhitespace != nil {
+			question.TextAnswer.NormalizeWhitespace = *q.NormalizeWhitespace
+		}
+	case OrderingQuestionType:
+		question.Items = append(question.Items, q.Items...)
+	default:
+		return Question{}, fmt.Errorf("unknown question type: %s", q.Type)
+	}
+	if !question.hasChoices() && len(q.Choices) > 0 {
+		return Question{}, fmt.Errorf("%s question cannot have choices", q.Type)
+	}
+	if q.Type != OrderingQuestionType && len(q.Items) > 0 {
+		return Question{}, fmt.Errorf("%s question cannot have items", q.Type)
+	}
+	if q.Type != NumericQuestionType && q.Answer != nil {
+		return Question{}, fmt.Errorf("%s question cannot have an answer", q.Type)
+	}
+	if q.Type != TextQuestionType && (len(q.Accept) > 0 || q.FoldCase != nil || q.NormalizeWhitespace != nil) {
+		return Question{}, fmt.Errorf("%s question cannot have accepted answers", q.Type)
+	}
+	return question, nil
+}
+
+// Converts the YAML mapping of extra frontmatter keys to a JSON object,
+// keeping the keys in order.
+func yamlToJSON(extra string) (json.RawMessage, error) {
+	if extra == "" {
+		return nil, nil
+	}
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte(extra), &document)
+	if err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	err = writeYamlNodeAsJSON(&buf, document.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeYamlNodeAsJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		buf.WriteString("{")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			key, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteString(":")
+			err = writeYamlNodeAsJSON(buf, node.Content[i+1])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteString("}")
+	case yaml.SequenceNode:
+		buf.WriteString("[")
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			err := writeYamlNodeAsJSON(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+	case yaml.AliasNode:
+		return writeYamlNodeAsJSON(buf, node.Alias)
+	default:
+		var value any
+		err := node.Decode(&value)
+		if err != nil {
+			return err
+		}
+		// Anything JSON doesn't have, like timestamps, stays as it was written
+		switch value.(type) {
+		case nil, bool, int, float64, string:
+		default:
+			value = node.Value
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
+
+// Converts a JSON object of extra frontmatter keys back to YAML,
+// in the block style the rest of the frontmatter is written in.
+func jsonToYaml(extra json.RawMessage) (string, error) {
+	if len(extra) == 0 || string(extra) == "null" {
+		return "", nil
+	}
+	// JSON is YAML, so it decodes to nodes in the same order
+	var document yaml.Node
+	err := yaml.Unmarshal(extra, &document)
+	if err != nil {
+		return "", err
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("must be an object")
+	}
+	if len(mapping.Content) == 0 {
+		return "", nil
+	}
+	for i := 0; i < len(mapping.Content); i += 2 {
+		switch mapping.Content[i].Value {
+		case "title", "description", "authors", "tags", "license", "estimated_minutes", "language", "protocol_version":
+			return "", fmt.Errorf("%s is not an extra key", mapping.Content[i].Value)
+		}
+	}
+	clearYamlStyle(mapping)
+	return encodeYaml(mapping), nil
+}
+
+func clearYamlStyle(node *yaml.Node) {
+	// Strings that would read as something else unquoted still get quoted when encoded
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYamlStyle(child)
+	}
+}
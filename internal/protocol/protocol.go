@@ -0,0 +1,812 @@
+package protocol
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// The textbook protocol (see spec.md) is markdown with some frontmatter
+// metadata, where markdown comments like `[//]: # (content)` mark the
+// start of each block or piece of a block.
+// This package only deals with text <-> structs, i.e. it knows nothing
+// about how modules are stored, so that anything (server, client, CLI)
+// can parse and produce the same format.
+
+type BlockType string
+
+const (
+	ContentBlockType  BlockType = "content"
+	QuestionBlockType BlockType = "question"
+)
+
+type Module struct {
+	Title       string
+	Description string
+	Metadata    Metadata
+	Blocks      []Block
+}
+
+func NewModule(title string, description string, blocks []Block) Module {
+	return Module{title, description, Metadata{}, blocks}
+}
+
+type Block struct {
+	BlockType BlockType
+	Content   string
+	Question  Question
+}
+
+func NewContentBlock(content string) Block {
+	return Block{ContentBlockType, content, Question{}}
+}
+
+func NewQuestionBlock(question Question) Block {
+	return Block{QuestionBlockType, "", question}
+}
+
+type QuestionType string
+
+const (
+	MultipleChoiceQuestionType QuestionType = "multiple_choice"
+	MultiSelectQuestionType    QuestionType = "multi_select"
+	NumericQuestionType        QuestionType = "numeric"
+	TextQuestionType           QuestionType = "text"
+	OrderingQuestionType       QuestionType = "ordering"
+)
+
+// How a question with more than one part to get right is scored.
+type Grading string
+
+const (
+	AllOrNothingGrading  Grading = "all_or_nothing"
+	PartialCreditGrading Grading = "partial"
+)
+
+type Question struct {
+	QuestionType QuestionType
+	Text         string
+	Choices      []Choice
+	// The items of an ordering question, in their correct order
+	Items      []string
+	Numeric    NumericAnswer
+	TextAnswer TextAnswer
+	Grading    Grading
+	// The name of the course knowledge point this question tests, if given
+	KnowledgePoint string
+	Explanation    string
+}
+
+func NewQuestion(text string, choices []Choice, explanation string) Question {
+	return Question{MultipleChoiceQuestionType, text, choices, []string{}, NumericAnswer{}, defaultTextAnswer(), AllOrNothingGrading, "", explanation}
+}
+
+func NewMultiSelectQuestion(text string, choices []Choice, grading Grading, explanation string) Question {
+	return Question{MultiSelectQuestionType, text, choices, []string{}, NumericAnswer{}, defaultTextAnswer(), grading, "", explanation}
+}
+
+func NewNumericQuestion(text string, answer NumericAnswer, explanation string) Question {
+	return Question{NumericQuestionType, text, []Choice{}, []string{}, answer, defaultTextAnswer(), AllOrNothingGrading, "", explanation}
+}
+
+func NewTextQuestion(text string, answer TextAnswer, explanation string) Question {
+	return Question{TextQuestionType, text, []Choice{}, []string{}, NumericAnswer{}, answer, AllOrNothingGrading, "", explanation}
+}
+
+func NewOrderingQuestion(text string, items []string, grading Grading, explanation string) Question {
+	return Question{OrderingQuestionType, text, []Choice{}, items, NumericAnswer{}, defaultTextAnswer(), grading, "", explanation}
+}
+
+func (q Question) hasChoices() bool {
+	return q.QuestionType == MultipleChoiceQuestionType || q.QuestionType == MultiSelectQuestionType
+}
+
+type Choice struct {
+	Text    string
+	Correct bool
+}
+
+func NewChoice(text string, correct bool) Choice {
+	return Choice{text, correct}
+}
+
+// The expected answer to a numeric question. A relative tolerance is
+// a percentage of the value, e.g. 2 means within 2% of the value.
+type NumericAnswer struct {
+	Value     float64
+	Tolerance float64
+	Relative  bool
+}
+
+func NewNumericAnswer(value float64, tolerance float64, relative bool) NumericAnswer {
+	return NumericAnswer{value, tolerance, relative}
+}
+
+func (a NumericAnswer) Accepts(value float64) bool {
+	tolerance := a.Tolerance
+	if a.Relative {
+		tolerance = math.Abs(a.Value) * a.Tolerance / 100
+	}
+	return math.Abs(value-a.Value) <= tolerance
+}
+
+// Parses a number, rejecting NaN and infinities.
+func ParseNumber(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a number", s)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%s is not a finite number", s)
+	}
+	return value, nil
+}
+
+func FormatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Tolerances are either absolute, e.g. "0.5", or relative, e.g. "2%".
+// An empty tolerance means the answer must match exactly.
+func ParseTolerance(s string) (float64, bool, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false, nil
+	}
+	relative := strings.HasSuffix(s, "%")
+	tolerance, err := ParseNumber(strings.TrimSuffix(s, "%"))
+	if err != nil {
+		return 0, false, err
+	}
+	if tolerance < 0 {
+		return 0, false, fmt.Errorf("tolerance cannot be negative")
+	}
+	return tolerance, relative, nil
+}
+
+func FormatTolerance(tolerance float64, relative bool) string {
+	if tolerance == 0 {
+		return ""
+	}
+	if relative {
+		return FormatNumber(tolerance) + "%"
+	}
+	return FormatNumber(tolerance)
+}
+
+// The accepted answers to a text question. By default answers are
+// compared ignoring case and differences in whitespace.
+type TextAnswer struct {
+	Accepted            []AcceptedAnswer
+	FoldCase            bool
+	NormalizeWhitespace bool
+}
+
+func NewTextAnswer(accepted []AcceptedAnswer, foldCase bool, normalizeWhitespace bool) TextAnswer {
+	return TextAnswer{accepted, foldCase, normalizeWhitespace}
+}
+
+func defaultTextAnswer() TextAnswer {
+	return TextAnswer{[]AcceptedAnswer{}, true, true}
+}
+
+// Either an exact string, or a regular expression that must match the whole answer.
+type AcceptedAnswer struct {
+	Text  string
+	Regex bool
+}
+
+func NewAcceptedAnswer(text string, regex bool) AcceptedAnswer {
+	return AcceptedAnswer{text, regex}
+}
+
+// Normalizing whitespace also trims it, otherwise answers are compared as is.
+func (a TextAnswer) normalize(s string) string {
+	if a.NormalizeWhitespace {
+		return strings.Join(strings.Fields(s), " ")
+	}
+	return s
+}
+
+// Accepted regexes are checked against every answer to their question, so
+// they're compiled once and kept, by pattern. The cache starts over once it
+// holds maxCompiledRegexes, so it can't grow forever.
+const maxCompiledRegexes = 1024
+
+var compiledRegexes = struct {
+	sync.Mutex
+	regexes map[string]*regexp.Regexp
+}{regexes: make(map[string]*regexp.Regexp)}
+
+func (a TextAnswer) compile(accepted AcceptedAnswer) (*regexp.Regexp, error) {
+	flags := ""
+	if a.FoldCase {
+		flags = "(?i)"
+	}
+	pattern := flags + "^(?:" + accepted.Text + ")$"
+	compiledRegexes.Lock()
+	defer compiledRegexes.Unlock()
+	if re, ok := compiledRegexes.regexes[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(compiledRegexes.regexes) >= maxCompiledRegexes {
+		clear(compiledRegexes.regexes)
+	}
+	compiledRegexes.regexes[pattern] = re
+	return re, nil
+}
+
+// Checks that every accepted regex compiles.
+func (a TextAnswer) Validate() error {
+	for _, accepted := range a.Accepted {
+		if !accepted.Regex {
+			continue
+		}
+		_, err := a.compile(accepted)
+		if err != nil {
+			return fmt.Errorf("invalid regex %s: %v", accepted.Text, err)
+		}
+	}
+	return nil
+}
+
+func (a TextAnswer) Accepts(answer string) bool {
+	answer = a.normalize(answer)
+	for _, accepted := range a.Accepted {
+		if accepted.Regex {
+			re, err := a.compile(accepted)
+			if err == nil && re.MatchString(answer) {
+				return true
+			}
+			continue
+		}
+		text := a.normalize(accepted.Text)
+		if text == answer || (a.FoldCase && strings.EqualFold(text, answer)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parsing
+
+type pieceType int
+
+const (
+	parsingNothing pieceType = iota
+	parsingContent
+	parsingQuestion
+	parsingChoice
+	parsingCorrectChoice
+	parsingAnswer
+	parsingAccept
+	parsingItem
+	parsingExplanation
+)
+
+var markerRegex = regexp.MustCompile(`^\[//\]: # \((.+?)\)$`)
+
+type marker struct {
+	pieceType pieceType
+	// Everything after the piece type, e.g. ["numeric"] for (question: numeric)
+	args []string
+	// The 1-indexed line of the marker in the source, or 0 for the start of the file
+	line int
+}
+
+var noMarker = marker{parsingNothing, nil, 0}
+
+// Returns the marker for a line, and whether the line is a marker at all.
+func parseMarker(line string) (marker, bool) {
+	matches := markerRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return noMarker, false
+	}
+	// The first element is the whole match, the second is the captured group
+	values := splitArgs(matches[1])
+	// Block types can optionally be followed by a subtype, e.g. "question: multiple_choice"
+	valueType := strings.TrimSuffix(values[0], ":")
+	args := values[1:]
+	switch valueType {
+	case "content":
+		return marker{parsingContent, args, 0}, true
+	case "question":
+		return marker{parsingQuestion, args, 0}, true
+	case "choice":
+		if len(args) == 1 && args[0] == "correct" {
+			return marker{parsingCorrectChoice, args, 0}, true
+		}
+		return marker{parsingChoice, args, 0}, true
+	case "answer":
+		return marker{parsingAnswer, args, 0}, true
+	case "accept":
+		return marker{parsingAccept, args, 0}, true
+	case "item":
+		return marker{parsingItem, args, 0}, true
+	case "explanation":
+		return marker{parsingExplanation, args, 0}, true
+	}
+	return marker{parsingNothing, args, 0}, true
+}
+
+// Splits marker text on whitespace, except inside double quotes,
+// so that options like kp="Modular inverses" stay one argument.
+func splitArgs(s string) []string {
+	args := []string{}
+	current := strings.Builder{}
+	inQuotes := false
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case inQuotes && r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && unicode.IsSpace(r):
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// Option values can be quoted to include spaces, e.g. kp="Modular inverses".
+func unquoteOption(key string, val string) (string, error) {
+	if !strings.HasPrefix(val, `"`) {
+		return val, nil
+	}
+	unquoted, err := strconv.Unquote(val)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted value for %s: %s", key, val)
+	}
+	return unquoted, nil
+}
+
+func parseBoolOption(key string, val string) (bool, error) {
+	switch val {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s must be true or false", key)
+}
+
+// e.g. (question), (question: numeric), (question: multi_select grading=partial),
+// (question: text fold_case=false), (question: ordering grading=partial),
+// (question kp="Modular inverses")
+// A question without a type is multiple choice, unless it turns out to
+// have more than one correct choice, in which case it's multi select.
+func (p *parser) parseQuestionMarker(args []string) error {
+	p.question = Question{QuestionType: MultipleChoiceQuestionType, Choices: []Choice{}, Items: []string{}, TextAnswer: defaultTextAnswer(), Grading: AllOrNothingGrading}
+	p.questionTypeExplicit = false
+	if len(args) > 0 && !strings.Contains(args[0], "=") {
+		switch questionType := QuestionType(args[0]); questionType {
+		case MultipleChoiceQuestionType, MultiSelectQuestionType, NumericQuestionType, TextQuestionType, OrderingQuestionType:
+			p.question.QuestionType = questionType
+			p.questionTypeExplicit = true
+		default:
+			return fmt.Errorf("unknown question type: %s", args[0])
+		}
+		args = args[1:]
+	}
+	for _, arg := range args {
+		key, val, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("unknown question option: %s", arg)
+		}
+		var err error
+		switch key {
+		case "grading":
+			switch grading := Grading(val); grading {
+			case AllOrNothingGrading, PartialCreditGrading:
+				p.question.Grading = grading
+			default:
+				return fmt.Errorf("unknown grading: %s", val)
+			}
+		case "fold_case":
+			p.question.TextAnswer.FoldCase, err = parseBoolOption(key, val)
+		case "normalize_whitespace":
+			p.question.TextAnswer.NormalizeWhitespace, err = parseBoolOption(key, val)
+		case "kp":
+			p.question.KnowledgePoint, err = unquoteOption(key, val)
+			if err == nil && strings.TrimSpace(p.question.KnowledgePoint) == "" {
+				err = fmt.Errorf("kp cannot be empty")
+			}
+		default:
+			return fmt.Errorf("unknown question option: %s", arg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) finishQuestion() {
+	correctCount := 0
+	for _, choice := range p.question.Choices {
+		if choice.Correct {
+			correctCount++
+		}
+	}
+	if !p.questionTypeExplicit && correctCount > 1 {
+		p.question.QuestionType = MultiSelectQuestionType
+	}
+	p.blocks = append(p.blocks, NewQuestionBlock(p.question))
+	p.source.BlockLines = append(p.source.BlockLines, p.questionLine)
+	p.question = Question{}
+}
+
+// e.g. (answer 125), (answer 3.14 tolerance=0.01), (answer 9.81 tolerance=1%)
+func parseNumericAnswer(args []string) (NumericAnswer, error) {
+	if len(args) == 0 {
+		return NumericAnswer{}, fmt.Errorf("answer must have a value")
+	}
+	value, err := ParseNumber(args[0])
+	if err != nil {
+		return NumericAnswer{}, fmt.Errorf("invalid answer: %v", err)
+	}
+	answer := NewNumericAnswer(value, 0, false)
+	for _, arg := range args[1:] {
+		key, val, ok := strings.Cut(arg, "=")
+		if !ok || key != "tolerance" {
+			return NumericAnswer{}, fmt.Errorf("unknown answer option: %s", arg)
+		}
+		answer.Tolerance, answer.Relative, err = ParseTolerance(val)
+		if err != nil {
+			return NumericAnswer{}, fmt.Errorf("invalid tolerance: %v", err)
+		}
+	}
+	return answer, nil
+}
+
+// Pieces that belong to the question before them.
+func (m marker) isQuestionPiece() bool {
+	switch m.pieceType {
+	case parsingChoice, parsingCorrectChoice, parsingAnswer, parsingAccept, parsingItem, parsingExplanation:
+		return true
+	}
+	return false
+}
+
+type parser struct {
+	blocks               []Block
+	question             Question
+	questionTypeExplicit bool
+	questionLine         int
+	source               Source
+	diagnostics          []Diagnostic
+	// Set after a problem with a question, so the rest of its pieces are skipped
+	// instead of each causing another problem.
+	skippingQuestion bool
+}
+
+// A problem with a module's source, at a 1-indexed line and column.
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func NewDiagnostic(line int, column int, message string) Diagnostic {
+	return Diagnostic{line, column, message}
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", d.Line, d.Column, d.Message)
+}
+
+// Where the parts of a module are in its source, by 1-indexed line.
+type Source struct {
+	// 0 if the metadata doesn't have them
+	TitleLine       int
+	DescriptionLine int
+	// The marker line of each block in the module
+	BlockLines []int
+	// Parts of the source that aren't part of the module, like unknown markers.
+	// They're ignored like any other markdown comment, but are usually a mistake.
+	Ignored []Diagnostic
+}
+
+// Called whenever we hit a new marker (or the end of the file), to add
+// the text we've buffered since the last marker to the module.
+func (p *parser) finishPiece(current marker, next marker, buffer []string) error {
+	text := strings.TrimSpace(strings.Join(buffer, "\n"))
+	switch current.pieceType {
+	case parsingContent:
+		p.blocks = append(p.blocks, NewContentBlock(text))
+		p.source.BlockLines = append(p.source.BlockLines, current.line)
+	case parsingQuestion:
+		err := p.parseQuestionMarker(current.args)
+		if err != nil {
+			return err
+		}
+		p.question.Text = text
+		p.questionLine = current.line
+	case parsingChoice, parsingCorrectChoice:
+		if !p.question.hasChoices() {
+			return fmt.Errorf("only multiple choice and multi select questions can have choices")
+		}
+		p.question.Choices = append(p.question.Choices, NewChoice(text, current.pieceType == parsingCorrectChoice))
+	case parsingAnswer:
+		if p.question.QuestionType != NumericQuestionType {
+			return fmt.Errorf("only numeric questions can have an answer")
+		}
+		if text != "" {
+			return fmt.Errorf("answer must be given in the marker, e.g. (answer 42)")
+		}
+		answer, err := parseNumericAnswer(current.args)
+		if err != nil {
+			return err
+		}
+		p.question.Numeric = answer
+	case parsingAccept:
+		if p.question.QuestionType != TextQuestionType {
+			return fmt.Errorf("only text questions can have accepted answers")
+		}
+		if text == "" {
+			return fmt.Errorf("accepted answers cannot be empty")
+		}
+		regex := len(current.args) == 1 && current.args[0] == "regex"
+		if len(current.args) > 0 && !regex {
+			return fmt.Errorf("unknown accept option: %s", strings.Join(current.args, " "))
+		}
+		p.question.TextAnswer.Accepted = append(p.question.TextAnswer.Accepted, NewAcceptedAnswer(text, regex))
+		err := p.question.TextAnswer.Validate()
+		if err != nil {
+			return err
+		}
+	case parsingItem:
+		if p.question.QuestionType != OrderingQuestionType {
+			return fmt.Errorf("only ordering questions can have items")
+		}
+		if text == "" {
+			return fmt.Errorf("items cannot be empty")
+		}
+		if len(current.args) > 0 {
+			return fmt.Errorf("unknown item option: %s", strings.Join(current.args, " "))
+		}
+		p.question.Items = append(p.question.Items, text)
+	case parsingExplanation:
+		p.question.Explanation = text
+	}
+
+	nextIsChoice := next.pieceType == parsingChoice || next.pieceType == parsingCorrectChoice
+	nextIsAnswer := next.pieceType == parsingAnswer
+	nextIsAccept := next.pieceType == parsingAccept
+	nextIsItem := next.pieceType == parsingItem
+	if current.pieceType == parsingQuestion {
+		if p.question.QuestionType == NumericQuestionType && !nextIsAnswer {
+			return fmt.Errorf("numeric question must be followed by answer")
+		}
+		if p.question.QuestionType == TextQuestionType && !nextIsAccept {
+			return fmt.Errorf("text question must be followed by accept")
+		}
+		if p.question.QuestionType == OrderingQuestionType && !nextIsItem {
+			return fmt.Errorf("ordering question must be followed by item")
+		}
+		if p.question.hasChoices() && !nextIsChoice {
+			return fmt.Errorf("question must be followed by choice or correct choice")
+		}
+	}
+	if current.pieceType == parsingAnswer && nextIsAnswer {
+		return fmt.Errorf("numeric question can only have one answer")
+	}
+
+	justParsedAnswer := current.pieceType == parsingChoice || current.pieceType == parsingCorrectChoice ||
+		current.pieceType == parsingAnswer || current.pieceType == parsingAccept || current.pieceType == parsingItem
+	nextParsingNonQuestion := !nextIsChoice && !nextIsAnswer && !nextIsAccept && !nextIsItem && next.pieceType != parsingExplanation
+	justParsedExplanation := current.pieceType == parsingExplanation
+	if justParsedExplanation || (justParsedAnswer && nextParsingNonQuestion) {
+		p.finishQuestion()
+	}
+	return nil
+}
+
+// Records a problem with the current piece, and skips the rest of
+// its question, if any, so the parser can keep going.
+func (p *parser) finishPieceOrSkip(current marker, next marker, buffer []string) {
+	if p.skippingQuestion && current.isQuestionPiece() {
+		return
+	}
+	p.skippingQuestion = false
+	err := p.finishPiece(current, next, buffer)
+	if err != nil {
+		p.diagnostics = append(p.diagnostics, NewDiagnostic(max(current.line, 1), 1, err.Error()))
+		p.question = Question{}
+		p.skippingQuestion = true
+	}
+}
+
+func Parse(text string) (Module, error) {
+	module, _, diagnostics := ParseSource(text)
+	if len(diagnostics) > 0 {
+		return Module{}, diagnostics[0]
+	}
+	return module, nil
+}
+
+// Parses a module like Parse, but keeps going after problems to find all of them,
+// and says where each part of the module is. The module only has the blocks
+// that parsed without problems.
+func ParseSource(text string) (Module, Source, []Diagnostic) {
+	metadataUnseen := 0
+	metadataProcessing := 1
+	metadataParsed := 2
+	metadataStatus := metadataUnseen
+	module := Module{}
+	p := parser{blocks: []Block{}, source: Source{BlockLines: []int{}, Ignored: []Diagnostic{}}, diagnostics: []Diagnostic{}}
+	current := noMarker
+	buffer := []string{}
+	// The first line of text since the last marker, for text that isn't in any block
+	textLine := 0
+	frontmatter := []string{}
+	frontmatterLine := 0
+	ignoreText := func() {
+		if current.line == 0 && textLine != 0 {
+			p.source.Ignored = append(p.source.Ignored, NewDiagnostic(textLine, 1, "text before the first marker is ignored"))
+		}
+	}
+
+	lineNumber := 0
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber++
+		if metadataStatus == metadataUnseen && line == "" {
+			continue
+		}
+		if metadataStatus == metadataUnseen && line == "---" {
+			metadataStatus = metadataProcessing
+			frontmatterLine = lineNumber + 1
+			continue
+		}
+		if metadataStatus == metadataProcessing && line == "---" {
+			metadataStatus = metadataParsed
+			p.parseFrontmatter(&module, frontmatter, frontmatterLine)
+			continue
+		}
+		if metadataStatus == metadataProcessing {
+			frontmatter = append(frontmatter, line)
+			continue
+		}
+		if metadataStatus != metadataParsed {
+			p.diagnostics = append(p.diagnostics, NewDiagnostic(lineNumber, 1, "metadata not parsed"))
+			return Module{}, p.source, p.diagnostics
+		}
+
+		next, isMarker := parseMarker(line)
+		if !isMarker {
+			if textLine == 0 && strings.TrimSpace(line) != "" {
+				textLine = lineNumber
+			}
+			buffer = append(buffer, line)
+			continue
+		}
+		next.line = lineNumber
+		if next.pieceType == parsingNothing {
+			p.source.Ignored = append(p.source.Ignored, NewDiagnostic(lineNumber, 1, fmt.Sprintf("unknown marker %s is ignored", line)))
+		}
+		// If we matched a new block, it means we're at the end
+		// of the previous block
+		ignoreText()
+		p.finishPieceOrSkip(current, next, buffer)
+		buffer = []string{}
+		textLine = 0
+		current = next
+	}
+	if err := scanner.Err(); err != nil {
+		p.diagnostics = append(p.diagnostics, NewDiagnostic(lineNumber+1, 1, err.Error()))
+		return Module{}, p.source, p.diagnostics
+	}
+	ignoreText()
+	p.finishPieceOrSkip(current, noMarker, buffer)
+	if metadataStatus != metadataParsed {
+		p.diagnostics = append(p.diagnostics, NewDiagnostic(max(lineNumber, 1), 1, "metadata not parsed"))
+	}
+	module.Blocks = p.blocks
+	return module, p.source, p.diagnostics
+}
+
+// Serializing
+
+func markerLine(text string) string {
+	return fmt.Sprintf("\n[//]: # (%s)", text)
+}
+
+// Adds the options every question type can have to its marker.
+func questionMarkerLine(text string, question Question) string {
+	if question.KnowledgePoint != "" {
+		text += " kp=" + strconv.Quote(question.KnowledgePoint)
+	}
+	return markerLine(text)
+}
+
+// Returns the module in the protocol's canonical markdown form.
+// Parse(m.Markdown()) should always give back m.
+func (m Module) Markdown() string {
+	pieces := make([]string, 0)
+	pieces = append(pieces, m.frontmatter())
+	for _, block := range m.Blocks {
+		switch block.BlockType {
+		case ContentBlockType:
+			pieces = append(pieces, markerLine("content"), block.Content)
+		case QuestionBlockType:
+			question := block.Question
+			switch question.QuestionType {
+			case NumericQuestionType:
+				pieces = append(pieces, questionMarkerLine("question: numeric", question), question.Text)
+				answerMarker := "answer " + FormatNumber(question.Numeric.Value)
+				if question.Numeric.Tolerance != 0 {
+					answerMarker += " tolerance=" + FormatTolerance(question.Numeric.Tolerance, question.Numeric.Relative)
+				}
+				pieces = append(pieces, markerLine(answerMarker))
+			case TextQuestionType:
+				questionMarker := "question: text"
+				if !question.TextAnswer.FoldCase {
+					questionMarker += " fold_case=false"
+				}
+				if !question.TextAnswer.NormalizeWhitespace {
+					questionMarker += " normalize_whitespace=false"
+				}
+				pieces = append(pieces, questionMarkerLine(questionMarker, question), question.Text)
+				for _, accepted := range question.TextAnswer.Accepted {
+					acceptMarker := "accept"
+					if accepted.Regex {
+						acceptMarker += " regex"
+					}
+					pieces = append(pieces, markerLine(acceptMarker), accepted.Text)
+				}
+			case OrderingQuestionType:
+				questionMarker := "question: ordering"
+				if question.Grading == PartialCreditGrading {
+					questionMarker += " grading=partial"
+				}
+				pieces = append(pieces, questionMarkerLine(questionMarker, question), question.Text)
+				for _, item := range question.Items {
+					pieces = append(pieces, markerLine("item"), item)
+				}
+			default:
+				questionMarker := "question"
+				if question.QuestionType == MultiSelectQuestionType {
+					questionMarker = "question: multi_select"
+					if question.Grading == PartialCreditGrading {
+						questionMarker += " grading=partial"
+					}
+				}
+				pieces = append(pieces, questionMarkerLine(questionMarker, question), question.Text)
+				for _, choice := range question.Choices {
+					choiceMarker := "choice"
+					if choice.Correct {
+						choiceMarker += " correct"
+					}
+					pieces = append(pieces, markerLine(choiceMarker), choice.Text)
+				}
+			}
+			if question.Explanation != "" {
+				pieces = append(pieces, markerLine("explanation"), question.Explanation)
+			}
+		}
+	}
+	return strings.Join(pieces, "\n")
+}
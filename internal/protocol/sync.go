@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// A course can be kept as a directory of module files, e.g. in git, and
+// synced to the server. The directory has a manifest naming the course and
+// each module's file, along with the id of the module it was synced to:
+//
+//	{
+//	  "courseId": 3,
+//	  "modules": [
+//	    {"file": "intro.md", "id": 12},
+//	    {"file": "new.md"}
+//	  ]
+//	}
+//
+// Modules without an id are new, and get one the first time they're synced.
+
+const SyncManifestFilename = "noobular.json"
+
+type SyncManifest struct {
+	CourseId int64              `json:"courseId"`
+	Modules  []SyncManifestFile `json:"modules"`
+}
+
+type SyncManifestFile struct {
+	File string `json:"file"`
+	Id   int64  `json:"id,omitempty"`
+}
+
+func ParseSyncManifest(data []byte) (SyncManifest, error) {
+	var manifest SyncManifest
+	err := json.Unmarshal(data, &manifest)
+	if err != nil {
+		return SyncManifest{}, fmt.Errorf("invalid %s: %v", SyncManifestFilename, err)
+	}
+	files := make(map[string]bool)
+	ids := make(map[int64]bool)
+	for _, module := range manifest.Modules {
+		if module.File == "" {
+			return SyncManifest{}, fmt.Errorf("module in %s has no file", SyncManifestFilename)
+		}
+		if files[module.File] {
+			return SyncManifest{}, fmt.Errorf("module %s listed more than once", module.File)
+		}
+		files[module.File] = true
+		if module.Id != 0 && ids[module.Id] {
+			return SyncManifest{}, fmt.Errorf("module id %d listed more than once", module.Id)
+		}
+		ids[module.Id] = true
+	}
+	return manifest, nil
+}
+
+func (m SyncManifest) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Returns a hash of the module's canonical markdown, so two modules have
+// the same hash exactly when they'd be exported the same.
+func (m Module) Hash() string {
+	return AssetHash([]byte(m.Markdown()))
+}
+
+// A module as the server has it, for deciding what needs syncing.
+type ModuleSummary struct {
+	Id    int64  `json:"id"`
+	Title string `json:"title"`
+	Hash  string `json:"hash"`
+}
+
+type SyncActionType string
+
+const (
+	SyncCreate    SyncActionType = "create"
+	SyncUpdate    SyncActionType = "update"
+	SyncUnchanged SyncActionType = "unchanged"
+	SyncDelete    SyncActionType = "delete"
+	// A module on the server that isn't in the manifest, but is kept
+	SyncRemoved SyncActionType = "removed"
+)
+
+type SyncAction struct {
+	ActionType SyncActionType
+	// The file in the manifest, empty for modules only on the server
+	File string
+	// The module on the server, 0 for modules to create
+	ModuleId int64
+	Title    string
+}
+
+func NewSyncAction(actionType SyncActionType, file string, moduleId int64, title string) SyncAction {
+	return SyncAction{actionType, file, moduleId, title}
+}
+
+// Decides what to do to make the server's modules match the manifest's,
+// given the manifest's modules parsed from their files. Modules on the server
+// that aren't in the manifest are deleted if deleteRemoved, otherwise just reported.
+func PlanSync(manifest SyncManifest, modules []Module, remote []ModuleSummary, deleteRemoved bool) ([]SyncAction, error) {
+	if len(modules) != len(manifest.Modules) {
+		return nil, fmt.Errorf("expected %d modules, got %d", len(manifest.Modules), len(modules))
+	}
+	remoteById := make(map[int64]ModuleSummary)
+	for _, summary := range remote {
+		remoteById[summary.Id] = summary
+	}
+	actions := []SyncAction{}
+	synced := make(map[int64]bool)
+	for i, file := range manifest.Modules {
+		module := modules[i]
+		if file.Id == 0 {
+			actions = append(actions, NewSyncAction(SyncCreate, file.File, 0, module.Title))
+			continue
+		}
+		summary, ok := remoteById[file.Id]
+		if !ok {
+			return nil, fmt.Errorf("%s: module %d is not in course %d", file.File, file.Id, manifest.CourseId)
+		}
+		synced[file.Id] = true
+		actionType := SyncUpdate
+		if summary.Hash == module.Hash() {
+			actionType = SyncUnchanged
+		}
+		actions = append(actions, NewSyncAction(actionType, file.File, file.Id, module.Title))
+	}
+	for _, summary := range remote {
+		if synced[summary.Id] {
+			continue
+		}
+		actionType := SyncRemoved
+		if deleteRemoved {
+			actionType = SyncDelete
+		}
+		actions = append(actions, NewSyncAction(actionType, "", summary.Id, summary.Title))
+	}
+	return actions, nil
+}
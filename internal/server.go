@@ -1,10 +1,12 @@
 package internal
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
@@ -19,17 +21,21 @@ const (
 	Production  Environment = "production"
 )
 
-func NewServer(dbClient *db.DbClient, renderer Renderer, webAuthn *webauthn.WebAuthn, jwtSecret []byte, port int, env Environment) *http.Server {
-	router := initRouter(dbClient, renderer, webAuthn, jwtSecret, env)
+func NewServer(dbClient *db.DbClient, renderer Renderer, webAuthn *webauthn.WebAuthn, jwtSecret []byte, config Config, metrics *Metrics) *http.Server {
+	dbClient.SetQueryObserver(metrics.ObserveQuery)
+	renderer.metrics = metrics
+	renderer.contents = NewContentCache(dbClient, DefaultContentCacheSize, metrics)
+	// Metrics are only served on their own server, see NewMetricsServer
+	router := initRouter(dbClient, renderer, webAuthn, jwtSecret, config.Env, config.Limits, config.Log.Forms, metrics)
 	return &http.Server{
-		Addr:      fmt.Sprintf(":%d", port),
+		Addr:      config.Addr,
 		Handler:   router,
 	}
 }
 
-func initRouter(dbClient *db.DbClient, renderer Renderer, webAuthn *webauthn.WebAuthn, jwtSecret []byte, env Environment) *http.ServeMux {
+func initRouter(dbClient *db.DbClient, renderer Renderer, webAuthn *webauthn.WebAuthn, jwtSecret []byte, env Environment, limits Limits, formLogging FormLogging, metrics *Metrics) *http.ServeMux {
 	newHandlerMap := func() HandlerMap {
-		return NewHandlerMap(dbClient, renderer, jwtSecret, env)
+		return NewHandlerMap(dbClient, renderer, jwtSecret, env, limits, formLogging, metrics)
 	}
 	mux := http.NewServeMux()
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
@@ -55,6 +61,13 @@ func initRouter(dbClient *db.DbClient, renderer Renderer, webAuthn *webauthn.Web
 	mux.Handle("/logout", newHandlerMap().
 		Get(authOptionalHandler(handleLogout)))
 
+	mux.Handle("/account", newHandlerMap().
+		Get(authRequiredHandler(handleAccountPage)))
+	mux.Handle("/account/token", newHandlerMap().
+		Post(authRequiredHandler(handleCreateAccessToken)))
+	mux.Handle("/account/token/{tokenId}", newHandlerMap().
+		Delete(authRequiredHandler(handleDeleteAccessToken)))
+
 	mux.Handle("/student", newHandlerMap().
 		Get(authRequiredHandler(handleStudentPage)))
 	mux.Handle("/student/course/{courseId}", newHandlerMap().
@@ -74,25 +87,41 @@ func initRouter(dbClient *db.DbClient, renderer Renderer, webAuthn *webauthn.Web
 	mux.Handle("/teacher/course/create", newHandlerMap().
 		Get(authRequiredHandler(handleCreateCoursePage)).
 		Post(authRequiredHandler(handleCreateCourse)))
+	mux.Handle("/teacher/course/import", newHandlerMap().
+		Post(tokenAuthHandler(db.CourseWriteTokenScope, handleImportCourse)))
 	mux.Handle("/teacher/course/{courseId}", newHandlerMap().
 		Get(authRequiredHandler(handleEditCoursePage)).
 		Put(authRequiredHandler(handleEditCourse)).
 		Delete(authRequiredHandler(handleDeleteCourse)))
+	mux.Handle("/teacher/course/{courseId}/module", newHandlerMap().
+		Get(tokenAuthHandler(db.CourseReadTokenScope, handleGetModuleSummaries)).
+		Post(tokenAuthHandler(db.CourseWriteTokenScope, handleCreateModuleSource)))
 	mux.Handle("/teacher/course/{courseId}/module/{moduleId}", newHandlerMap().
 		Get(authRequiredHandler(handleEditModulePage)).
 		Put(authRequiredHandler(handleEditModule)).
-		Delete(authRequiredHandler(handleDeleteModule)))
+		Delete(tokenAuthHandler(db.CourseWriteTokenScope, handleDeleteModule)))
+	mux.Handle("/teacher/course/{courseId}/module/{moduleId}/source", newHandlerMap().
+		Put(tokenAuthHandler(db.CourseWriteTokenScope, handleUploadModuleSource)))
+	mux.Handle("/teacher/course/{courseId}/module/{moduleId}/import", newHandlerMap().
+		Post(tokenAuthHandler(db.CourseWriteTokenScope, handleImportQuestions)))
 	mux.Handle("/teacher/course/{courseId}/module/{moduleId}/preview", newHandlerMap().
 		Get(authRequiredHandler(handlePreviewModulePage)))
+	mux.Handle("/teacher/course/{courseId}/export", newHandlerMap().
+		Get(tokenAuthHandler(db.CourseReadTokenScope, handleExportCourse)))
 	mux.Handle("/teacher/course/{courseId}/prereq", newHandlerMap().
 		Get(authRequiredHandler(handlePrereqPage)))
 	mux.Handle("/teacher/course/{courseId}/module/{moduleId}/prereq", newHandlerMap().
 		Get(authRequiredHandler(handlePrereqForm)).
 		Put(authRequiredHandler(handleEditPrereqs)))
 	mux.Handle("/teacher/course/{courseId}/module/{moduleId}/export", newHandlerMap().
-		Get(authRequiredHandler(handleExportModule)))
+		Get(tokenAuthHandler(db.CourseReadTokenScope, handleExportModule)))
 	mux.Handle("/teacher/course/{courseId}/knowledge-point", newHandlerMap().
 		Post(authRequiredHandler(handleCreateKnowledgePoint)))
+	mux.Handle("/teacher/course/{courseId}/asset", newHandlerMap().
+		Post(tokenAuthHandler(db.CourseWriteTokenScope, handleUploadAsset)))
+
+	mux.Handle("/asset/{hash}", newHandlerMap().
+		Get(handleAsset))
 
 	mux.Handle("/ui/{questionIdx}/choice", newHandlerMap().
 		Get(handleAddChoice))
@@ -100,6 +129,32 @@ func initRouter(dbClient *db.DbClient, renderer Renderer, webAuthn *webauthn.Web
 		Get(handleAddElement).
 		Delete(handleDeleteElement))
 
+	mux.Handle(ApiPrefix+"/user", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetUser)))
+	mux.Handle(ApiPrefix+"/courses", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetCourses)).
+		Post(apiAuthHandler(db.CourseWriteTokenScope, handleApiCreateCourse)))
+	mux.Handle(ApiPrefix+"/teacher/courses", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetTeacherCourses)))
+	mux.Handle(ApiPrefix+"/student/courses", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetStudentCourses)))
+	mux.Handle(ApiPrefix+"/courses/{courseId}", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetCourse)))
+	mux.Handle(ApiPrefix+"/courses/{courseId}/enrollment", newHandlerMap().
+		Post(apiAuthHandler(db.CourseWriteTokenScope, handleApiEnroll)))
+	mux.Handle(ApiPrefix+"/courses/{courseId}/modules", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetModules)))
+	mux.Handle(ApiPrefix+"/courses/{courseId}/modules/{moduleId}", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetModule)))
+	mux.Handle(ApiPrefix+"/courses/{courseId}/modules/{moduleId}/version", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetModuleVersion)))
+	mux.Handle(ApiPrefix+"/courses/{courseId}/knowledge-points", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetKnowledgePoints)))
+	mux.Handle(ApiPrefix+"/courses/{courseId}/prereqs", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetPrereqs)))
+	mux.Handle(ApiPrefix+"/courses/{courseId}/progress", newHandlerMap().
+		Get(apiAuthHandler(db.CourseReadTokenScope, handleApiGetProgress)))
+
 	return mux
 }
 
@@ -109,10 +164,12 @@ type HandlerContext struct {
 	renderer  Renderer
 	jwtSecret []byte
 	env       Environment
+	limits    Limits
+	metrics   *Metrics
 }
 
-func NewHandlerContext(dbClient *db.DbClient, renderer Renderer, jwtSecret []byte, env Environment) HandlerContext {
-	return HandlerContext{dbClient, renderer, jwtSecret, env}
+func NewHandlerContext(dbClient *db.DbClient, renderer Renderer, jwtSecret []byte, env Environment, limits Limits, metrics *Metrics) HandlerContext {
+	return HandlerContext{dbClient, renderer, jwtSecret, env, limits, metrics}
 }
 
 // Basically an http.Handle but returns an error
@@ -122,9 +179,26 @@ type HandlerMap struct {
 	handlers        map[string]HandlerMapHandler
 	ctx             HandlerContext
 	reloadTemplates bool
+	formLogging     FormLogging
+}
+
+// Remembers the status written, for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
 }
 
 func (hm HandlerMap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	var requestIdOpt uuid.NullUUID
 	requestIdOpt.UnmarshalText([]byte(r.Header.Get("X-Request-Id")))
 	var requestId uuid.UUID
@@ -133,34 +207,51 @@ func (hm HandlerMap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 		requestId = uuid.New()
 	}
+	r = r.WithContext(withRequestLog(r.Context(), &requestLog{id: requestId, route: r.Pattern}))
+	recorder := &statusRecorder{w, http.StatusOK}
+	w = recorder
+	// Logged once we're done, so it has the user and status
+	logAttrs := []any{"method", r.Method, "path", r.URL.Path}
+	defer func() {
+		latency := time.Since(start)
+		logAttrs = append(logAttrs, "status", recorder.status, "latencyMs", latency.Milliseconds())
+		slog.InfoContext(r.Context(), "Request", logAttrs...)
+		hm.ctx.metrics.ObserveRequest(r.Pattern, r.Method, recorder.status, latency)
+	}()
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		hm.writeError(w, r, requestId, badRequestErrorf("Invalid form: %v", err))
 		return
 	}
-	log.Println(requestId, r.Method, r.URL.Path, r.Form)
+	if hm.formLogging != NoFormLogging && len(r.Form) > 0 {
+		logAttrs = append(logAttrs, "form", formLogValue(r.Form, hm.formLogging))
+	}
 	if hm.reloadTemplates {
 		// Reload templates so we don't have to restart the server
 		// to see changes
 		hm.ctx.renderer.refreshTemplates()
 	}
+	// So queries are canceled with the request, and logs are tagged with it
+	ctx := hm.ctx
+	ctx.dbClient = ctx.dbClient.WithContext(r.Context())
+	ctx.renderer.contents = ctx.renderer.contents.WithContext(r.Context())
 	handler, ok := hm.handlers[r.Method]
 	if !ok {
-		log.Printf("%s Method %s not allowed for path %s", requestId, r.Method, r.URL.Path)
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		hm.writeError(w, r, requestId, newHandlerErrorf(MethodNotAllowedErrorKind, "Method %s not allowed for path %s", r.Method, r.URL.Path))
+		return
 	}
-	err = handler(w, r, hm.ctx)
+	err = handler(w, r, ctx)
 	if err != nil {
-		log.Println(requestId, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		hm.writeError(w, r, requestId, err)
 	}
 }
 
-func NewHandlerMap(dbClient *db.DbClient, renderer Renderer, jwtSecret []byte, env Environment) HandlerMap {
+func NewHandlerMap(dbClient *db.DbClient, renderer Renderer, jwtSecret []byte, env Environment, limits Limits, formLogging FormLogging, metrics *Metrics) HandlerMap {
 	return HandlerMap{
 		handlers:        make(map[string]HandlerMapHandler),
-		ctx:             NewHandlerContext(dbClient, renderer, jwtSecret, env),
+		ctx:             NewHandlerContext(dbClient, renderer, jwtSecret, env, limits, metrics),
 		reloadTemplates: env == Local,
+		formLogging:     formLogging,
 	}
 }
 
@@ -192,52 +283,74 @@ type OptionalUserHandler func(http.ResponseWriter, *http.Request, HandlerContext
 
 func handleHomePage(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user *db.User) error {
 	if r.URL.Path != "/" {
-		// TODO: We should return 404 here
-		return fmt.Errorf("Not found")
+		return notFoundErrorf("Page %s not found", r.URL.Path)
 	}
 	return ctx.renderer.RenderHomePage(w, user != nil)
 }
 
+// Assets
+
+// Assets are content addressed, so they never change and can be cached forever.
+func handleAsset(w http.ResponseWriter, r *http.Request, ctx HandlerContext) error {
+	hash := r.PathValue("hash")
+	asset, err := ctx.dbClient.GetAsset(hash)
+	if err == sql.ErrNoRows {
+		return notFoundErrorf("Asset %s not found", hash)
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", asset.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, asset.Hash))
+	// SVGs can have scripts, which shouldn't run if someone opens one directly.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(asset.Data))
+	return nil
+}
+
 // Browse page
 
+// How many public courses the browse page lists.
+const BrowsePageCourses = 32
+
 func handleBrowsePage(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user *db.User) error {
-	courses, err := ctx.dbClient.GetPublicCourses()
+	courses, err := ctx.dbClient.GetPublicCoursesPage(BrowsePageCourses, 0)
 	if err != nil {
 		return err
 	}
-	uiCourses := make([]UiCourse, 0)
-	for _, course := range courses {
-		modules, err := ctx.dbClient.GetModules(course.Id)
+	courseIds := make([]int, len(courses))
+	for i, course := range courses {
+		courseIds[i] = course.Id
+	}
+	modules, err := ctx.dbClient.GetLatestModuleVersions(courseIds)
+	if err != nil {
+		return err
+	}
+	uiModules := make(map[int][]UiModule) // courseId -> modules with blocks
+	for _, module := range modules {
+		if module.BlockCount == 0 {
+			continue
+		}
+		uiModules[module.CourseId] = append(uiModules[module.CourseId], NewUiModuleTeacher(module.CourseId, module.Version))
+	}
+	enrolled := make(map[int]bool) // courseId -> enrolled
+	if user != nil {
+		enrolledCourses, err := ctx.dbClient.GetEnrolledCourses(user.Id)
 		if err != nil {
 			return err
 		}
-		uiModules := make([]UiModule, 0)
-		for _, module := range modules {
-			moduleVersion, err := ctx.dbClient.GetLatestModuleVersion(module.Id)
-			if err != nil {
-				return err
-			}
-			blockCount, err := ctx.dbClient.GetBlockCount(moduleVersion.Id)
-			if err != nil {
-				return err
-			}
-			if blockCount == 0 {
-				continue
-			}
-			uiModules = append(uiModules, NewUiModuleTeacher(course.Id, moduleVersion))
+		for _, course := range enrolledCourses {
+			enrolled[course.Id] = true
 		}
-		if len(uiModules) == 0 {
+	}
+	uiCourses := make([]UiCourse, 0)
+	for _, course := range courses {
+		if len(uiModules[course.Id]) == 0 {
 			continue
 		}
-		enrolled := false
-		if user != nil {
-			_, err = ctx.dbClient.GetEnrollment(user.Id, course.Id)
-			if err != nil && err != sql.ErrNoRows {
-				return err
-			}
-			enrolled = err != sql.ErrNoRows
-		}
-		uiCourses = append(uiCourses, NewUiCourseEnrolled(course, uiModules, enrolled))
+		uiCourses = append(uiCourses, NewUiCourseEnrolled(course, uiModules[course.Id], enrolled[course.Id]))
 	}
 	return ctx.renderer.RenderBrowsePage(w, uiCourses, user != nil)
 }
@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark"
+
+	"noobular/internal/db"
+	"noobular/internal/protocol"
+)
+
+// Renders the latest version of each of a course's modules to a directory
+// of HTML pages that work without the server, e.g. opened from disk:
+//
+//	index.html       the course, linking to each module
+//	module-001.html  each module, in the course's order
+//	assets/          the course's assets
+//	style/           the stylesheets and KaTeX fonts
+//
+// Questions show their answer and explanation when the reader asks for it.
+func ExportSite(dbClient *db.DbClient, renderer Renderer, courseId int, dir string) error {
+	ctx := HandlerContext{dbClient: dbClient, renderer: renderer}
+	course, err := dbClient.GetCourse(courseId)
+	if err != nil {
+		return fmt.Errorf("Course %d not found", courseId)
+	}
+	modules, protocolModules, err := getCourseProtocolModules(ctx, course.Id)
+	if err != nil {
+		return err
+	}
+	assets, err := dbClient.GetCourseAssets(course.Id)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Join(dir, "assets"), 0755)
+	if err != nil {
+		return err
+	}
+	assetFiles := make(map[string]string) // hash -> filename
+	for _, asset := range assets {
+		filename := protocol.NewAsset(asset.ContentType, asset.Data).Filename()
+		err = os.WriteFile(filepath.Join(dir, filename), asset.Data, 0644)
+		if err != nil {
+			return err
+		}
+		assetFiles[asset.Hash] = filename
+	}
+	md := newMdWithAssetUrls(func(hash string) string {
+		if filename, ok := assetFiles[hash]; ok {
+			return filename
+		}
+		return protocol.AssetScheme + hash
+	})
+
+	uiCourse := UiSiteCourse{course.Title, course.Description, make([]UiSiteModuleLink, len(modules))}
+	pages := make([]UiSiteModule, len(modules))
+	for i, module := range modules {
+		pages[i], err = newUiSiteModule(md, course.Title, protocolModules[i], i)
+		if err != nil {
+			return fmt.Errorf("Error rendering module %d: %v", module.Version.ModuleId, err)
+		}
+		uiCourse.Modules[i] = UiSiteModuleLink{module.Version.Title, module.Version.Description, siteModuleFilename(i)}
+	}
+	for i := range pages {
+		if i > 0 {
+			pages[i].Previous = siteModuleFilename(i - 1)
+		}
+		if i+1 < len(pages) {
+			pages[i].Next = siteModuleFilename(i + 1)
+		}
+	}
+
+	var buf bytes.Buffer
+	err = renderer.RenderSiteIndex(&buf, uiCourse)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dir, "index.html"), buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	for i, page := range pages {
+		buf.Reset()
+		err = renderer.RenderSiteModule(&buf, page)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(dir, siteModuleFilename(i)), buf.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return copyDir(filepath.Join(renderer.projectRootDir, "style"), filepath.Join(dir, "style"))
+}
+
+func siteModuleFilename(idx int) string {
+	return fmt.Sprintf("module-%03d.html", idx+1)
+}
+
+func renderMarkdown(md goldmark.Markdown, text string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(text), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+func newUiSiteModule(md goldmark.Markdown, courseTitle string, module protocol.Module, moduleIdx int) (UiSiteModule, error) {
+	page := UiSiteModule{CourseTitle: courseTitle, Title: module.Title, Description: module.Description, Blocks: make([]UiSiteBlock, len(module.Blocks))}
+	// Seeded so exporting the same course gives the same pages
+	shuffle := rand.New(rand.NewPCG(uint64(moduleIdx), 0))
+	questionNumber := 0
+	for i, block := range module.Blocks {
+		if block.BlockType == protocol.ContentBlockType {
+			content, err := renderMarkdown(md, block.Content)
+			if err != nil {
+				return UiSiteModule{}, err
+			}
+			page.Blocks[i] = UiSiteBlock{Content: content}
+			continue
+		}
+		questionNumber++
+		question, err := newUiSiteQuestion(md, block.Question, questionNumber, shuffle)
+		if err != nil {
+			return UiSiteModule{}, err
+		}
+		page.Blocks[i] = UiSiteBlock{Question: &question}
+	}
+	return page, nil
+}
+
+func newUiSiteQuestion(md goldmark.Markdown, question protocol.Question, number int, shuffle *rand.Rand) (UiSiteQuestion, error) {
+	uiQuestion := UiSiteQuestion{Number: number}
+	texts := []string{}
+	// The answer is written as markdown, so math in it renders like everywhere else
+	answer := ""
+	switch question.QuestionType {
+	case protocol.MultipleChoiceQuestionType, protocol.MultiSelectQuestionType:
+		uiQuestion.ChoiceInput = "radio"
+		if question.QuestionType == protocol.MultiSelectQuestionType {
+			uiQuestion.ChoiceInput = "checkbox"
+		}
+		for _, choice := range question.Choices {
+			texts = append(texts, choice.Text)
+			if choice.Correct {
+				answer += "- " + choice.Text + "\n"
+			}
+		}
+	case protocol.NumericQuestionType:
+		uiQuestion.FreeResponse = true
+		answer = protocol.FormatNumber(question.Numeric.Value)
+		if tolerance := protocol.FormatTolerance(question.Numeric.Tolerance, question.Numeric.Relative); tolerance != "" {
+			answer += " ± " + tolerance
+		}
+	case protocol.TextQuestionType:
+		uiQuestion.FreeResponse = true
+		for _, accepted := range question.TextAnswer.Accepted {
+			if accepted.Regex {
+				answer += "- Anything matching `" + accepted.Text + "`\n"
+			} else {
+				answer += "- " + accepted.Text + "\n"
+			}
+		}
+	case protocol.OrderingQuestionType:
+		for i, item := range question.Items {
+			answer += fmt.Sprintf("%d. %s\n", i+1, item)
+		}
+		texts = append(texts, question.Items...)
+		shuffle.Shuffle(len(texts), func(i, j int) { texts[i], texts[j] = texts[j], texts[i] })
+	}
+
+	var err error
+	uiQuestion.Text, err = renderMarkdown(md, question.Text)
+	if err != nil {
+		return UiSiteQuestion{}, err
+	}
+	rendered := make([]template.HTML, len(texts))
+	for i, text := range texts {
+		rendered[i], err = renderMarkdown(md, text)
+		if err != nil {
+			return UiSiteQuestion{}, err
+		}
+	}
+	if question.QuestionType == protocol.OrderingQuestionType {
+		uiQuestion.Items = rendered
+	} else {
+		uiQuestion.Choices = rendered
+	}
+	uiQuestion.Answer, err = renderMarkdown(md, strings.TrimSpace(answer))
+	if err != nil {
+		return UiSiteQuestion{}, err
+	}
+	uiQuestion.Explanation, err = renderMarkdown(md, question.Explanation)
+	if err != nil {
+		return UiSiteQuestion{}, err
+	}
+	return uiQuestion, nil
+}
+
+func copyDir(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relative)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
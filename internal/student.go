@@ -1,15 +1,22 @@
 package internal
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"noobular/internal/db"
+	"noobular/internal/protocol"
 )
 
 // Student page
@@ -41,77 +48,29 @@ func handleStudentCoursePage(w http.ResponseWriter, r *http.Request, ctx Handler
 	if err != nil {
 		return err
 	}
-	modules, err := ctx.dbClient.GetModules(course.Id)
+	progress, err := ctx.dbClient.GetModuleProgress(user.Id, course.Id)
 	if err != nil {
 		return err
 	}
-	visitMap := make(map[int]db.Visit) // moduleId -> visit
-	versionMap := make(map[int]db.ModuleVersion) // moduleId -> module version
-	blockCountMap := make(map[int]int) // moduleId -> block count
-	for _, module := range modules {
-		visit, err := ctx.dbClient.GetVisit(user.Id, module.Id)
-		if err != nil && err != sql.ErrNoRows {
-			return err
-		}
-		if err == nil {
-			visitMap[module.Id] = visit
-		}
-		var moduleVersion db.ModuleVersion
-		if err == sql.ErrNoRows {
-			moduleVersion, err = ctx.dbClient.GetLatestModuleVersion(module.Id)
-		} else {
-			moduleVersion, err = ctx.dbClient.GetModuleVersion(visit.ModuleVersionId)
-		}
-		if err != nil {
-			return err
-		}
-		versionMap[module.Id] = moduleVersion
-		blockCount, err := ctx.dbClient.GetBlockCount(moduleVersion.Id)
-		if err != nil {
-			return err
-		}
-		blockCountMap[module.Id] = blockCount
+	prereqs, err := ctx.dbClient.GetCoursePrereqs(course.Id)
+	if err != nil {
+		return err
 	}
+	completed := completedModules(progress)
 
 	uiModules := make([]UiModule, 0)
 	totalPoints := 0
-	for _, module := range modules {
-		prereqs, err := ctx.dbClient.GetPrereqs(module.Id)
-		if err != nil {
-			return err
-		}
-		completedAllPrereqs := true
-		for _, prereq := range prereqs {
-			visit, ok := visitMap[prereq.PrereqModuleId]
-			if !ok || visit.BlockIndex != blockCountMap[prereq.PrereqModuleId] {
-				completedAllPrereqs = false
-				break
-			}
-		}
-		if !completedAllPrereqs {
-			log.Printf("Skipping module because not all prereqs are completed: %d", module.Id)
+	for _, module := range progress {
+		if !completedPrereqs(prereqs, module.ModuleId, completed) {
+			slog.DebugContext(r.Context(), "Skipping module because not all prereqs are completed", "moduleId", module.ModuleId)
 			continue
 		}
-
-		visit, _ := visitMap[module.Id]
-		moduleVersion, _ := versionMap[module.Id]
-		blockCount := blockCountMap[module.Id]
-		if blockCount == 0 {
-			log.Printf("Skipping module because it has no blocks: %d %s", module.Id, moduleVersion.Title)
+		if module.BlockCount == 0 {
+			slog.DebugContext(r.Context(), "Skipping module because it has no blocks", "moduleId", module.ModuleId)
 			continue
 		}
-		point, err := ctx.dbClient.GetPoint(user.Id, module.Id)
-		if err != nil && err != sql.ErrNoRows {
-			return err
-		}
-		pointCount := 0
-		if err == nil {
-			pointCount = point.Count
-		}
-		totalPoints += pointCount
-		completed := visit.BlockIndex == blockCount
-
-		uiModules = append(uiModules, NewUiModuleStudent(course.Id, moduleVersion, blockCount, completed, point.CreatedAt, pointCount))
+		totalPoints += module.Point.Count
+		uiModules = append(uiModules, NewUiModuleStudent(course.Id, module.Version, module.BlockCount, module.Completed(), module.Point.CreatedAt, module.Point.Count))
 	}
 	sort.Slice(uiModules, func(i, j int) bool {
 		if !uiModules[i].Completed { return true }
@@ -125,6 +84,25 @@ func handleStudentCoursePage(w http.ResponseWriter, r *http.Request, ctx Handler
 	})
 }
 
+// moduleId -> whether the student has completed it
+func completedModules(progress []db.ModuleProgress) map[int]bool {
+	completed := make(map[int]bool)
+	for _, module := range progress {
+		completed[module.ModuleId] = module.Completed()
+	}
+	return completed
+}
+
+// Returns whether every prereq of the module is completed.
+func completedPrereqs(prereqs []db.Prereq, moduleId int, completed map[int]bool) bool {
+	for _, prereq := range prereqs {
+		if prereq.ModuleId == moduleId && !completed[prereq.PrereqModuleId] {
+			return false
+		}
+	}
+	return true
+}
+
 // Take course
 
 func handleTakeCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
@@ -142,7 +120,7 @@ func handleTakeCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext
 		return err
 	}
 	if !course.Public {
-		return fmt.Errorf("Cannot enroll in private course.")
+		return forbiddenErrorf("Cannot enroll in private course.")
 	}
 	_, err = db.InsertEnrollment(tx, user.Id, courseId)
 	if err != nil {
@@ -152,6 +130,7 @@ func handleTakeCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext
 	if err != nil {
 		return err
 	}
+	ctx.metrics.CountEnrollment()
 	w.Header().Add("HX-Redirect", fmt.Sprintf("/student/course/%d", courseId))
 	return nil
 }
@@ -195,7 +174,7 @@ func getModule(ctx HandlerContext, courseId int, moduleId int, userId int64, cre
 			return UiModule{}, db.Visit{}, err
 		}
 	} else if err == sql.ErrNoRows {
-		return UiModule{}, db.Visit{}, fmt.Errorf("No visit found for module %d", moduleId)
+		return UiModule{}, db.Visit{}, notFoundErrorf("No visit found for module %d", moduleId)
 	}
 	moduleVersion, err := ctx.dbClient.GetModuleVersion(visit.ModuleVersionId)
 	if err != nil {
@@ -208,83 +187,118 @@ func getModule(ctx HandlerContext, courseId int, moduleId int, userId int64, cre
 	return NewUiModuleStudent(module.CourseId, moduleVersion, blockCount, false, time.Now(), 0), visit, nil
 }
 
+// Returns a block of a module version as the user sees it.
 func getBlock(ctx HandlerContext, moduleVersionId int64, blockIdx int, userId int64) (UiBlock, error) {
-	block, err := ctx.dbClient.GetBlock(moduleVersionId, blockIdx)
+	module, err := ctx.dbClient.GetModuleContentsForUser(moduleVersionId, userId)
 	if err != nil {
-		return UiBlock{}, fmt.Errorf("Error getting block %d for module %d: %v", blockIdx, moduleVersionId, err)
+		return UiBlock{}, fmt.Errorf("Error getting contents of module %d: %v", moduleVersionId, err)
 	}
-	// TODO: use a html sanitizer like blue monday?
-	if block.BlockType == db.KnowledgePointBlockType {
-		knowledgePoint, err := ctx.dbClient.GetKnowledgePointFromBlock(block.Id)
-		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error getting knowledge point for block %d: %v", block.Id, err)
-		}
-		question, err := ctx.dbClient.GetQuestionFromKnowledgePoint(knowledgePoint.Id)
-		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error getting question for knowledge point %d: %v", knowledgePoint.Id, err)
-		}
-		questionContent, err := ctx.dbClient.GetContent(question.ContentId)
-		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error getting question content for question %d: %v", question.Id, err)
+	block, ok := module.Block(blockIdx)
+	if !ok {
+		return UiBlock{}, fmt.Errorf("Error getting block %d for module %d: %v", blockIdx, moduleVersionId, sql.ErrNoRows)
+	}
+	uiBlock, err := uiBlockFromContents(ctx.renderer.contents, block)
+	if err != nil {
+		return UiBlock{}, err
+	}
+	tokenizeOrdering(ctx, &uiBlock.Question)
+	return uiBlock, nil
+}
+
+// Returns the first n blocks of a module version as the user sees them.
+func getBlocks(ctx HandlerContext, moduleVersionId int64, n int, userId int64) ([]UiBlock, error) {
+	module, err := ctx.dbClient.GetModuleContentsForUser(moduleVersionId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting contents of module %d: %v", moduleVersionId, err)
+	}
+	uiBlocks := make([]UiBlock, n)
+	for blockIdx := 0; blockIdx < n; blockIdx++ {
+		block, ok := module.Block(blockIdx)
+		if !ok {
+			return nil, fmt.Errorf("Error getting block %d for module %d: %v", blockIdx, moduleVersionId, sql.ErrNoRows)
 		}
-		choices, err := ctx.dbClient.GetChoicesForQuestion(question.Id)
+		uiBlocks[blockIdx], err = uiBlockFromContents(ctx.renderer.contents, block)
 		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error getting choices for question %d: %v", question.Id, err)
-		}
-		choiceContents := make([]db.Content, 0)
-		for _, choice := range choices {
-			choiceContent, err := ctx.dbClient.GetContent(choice.ContentId)
-			if err != nil {
-				return UiBlock{}, fmt.Errorf("Error getting choice content for choice %d: %v", choice.Id, err)
-			}
-			choiceContents = append(choiceContents, choiceContent)
+			return nil, err
 		}
-		explanationContent, err := ctx.dbClient.GetExplanationForQuestion(question.Id)
-		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error getting explanation for question %d: %v", question.Id, err)
+		tokenizeOrdering(ctx, &uiBlocks[blockIdx].Question)
+	}
+	return uiBlocks, nil
+}
+
+// Gives each item of an ordering question a token to post its position by,
+// since choice ids could give away the order the items were made in.
+func tokenizeOrdering(ctx HandlerContext, question *UiQuestion) {
+	if !question.IsOrdering() {
+		return
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	question.Ordering.Nonce = hex.EncodeToString(nonce)
+	question.Ordering.Tokens = make(map[int]string, len(question.Choices))
+	for _, item := range question.Choices {
+		question.Ordering.Tokens[item.Id] = orderingItemToken(ctx.jwtSecret, question.Ordering.Nonce, item.Id)
+	}
+}
+
+// Only the server can make an item's token, and a new nonce each render
+// means tokens can't be matched up across renders either.
+func orderingItemToken(secret []byte, nonce string, choiceId int) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "ordering-item:%s:%d", nonce, choiceId)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func uiBlockFromContents(contents *ContentCache, block db.BlockContents) (UiBlock, error) {
+	blockIdx := block.Block.BlockIndex
+	// TODO: use a html sanitizer like blue monday?
+	if block.Block.BlockType == db.KnowledgePointBlockType {
+		question := block.Question
+		if question.Question.QuestionType == db.NumericQuestionType {
+			return numericQuestionBlock(contents, question, blockIdx)
 		}
-		choiceId, err := ctx.dbClient.GetAnswer(userId, question.Id)
-		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error getting answer for question %d: %v", question.Id, err)
+		if question.Question.QuestionType == db.TextQuestionType {
+			return textQuestionBlock(contents, question, blockIdx)
 		}
-
-		questionRendered, err := NewUiContentRendered(questionContent)
+		questionRendered, err := contents.Render(question.Content)
 		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error converting question content for question %d: %v", question.Id, err)
+			return UiBlock{}, fmt.Errorf("Error converting question content for question %d: %v", question.Question.Id, err)
 		}
 		choicesRendered := make([]UiContent, 0)
-		for _, choiceContent := range choiceContents {
-			rendered, err := NewUiContentRendered(choiceContent)
+		for _, choiceContent := range question.ChoiceContents {
+			rendered, err := contents.Render(choiceContent)
 			if err != nil {
-				return UiBlock{}, fmt.Errorf("Error converting choice content for question %d: %v", question.Id, err)
+				return UiBlock{}, fmt.Errorf("Error converting choice content for question %d: %v", question.Question.Id, err)
 			}
 			choicesRendered = append(choicesRendered, rendered)
 		}
-		explanationRendered, err := NewUiContentRendered(explanationContent)
+		explanationRendered, err := contents.Render(question.Explanation)
 		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error converting explanation content for question %d: %v", question.Id, err)
+			return UiBlock{}, fmt.Errorf("Error converting explanation content for question %d: %v", question.Question.Id, err)
 		}
+		choices := question.Choices
+		chosenChoiceIds := question.ChosenChoiceIds
 		var uiQuestion UiQuestion
-		if choiceId == -1 {
-			uiQuestion = NewUiQuestionTake(question, questionRendered, choices, choicesRendered, explanationRendered)
+		if question.Question.QuestionType == db.OrderingQuestionType && len(chosenChoiceIds) == 0 {
+			uiQuestion = NewUiOrderingQuestionTake(question.Question, questionRendered, choices, choicesRendered, explanationRendered)
+		} else if question.Question.QuestionType == db.OrderingQuestionType {
+			uiQuestion = NewUiOrderingQuestionAnswered(question.Question, questionRendered, choices, choicesRendered, chosenChoiceIds, explanationRendered)
+		} else if len(chosenChoiceIds) == 0 {
+			uiQuestion = NewUiQuestionTake(question.Question, questionRendered, choices, choicesRendered, explanationRendered)
 		} else {
-			uiQuestion = NewUiQuestionAnswered(question, questionRendered, choices, choicesRendered, choiceId, explanationRendered)
+			uiQuestion = NewUiQuestionAnswered(question.Question, questionRendered, choices, choicesRendered, chosenChoiceIds, explanationRendered)
 		}
 		uiBlock := NewUiBlockQuestion(uiQuestion, blockIdx)
 		return uiBlock, nil
-	} else if block.BlockType == db.ContentBlockType {
-		content, err := ctx.dbClient.GetContentFromBlock(block.Id)
-		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error getting content for block %d: %v", block.Id, err)
-		}
-		rendered, err := NewUiContentRendered(content)
+	} else if block.Block.BlockType == db.ContentBlockType {
+		rendered, err := contents.Render(block.Content)
 		if err != nil {
-			return UiBlock{}, fmt.Errorf("Error converting content for block %d: %v", block.Id, err)
+			return UiBlock{}, fmt.Errorf("Error converting content for block %d: %v", block.Block.Id, err)
 		}
 		uiBlock := NewUiBlockContent(rendered, blockIdx)
 		return uiBlock, nil
 	} else {
-		return UiBlock{}, fmt.Errorf("Unknown block type %s", block.BlockType)
+		return UiBlock{}, fmt.Errorf("Unknown block type %s", block.Block.BlockType)
 	}
 }
 
@@ -298,45 +312,30 @@ func handleTakeModulePage(w http.ResponseWriter, r *http.Request, ctx HandlerCon
 		return err
 	}
 
-	prereqs, err := ctx.dbClient.GetPrereqs(moduleId)
+	progress, err := ctx.dbClient.GetModuleProgress(user.Id, courseId)
 	if err != nil {
 		return err
 	}
-	completedAllPrereqs := true
-	for _, prereq := range prereqs {
-		visit, err := ctx.dbClient.GetVisit(user.Id, prereq.PrereqModuleId)
-		if err != nil && err != sql.ErrNoRows {
-			return err
-		}
-		if err == sql.ErrNoRows {
-			completedAllPrereqs = false
-			break
-		}
-		blockCount, err := ctx.dbClient.GetBlockCount(visit.ModuleVersionId)
-		if visit.BlockIndex != blockCount {
-			completedAllPrereqs = false
-			break
-		}
+	prereqs, err := ctx.dbClient.GetPrereqs(moduleId)
+	if err != nil {
+		return err
 	}
+	completedAllPrereqs := completedPrereqs(prereqs, moduleId, completedModules(progress))
 	if !completedAllPrereqs {
-		return fmt.Errorf("Cannot take module %d because prereqs are not completed", moduleId)
+		return forbiddenErrorf("Cannot take module %d because prereqs are not completed", moduleId)
 	}
 
 	module, visit, err := getModule(ctx, courseId, moduleId, user.Id, true)
 	if err != nil {
-		return fmt.Errorf("Error getting module %d: %v", moduleId, err)
+		return fmt.Errorf("Error getting module %d: %w", moduleId, err)
 	}
 	if visit.BlockIndex > module.BlockCount {
 		return fmt.Errorf("Block index %d is out of bounds (>=%d) for module %d", visit.BlockIndex, module.BlockCount, moduleId)
 	}
 	nBlocks := min(visit.BlockIndex+1, module.BlockCount)
-	uiBlocks := make([]UiBlock, nBlocks)
-	for blockIdx := 0; blockIdx < nBlocks; blockIdx++ {
-		uiBlock, err := getBlock(ctx, visit.ModuleVersionId, blockIdx, user.Id)
-		if err != nil {
-			return fmt.Errorf("Error getting block %d for module %d: %v", blockIdx, moduleId, err)
-		}
-		uiBlocks[blockIdx] = uiBlock
+	uiBlocks, err := getBlocks(ctx, visit.ModuleVersionId, nBlocks, user.Id)
+	if err != nil {
+		return fmt.Errorf("Error getting blocks for module %d: %v", moduleId, err)
 	}
 	uiModule := UiTakeModulePage{
 		Module:     module,
@@ -353,10 +352,10 @@ func getTakeModule(req takeModuleRequest, ctx HandlerContext, userId int64) (UiT
 		return UiTakeModule{}, db.Visit{}, err
 	}
 	if req.blockIdx >= module.BlockCount {
-		return UiTakeModule{}, db.Visit{}, fmt.Errorf("Block index %d is out of bounds (>=%d) for module %d", req.blockIdx, module.BlockCount, req.moduleId)
+		return UiTakeModule{}, db.Visit{}, notFoundErrorf("Block index %d is out of bounds (>=%d) for module %d", req.blockIdx, module.BlockCount, req.moduleId)
 	}
 	if req.blockIdx > visit.BlockIndex+1 {
-		return UiTakeModule{}, db.Visit{}, fmt.Errorf("Block index %d is ahead of visit block index %d for module %d", req.blockIdx, visit.BlockIndex, req.moduleId)
+		return UiTakeModule{}, db.Visit{}, forbiddenErrorf("Block index %d is ahead of visit block index %d for module %d", req.blockIdx, visit.BlockIndex, req.moduleId)
 	}
 	uiBlock, err := getBlock(ctx, visit.ModuleVersionId, req.blockIdx, userId)
 	if err != nil {
@@ -386,6 +385,163 @@ func handleTakeModule(w http.ResponseWriter, r *http.Request, ctx HandlerContext
 	return ctx.renderer.RenderTakeModule(w, module)
 }
 
+func numericQuestionBlock(contents *ContentCache, question db.QuestionContents, blockIdx int) (UiBlock, error) {
+	questionRendered, err := contents.Render(question.Content)
+	if err != nil {
+		return UiBlock{}, fmt.Errorf("Error converting question content for question %d: %v", question.Question.Id, err)
+	}
+	explanationRendered, err := contents.Render(question.Explanation)
+	if err != nil {
+		return UiBlock{}, fmt.Errorf("Error converting explanation content for question %d: %v", question.Question.Id, err)
+	}
+	var uiQuestion UiQuestion
+	if !question.Answered {
+		uiQuestion = NewUiNumericQuestionTake(question.Question, questionRendered, question.NumericSolution, explanationRendered)
+	} else {
+		uiQuestion = NewUiNumericQuestionAnswered(question.Question, questionRendered, question.NumericSolution, question.NumericAnswer, explanationRendered)
+	}
+	return NewUiBlockQuestion(uiQuestion, blockIdx), nil
+}
+
+func textQuestionBlock(contents *ContentCache, question db.QuestionContents, blockIdx int) (UiBlock, error) {
+	questionRendered, err := contents.Render(question.Content)
+	if err != nil {
+		return UiBlock{}, fmt.Errorf("Error converting question content for question %d: %v", question.Question.Id, err)
+	}
+	explanationRendered, err := contents.Render(question.Explanation)
+	if err != nil {
+		return UiBlock{}, fmt.Errorf("Error converting explanation content for question %d: %v", question.Question.Id, err)
+	}
+	solution := question.TextSolution
+	accepted := question.AcceptedAnswers
+	var uiQuestion UiQuestion
+	if !question.Answered {
+		uiQuestion = NewUiTextQuestionTake(question.Question, questionRendered, solution, accepted, explanationRendered)
+	} else {
+		uiQuestion = NewUiTextQuestionAnswered(question.Question, questionRendered, solution, accepted, question.TextAnswer, explanationRendered)
+	}
+	return NewUiBlockQuestion(uiQuestion, blockIdx), nil
+}
+
+func protocolTextAnswer(solution db.TextSolution, accepted []db.AcceptedAnswer) protocol.TextAnswer {
+	protocolAccepted := make([]protocol.AcceptedAnswer, len(accepted))
+	for i, answer := range accepted {
+		protocolAccepted[i] = protocol.NewAcceptedAnswer(answer.Answer, answer.Regex)
+	}
+	return protocol.NewTextAnswer(protocolAccepted, solution.FoldCase, solution.NormalizeWhitespace)
+}
+
+func textSolutionAccepts(solution db.TextSolution, accepted []db.AcceptedAnswer, answer string) bool {
+	return protocolTextAnswer(solution, accepted).Accepts(answer)
+}
+
+func numericSolutionAccepts(solution db.NumericSolution, value float64) bool {
+	return protocol.NewNumericAnswer(solution.Value, solution.Tolerance, solution.Relative).Accepts(value)
+}
+
+// Scores the chosen choices of a question from 0 to 1. All or nothing
+// grading needs exactly the correct choices chosen. Partial credit gives
+// a point for each correct choice chosen, takes one away for each
+// incorrect choice chosen, and divides by the number of correct choices.
+func gradeChoices(correct []bool, chosen []bool, grading db.Grading) float64 {
+	correctCount := 0
+	correctChosen := 0
+	incorrectChosen := 0
+	for i := range correct {
+		if correct[i] {
+			correctCount++
+		}
+		if chosen[i] && correct[i] {
+			correctChosen++
+		} else if chosen[i] {
+			incorrectChosen++
+		}
+	}
+	if correctCount == 0 {
+		return 0
+	}
+	if grading != db.PartialCreditGrading {
+		if correctChosen == correctCount && incorrectChosen == 0 {
+			return 1
+		}
+		return 0
+	}
+	return max(0, float64(correctChosen-incorrectChosen)/float64(correctCount))
+}
+
+// Scores the order a student put items in from 0 to 1, given the item
+// ids in their correct order. All or nothing grading needs exactly the
+// correct order. Partial credit takes away a share for each pair of
+// items that are in the wrong order relative to each other.
+func gradeOrdering(correctOrder []int, order []int, grading db.Grading) float64 {
+	if len(order) == 0 || len(order) != len(correctOrder) {
+		return 0
+	}
+	if slices.Equal(correctOrder, order) {
+		return 1
+	}
+	if grading != db.PartialCreditGrading {
+		return 0
+	}
+	positions := make([]int, len(order))
+	for i, id := range order {
+		positions[i] = slices.Index(correctOrder, id)
+		if positions[i] == -1 {
+			return 0
+		}
+	}
+	inversions := 0
+	for i := range positions {
+		for j := i + 1; j < len(positions); j++ {
+			if positions[i] > positions[j] {
+				inversions++
+			}
+		}
+	}
+	pairs := len(order) * (len(order) - 1) / 2
+	return 1 - float64(inversions)/float64(pairs)
+}
+
+// Returns the fraction of a question the user got right, from 0 to 1.
+func questionScore(question db.QuestionContents) float64 {
+	if !question.Answered {
+		return 0
+	}
+	switch question.Question.QuestionType {
+	case db.NumericQuestionType:
+		if numericSolutionAccepts(question.NumericSolution, question.NumericAnswer) {
+			return 1
+		}
+		return 0
+	case db.TextQuestionType:
+		if textSolutionAccepts(question.TextSolution, question.AcceptedAnswers, question.TextAnswer) {
+			return 1
+		}
+		return 0
+	case db.MultiSelectQuestionType:
+		correct := make([]bool, len(question.Choices))
+		chosen := make([]bool, len(question.Choices))
+		for i, choice := range question.Choices {
+			correct[i] = choice.Correct
+			chosen[i] = slices.Contains(question.ChosenChoiceIds, choice.Id)
+		}
+		return gradeChoices(correct, chosen, question.Question.Grading)
+	case db.OrderingQuestionType:
+		correctOrder := make([]int, len(question.Choices))
+		for i, choice := range question.Choices {
+			correctOrder[i] = choice.Id
+		}
+		return gradeOrdering(correctOrder, question.ChosenChoiceIds, question.Question.Grading)
+	default:
+		for _, choice := range question.Choices {
+			if choice.Id == question.ChosenChoiceIds[0] && choice.Correct {
+				return 1
+			}
+		}
+		return 0
+	}
+}
+
 func handleAnswerQuestion(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
 	req, err := parseTakeModuleRequest(r)
 	if err != nil {
@@ -396,12 +552,97 @@ func handleAnswerQuestion(w http.ResponseWriter, r *http.Request, ctx HandlerCon
 		return err
 	}
 	if uiTakeModule.Block.BlockType != db.KnowledgePointBlockType {
-		return fmt.Errorf("Tried to submit answer, but block at index %d for module %d is not a knowledge point block", req.blockIdx, req.moduleId)
+		return badRequestErrorf("Tried to submit answer, but block at index %d for module %d is not a knowledge point block", req.blockIdx, req.moduleId)
 	}
 	err = r.ParseForm()
 	if err != nil {
 		return err
 	}
+	if uiTakeModule.Block.Question.IsNumeric() {
+		value, err := protocol.ParseNumber(r.Form.Get("numeric-answer"))
+		if err != nil {
+			return badRequestErrorf("Invalid answer: %v", err)
+		}
+		err = ctx.dbClient.StoreNumericAnswer(user.Id, uiTakeModule.Block.Question.Id, value)
+		if err != nil {
+			return err
+		}
+		ctx.metrics.CountAnswer()
+		uiTakeModule.Block.Question.Numeric = NewUiNumericAnswered(uiTakeModule.Block.Question.Numeric.Solution, value)
+		return ctx.renderer.RenderQuestionSubmitted(w, uiTakeModule)
+	}
+	if uiTakeModule.Block.Question.IsText() {
+		// Kept as is, since whether surrounding whitespace matters is up to the solution
+		answer := r.Form.Get("text-answer")
+		if strings.TrimSpace(answer) == "" {
+			return badRequestErrorf("Answer cannot be empty")
+		}
+		if len(answer) > ctx.limits.MaxChoiceLength {
+			return badRequestErrorf("Answer cannot be longer than %d characters", ctx.limits.MaxChoiceLength)
+		}
+		err = ctx.dbClient.StoreTextAnswer(user.Id, uiTakeModule.Block.Question.Id, answer)
+		if err != nil {
+			return err
+		}
+		ctx.metrics.CountAnswer()
+		textAnswer := uiTakeModule.Block.Question.TextAnswer
+		uiTakeModule.Block.Question.TextAnswer = NewUiTextAnswerAnswered(textAnswer.Solution, textAnswer.Accepted, answer)
+		return ctx.renderer.RenderQuestionSubmitted(w, uiTakeModule)
+	}
+	if uiTakeModule.Block.Question.IsOrdering() {
+		// Each item is given a position from 1 to the number of items,
+		// posted by the token it was rendered with
+		items := uiTakeModule.Block.Question.Choices
+		nonce := r.Form.Get("ordering-nonce")
+		order := make([]int, len(items))
+		for _, item := range items {
+			position, err := strconv.Atoi(r.Form.Get("position-" + orderingItemToken(ctx.jwtSecret, nonce, item.Id)))
+			if err != nil {
+				return badRequestErrorf("Must place every item")
+			}
+			if position < 1 || position > len(items) {
+				return badRequestErrorf("Item positions must be between 1 and %d", len(items))
+			}
+			if order[position-1] != 0 {
+				return badRequestErrorf("Each position can only have one item")
+			}
+			order[position-1] = item.Id
+		}
+		err = ctx.dbClient.StoreAnswers(user.Id, uiTakeModule.Block.Question.Id, order)
+		if err != nil {
+			return err
+		}
+		ctx.metrics.CountAnswer()
+		uiTakeModule.Block.Question.Ordering = NewUiOrderingAnswered(items, order)
+		return ctx.renderer.RenderQuestionSubmitted(w, uiTakeModule)
+	}
+	if uiTakeModule.Block.Question.IsMultiSelect() {
+		chosenChoiceIds := []int{}
+		for _, choiceIdStr := range r.Form["choice"] {
+			choiceId, err := strconv.Atoi(choiceIdStr)
+			if err != nil {
+				return err
+			}
+			if !slices.ContainsFunc(uiTakeModule.Block.Question.Choices, func(c UiChoice) bool { return c.Id == choiceId }) {
+				return badRequestErrorf("Choice %d is not a choice for this question", choiceId)
+			}
+			if !slices.Contains(chosenChoiceIds, choiceId) {
+				chosenChoiceIds = append(chosenChoiceIds, choiceId)
+			}
+		}
+		if len(chosenChoiceIds) == 0 {
+			return badRequestErrorf("Must choose at least one choice")
+		}
+		err = ctx.dbClient.StoreAnswers(user.Id, uiTakeModule.Block.Question.Id, chosenChoiceIds)
+		if err != nil {
+			return err
+		}
+		ctx.metrics.CountAnswer()
+		for i, choice := range uiTakeModule.Block.Question.Choices {
+			uiTakeModule.Block.Question.Choices[i].Chosen = slices.Contains(chosenChoiceIds, choice.Id)
+		}
+		return ctx.renderer.RenderQuestionSubmitted(w, uiTakeModule)
+	}
 	choiceId, err := strconv.Atoi(r.Form.Get("choice"))
 	if err != nil {
 		return err
@@ -410,6 +651,7 @@ func handleAnswerQuestion(w http.ResponseWriter, r *http.Request, ctx HandlerCon
 	if err != nil {
 		return err
 	}
+	ctx.metrics.CountAnswer()
 	for i, choice := range uiTakeModule.Block.Question.Choices {
 		if choice.Id == choiceId {
 			uiTakeModule.Block.Question.Choices[i].Chosen = true
@@ -437,7 +679,7 @@ func handleCompleteModule(w http.ResponseWriter, r *http.Request, ctx HandlerCon
 		return err
 	}
 	if visit.BlockIndex < blockCount-1 {
-		return fmt.Errorf("Tried to complete module %d, but only at block index %d", moduleId, visit.BlockIndex)
+		return conflictErrorf("Tried to complete module %d, but only at block index %d", moduleId, visit.BlockIndex)
 	}
 	if visit.BlockIndex == blockCount {
 		// Already completed, skip to redirect
@@ -446,48 +688,31 @@ func handleCompleteModule(w http.ResponseWriter, r *http.Request, ctx HandlerCon
 	}
 
 	// Calculate points to award
-	blocks, err := ctx.dbClient.GetBlocks(visit.ModuleVersionId)
+	module, err := ctx.dbClient.GetModuleContentsForUser(visit.ModuleVersionId, user.Id)
 	if err != nil {
 		return err
 	}
-	correctAnswers := 0
+	// Partial credit questions can be partially correct, so this isn't always a whole number
+	correctAnswers := 0.0
 	questionCount := 0
-	for _, block := range blocks {
-		if block.BlockType == db.KnowledgePointBlockType {
+	for _, block := range module.Blocks {
+		if block.Block.BlockType == db.KnowledgePointBlockType {
 			questionCount += 1
-			knowledgePoint, err := ctx.dbClient.GetKnowledgePointFromBlock(block.Id)
-			if err != nil {
-				return err
-			}
-			question, err := ctx.dbClient.GetQuestionFromKnowledgePoint(knowledgePoint.Id)
-			if err != nil {
-				return err
-			}
-			choiceId, err := ctx.dbClient.GetAnswer(user.Id, question.Id)
-			if err != nil {
-				return err
-			}
-			choice, err := ctx.dbClient.GetChoice(choiceId)
-			if err != nil {
-				return err
-			}
-			if choice.Correct {
-				correctAnswers += 1
-			}
+			correctAnswers += questionScore(block.Question)
 		}
 	}
 	pointCount := blockCount
 	if questionCount > 0 && correctAnswers == 0 {
 		// No points if all questions are wrong
 		pointCount = 0
-	} else if correctAnswers == questionCount {
+	} else if correctAnswers == float64(questionCount) {
 		// Bonus points for perfect score
 		pointCount += pointCount / 4
-	} else if correctAnswers == questionCount-1 {
+	} else if correctAnswers >= float64(questionCount-1) {
 		// No penalty for one mistake
 	} else {
 		// Get points proportional to correct answers
-		pointCount = pointCount * correctAnswers / questionCount
+		pointCount = int(float64(pointCount) * correctAnswers / float64(questionCount))
 	}
 
 	tx, err := ctx.dbClient.Begin()
@@ -504,6 +729,7 @@ func handleCompleteModule(w http.ResponseWriter, r *http.Request, ctx HandlerCon
 	if err != nil {
 		return err
 	}
+	ctx.metrics.CountModuleCompleted(pointCount)
 	w.Header().Add("HX-Redirect", fmt.Sprintf("/student/course/%d", courseId))
 	return nil
 }
@@ -1,14 +1,21 @@
 package internal
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"noobular/internal/db"
+	"noobular/internal/protocol"
 )
 
 // Courses page
@@ -90,43 +97,44 @@ func parseCreateCourseRequest(r *http.Request) (createCourseRequest, error) {
 	return createCourseRequest{title, description, public, moduleTitles, moduleDescriptions}, nil
 }
 
+// Defaults for the limits servers can configure (see Limits)
 const TitleMaxLength = 128
 const DescriptionMaxLength = 1024
 const MaxModules = 128
 
-func validateCourseRequest(title string, description string, moduleTitles []string, moduleDescriptions []string) error {
+func validateCourseRequest(limits Limits, title string, description string, moduleTitles []string, moduleDescriptions []string) error {
 	if title == "" {
 		return fmt.Errorf("Title cannot be empty")
 	}
-	if len(title) > TitleMaxLength {
-		return fmt.Errorf("Title cannot be longer than %d characters", TitleMaxLength)
+	if len(title) > limits.MaxTitleLength {
+		return fmt.Errorf("Title cannot be longer than %d characters", limits.MaxTitleLength)
 	}
 	if description == "" {
 		return fmt.Errorf("Description cannot be empty")
 	}
-	if len(description) > DescriptionMaxLength {
-		return fmt.Errorf("Description cannot be longer than %d characters", DescriptionMaxLength)
+	if len(description) > limits.MaxDescriptionLength {
+		return fmt.Errorf("Description cannot be longer than %d characters", limits.MaxDescriptionLength)
 	}
 	if len(moduleDescriptions) != len(moduleTitles) {
 		return fmt.Errorf("Each module must have a title and description")
 	}
-	if len(moduleTitles) > MaxModules {
-		return fmt.Errorf("Cannot have more than %d modules", MaxModules)
+	if len(moduleTitles) > limits.MaxModules {
+		return fmt.Errorf("Cannot have more than %d modules", limits.MaxModules)
 	}
 	for _, moduleTitle := range moduleTitles {
 		if moduleTitle == "" {
 			return fmt.Errorf("Module titles cannot be empty")
 		}
-		if len(moduleTitle) > TitleMaxLength {
-			return fmt.Errorf("Module titles cannot be longer than %d characters", TitleMaxLength)
+		if len(moduleTitle) > limits.MaxTitleLength {
+			return fmt.Errorf("Module titles cannot be longer than %d characters", limits.MaxTitleLength)
 		}
 	}
 	for _, moduleDescription := range moduleDescriptions {
 		if moduleDescription == "" {
 			return fmt.Errorf("Module descriptions cannot be empty")
 		}
-		if len(moduleDescription) > DescriptionMaxLength {
-			return fmt.Errorf("Module descriptions cannot be longer than %d characters", DescriptionMaxLength)
+		if len(moduleDescription) > limits.MaxDescriptionLength {
+			return fmt.Errorf("Module descriptions cannot be longer than %d characters", limits.MaxDescriptionLength)
 		}
 	}
 	return nil
@@ -135,11 +143,11 @@ func validateCourseRequest(title string, description string, moduleTitles []stri
 func handleCreateCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
 	req, err := parseCreateCourseRequest(r)
 	if err != nil {
-		return fmt.Errorf("Error parsing create course request: %v", err)
+		return badRequestErrorf("Error parsing create course request: %v", err)
 	}
-	err = validateCourseRequest(req.title, req.description, req.moduleTitles, req.moduleDescriptions)
+	err = validateCourseRequest(ctx.limits, req.title, req.description, req.moduleTitles, req.moduleDescriptions)
 	if err != nil {
-		return fmt.Errorf("Error validating create course request: %v", err)
+		return badRequestErrorf("Error validating create course request: %v", err)
 	}
 	course, err := ctx.dbClient.CreateCourse(user.Id, req.title, req.description, req.public)
 	if err != nil {
@@ -225,11 +233,11 @@ func parseEditCourseRequest(r *http.Request) (editCourseRequest, error) {
 func handleEditCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
 	req, err := parseEditCourseRequest(r)
 	if err != nil {
-		return fmt.Errorf("Error parsing edit course request: %v", err)
+		return badRequestErrorf("Error parsing edit course request: %v", err)
 	}
-	err = validateCourseRequest(req.title, req.description, req.moduleTitles, req.moduleDescriptions)
+	err = validateCourseRequest(ctx.limits, req.title, req.description, req.moduleTitles, req.moduleDescriptions)
 	if err != nil {
-		return fmt.Errorf("Error validating edit course request: %v", err)
+		return badRequestErrorf("Error validating edit course request: %v", err)
 	}
 	_, err = ctx.dbClient.GetTeacherCourse(req.courseId, user.Id)
 	if err != nil {
@@ -302,10 +310,18 @@ func handleAddElement(w http.ResponseWriter, r *http.Request, ctx HandlerContext
 		err = ctx.renderer.RenderNewModule(w, EmptyModule())
 	} else if element == "question" {
 		err = ctx.renderer.RenderNewQuestion(w, EmptyQuestion())
+	} else if element == "multi_select_question" {
+		err = ctx.renderer.RenderNewQuestion(w, EmptyMultiSelectQuestion())
+	} else if element == "ordering_question" {
+		err = ctx.renderer.RenderNewQuestion(w, EmptyOrderingQuestion())
+	} else if element == "text_question" {
+		err = ctx.renderer.RenderNewQuestion(w, EmptyTextQuestion())
+	} else if element == "numeric_question" {
+		err = ctx.renderer.RenderNewQuestion(w, EmptyNumericQuestion())
 	} else if element == "content" {
 		err = ctx.renderer.RenderNewContent(w, EmptyContent())
 	} else {
-		err = fmt.Errorf("Unknown element: %s", element)
+		err = notFoundErrorf("Unknown element: %s", element)
 	}
 	return err
 }
@@ -315,7 +331,11 @@ func handleAddChoice(w http.ResponseWriter, r *http.Request, ctx HandlerContext)
 	if err != nil {
 		return err
 	}
-	return ctx.renderer.RenderNewChoice(w, EmptyChoice(questionIdx))
+	questionType := db.MultipleChoiceQuestionType
+	if t := db.QuestionType(r.URL.Query().Get("type")); t == db.MultiSelectQuestionType || t == db.OrderingQuestionType {
+		questionType = t
+	}
+	return ctx.renderer.RenderNewChoice(w, EmptyChoice(questionIdx, questionType))
 }
 
 func handleDeleteElement(w http.ResponseWriter, r *http.Request, ctx HandlerContext) error {
@@ -359,51 +379,28 @@ func handleEditModulePage(w http.ResponseWriter, r *http.Request, ctx HandlerCon
 	if err != nil {
 		return fmt.Errorf("Error getting module version: %w", err)
 	}
-	blocks, err := ctx.dbClient.GetBlocks(moduleVersion.Id)
+	module, err := ctx.dbClient.GetModuleContents(moduleVersion.Id)
 	if err != nil {
 		return fmt.Errorf("Error getting blocks: %w", err)
 	}
-	uiBlocks := make([]UiBlock, len(blocks))
-	for _, block := range blocks {
-		uiBlock := UiBlock{BlockType: block.BlockType}
-		if block.BlockType == db.ContentBlockType {
-			content, err := ctx.dbClient.GetContentFromBlock(block.Id)
-			if err != nil {
-				return err
-			}
-			uiBlock.Content = NewUiContent(content)
-		} else if block.BlockType == db.KnowledgePointBlockType {
-			knowledgePoint, err := ctx.dbClient.GetKnowledgePointFromBlock(block.Id)
-			if err != nil {
-				return fmt.Errorf("Error getting knowledge point for block %d: %w", block.Id, err)
-			}
-			question, err := ctx.dbClient.GetQuestionFromKnowledgePoint(knowledgePoint.Id)
-			if err != nil {
-				return fmt.Errorf("Error getting question for block %d: %w", block.Id, err)
-			}
-			questionContent, err := ctx.dbClient.GetContent(question.ContentId)
-			if err != nil {
-				return fmt.Errorf("Error getting content for question %d: %w", question.Id, err)
-			}
-			choices, err := ctx.dbClient.GetChoicesForQuestion(question.Id)
-			if err != nil {
-				return fmt.Errorf("Error getting choices for question %d: %w", question.Id, err)
-			}
-			choiceContents := make([]db.Content, 0)
-			for _, choice := range choices {
-				choiceContent, err := ctx.dbClient.GetContent(choice.ContentId)
-				if err != nil {
-					return fmt.Errorf("Error getting content for choice %d: %w", choice.Id, err)
-				}
-				choiceContents = append(choiceContents, choiceContent)
-			}
-			explanation, err := ctx.dbClient.GetExplanationForQuestion(question.Id)
-			if err != nil {
-				return err
+	uiBlocks := make([]UiBlock, len(module.Blocks))
+	for _, block := range module.Blocks {
+		uiBlock := UiBlock{BlockType: block.Block.BlockType}
+		if block.Block.BlockType == db.ContentBlockType {
+			uiBlock.Content = NewUiContent(block.Content)
+		} else if block.Block.BlockType == db.KnowledgePointBlockType {
+			question := block.Question
+			if question.Question.QuestionType == db.NumericQuestionType {
+				uiBlock.Question = NewUiNumericQuestionEdit(question.Question, question.Content, question.NumericSolution, question.Explanation)
+			} else if question.Question.QuestionType == db.TextQuestionType {
+				uiBlock.Question = NewUiTextQuestionEdit(question.Question, question.Content, question.TextSolution, question.AcceptedAnswers, question.Explanation)
+			} else {
+				uiBlock.Question = NewUiQuestionEdit(question.Question, question.Content, question.Choices, question.ChoiceContents, question.Explanation)
 			}
-			uiBlock.Question = NewUiQuestionEdit(question, questionContent, choices, choiceContents, explanation)
+			uiBlock.Question.KnowledgePoint = authoredKnowledgePointName(block.KnowledgePoint)
+			uiBlock.Question.KnowledgePointId = block.KnowledgePoint.Id
 		} else {
-			return fmt.Errorf("invalid block type: %s", block.BlockType)
+			return fmt.Errorf("invalid block type: %s", block.Block.BlockType)
 		}
 		uiBlocks = append(uiBlocks, uiBlock)
 	}
@@ -425,9 +422,23 @@ type editModuleRequest struct {
 	blockTypes        []string
 	contents          []string
 	questions         []string
+	questionTypes     []db.QuestionType
 	choicesByQuestion [][]string
-	correctChoiceIdxs []int
+	// Multiple choice questions have exactly one, multi select questions can have more
+	correctChoiceIdxs [][]int
+	gradings          []db.Grading
+	// Only set for numeric questions
+	numericSolutions []db.NumericSolution
+	// Only set for text questions
+	textSolutions   []db.TextSolution
+	acceptedAnswers [][]db.AcceptedAnswer
+	// Empty if the question should get its own knowledge point
+	knowledgePoints []string
+	// The knowledge point each question tested before it was edited, or -1 for new questions
+	knowledgePointIds []int64
 	explanations      []string
+	// Only set for module source, since the edit form keeps the previous version's metadata
+	metadata *db.ModuleMetadata
 }
 
 func parseEditModuleRequest(r *http.Request) (editModuleRequest, error) {
@@ -444,7 +455,6 @@ func parseEditModuleRequest(r *http.Request) (editModuleRequest, error) {
 	if err != nil {
 		return editModuleRequest{}, err
 	}
-	log.Println("Form:", r.Form)
 	title := r.Form.Get("title")
 	description := r.Form.Get("description")
 	blockTypes := r.Form["block-type[]"]
@@ -454,6 +464,11 @@ func parseEditModuleRequest(r *http.Request) (editModuleRequest, error) {
 	if len(questions) != len(questionIdxs) {
 		return editModuleRequest{}, fmt.Errorf("Each question must have an index")
 	}
+	// Older forms don't send question types, in which case every question is multiple choice.
+	questionTypes := r.Form["question-type[]"]
+	if len(questionTypes) != 0 && len(questionTypes) != len(questions) {
+		return editModuleRequest{}, fmt.Errorf("Each question must have a type")
+	}
 	explanations := r.Form["question-explanation[]"]
 	choices := r.Form["choice-title[]"]
 	// These match choices 1-1 (including having "end-choice")
@@ -463,17 +478,70 @@ func parseEditModuleRequest(r *http.Request) (editModuleRequest, error) {
 	// i.e. we expect r.Form["choice-title[]"] to look something like:
 	// ["choice1", "choice2", "end-choice", "choice3", "choice4", "end-choice"]
 	uiQuestions := make([]string, len(questions))
+	uiQuestionTypes := make([]db.QuestionType, len(questions))
 	uiChoicesByQuestion := make([][]string, len(questions))
-	correctChoicesByQuestion := make([]int, len(questions))
+	correctChoicesByQuestion := make([][]int, len(questions))
+	gradings := make([]db.Grading, len(questions))
+	numericSolutions := make([]db.NumericSolution, len(questions))
+	textSolutions := make([]db.TextSolution, len(questions))
+	acceptedAnswers := make([][]db.AcceptedAnswer, len(questions))
+	knowledgePoints := make([]string, len(questions))
+	knowledgePointIds := make([]int64, len(questions))
 	choiceIdx := 0
 	for i, question := range questions {
-		uiChoices := make([]string, 0)
-		// This holds the choiceUiIdx of the correct choice for each question.
-		correctChoiceIdxStr := r.Form.Get(fmt.Sprintf("correct-choice-%s", questionIdxs[i]))
-		correctChoiceIdx, err := strconv.Atoi(correctChoiceIdxStr)
-		if err != nil {
-			return editModuleRequest{}, fmt.Errorf("Each question must have a correct choice")
+		uiQuestionTypes[i] = db.MultipleChoiceQuestionType
+		if len(questionTypes) != 0 {
+			uiQuestionTypes[i] = db.QuestionType(questionTypes[i])
+		}
+		knowledgePoints[i] = strings.TrimSpace(r.Form.Get(fmt.Sprintf("question-kp-%s", questionIdxs[i])))
+		knowledgePointIds[i] = -1
+		if knowledgePointId := r.Form.Get(fmt.Sprintf("question-kp-id-%s", questionIdxs[i])); knowledgePointId != "" {
+			knowledgePointIds[i], err = strconv.ParseInt(knowledgePointId, 10, 64)
+			if err != nil {
+				return editModuleRequest{}, fmt.Errorf("Invalid knowledge point id: %s", knowledgePointId)
+			}
+		}
+		gradings[i] = db.AllOrNothingGrading
+		if grading := r.Form.Get(fmt.Sprintf("question-grading-%s", questionIdxs[i])); grading != "" {
+			gradings[i] = db.Grading(grading)
+		}
+		// This holds the choiceUiIdxs of the correct choices for each question.
+		correctChoiceUiIdxs := []int{}
+		switch uiQuestionTypes[i] {
+		case db.MultipleChoiceQuestionType, db.MultiSelectQuestionType:
+			correctChoiceIdxStrs := r.Form[fmt.Sprintf("correct-choice-%s", questionIdxs[i])]
+			if len(correctChoiceIdxStrs) == 0 {
+				return editModuleRequest{}, fmt.Errorf("Each question must have a correct choice")
+			}
+			for _, correctChoiceIdxStr := range correctChoiceIdxStrs {
+				correctChoiceUiIdx, err := strconv.Atoi(correctChoiceIdxStr)
+				if err != nil {
+					return editModuleRequest{}, fmt.Errorf("Each question must have a correct choice")
+				}
+				correctChoiceUiIdxs = append(correctChoiceUiIdxs, correctChoiceUiIdx)
+			}
+		case db.OrderingQuestionType:
+			// Items are in their correct order, so there's nothing else to parse
+		case db.NumericQuestionType:
+			value, err := protocol.ParseNumber(r.Form.Get(fmt.Sprintf("numeric-value-%s", questionIdxs[i])))
+			if err != nil {
+				return editModuleRequest{}, fmt.Errorf("Numeric questions must have a valid answer: %v", err)
+			}
+			tolerance, relative, err := protocol.ParseTolerance(r.Form.Get(fmt.Sprintf("numeric-tolerance-%s", questionIdxs[i])))
+			if err != nil {
+				return editModuleRequest{}, fmt.Errorf("Numeric questions must have a valid tolerance: %v", err)
+			}
+			numericSolutions[i] = db.NewNumericSolution(-1, -1, value, tolerance, relative)
+		case db.TextQuestionType:
+			foldCase := r.Form.Get(fmt.Sprintf("text-fold-case-%s", questionIdxs[i])) != ""
+			normalizeWhitespace := r.Form.Get(fmt.Sprintf("text-normalize-whitespace-%s", questionIdxs[i])) != ""
+			textSolutions[i] = db.NewTextSolution(-1, -1, foldCase, normalizeWhitespace)
+			acceptedAnswers[i] = parseAcceptedLines(r.Form.Get(fmt.Sprintf("text-accept-%s", questionIdxs[i])))
+		default:
+			return editModuleRequest{}, fmt.Errorf("Unknown question type: %s", uiQuestionTypes[i])
 		}
+		uiChoices := make([]string, 0)
+		correctChoicesByQuestion[i] = []int{}
 		for ; choiceIdx < len(choices); choiceIdx++ {
 			choice := choices[choiceIdx]
 			if choice == "end-choice" {
@@ -485,8 +553,8 @@ func parseEditModuleRequest(r *http.Request) (editModuleRequest, error) {
 			if err != nil {
 				return editModuleRequest{}, fmt.Errorf("Error parsing choice index: %v", err)
 			}
-			if choiceUiIdx == correctChoiceIdx {
-				correctChoicesByQuestion[i] = len(uiChoices) - 1
+			if slices.Contains(correctChoiceUiIdxs, choiceUiIdx) {
+				correctChoicesByQuestion[i] = append(correctChoicesByQuestion[i], len(uiChoices)-1)
 			}
 		}
 		uiQuestions[i] = question
@@ -500,53 +568,151 @@ func parseEditModuleRequest(r *http.Request) (editModuleRequest, error) {
 		blockTypes,
 		contents,
 		uiQuestions,
+		uiQuestionTypes,
 		uiChoicesByQuestion,
 		correctChoicesByQuestion,
+		gradings,
+		numericSolutions,
+		textSolutions,
+		acceptedAnswers,
+		knowledgePoints,
+		knowledgePointIds,
 		explanations,
+		nil,
 	}, nil
 }
 
+// The edit form takes one accepted answer per line, where /regex/ is a regex.
+func parseAcceptedLines(text string) []db.AcceptedAnswer {
+	accepted := []db.AcceptedAnswer{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+			accepted = append(accepted, db.NewAcceptedAnswer(-1, -1, line[1:len(line)-1], true))
+		} else {
+			accepted = append(accepted, db.NewAcceptedAnswer(-1, -1, line, false))
+		}
+	}
+	return accepted
+}
+
 const MaxBlocks = 64
 const MaxContentLength = 4096
 const MaxQuestionLength = 2048
 const MaxChoices = 16
 const MaxChoiceLength = 1024
+const MaxMetadataListLength = 16
 
-func validateEditModuleRequest(req editModuleRequest) error {
-	if len(req.blockTypes) > MaxBlocks {
-		return fmt.Errorf("Cannot have more than %d blocks", MaxBlocks)
+func validateEditModuleRequest(limits Limits, req editModuleRequest) error {
+	if len(req.blockTypes) > limits.MaxBlocks {
+		return fmt.Errorf("Cannot have more than %d blocks", limits.MaxBlocks)
 	}
 	if req.title == "" {
 		return fmt.Errorf("Title cannot be empty")
 	}
-	if len(req.title) > TitleMaxLength {
-		return fmt.Errorf("Title cannot be longer than %d characters", TitleMaxLength)
+	if len(req.title) > limits.MaxTitleLength {
+		return fmt.Errorf("Title cannot be longer than %d characters", limits.MaxTitleLength)
 	}
 	if req.description == "" {
 		return fmt.Errorf("Description cannot be empty")
 	}
-	if len(req.description) > DescriptionMaxLength {
-		return fmt.Errorf("Description cannot be longer than %d characters", DescriptionMaxLength)
+	if len(req.description) > limits.MaxDescriptionLength {
+		return fmt.Errorf("Description cannot be longer than %d characters", limits.MaxDescriptionLength)
+	}
+	if req.metadata != nil {
+		err := validateModuleMetadata(limits, *req.metadata)
+		if err != nil {
+			return err
+		}
 	}
 	for i, question := range req.questions {
 		if question == "" {
 			return fmt.Errorf("Questions cannot be empty")
 		}
-		if len(question) > MaxQuestionLength {
-			return fmt.Errorf("Questions cannot be longer than %d characters", MaxQuestionLength)
+		if len(question) > limits.MaxQuestionLength {
+			return fmt.Errorf("Questions cannot be longer than %d characters", limits.MaxQuestionLength)
+		}
+		if len(req.knowledgePoints[i]) > limits.MaxTitleLength {
+			return fmt.Errorf("Knowledge point names cannot be longer than %d characters", limits.MaxTitleLength)
+		}
+		if req.gradings[i] != db.AllOrNothingGrading && req.gradings[i] != db.PartialCreditGrading {
+			return fmt.Errorf("Unknown grading: %s", req.gradings[i])
+		}
+		if req.questionTypes[i] == db.NumericQuestionType {
+			if len(req.choicesByQuestion[i]) != 0 {
+				return fmt.Errorf("Numeric questions cannot have choices")
+			}
+			solution := req.numericSolutions[i]
+			if math.IsNaN(solution.Value) || math.IsInf(solution.Value, 0) {
+				return fmt.Errorf("Numeric answers must be finite numbers")
+			}
+			if !(solution.Tolerance >= 0) || math.IsInf(solution.Tolerance, 0) {
+				return fmt.Errorf("Tolerances must be non-negative finite numbers")
+			}
+			continue
+		}
+		if req.questionTypes[i] == db.TextQuestionType {
+			if len(req.choicesByQuestion[i]) != 0 {
+				return fmt.Errorf("Text questions cannot have choices")
+			}
+			if len(req.acceptedAnswers[i]) == 0 {
+				return fmt.Errorf("Text questions must have at least one accepted answer")
+			}
+			if len(req.acceptedAnswers[i]) > limits.MaxChoices {
+				return fmt.Errorf("Text questions cannot have more than %d accepted answers", limits.MaxChoices)
+			}
+			for _, accepted := range req.acceptedAnswers[i] {
+				if accepted.Answer == "" {
+					return fmt.Errorf("Accepted answers cannot be empty")
+				}
+				if len(accepted.Answer) > limits.MaxChoiceLength {
+					return fmt.Errorf("Accepted answers cannot be longer than %d characters", limits.MaxChoiceLength)
+				}
+			}
+			err := protocolTextAnswer(req.textSolutions[i], req.acceptedAnswers[i]).Validate()
+			if err != nil {
+				return fmt.Errorf("Invalid accepted answer: %v", err)
+			}
+			continue
+		}
+		if req.questionTypes[i] == db.OrderingQuestionType {
+			if len(req.choicesByQuestion[i]) < 2 {
+				return fmt.Errorf("Ordering questions must have at least two items")
+			}
+			if len(req.choicesByQuestion[i]) > limits.MaxChoices {
+				return fmt.Errorf("Ordering questions cannot have more than %d items", limits.MaxChoices)
+			}
+			for _, item := range req.choicesByQuestion[i] {
+				if item == "" {
+					return fmt.Errorf("Items cannot be empty")
+				}
+				if len(item) > limits.MaxChoiceLength {
+					return fmt.Errorf("Items cannot be longer than %d characters", limits.MaxChoiceLength)
+				}
+			}
+			continue
 		}
 		if len(req.choicesByQuestion[i]) == 0 {
 			return fmt.Errorf("Questions must have at least one choice")
 		}
-		if len(req.choicesByQuestion[i]) > MaxChoices {
-			return fmt.Errorf("Questions cannot have more than %d choices", MaxChoices)
+		if len(req.correctChoiceIdxs[i]) == 0 {
+			return fmt.Errorf("Each question must have a correct choice")
+		}
+		if req.questionTypes[i] == db.MultipleChoiceQuestionType && len(req.correctChoiceIdxs[i]) > 1 {
+			return fmt.Errorf("Each question must have only one correct choice")
+		}
+		if len(req.choicesByQuestion[i]) > limits.MaxChoices {
+			return fmt.Errorf("Questions cannot have more than %d choices", limits.MaxChoices)
 		}
 		for _, choice := range req.choicesByQuestion[i] {
 			if choice == "" {
 				return fmt.Errorf("Choices cannot be empty")
 			}
-			if len(choice) > MaxChoiceLength {
-				return fmt.Errorf("Choices cannot be longer than %d characters", MaxChoiceLength)
+			if len(choice) > limits.MaxChoiceLength {
+				return fmt.Errorf("Choices cannot be longer than %d characters", limits.MaxChoiceLength)
 			}
 		}
 	}
@@ -554,35 +720,187 @@ func validateEditModuleRequest(req editModuleRequest) error {
 		if content == "" {
 			return fmt.Errorf("Contents cannot be empty")
 		}
-		if len(content) > MaxContentLength {
-			return fmt.Errorf("Contents cannot be longer than %d characters", MaxContentLength)
+		if len(content) > limits.MaxContentLength {
+			return fmt.Errorf("Contents cannot be longer than %d characters", limits.MaxContentLength)
+		}
+	}
+	return nil
+}
+
+func validateModuleMetadata(limits Limits, metadata db.ModuleMetadata) error {
+	if len(metadata.Authors) > MaxMetadataListLength {
+		return fmt.Errorf("Cannot have more than %d authors", MaxMetadataListLength)
+	}
+	for _, author := range metadata.Authors {
+		if author == "" || len(author) > limits.MaxTitleLength {
+			return fmt.Errorf("Authors must be between 1 and %d characters", limits.MaxTitleLength)
+		}
+	}
+	if len(metadata.Tags) > MaxMetadataListLength {
+		return fmt.Errorf("Cannot have more than %d tags", MaxMetadataListLength)
+	}
+	for _, tag := range metadata.Tags {
+		if tag == "" || len(tag) > limits.MaxTitleLength {
+			return fmt.Errorf("Tags must be between 1 and %d characters", limits.MaxTitleLength)
 		}
 	}
+	if len(metadata.License) > limits.MaxTitleLength {
+		return fmt.Errorf("License cannot be longer than %d characters", limits.MaxTitleLength)
+	}
+	if len(metadata.Language) > limits.MaxTitleLength {
+		return fmt.Errorf("Language cannot be longer than %d characters", limits.MaxTitleLength)
+	}
+	if metadata.EstimatedMinutes < 0 {
+		return fmt.Errorf("Estimated minutes cannot be negative")
+	}
+	if len(metadata.Extra) > limits.MaxDescriptionLength {
+		return fmt.Errorf("Other metadata cannot be longer than %d characters", limits.MaxDescriptionLength)
+	}
 	return nil
 }
 
 func handleEditModule(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
 	req, err := parseEditModuleRequest(r)
 	if err != nil {
-		return fmt.Errorf("Error parsing edit module request: %v", err)
+		return badRequestErrorf("Error parsing edit module request: %v", err)
 	}
-	err = validateEditModuleRequest(req)
+	err = editModule(ctx, user, req)
 	if err != nil {
-		return fmt.Errorf("Error validating edit module request: %v", err)
+		return err
 	}
-	_, err = ctx.dbClient.GetModuleCourse(user.Id, req.moduleId)
+	return ctx.renderer.RenderModuleEdited(w)
+}
+
+// Validates and inserts a new module version. This is shared between
+// the edit module form and uploading module source.
+func editModule(ctx HandlerContext, user db.User, req editModuleRequest) error {
+	err := validateEditModuleRequest(ctx.limits, req)
 	if err != nil {
-		return fmt.Errorf("Module %d not found", req.moduleId)
+		return badRequestErrorf("Error validating edit module request: %v", err)
+	}
+	moduleCourse, err := ctx.dbClient.GetModuleCourse(user.Id, req.moduleId)
+	if err != nil || int64(moduleCourse.Id) != req.courseId {
+		return notFoundErrorf("Module %d not found", req.moduleId)
 	}
 	tx, err := ctx.dbClient.Begin()
 	defer tx.Rollback()
 	if err != nil {
 		return err
 	}
+	err = insertModuleVersion(tx, req)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const defaultKnowledgePointPrefix = "knowledge point: "
+
+// Questions without a knowledge point given get their own, named after the question.
+func defaultKnowledgePointName(question string) string {
+	return defaultKnowledgePointPrefix + question
+}
+
+func isDefaultKnowledgePointName(name string) bool {
+	return strings.HasPrefix(name, defaultKnowledgePointPrefix)
+}
+
+// Returns the knowledge point name to show authors for a question,
+// which is empty if the question just has its own default one.
+func authoredKnowledgePointName(knowledgePoint db.KnowledgePoint) string {
+	if isDefaultKnowledgePointName(knowledgePoint.Name) {
+		return ""
+	}
+	return knowledgePoint.Name
+}
+
+// Returns the knowledge point a question tests. Named knowledge points are found
+// (or created) by name. Otherwise the question keeps its own default knowledge point
+// from before, so students' history follows it across versions. That's the one the
+// edit form says it had, or else the one of a question in the course with the same text.
+// It's renamed along with the question, unless other questions still test it, in which
+// case the question gets a default knowledge point of its own instead.
+func getQuestionKnowledgePoint(tx *sql.Tx, courseId int64, moduleId int, moduleVersionId int64, name string, knowledgePointId int64, question string) (db.KnowledgePoint, error) {
+	if name != "" {
+		return db.GetOrInsertKnowledgePoint(tx, courseId, name)
+	}
+	knowledgePoint, err := db.GetKnowledgePoint(tx, courseId, knowledgePointId)
+	if err == sql.ErrNoRows || (err == nil && !isDefaultKnowledgePointName(knowledgePoint.Name)) {
+		knowledgePoint, err = db.GetKnowledgePointForQuestion(tx, courseId, question)
+	}
+	if err == sql.ErrNoRows || (err == nil && !isDefaultKnowledgePointName(knowledgePoint.Name)) {
+		return db.InsertKnowledgePoint(tx, courseId, defaultKnowledgePointName(question))
+	}
+	if err != nil {
+		return db.KnowledgePoint{}, err
+	}
+	// Keep the name in line with the edited question.
+	if knowledgePoint.Name != defaultKnowledgePointName(question) {
+		used, err := db.IsKnowledgePointUsedElsewhere(tx, knowledgePoint.Id, moduleId, moduleVersionId)
+		if err != nil {
+			return db.KnowledgePoint{}, err
+		}
+		if used {
+			return db.GetOrInsertKnowledgePoint(tx, courseId, defaultKnowledgePointName(question))
+		}
+		knowledgePoint.Name = defaultKnowledgePointName(question)
+		err = db.UpdateKnowledgePointName(tx, knowledgePoint.Id, knowledgePoint.Name)
+		if err != nil {
+			return db.KnowledgePoint{}, err
+		}
+	}
+	return knowledgePoint, nil
+}
+
+// Modules can only reference their course's assets, since a course stops
+// having an asset once nothing in it references it, and the asset is
+// deleted once no course has it.
+func validateAssetReferences(tx *sql.Tx, req editModuleRequest) error {
+	courseHashes, err := db.GetCourseAssetHashes(tx, int(req.courseId))
+	if err != nil {
+		return err
+	}
+	texts := append(append(append([]string{}, req.contents...), req.questions...), req.explanations...)
+	for _, choices := range req.choicesByQuestion {
+		texts = append(texts, choices...)
+	}
+	for _, text := range texts {
+		for _, hash := range protocol.AssetReferences(text) {
+			if !courseHashes[hash] {
+				return badRequestErrorf("Asset %s%s isn't one of the course's assets, upload it to the course first", protocol.AssetScheme, hash)
+			}
+		}
+	}
+	return nil
+}
+
+// Inserts the module version for an otherwise validated request, once
+// its asset references are.
+func insertModuleVersion(tx *sql.Tx, req editModuleRequest) error {
+	err := validateAssetReferences(tx, req)
+	if err != nil {
+		return err
+	}
+	metadata := db.ModuleMetadata{}
+	if req.metadata != nil {
+		metadata = *req.metadata
+	} else {
+		previous, err := db.GetLatestModuleVersion(tx, req.moduleId)
+		if err == nil {
+			metadata, err = db.GetModuleMetadata(tx, previous.Id)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
 	version, err := db.InsertModuleVersion(tx, req.moduleId, req.title, req.description)
 	if err != nil {
 		return err
 	}
+	err = db.InsertModuleMetadata(tx, version.Id, metadata)
+	if err != nil {
+		return err
+	}
 	questionIdx := 0
 	contentIdx := 0
 	for i, blockType := range req.blockTypes {
@@ -597,16 +915,27 @@ func handleEditModule(w http.ResponseWriter, r *http.Request, ctx HandlerContext
 			}
 			contentIdx += 1
 		} else if db.BlockType(blockType) == db.KnowledgePointBlockType {
-			knowledgePointName := "knowledge point: " + req.questions[questionIdx]
-			knowledgePoint, err := db.InsertKnowledgePoint(tx, req.courseId, knowledgePointName)
+			knowledgePoint, err := getQuestionKnowledgePoint(tx, req.courseId, req.moduleId, version.Id, req.knowledgePoints[questionIdx], req.knowledgePointIds[questionIdx], req.questions[questionIdx])
 			if err != nil {
 				return err
 			}
-			err = db.InsertKnowledgePointBlock(tx, blockId, knowledgePoint.Id)
+			var questionId int64
+			switch req.questionTypes[questionIdx] {
+			case db.NumericQuestionType:
+				questionId, err = db.InsertNumericQuestion(tx, knowledgePoint.Id, req.questions[questionIdx], req.numericSolutions[questionIdx], req.explanations[questionIdx])
+			case db.TextQuestionType:
+				questionId, err = db.InsertTextQuestion(tx, knowledgePoint.Id, req.questions[questionIdx], req.textSolutions[questionIdx], req.acceptedAnswers[questionIdx], req.explanations[questionIdx])
+			case db.OrderingQuestionType:
+				questionId, err = db.InsertOrderingQuestion(tx, knowledgePoint.Id, req.questions[questionIdx], req.choicesByQuestion[questionIdx], req.gradings[questionIdx], req.explanations[questionIdx])
+			case db.MultiSelectQuestionType:
+				questionId, err = db.InsertMultiSelectQuestion(tx, knowledgePoint.Id, req.questions[questionIdx], req.choicesByQuestion[questionIdx], req.correctChoiceIdxs[questionIdx], req.gradings[questionIdx], req.explanations[questionIdx])
+			default:
+				questionId, err = db.InsertQuestion(tx, knowledgePoint.Id, req.questions[questionIdx], req.choicesByQuestion[questionIdx], req.correctChoiceIdxs[questionIdx][0], req.explanations[questionIdx])
+			}
 			if err != nil {
 				return err
 			}
-			err = db.InsertQuestion(tx, knowledgePoint.Id, req.questions[questionIdx], req.choicesByQuestion[questionIdx], req.correctChoiceIdxs[questionIdx], req.explanations[questionIdx])
+			err = db.InsertKnowledgePointBlock(tx, blockId, knowledgePoint.Id, questionId)
 			if err != nil {
 				return err
 			}
@@ -626,159 +955,444 @@ func handleEditModule(w http.ResponseWriter, r *http.Request, ctx HandlerContext
 			return err
 		}
 	}
-	err = tx.Commit()
-	if err != nil {
-		return err
-	}
-	return ctx.renderer.RenderModuleEdited(w)
+	return nil
 }
 
-// Preview page
+// Upload module source
 
-func handlePreviewModulePage(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
-	courseId, err := strconv.Atoi(r.PathValue("courseId"))
-	if err != nil {
-		return err
+const MaxModuleSourceLength = 1 << 20
+
+// Converts a parsed module into the same request the edit module form produces.
+func newEditModuleRequest(courseId int64, moduleId int, module protocol.Module) (editModuleRequest, error) {
+	metadata := db.NewModuleMetadata(module.Metadata.Authors, module.Metadata.Tags, module.Metadata.License,
+		module.Metadata.EstimatedMinutes, module.Metadata.Language, module.Metadata.ProtocolVersion, module.Metadata.Extra)
+	req := editModuleRequest{
+		courseId:          courseId,
+		moduleId:          moduleId,
+		title:             module.Title,
+		description:       module.Description,
+		blockTypes:        []string{},
+		contents:          []string{},
+		questions:         []string{},
+		questionTypes:     []db.QuestionType{},
+		choicesByQuestion: [][]string{},
+		correctChoiceIdxs: [][]int{},
+		gradings:          []db.Grading{},
+		numericSolutions:  []db.NumericSolution{},
+		textSolutions:     []db.TextSolution{},
+		acceptedAnswers:   [][]db.AcceptedAnswer{},
+		knowledgePoints:   []string{},
+		knowledgePointIds: []int64{},
+		explanations:      []string{},
+		metadata:          &metadata,
+	}
+	for _, block := range module.Blocks {
+		switch block.BlockType {
+		case protocol.ContentBlockType:
+			req.blockTypes = append(req.blockTypes, string(db.ContentBlockType))
+			req.contents = append(req.contents, block.Content)
+		case protocol.QuestionBlockType:
+			question := block.Question
+			questionType := db.MultipleChoiceQuestionType
+			choices := make([]string, len(question.Choices))
+			correctChoiceIdxs := []int{}
+			numericSolution := db.NumericSolution{}
+			textSolution := db.TextSolution{}
+			acceptedAnswers := []db.AcceptedAnswer{}
+			switch question.QuestionType {
+			case protocol.NumericQuestionType:
+				questionType = db.NumericQuestionType
+				answer := question.Numeric
+				numericSolution = db.NewNumericSolution(-1, -1, answer.Value, answer.Tolerance, answer.Relative)
+			case protocol.OrderingQuestionType:
+				questionType = db.OrderingQuestionType
+				choices = slices.Clone(question.Items)
+			case protocol.TextQuestionType:
+				questionType = db.TextQuestionType
+				answer := question.TextAnswer
+				textSolution = db.NewTextSolution(-1, -1, answer.FoldCase, answer.NormalizeWhitespace)
+				for _, accepted := range answer.Accepted {
+					acceptedAnswers = append(acceptedAnswers, db.NewAcceptedAnswer(-1, -1, accepted.Text, accepted.Regex))
+				}
+			default:
+				for i, choice := range question.Choices {
+					choices[i] = choice.Text
+					if choice.Correct {
+						correctChoiceIdxs = append(correctChoiceIdxs, i)
+					}
+				}
+				if len(correctChoiceIdxs) == 0 {
+					return editModuleRequest{}, fmt.Errorf("Each question must have a correct choice")
+				}
+				if question.QuestionType == protocol.MultiSelectQuestionType {
+					questionType = db.MultiSelectQuestionType
+				} else if len(correctChoiceIdxs) > 1 {
+					return editModuleRequest{}, fmt.Errorf("Each question must have only one correct choice")
+				}
+			}
+			req.blockTypes = append(req.blockTypes, string(db.KnowledgePointBlockType))
+			req.questions = append(req.questions, question.Text)
+			req.questionTypes = append(req.questionTypes, questionType)
+			req.choicesByQuestion = append(req.choicesByQuestion, choices)
+			req.correctChoiceIdxs = append(req.correctChoiceIdxs, correctChoiceIdxs)
+			req.gradings = append(req.gradings, db.Grading(question.Grading))
+			req.numericSolutions = append(req.numericSolutions, numericSolution)
+			req.textSolutions = append(req.textSolutions, textSolution)
+			req.acceptedAnswers = append(req.acceptedAnswers, acceptedAnswers)
+			req.knowledgePoints = append(req.knowledgePoints, question.KnowledgePoint)
+			req.knowledgePointIds = append(req.knowledgePointIds, -1)
+			req.explanations = append(req.explanations, question.Explanation)
+		default:
+			return editModuleRequest{}, fmt.Errorf("invalid block type: %s", block.BlockType)
+		}
 	}
-	// Check they can access this
-	course, err := ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	return req, nil
+}
+
+func parseModuleSourceRequest(w http.ResponseWriter, r *http.Request, limits Limits) (editModuleRequest, error) {
+	courseIdInt, err := strconv.Atoi(r.PathValue("courseId"))
 	if err != nil {
-		return err
+		return editModuleRequest{}, err
 	}
 	moduleId, err := strconv.Atoi(r.PathValue("moduleId"))
 	if err != nil {
-		return err
+		return editModuleRequest{}, err
 	}
-	module, err := ctx.dbClient.GetModule(courseId, moduleId)
+	module, err := parseModuleSource(w, r, limits)
 	if err != nil {
-		return err
+		return editModuleRequest{}, err
 	}
-	moduleVersion, err := ctx.dbClient.GetLatestModuleVersion(module.Id)
-	if err != nil {
-		return err
+	return newEditModuleRequest(int64(courseIdInt), moduleId, module)
+}
+
+// Parses a module from the request body, in markdown or JSON depending on its content type.
+func parseModuleSource(w http.ResponseWriter, r *http.Request, limits Limits) (protocol.Module, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/markdown" && mediaType != "application/json") {
+		return protocol.Module{}, fmt.Errorf("Module source must have content type text/markdown or application/json")
 	}
-	blocks, err := ctx.dbClient.GetBlocks(moduleVersion.Id)
-	blockCount := len(blocks)
-	uiBlocks := make([]UiBlock, blockCount)
-	for blockIdx := 0; blockIdx < blockCount; blockIdx++ {
-		uiBlock, err := getBlock(ctx, moduleVersion.Id, blockIdx, user.Id)
-		if err != nil {
-			return fmt.Errorf("Error getting block %d for module %d: %v", blockIdx, moduleId, err)
-		}
-		uiBlocks[blockIdx] = uiBlock
+	source, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(limits.MaxModuleSourceLength)))
+	if err != nil {
+		return protocol.Module{}, err
 	}
-	uiModule := UiTakeModulePage{
-		Module:     NewUiModuleStudent(course.Id, moduleVersion, blockCount, false, time.Now(), 0),
-		Blocks:     uiBlocks,
-		VisitIndex: blockCount,
-		Preview:    true,
+	if mediaType == "application/json" {
+		return protocol.ParseJSON(source)
 	}
-	return ctx.renderer.RenderTakeModulePage(w, uiModule)
+	return protocol.Parse(string(source))
 }
 
-// Prereqs page
-
-func uiPrereqsFromModules(modules []db.Module, uiModuleMap map[int]UiModule, prereqs []db.Prereq) []UiPrereq {
-	uiPrereqs := make([]UiPrereq, 0)
-	prereqSet := make(map[int]bool)
-	for _, prereq := range prereqs {
-		prereqSet[prereq.PrereqModuleId] = true
+func handleUploadModuleSource(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	req, err := parseModuleSourceRequest(w, r, ctx.limits)
+	if err != nil {
+		return badRequestErrorf("Error parsing module source: %v", err)
 	}
-	for _, module := range modules {
-		uiPrereqs = append(uiPrereqs, NewUiPrereq(uiModuleMap[module.Id], prereqSet[module.Id]))
+	err = editModule(ctx, user, req)
+	if err != nil {
+		return err
 	}
-	return uiPrereqs
+	return ctx.renderer.RenderModuleEdited(w)
 }
 
-func handlePrereqPage(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+// Creates a module from its source, responding with the new module's id.
+func handleCreateModuleSource(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
 	courseId, err := strconv.Atoi(r.PathValue("courseId"))
 	if err != nil {
 		return err
 	}
-	course, err := ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	_, err = ctx.dbClient.GetTeacherCourse(courseId, user.Id)
 	if err != nil {
 		return err
 	}
-	modules, err := ctx.dbClient.GetModules(course.Id)
+	module, err := parseModuleSource(w, r, ctx.limits)
 	if err != nil {
-		return err
+		return badRequestErrorf("Error parsing module source: %v", err)
 	}
-	uiModules, err := getTeacherUiModulesForCourse(ctx, course.Id)
+	req, err := newEditModuleRequest(int64(courseId), -1, module)
 	if err != nil {
-		return err
+		return badRequestErrorf("Error parsing module source: %v", err)
 	}
-	uiModuleMap := make(map[int]UiModule)
-	for _, uiModule := range uiModules {
-		uiModuleMap[uiModule.Id] = uiModule
+	err = validateEditModuleRequest(ctx.limits, req)
+	if err != nil {
+		return badRequestErrorf("Error validating edit module request: %v", err)
 	}
-	var uiModule UiModule
-	uiPrereqs := make([]UiPrereq, 0)
-	if len(modules) > 0 {
-		uiModule = uiModuleMap[modules[0].Id]
-		prereqs, err := ctx.dbClient.GetPrereqs(modules[0].Id)
-		if err != nil {
-			return err
-		}
-		uiPrereqs = uiPrereqsFromModules(modules, uiModuleMap, prereqs)
+	modules, err := ctx.dbClient.GetModules(courseId)
+	if err != nil {
+		return err
 	}
-	return ctx.renderer.RenderPrereqPage(w, UiPrereqPageArgs{NewUiCourse(course, uiModules), UiPrereqForm{uiModule, uiPrereqs}})
-}
-
-func handlePrereqForm(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
-	courseId, err := strconv.Atoi(r.PathValue("courseId"))
+	if len(modules) >= ctx.limits.MaxModules {
+		return conflictErrorf("Cannot have more than %d modules", ctx.limits.MaxModules)
+	}
+	tx, err := ctx.dbClient.Begin()
+	defer tx.Rollback()
 	if err != nil {
 		return err
 	}
-	course, err := ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	dbModule, err := db.CreateModule(tx, courseId, req.title, req.description)
 	if err != nil {
 		return err
 	}
-	moduleId, err := strconv.Atoi(r.PathValue("moduleId"))
+	req.moduleId = dbModule.Id
+	err = insertModuleVersion(tx, req)
 	if err != nil {
 		return err
 	}
-	modules, err := ctx.dbClient.GetModules(course.Id)
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
-	uiModules, err := getTeacherUiModulesForCourse(ctx, course.Id)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = io.WriteString(w, strconv.Itoa(dbModule.Id))
+	return err
+}
+
+// Responds with each of the course's modules and the hash of its latest
+// version, so tools can tell which modules changed without exporting them all.
+func handleGetModuleSummaries(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	courseId, err := strconv.Atoi(r.PathValue("courseId"))
 	if err != nil {
 		return err
 	}
-	uiModuleMap := make(map[int]UiModule)
-	for _, uiModule := range uiModules {
-		uiModuleMap[uiModule.Id] = uiModule
+	course, err := ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	if err != nil {
+		return err
 	}
-	prereqs, err := ctx.dbClient.GetPrereqs(moduleId)
+	modules, protocolModules, err := getCourseProtocolModules(ctx, course.Id)
 	if err != nil {
 		return err
 	}
-	uiPrereqs := uiPrereqsFromModules(modules, uiModuleMap, prereqs)
-	uiModule, ok := uiModuleMap[moduleId]
-	if !ok {
-		return fmt.Errorf("Module %d not found", moduleId)
+	summaries := make([]protocol.ModuleSummary, len(modules))
+	for i, module := range modules {
+		summaries[i] = protocol.ModuleSummary{Id: int64(module.Version.ModuleId), Title: module.Version.Title, Hash: protocolModules[i].Hash()}
 	}
-	return ctx.renderer.RenderPrereqForm(w, UiPrereqForm{uiModule, uiPrereqs})
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(summaries)
 }
 
-type prereqRequest struct {
-	courseId        int
-	moduleId        int
-	prereqModuleIds map[int]bool
+// Question imports
+
+type importQuestionsRequest struct {
+	courseId int
+	moduleId int
+	format   protocol.ImportFormat
+	text     string
 }
 
-func parsePrereqRequest(r *http.Request) (prereqRequest, error) {
+func parseImportQuestionsRequest(w http.ResponseWriter, r *http.Request, limits Limits) (importQuestionsRequest, error) {
 	courseId, err := strconv.Atoi(r.PathValue("courseId"))
 	if err != nil {
-		return prereqRequest{}, err
+		return importQuestionsRequest{}, err
 	}
 	moduleId, err := strconv.Atoi(r.PathValue("moduleId"))
 	if err != nil {
-		return prereqRequest{}, err
+		return importQuestionsRequest{}, err
 	}
-	err = r.ParseForm()
+	r.Body = http.MaxBytesReader(w, r.Body, int64(limits.MaxModuleSourceLength+1<<10))
+	file, _, err := r.FormFile("file")
 	if err != nil {
-		return prereqRequest{}, err
+		return importQuestionsRequest{}, err
+	}
+	defer file.Close()
+	text, err := io.ReadAll(file)
+	if err != nil {
+		return importQuestionsRequest{}, err
+	}
+	if len(text) > limits.MaxModuleSourceLength {
+		return importQuestionsRequest{}, fmt.Errorf("Imports cannot be larger than %d bytes", limits.MaxModuleSourceLength)
+	}
+	format := protocol.ImportFormat(r.FormValue("format"))
+	return importQuestionsRequest{courseId, moduleId, format, string(text)}, nil
+}
+
+// Adds the questions from a question bank to the end of the module, responding
+// with the items that couldn't be imported.
+func handleImportQuestions(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	req, err := parseImportQuestionsRequest(w, r, ctx.limits)
+	if err != nil {
+		return badRequestErrorf("Error parsing import questions request: %v", err)
+	}
+	_, err = ctx.dbClient.GetTeacherCourse(req.courseId, user.Id)
+	if err != nil {
+		return err
+	}
+	moduleCourse, err := ctx.dbClient.GetModuleCourse(user.Id, req.moduleId)
+	if err != nil || moduleCourse.Id != req.courseId {
+		return notFoundErrorf("Module %d not found", req.moduleId)
+	}
+	blocks, diagnostics, err := protocol.ImportBlocks(req.format, req.text)
+	if err != nil {
+		return badRequestErrorf("Error importing blocks: %v", err)
+	}
+	problems := make([]string, len(diagnostics))
+	for i, diagnostic := range diagnostics {
+		problems[i] = diagnostic.Error()
+	}
+	if len(blocks) == 0 {
+		return badRequestErrorf("Nothing to import: %s", strings.Join(problems, "; "))
+	}
+	moduleVersion, err := ctx.dbClient.GetLatestModuleVersion(req.moduleId)
+	if err != nil {
+		return err
+	}
+	module, err := getProtocolModule(ctx, moduleVersion)
+	if err != nil {
+		return err
+	}
+	module.Blocks = append(module.Blocks, blocks...)
+	editReq, err := newEditModuleRequest(int64(req.courseId), req.moduleId, module)
+	if err != nil {
+		return err
+	}
+	err = editModule(ctx, user, editReq)
+	if err != nil {
+		return err
+	}
+	return ctx.renderer.RenderQuestionsImported(w, UiImportedQuestions{len(blocks), problems})
+}
+
+// Preview page
+
+func handlePreviewModulePage(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	courseId, err := strconv.Atoi(r.PathValue("courseId"))
+	if err != nil {
+		return err
+	}
+	// Check they can access this
+	course, err := ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	if err != nil {
+		return err
+	}
+	moduleId, err := strconv.Atoi(r.PathValue("moduleId"))
+	if err != nil {
+		return err
+	}
+	module, err := ctx.dbClient.GetModule(courseId, moduleId)
+	if err != nil {
+		return err
+	}
+	moduleVersion, err := ctx.dbClient.GetLatestModuleVersion(module.Id)
+	if err != nil {
+		return err
+	}
+	blockCount, err := ctx.dbClient.GetBlockCount(moduleVersion.Id)
+	if err != nil {
+		return err
+	}
+	uiBlocks, err := getBlocks(ctx, moduleVersion.Id, blockCount, user.Id)
+	if err != nil {
+		return fmt.Errorf("Error getting blocks for module %d: %v", moduleId, err)
+	}
+	uiModule := UiTakeModulePage{
+		Module:     NewUiModuleStudent(course.Id, moduleVersion, blockCount, false, time.Now(), 0),
+		Blocks:     uiBlocks,
+		VisitIndex: blockCount,
+		Preview:    true,
+	}
+	return ctx.renderer.RenderTakeModulePage(w, uiModule)
+}
+
+// Prereqs page
+
+func uiPrereqsFromModules(modules []db.Module, uiModuleMap map[int]UiModule, prereqs []db.Prereq) []UiPrereq {
+	uiPrereqs := make([]UiPrereq, 0)
+	prereqSet := make(map[int]bool)
+	for _, prereq := range prereqs {
+		prereqSet[prereq.PrereqModuleId] = true
+	}
+	for _, module := range modules {
+		uiPrereqs = append(uiPrereqs, NewUiPrereq(uiModuleMap[module.Id], prereqSet[module.Id]))
+	}
+	return uiPrereqs
+}
+
+func handlePrereqPage(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	courseId, err := strconv.Atoi(r.PathValue("courseId"))
+	if err != nil {
+		return err
+	}
+	course, err := ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	if err != nil {
+		return err
+	}
+	modules, err := ctx.dbClient.GetModules(course.Id)
+	if err != nil {
+		return err
+	}
+	uiModules, err := getTeacherUiModulesForCourse(ctx, course.Id)
+	if err != nil {
+		return err
+	}
+	uiModuleMap := make(map[int]UiModule)
+	for _, uiModule := range uiModules {
+		uiModuleMap[uiModule.Id] = uiModule
+	}
+	var uiModule UiModule
+	uiPrereqs := make([]UiPrereq, 0)
+	if len(modules) > 0 {
+		uiModule = uiModuleMap[modules[0].Id]
+		prereqs, err := ctx.dbClient.GetPrereqs(modules[0].Id)
+		if err != nil {
+			return err
+		}
+		uiPrereqs = uiPrereqsFromModules(modules, uiModuleMap, prereqs)
+	}
+	return ctx.renderer.RenderPrereqPage(w, UiPrereqPageArgs{NewUiCourse(course, uiModules), UiPrereqForm{uiModule, uiPrereqs}})
+}
+
+func handlePrereqForm(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	courseId, err := strconv.Atoi(r.PathValue("courseId"))
+	if err != nil {
+		return err
+	}
+	course, err := ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	if err != nil {
+		return err
+	}
+	moduleId, err := strconv.Atoi(r.PathValue("moduleId"))
+	if err != nil {
+		return err
+	}
+	modules, err := ctx.dbClient.GetModules(course.Id)
+	if err != nil {
+		return err
+	}
+	uiModules, err := getTeacherUiModulesForCourse(ctx, course.Id)
+	if err != nil {
+		return err
+	}
+	uiModuleMap := make(map[int]UiModule)
+	for _, uiModule := range uiModules {
+		uiModuleMap[uiModule.Id] = uiModule
+	}
+	prereqs, err := ctx.dbClient.GetPrereqs(moduleId)
+	if err != nil {
+		return err
+	}
+	uiPrereqs := uiPrereqsFromModules(modules, uiModuleMap, prereqs)
+	uiModule, ok := uiModuleMap[moduleId]
+	if !ok {
+		return notFoundErrorf("Module %d not found", moduleId)
+	}
+	return ctx.renderer.RenderPrereqForm(w, UiPrereqForm{uiModule, uiPrereqs})
+}
+
+type prereqRequest struct {
+	courseId        int
+	moduleId        int
+	prereqModuleIds map[int]bool
+}
+
+func parsePrereqRequest(r *http.Request) (prereqRequest, error) {
+	courseId, err := strconv.Atoi(r.PathValue("courseId"))
+	if err != nil {
+		return prereqRequest{}, err
+	}
+	moduleId, err := strconv.Atoi(r.PathValue("moduleId"))
+	if err != nil {
+		return prereqRequest{}, err
+	}
+	err = r.ParseForm()
+	if err != nil {
+		return prereqRequest{}, err
 	}
 	prereqModuleIds := make(map[int]bool)
 	for _, prereqModuleIdStr := range r.Form["prereqs[]"] {
@@ -851,7 +1465,7 @@ func handleEditPrereqs(w http.ResponseWriter, r *http.Request, ctx HandlerContex
 		}
 	}
 	if hasCycle(edges, req.moduleId) {
-		return fmt.Errorf("Cannot create cycle in prereqs")
+		return badRequestErrorf("Cannot create cycle in prereqs")
 	}
 	// Note: hasCycle modifies edges, so if we want to use it again
 	// afterwards we'll need to pass a copy in.
@@ -878,6 +1492,97 @@ func handleEditPrereqs(w http.ResponseWriter, r *http.Request, ctx HandlerContex
 	return ctx.renderer.RenderPrereqEditedResponse(w, NewUiModuleTeacher(req.courseId, moduleVersion))
 }
 
+// Reads the latest version of a module out of the db in its protocol form.
+func getProtocolModule(ctx HandlerContext, moduleVersion db.ModuleVersion) (protocol.Module, error) {
+	contents, err := ctx.dbClient.GetModuleContents(moduleVersion.Id)
+	if err != nil {
+		return protocol.Module{}, err
+	}
+	metadata, err := ctx.dbClient.GetModuleMetadata(moduleVersion.Id)
+	if err != nil {
+		return protocol.Module{}, err
+	}
+	return newProtocolModule(moduleVersion, contents, metadata)
+}
+
+// Returns the latest version of each of the course's modules, in order,
+// along with its protocol module. Takes the same number of queries however
+// many modules the course has.
+func getCourseProtocolModules(ctx HandlerContext, courseId int) ([]db.ModuleSummary, []protocol.Module, error) {
+	summaries, err := ctx.dbClient.GetLatestModuleVersions([]int{courseId})
+	if err != nil {
+		return nil, nil, err
+	}
+	moduleVersionIds := make([]int64, len(summaries))
+	for i, summary := range summaries {
+		moduleVersionIds[i] = summary.Version.Id
+	}
+	contents, err := ctx.dbClient.GetModulesContents(moduleVersionIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata, err := ctx.dbClient.GetModulesMetadata(moduleVersionIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	modules := make([]protocol.Module, len(summaries))
+	for i, summary := range summaries {
+		modules[i], err = newProtocolModule(summary.Version, contents[summary.Version.Id], metadata[summary.Version.Id])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return summaries, modules, nil
+}
+
+func newProtocolModule(moduleVersion db.ModuleVersion, contents db.ModuleContents, metadata db.ModuleMetadata) (protocol.Module, error) {
+	protocolBlocks := make([]protocol.Block, 0)
+	for _, block := range contents.Blocks {
+		if block.Block.BlockType == db.ContentBlockType {
+			protocolBlocks = append(protocolBlocks, protocol.NewContentBlock(block.Content.Content))
+		} else if block.Block.BlockType == db.KnowledgePointBlockType {
+			protocolQuestion := protocolQuestionFromContents(block.Question)
+			protocolQuestion.KnowledgePoint = authoredKnowledgePointName(block.KnowledgePoint)
+			protocolBlocks = append(protocolBlocks, protocol.NewQuestionBlock(protocolQuestion))
+		} else {
+			return protocol.Module{}, fmt.Errorf("invalid block type: %s", block.Block.BlockType)
+		}
+	}
+	module := protocol.NewModule(moduleVersion.Title, moduleVersion.Description, protocolBlocks)
+	module.Metadata = protocol.NewMetadata(metadata.Authors, metadata.Tags, metadata.License,
+		metadata.EstimatedMinutes, metadata.Language, metadata.ProtocolVersion, metadata.Extra)
+	return module, nil
+}
+
+func protocolQuestionFromContents(question db.QuestionContents) protocol.Question {
+	text := question.Content.Content
+	explanation := question.Explanation.Content
+	switch question.Question.QuestionType {
+	case db.NumericQuestionType:
+		solution := question.NumericSolution
+		answer := protocol.NewNumericAnswer(solution.Value, solution.Tolerance, solution.Relative)
+		return protocol.NewNumericQuestion(text, answer, explanation)
+	case db.TextQuestionType:
+		return protocol.NewTextQuestion(text, protocolTextAnswer(question.TextSolution, question.AcceptedAnswers), explanation)
+	}
+	protocolChoices := make([]protocol.Choice, len(question.Choices))
+	for i, choice := range question.Choices {
+		protocolChoices[i] = protocol.NewChoice(question.ChoiceContents[i].Content, choice.Correct)
+	}
+	grading := protocol.Grading(question.Question.Grading)
+	switch question.Question.QuestionType {
+	case db.MultiSelectQuestionType:
+		return protocol.NewMultiSelectQuestion(text, protocolChoices, grading, explanation)
+	case db.OrderingQuestionType:
+		items := make([]string, len(protocolChoices))
+		for i, choice := range protocolChoices {
+			items[i] = choice.Text
+		}
+		return protocol.NewOrderingQuestion(text, items, grading, explanation)
+	}
+	return protocol.NewQuestion(text, protocolChoices, explanation)
+}
+
 func handleExportModule(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
 	courseId, err := strconv.Atoi(r.PathValue("courseId"))
 	if err != nil {
@@ -891,80 +1596,280 @@ func handleExportModule(w http.ResponseWriter, r *http.Request, ctx HandlerConte
 	if err != nil {
 		return err
 	}
-	_, err = ctx.dbClient.GetModuleCourse(user.Id, moduleId)
+	moduleCourse, err := ctx.dbClient.GetModuleCourse(user.Id, moduleId)
 	if err != nil {
 		return err
 	}
+	if moduleCourse.Id != courseId {
+		return notFoundErrorf("Module %d not found", moduleId)
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "zip" && format != "json" {
+		return badRequestErrorf("Unknown export format %q", format)
+	}
 	moduleVersion, err := ctx.dbClient.GetLatestModuleVersion(moduleId)
 	if err != nil {
 		return err
 	}
-	blocks, err := ctx.dbClient.GetBlocks(moduleVersion.Id)
+	module, err := getProtocolModule(ctx, moduleVersion)
 	if err != nil {
 		return err
 	}
-	metadataStr := func(text string) string {
-		return fmt.Sprintf("\n[//]: # (%s)", text)
+	switch format {
+	case "zip":
+		return writeModuleArchive(w, ctx, moduleCourse.Id, moduleVersion, module)
+	case "json":
+		data, err := module.JSON()
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(data)
+		return err
 	}
-	textPieces := make([]string, 0)
-	textPieces = append(textPieces, fmt.Sprintf("---\ntitle: %s\ndescription: %s\n---\n", moduleVersion.Title, moduleVersion.Description))
-	for _, block := range blocks {
-		if block.BlockType == db.ContentBlockType {
-			content, err := ctx.dbClient.GetContentFromBlock(block.Id)
-			if err != nil {
-				return err
-			}
-			textPieces = append(textPieces, metadataStr("content"))
-			textPieces = append(textPieces, content.Content)
-		} else if block.BlockType == db.KnowledgePointBlockType {
-			knowledgePoint, err := ctx.dbClient.GetKnowledgePointFromBlock(block.Id)
-			if err != nil {
-				return err
-			}
-			question, err := ctx.dbClient.GetQuestionFromKnowledgePoint(knowledgePoint.Id)
-			if err != nil {
-				return err
-			}
-			questionContent, err := ctx.dbClient.GetContent(question.ContentId)
-			if err != nil {
-				return err
-			}
-			choices, err := ctx.dbClient.GetChoicesForQuestion(question.Id)
-			if err != nil {
-				return err
-			}
-			choiceContents := make([]db.Content, 0)
-			for _, choice := range choices {
-				choiceContent, err := ctx.dbClient.GetContent(choice.ContentId)
-				if err != nil {
-					return err
-				}
-				choiceContents = append(choiceContents, choiceContent)
-			}
-			explanation, err := ctx.dbClient.GetExplanationForQuestion(question.Id)
-			if err != nil {
-				return err
-			}
-			textPieces = append(textPieces, metadataStr("question"))
-			textPieces = append(textPieces, questionContent.Content)
-			for i, choice := range choices {
-				metadata := "choice"
-				if choice.Correct {
-					metadata += " correct"
-				}
-				textPieces = append(textPieces, metadataStr(metadata))
-				textPieces = append(textPieces, choiceContents[i].Content)
-			}
-			if explanation.Content != "" {
-				textPieces = append(textPieces, metadataStr("explanation"))
-				textPieces = append(textPieces, explanation.Content)
-			}
-		} else {
-			return fmt.Errorf("invalid block type: %s", block.BlockType)
+	return ctx.renderer.RenderExportedModule(w, module.Markdown())
+}
+
+// Sends the module with the course's assets it references.
+func writeModuleArchive(w http.ResponseWriter, ctx HandlerContext, courseId int, moduleVersion db.ModuleVersion, module protocol.Module) error {
+	assets, err := ctx.dbClient.GetCourseAssets(courseId)
+	if err != nil {
+		return err
+	}
+	assetsByHash := make(map[string]db.Asset)
+	for _, asset := range assets {
+		assetsByHash[asset.Hash] = asset
+	}
+	protocolAssets := []protocol.Asset{}
+	for _, hash := range protocol.AssetReferences(module.Markdown()) {
+		if asset, ok := assetsByHash[hash]; ok {
+			protocolAssets = append(protocolAssets, protocol.NewAsset(asset.ContentType, asset.Data))
 		}
 	}
-	text := strings.Join(textPieces, "\n")
-	return ctx.renderer.RenderExportedModule(w, text)
+	var buf bytes.Buffer
+	err = protocol.WriteModuleArchive(&buf, module, protocolAssets)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"module-%d.zip\"", moduleVersion.ModuleId))
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// Course bundles
+
+func handleExportCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	courseId, err := strconv.Atoi(r.PathValue("courseId"))
+	if err != nil {
+		return err
+	}
+	course, err := ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	if err != nil {
+		return err
+	}
+	modules, protocolModules, err := getCourseProtocolModules(ctx, course.Id)
+	if err != nil {
+		return err
+	}
+	moduleIdxs := make(map[int]int) // moduleId -> index in bundle
+	for i, module := range modules {
+		moduleIdxs[module.Version.ModuleId] = i
+	}
+	prereqs, err := ctx.dbClient.GetCoursePrereqs(course.Id)
+	if err != nil {
+		return err
+	}
+	protocolPrereqs := make([]protocol.Prereq, 0)
+	for _, prereq := range prereqs {
+		protocolPrereqs = append(protocolPrereqs, protocol.NewPrereq(moduleIdxs[prereq.ModuleId], moduleIdxs[prereq.PrereqModuleId]))
+	}
+	knowledgePoints, err := ctx.dbClient.GetKnowledgePoints(int64(course.Id))
+	if err != nil {
+		return err
+	}
+	knowledgePointNames := make([]string, len(knowledgePoints))
+	for i, knowledgePoint := range knowledgePoints {
+		knowledgePointNames[i] = knowledgePoint.Name
+	}
+	assets, err := ctx.dbClient.GetCourseAssets(course.Id)
+	if err != nil {
+		return err
+	}
+	protocolAssets := make([]protocol.Asset, len(assets))
+	for i, asset := range assets {
+		protocolAssets[i] = protocol.NewAsset(asset.ContentType, asset.Data)
+	}
+	bundle := protocol.NewCourse(course.Title, course.Description, course.Public, protocolModules, protocolPrereqs, knowledgePointNames, protocolAssets)
+	// Write to a buffer first so we can still return an error before sending anything.
+	var buf bytes.Buffer
+	err = protocol.WriteBundle(&buf, bundle)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"course-%d.zip\"", course.Id))
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// Accepts the bundle either as the raw request body (for tools),
+// or as a file from a multipart form (for the browser).
+func parseImportCourseRequest(w http.ResponseWriter, r *http.Request, limits Limits) (protocol.Course, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(limits.MaxCourseBundleLength()))
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return protocol.Course{}, err
+	}
+	var data []byte
+	if mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("bundle")
+		if err != nil {
+			return protocol.Course{}, err
+		}
+		defer file.Close()
+		data, err = io.ReadAll(file)
+		if err != nil {
+			return protocol.Course{}, err
+		}
+	} else if mediaType == "application/zip" {
+		data, err = io.ReadAll(r.Body)
+		if err != nil {
+			return protocol.Course{}, err
+		}
+	} else {
+		return protocol.Course{}, fmt.Errorf("Course bundle must have content type application/zip")
+	}
+	return protocol.ReadBundle(data, limits.BundleLimits())
+}
+
+func copyEdges(edges map[int][]int) map[int][]int {
+	edgesCopy := make(map[int][]int)
+	for k, v := range edges {
+		edgesCopy[k] = append([]int{}, v...)
+	}
+	return edgesCopy
+}
+
+func validateImportCourseRequest(limits Limits, course protocol.Course) error {
+	moduleTitles := make([]string, len(course.Modules))
+	moduleDescriptions := make([]string, len(course.Modules))
+	for i, module := range course.Modules {
+		moduleTitles[i] = module.Title
+		moduleDescriptions[i] = module.Description
+	}
+	err := validateCourseRequest(limits, course.Title, course.Description, moduleTitles, moduleDescriptions)
+	if err != nil {
+		return err
+	}
+	if len(course.Assets) > limits.MaxAssets {
+		return fmt.Errorf("Courses cannot have more than %d assets", limits.MaxAssets)
+	}
+	for _, asset := range course.Assets {
+		if len(asset.Data) > limits.MaxAssetLength {
+			return fmt.Errorf("Assets cannot be larger than %d bytes", limits.MaxAssetLength)
+		}
+	}
+	if len(course.KnowledgePoints) > limits.MaxKnowledgePoints() {
+		return fmt.Errorf("Courses cannot have more than %d knowledge points", limits.MaxKnowledgePoints())
+	}
+	for _, name := range course.KnowledgePoints {
+		if len(name) > limits.MaxTitleLength {
+			return fmt.Errorf("Knowledge point names cannot be longer than %d characters", limits.MaxTitleLength)
+		}
+	}
+	edges := make(map[int][]int) // prereq idx -> module idxs
+	for _, prereq := range course.Prereqs {
+		edges[prereq.PrereqModuleIdx] = append(edges[prereq.PrereqModuleIdx], prereq.ModuleIdx)
+	}
+	for i := range course.Modules {
+		if hasCycle(copyEdges(edges), i) {
+			return badRequestErrorf("Cannot create cycle in prereqs")
+		}
+	}
+	return nil
+}
+
+func handleImportCourse(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	bundle, err := parseImportCourseRequest(w, r, ctx.limits)
+	if err != nil {
+		return badRequestErrorf("Error parsing import course request: %v", err)
+	}
+	err = validateImportCourseRequest(ctx.limits, bundle)
+	if err != nil {
+		return badRequestErrorf("Error validating import course request: %v", err)
+	}
+	tx, err := ctx.dbClient.Begin()
+	defer tx.Rollback()
+	if err != nil {
+		return err
+	}
+	course, err := db.CreateCourse(tx, user.Id, bundle.Title, bundle.Description, bundle.Public)
+	if err != nil {
+		return err
+	}
+	// Before the modules, which can only reference the course's assets
+	for _, asset := range bundle.Assets {
+		dbAsset, err := db.InsertAsset(tx, asset.ContentType, asset.Data)
+		if err != nil {
+			return err
+		}
+		err = db.InsertCourseAsset(tx, course.Id, dbAsset.Id)
+		if err != nil {
+			return err
+		}
+	}
+	moduleIds := make([]int, len(bundle.Modules))
+	for i, protocolModule := range bundle.Modules {
+		module, err := db.CreateModule(tx, course.Id, protocolModule.Title, protocolModule.Description)
+		if err != nil {
+			return err
+		}
+		moduleIds[i] = module.Id
+		req, err := newEditModuleRequest(int64(course.Id), module.Id, protocolModule)
+		if err != nil {
+			return badRequestErrorf("Error in module %d: %v", i+1, err)
+		}
+		err = validateEditModuleRequest(ctx.limits, req)
+		if err != nil {
+			return badRequestErrorf("Error validating module %d: %v", i+1, err)
+		}
+		err = insertModuleVersion(tx, req)
+		if err != nil {
+			return err
+		}
+	}
+	for _, prereq := range bundle.Prereqs {
+		_, err = db.InsertPrereq(tx, moduleIds[prereq.ModuleIdx], moduleIds[prereq.PrereqModuleIdx])
+		if err != nil {
+			return err
+		}
+	}
+	// Questions already created their own knowledge points, only add the rest.
+	knowledgePoints, err := db.GetKnowledgePoints(tx, int64(course.Id))
+	if err != nil {
+		return err
+	}
+	knowledgePointNames := make(map[string]bool)
+	for _, knowledgePoint := range knowledgePoints {
+		knowledgePointNames[knowledgePoint.Name] = true
+	}
+	for _, name := range bundle.KnowledgePoints {
+		if name == "" || knowledgePointNames[name] {
+			continue
+		}
+		_, err = db.InsertKnowledgePoint(tx, int64(course.Id), name)
+		if err != nil {
+			return err
+		}
+		knowledgePointNames[name] = true
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	w.Header().Add("HX-Redirect", fmt.Sprintf("/teacher?newCourse=%d#course-%d", course.Id, course.Id))
+	return nil
 }
 
 // Knowledge Points
@@ -979,15 +1884,129 @@ func handleCreateKnowledgePoint(w http.ResponseWriter, r *http.Request, ctx Hand
 	if err != nil {
 		return err
 	}
-	name := r.Form.Get("name")
+	name := strings.TrimSpace(r.Form.Get("name"))
 	if name == "" {
-		return fmt.Errorf("Name cannot be empty")
+		return badRequestErrorf("Name cannot be empty")
+	}
+	if len(name) > ctx.limits.MaxTitleLength {
+		return badRequestErrorf("Name cannot be longer than %d characters", ctx.limits.MaxTitleLength)
+	}
+	_, err = ctx.dbClient.GetTeacherCourse(courseIdInt, user.Id)
+	if err != nil {
+		return err
 	}
 	tx, err := ctx.dbClient.Begin()
 	defer tx.Rollback()
 	if err != nil {
 		return err
 	}
+	// Questions refer to knowledge points by name, so names are unique within a course
+	_, err = db.GetKnowledgePointByName(tx, courseId, name)
+	if err == nil {
+		return conflictErrorf("Knowledge point %s already exists", name)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
 	_, err = db.InsertKnowledgePoint(tx, courseId, name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Assets
+
+const MaxAssets = 64
+const MaxAssetLength = 4 << 20
+
+// Returns the asset content type for the data, or an error if it isn't a type assets can be.
+func assetContentType(data []byte) (string, error) {
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	// SVGs are sniffed as XML or plain text
+	if (contentType == "text/xml" || contentType == "text/plain") && bytes.Contains(data, []byte("<svg")) {
+		contentType = "image/svg+xml"
+	}
+	if _, ok := protocol.AssetExtensions[contentType]; !ok {
+		return "", fmt.Errorf("Assets must be PNG, JPEG, GIF, WebP, SVG or PDF files")
+	}
+	return contentType, nil
+}
+
+// Accepts the file either as the raw request body (for tools),
+// or as a file from a multipart form (for the browser).
+func parseUploadAssetRequest(w http.ResponseWriter, r *http.Request, limits Limits) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(limits.MaxAssetLength+1<<10))
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		data, err = io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > limits.MaxAssetLength {
+		return nil, fmt.Errorf("Assets cannot be larger than %d bytes", limits.MaxAssetLength)
+	}
+	return data, nil
+}
+
+// Responds with the asset: reference to use in markdown.
+func handleUploadAsset(w http.ResponseWriter, r *http.Request, ctx HandlerContext, user db.User) error {
+	courseId, err := strconv.Atoi(r.PathValue("courseId"))
+	if err != nil {
+		return err
+	}
+	_, err = ctx.dbClient.GetTeacherCourse(courseId, user.Id)
+	if err != nil {
+		return err
+	}
+	data, err := parseUploadAssetRequest(w, r, ctx.limits)
+	if err != nil {
+		return badRequestErrorf("Error parsing upload asset request: %v", err)
+	}
+	contentType, err := assetContentType(data)
+	if err != nil {
+		return badRequestErrorf("%v", err)
+	}
+	tx, err := ctx.dbClient.Begin()
+	defer tx.Rollback()
+	if err != nil {
+		return err
+	}
+	assets, err := db.GetCourseAssets(tx, courseId)
+	if err != nil {
+		return err
+	}
+	if len(assets) >= ctx.limits.MaxAssets {
+		return conflictErrorf("Courses cannot have more than %d assets", ctx.limits.MaxAssets)
+	}
+	asset, err := db.InsertAsset(tx, contentType, data)
+	if err != nil {
+		return err
+	}
+	err = db.InsertCourseAsset(tx, courseId, asset.Id)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = io.WriteString(w, protocol.AssetScheme+asset.Hash)
 	return err
 }
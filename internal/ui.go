@@ -4,22 +4,33 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"io"
 	"math/rand/v2"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/graemephi/goldmark-qjs-katex"
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 
 	"noobular/internal/db"
+	"noobular/internal/protocol"
 )
 
 type Renderer struct {
 	projectRootDir string
 	templates      map[string]*template.Template
+	// Optional, to time renders
+	metrics *Metrics
+	// Optional, to cache rendered content
+	contents *ContentCache
 }
 
 func NewRenderer(projectRootDir string) Renderer {
@@ -33,6 +44,14 @@ func (r *Renderer) refreshTemplates() {
 	r.templates = initTemplates(r.projectRootDir)
 }
 
+// Renders the named template from a template file.
+func (r *Renderer) execute(w io.Writer, file string, name string, data interface{}) error {
+	start := time.Now()
+	err := r.templates[file].ExecuteTemplate(w, name, data)
+	r.metrics.ObserveRender(file, name, time.Since(start))
+	return err
+}
+
 func initTemplates(projectRootDir string) map[string]*template.Template {
 	funcMap := template.FuncMap{
 		"TitleCase": func(s string) string {
@@ -44,8 +63,20 @@ func initTemplates(projectRootDir string) map[string]*template.Template {
 		"EmptyQuestion": func() UiQuestion {
 			return EmptyQuestion()
 		},
-		"EmptyChoice": func(questionIdx int) UiChoice {
-			return EmptyChoice(questionIdx)
+		"EmptyNumericQuestion": func() UiQuestion {
+			return EmptyNumericQuestion()
+		},
+		"EmptyMultiSelectQuestion": func() UiQuestion {
+			return EmptyMultiSelectQuestion()
+		},
+		"EmptyTextQuestion": func() UiQuestion {
+			return EmptyTextQuestion()
+		},
+		"EmptyOrderingQuestion": func() UiQuestion {
+			return EmptyOrderingQuestion()
+		},
+		"EmptyChoice": func(questionIdx int, questionType db.QuestionType) UiChoice {
+			return EmptyChoice(questionIdx, questionType)
 		},
 		"NumRange": func(n int) []int {
 			nums := make([]int, n)
@@ -69,11 +100,17 @@ func initTemplates(projectRootDir string) map[string]*template.Template {
 				       "edited_course_response.html"},
 		"edit_module.html":   {"page.html", "edit_module.html",
 				       "add_element.html",
-				       "edited_module_response.html"},
+				       "edited_module_response.html",
+				       "imported_questions_response.html"},
 		"prereq.html":        {"page.html", "prereq.html"},
 		"take_module.html":   {"page.html", "take_module.html"},
 		"add_element.html":   {"add_element.html"},
 		"export_module.html": {"export_module.html"},
+		"account.html":       {"page.html", "account.html",
+				       "created_token_response.html"},
+		"error.html":         {"page.html", "error.html"},
+		"site_index.html":    {"site_page.html", "site_index.html"},
+		"site_module.html":   {"site_page.html", "site_module.html"},
 	}
 	templates := make(map[string]*template.Template)
 	for name, paths := range filePaths {
@@ -96,6 +133,22 @@ func NewPageArgs(showNav, loggedIn bool, contentArgs interface{}) PageArgs {
 	return PageArgs{showNav, loggedIn, contentArgs}
 }
 
+type UiError struct {
+	Title   string
+	Message string
+	// Only set for internal errors, which don't say what went wrong
+	RequestId string
+}
+
+func (r *Renderer) RenderErrorPage(w http.ResponseWriter, loggedIn bool, uiError UiError) error {
+	return r.execute(w, "error.html", "page.html", NewPageArgs(true, loggedIn, uiError))
+}
+
+// Renders the error as a dismissable message htmx adds to the page.
+func (r *Renderer) RenderErrorFragment(w http.ResponseWriter, uiError UiError) error {
+	return r.execute(w, "error.html", "error_fragment", uiError)
+}
+
 // Home page - optional login
 // Browse page - optional login
 // Sign in/up page - not logged in
@@ -105,7 +158,7 @@ func NewPageArgs(showNav, loggedIn bool, contentArgs interface{}) PageArgs {
 
 // Basic welcome page when a user is not logged in.
 func (r *Renderer) RenderHomePage(w http.ResponseWriter, loggedIn bool) error {
-	return r.templates["index.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, loggedIn, nil))
+	return r.execute(w, "index.html", "page.html", NewPageArgs(true, loggedIn, nil))
 }
 
 type SignupPageArgs struct {
@@ -113,15 +166,15 @@ type SignupPageArgs struct {
 }
 
 func (r *Renderer) RenderSignupPage(w http.ResponseWriter) error {
-	return r.templates["signup.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, false, SignupPageArgs{false}))
+	return r.execute(w, "signup.html", "page.html", NewPageArgs(true, false, SignupPageArgs{false}))
 }
 
 func (r *Renderer) RenderSigninPage(w http.ResponseWriter) error {
-	return r.templates["signup.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, false, SignupPageArgs{true}))
+	return r.execute(w, "signup.html", "page.html", NewPageArgs(true, false, SignupPageArgs{true}))
 }
 
 func (r *Renderer) RenderBrowsePage(w http.ResponseWriter, courses []UiCourse, loggedIn bool) error {
-	return r.templates["courses.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, loggedIn, CoursePageArgs{0, false, loggedIn, courses}))
+	return r.execute(w, "courses.html", "page.html", NewPageArgs(true, loggedIn, CoursePageArgs{0, false, loggedIn, courses}))
 }
 
 // Course struct for feeding into a template to be rendered
@@ -211,44 +264,149 @@ func NewUiBlockContent(content UiContent, idx int) UiBlock {
 type UiQuestion struct {
 	Id int
 	// This is a random integer created to differentiate questions in the UI.
-	Idx         int
-	Content     UiContent
-	Choices     []UiChoice
-	Explanation UiContent
+	Idx          int
+	QuestionType db.QuestionType
+	Grading      db.Grading
+	Content      UiContent
+	Choices      []UiChoice
+	Numeric      UiNumeric
+	TextAnswer   UiTextAnswer
+	Ordering     UiOrdering
+	// The name of the knowledge point the author gave this question, if any
+	KnowledgePoint string
+	// The knowledge point this question tested when it was loaded for editing, or -1
+	KnowledgePointId int64
+	Explanation      UiContent
 }
 
 func NewUiQuestionEdit(q db.Question, content db.Content, choices []db.Choice, choiceContents []db.Content, explanation db.Content) UiQuestion {
 	questionIdx := rand.Int()
 	uiChoices := make([]UiChoice, 0)
 	for i, choice := range choices {
-		uiChoices = append(uiChoices, NewUiChoice(questionIdx, choice, NewUiContent(choiceContents[i])))
+		uiChoices = append(uiChoices, NewUiChoice(questionIdx, q.QuestionType, choice, NewUiContent(choiceContents[i])))
 	}
-	return UiQuestion{q.Id, questionIdx, NewUiContent(content), uiChoices, NewUiContent(explanation)}
+	return UiQuestion{q.Id, questionIdx, q.QuestionType, q.Grading, NewUiContent(content), uiChoices, UiNumeric{}, UiTextAnswer{}, UiOrdering{}, "", -1, NewUiContent(explanation)}
 }
 
 func NewUiQuestionTake(q db.Question, content UiContent, choices []db.Choice, choiceContents []UiContent, explanation UiContent) UiQuestion {
-	questionIdx := rand.Int()
-	uiChoices := make([]UiChoice, len(choices))
-	for i, choice := range choices {
-		uiChoices[i] = NewUiChoice(questionIdx, choice, choiceContents[i])
-	}
-	return UiQuestion{q.Id, questionIdx, content, uiChoices, explanation}
+	return NewUiQuestionAnswered(q, content, choices, choiceContents, []int{}, explanation)
 }
 
-func NewUiQuestionAnswered(q db.Question, content UiContent, choices []db.Choice, choiceContents []UiContent, chosenChoiceId int, explanation UiContent) UiQuestion {
+func NewUiQuestionAnswered(q db.Question, content UiContent, choices []db.Choice, choiceContents []UiContent, chosenChoiceIds []int, explanation UiContent) UiQuestion {
 	questionIdx := rand.Int()
 	uiChoices := make([]UiChoice, len(choices))
 	for i, choice := range choices {
-		if choice.Id == chosenChoiceId {
-			uiChoices[i] = NewUiChoiceChosen(questionIdx, choice, choiceContents[i])
+		if slices.Contains(chosenChoiceIds, choice.Id) {
+			uiChoices[i] = NewUiChoiceChosen(questionIdx, q.QuestionType, choice, choiceContents[i])
 		} else {
-			uiChoices[i] = NewUiChoice(questionIdx, choice, choiceContents[i])
+			uiChoices[i] = NewUiChoice(questionIdx, q.QuestionType, choice, choiceContents[i])
 		}
 	}
-	return UiQuestion{q.Id, questionIdx, content, uiChoices, explanation}
+	return UiQuestion{q.Id, questionIdx, q.QuestionType, q.Grading, content, uiChoices, UiNumeric{}, UiTextAnswer{}, UiOrdering{}, "", -1, explanation}
+}
+
+func NewUiNumericQuestionEdit(q db.Question, content db.Content, solution db.NumericSolution, explanation db.Content) UiQuestion {
+	return UiQuestion{q.Id, rand.Int(), db.NumericQuestionType, q.Grading, NewUiContent(content), []UiChoice{}, NewUiNumeric(solution), UiTextAnswer{}, UiOrdering{}, "", -1, NewUiContent(explanation)}
+}
+
+func NewUiNumericQuestionTake(q db.Question, content UiContent, solution db.NumericSolution, explanation UiContent) UiQuestion {
+	return UiQuestion{q.Id, rand.Int(), db.NumericQuestionType, q.Grading, content, []UiChoice{}, NewUiNumeric(solution), UiTextAnswer{}, UiOrdering{}, "", -1, explanation}
+}
+
+func NewUiNumericQuestionAnswered(q db.Question, content UiContent, solution db.NumericSolution, answer float64, explanation UiContent) UiQuestion {
+	return UiQuestion{q.Id, rand.Int(), db.NumericQuestionType, q.Grading, content, []UiChoice{}, NewUiNumericAnswered(solution, answer), UiTextAnswer{}, UiOrdering{}, "", -1, explanation}
+}
+
+func NewUiTextQuestionEdit(q db.Question, content db.Content, solution db.TextSolution, accepted []db.AcceptedAnswer, explanation db.Content) UiQuestion {
+	return UiQuestion{q.Id, rand.Int(), db.TextQuestionType, q.Grading, NewUiContent(content), []UiChoice{}, UiNumeric{}, NewUiTextAnswer(solution, accepted), UiOrdering{}, "", -1, NewUiContent(explanation)}
+}
+
+func NewUiTextQuestionTake(q db.Question, content UiContent, solution db.TextSolution, accepted []db.AcceptedAnswer, explanation UiContent) UiQuestion {
+	return UiQuestion{q.Id, rand.Int(), db.TextQuestionType, q.Grading, content, []UiChoice{}, UiNumeric{}, NewUiTextAnswer(solution, accepted), UiOrdering{}, "", -1, explanation}
+}
+
+func NewUiTextQuestionAnswered(q db.Question, content UiContent, solution db.TextSolution, accepted []db.AcceptedAnswer, answer string, explanation UiContent) UiQuestion {
+	return UiQuestion{q.Id, rand.Int(), db.TextQuestionType, q.Grading, content, []UiChoice{}, UiNumeric{}, NewUiTextAnswerAnswered(solution, accepted, answer), UiOrdering{}, "", -1, explanation}
+}
+
+// Ordering questions keep their items as choices in the correct order.
+func NewUiOrderingQuestionTake(q db.Question, content UiContent, choices []db.Choice, choiceContents []UiContent, explanation UiContent) UiQuestion {
+	question := NewUiQuestionAnswered(q, content, choices, choiceContents, []int{}, explanation)
+	question.Ordering = NewUiOrdering(question.Choices)
+	return question
+}
+
+func NewUiOrderingQuestionAnswered(q db.Question, content UiContent, choices []db.Choice, choiceContents []UiContent, order []int, explanation UiContent) UiQuestion {
+	question := NewUiQuestionAnswered(q, content, choices, choiceContents, order, explanation)
+	question.Ordering = NewUiOrderingAnswered(question.Choices, order)
+	return question
+}
+
+func (q UiQuestion) IsNumeric() bool {
+	return q.QuestionType == db.NumericQuestionType
+}
+
+func (q UiQuestion) IsText() bool {
+	return q.QuestionType == db.TextQuestionType
+}
+
+func (q UiQuestion) IsMultiSelect() bool {
+	return q.QuestionType == db.MultiSelectQuestionType
+}
+
+func (q UiQuestion) IsOrdering() bool {
+	return q.QuestionType == db.OrderingQuestionType
+}
+
+func (q UiQuestion) IsPartialCredit() bool {
+	return q.Grading == db.PartialCreditGrading
+}
+
+// The fraction of the question the student got right, from 0 to 1.
+func (q UiQuestion) Score() float64 {
+	if q.IsNumeric() {
+		if q.Numeric.Answered && q.Numeric.Correct() {
+			return 1
+		}
+		return 0
+	}
+	if q.IsText() {
+		if q.TextAnswer.Answered && q.TextAnswer.Correct() {
+			return 1
+		}
+		return 0
+	}
+	if q.IsOrdering() {
+		correctOrder := make([]int, len(q.Choices))
+		for i, choice := range q.Choices {
+			correctOrder[i] = choice.Id
+		}
+		return gradeOrdering(correctOrder, q.Ordering.AnswerIds(), q.Grading)
+	}
+	correct := make([]bool, len(q.Choices))
+	chosen := make([]bool, len(q.Choices))
+	for i, choice := range q.Choices {
+		correct[i] = choice.IsCorrect
+		chosen[i] = choice.Chosen
+	}
+	return gradeChoices(correct, chosen, q.Grading)
+}
+
+func (q UiQuestion) PartiallyCorrect() bool {
+	score := q.Score()
+	return score > 0 && score < 1
 }
 
 func (q UiQuestion) Answered() bool {
+	if q.IsNumeric() {
+		return q.Numeric.Answered
+	}
+	if q.IsText() {
+		return q.TextAnswer.Answered
+	}
+	if q.IsOrdering() {
+		return len(q.Ordering.Answer) > 0
+	}
 	for _, choice := range q.Choices {
 		if choice.Chosen {
 			return true
@@ -258,16 +416,28 @@ func (q UiQuestion) Answered() bool {
 }
 
 func (q UiQuestion) AnsweredCorrectly() bool {
-	for _, choice := range q.Choices {
-		if choice.Chosen && choice.IsCorrect {
-			return true
-		}
-	}
-	return false
+	return q.Answered() && q.Score() == 1
 }
 
 func EmptyQuestion() UiQuestion {
-	return UiQuestion{-1, rand.Int(), EmptyContent(), []UiChoice{}, EmptyContent()}
+	return UiQuestion{-1, rand.Int(), db.MultipleChoiceQuestionType, db.AllOrNothingGrading, EmptyContent(), []UiChoice{}, UiNumeric{}, UiTextAnswer{}, UiOrdering{}, "", -1, EmptyContent()}
+}
+
+func EmptyMultiSelectQuestion() UiQuestion {
+	return UiQuestion{-1, rand.Int(), db.MultiSelectQuestionType, db.AllOrNothingGrading, EmptyContent(), []UiChoice{}, UiNumeric{}, UiTextAnswer{}, UiOrdering{}, "", -1, EmptyContent()}
+}
+
+func EmptyTextQuestion() UiQuestion {
+	textAnswer := NewUiTextAnswer(db.NewTextSolution(-1, -1, true, true), []db.AcceptedAnswer{})
+	return UiQuestion{-1, rand.Int(), db.TextQuestionType, db.AllOrNothingGrading, EmptyContent(), []UiChoice{}, UiNumeric{}, textAnswer, UiOrdering{}, "", -1, EmptyContent()}
+}
+
+func EmptyOrderingQuestion() UiQuestion {
+	return UiQuestion{-1, rand.Int(), db.OrderingQuestionType, db.AllOrNothingGrading, EmptyContent(), []UiChoice{}, UiNumeric{}, UiTextAnswer{}, UiOrdering{}, "", -1, EmptyContent()}
+}
+
+func EmptyNumericQuestion() UiQuestion {
+	return UiQuestion{-1, rand.Int(), db.NumericQuestionType, db.AllOrNothingGrading, EmptyContent(), []UiChoice{}, UiNumeric{}, UiTextAnswer{}, UiOrdering{}, "", -1, EmptyContent()}
 }
 
 func (q UiQuestion) ElementType() string {
@@ -282,28 +452,158 @@ func (q UiQuestion) IsEmpty() bool {
 	return q.Id == -1
 }
 
+type UiNumeric struct {
+	Solution db.NumericSolution
+	Answered bool
+	Answer   float64
+}
+
+func NewUiNumeric(solution db.NumericSolution) UiNumeric {
+	return UiNumeric{solution, false, 0}
+}
+
+func NewUiNumericAnswered(solution db.NumericSolution, answer float64) UiNumeric {
+	return UiNumeric{solution, true, answer}
+}
+
+func (n UiNumeric) Correct() bool {
+	return numericSolutionAccepts(n.Solution, n.Answer)
+}
+
+func (n UiNumeric) ValueText() string {
+	return protocol.FormatNumber(n.Solution.Value)
+}
+
+func (n UiNumeric) ToleranceText() string {
+	return protocol.FormatTolerance(n.Solution.Tolerance, n.Solution.Relative)
+}
+
+func (n UiNumeric) AnswerText() string {
+	return protocol.FormatNumber(n.Answer)
+}
+
+type UiTextAnswer struct {
+	Solution db.TextSolution
+	Accepted []db.AcceptedAnswer
+	Answered bool
+	Answer   string
+}
+
+func NewUiTextAnswer(solution db.TextSolution, accepted []db.AcceptedAnswer) UiTextAnswer {
+	return UiTextAnswer{solution, accepted, false, ""}
+}
+
+func NewUiTextAnswerAnswered(solution db.TextSolution, accepted []db.AcceptedAnswer, answer string) UiTextAnswer {
+	return UiTextAnswer{solution, accepted, true, answer}
+}
+
+func (a UiTextAnswer) Correct() bool {
+	return textSolutionAccepts(a.Solution, a.Accepted, a.Answer)
+}
+
+// The accepted answers that aren't regexes, to show students what we were looking for.
+func (a UiTextAnswer) AcceptedStrings() []string {
+	accepted := []string{}
+	for _, answer := range a.Accepted {
+		if !answer.Regex {
+			accepted = append(accepted, answer.Answer)
+		}
+	}
+	return accepted
+}
+
+// One accepted answer per line for the edit form, with regexes written as /regex/.
+func (a UiTextAnswer) AcceptedLines() string {
+	lines := make([]string, len(a.Accepted))
+	for i, answer := range a.Accepted {
+		if answer.Regex {
+			lines[i] = "/" + answer.Answer + "/"
+		} else {
+			lines[i] = answer.Answer
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type UiOrdering struct {
+	// The items in the order they start in for the student to rearrange,
+	// which is shuffled so that it isn't already the answer.
+	Shuffled []UiChoice
+	// The items in the order the student put them in, empty if unanswered.
+	Answer []UiChoice
+	// Items are posted by token rather than id, see tokenizeOrdering.
+	Nonce string
+	// choiceId -> token
+	Tokens map[int]string
+}
+
+func NewUiOrdering(items []UiChoice) UiOrdering {
+	shuffled := slices.Clone(items)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	if len(shuffled) > 1 && slices.EqualFunc(shuffled, items, func(a, b UiChoice) bool { return a.Id == b.Id }) {
+		shuffled = append(shuffled[1:], shuffled[0])
+	}
+	return UiOrdering{shuffled, []UiChoice{}, "", map[int]string{}}
+}
+
+func NewUiOrderingAnswered(items []UiChoice, order []int) UiOrdering {
+	answer := make([]UiChoice, 0, len(order))
+	for _, choiceId := range order {
+		idx := slices.IndexFunc(items, func(c UiChoice) bool { return c.Id == choiceId })
+		if idx != -1 {
+			answer = append(answer, items[idx])
+		}
+	}
+	return UiOrdering{items, answer, "", map[int]string{}}
+}
+
+func (o UiOrdering) Token(choiceId int) string {
+	return o.Tokens[choiceId]
+}
+
+func (o UiOrdering) AnswerIds() []int {
+	ids := make([]int, len(o.Answer))
+	for i, choice := range o.Answer {
+		ids[i] = choice.Id
+	}
+	return ids
+}
+
 type UiChoice struct {
 	Id int
 	// This is a random integer created to differentiate questions in the UI.
 	QuestionIdx int
 	/// A random idx just to differentiate choices in the UI
 	/// so that label elements can be associated with certain choices.
-	Idx       int
-	Content   UiContent
-	Chosen    bool
-	IsCorrect bool
+	Idx int
+	// The type of the question this choice belongs to, which decides
+	// whether it's a radio button, a checkbox, or an item to put in order.
+	QuestionType db.QuestionType
+	Content      UiContent
+	Chosen       bool
+	IsCorrect    bool
 }
 
-func NewUiChoice(questionIdx int, c db.Choice, content UiContent) UiChoice {
-	return UiChoice{c.Id, questionIdx, rand.Int(), content, false, c.Correct}
+func NewUiChoice(questionIdx int, questionType db.QuestionType, c db.Choice, content UiContent) UiChoice {
+	return UiChoice{c.Id, questionIdx, rand.Int(), questionType, content, false, c.Correct}
 }
 
-func NewUiChoiceChosen(questionIdx int, c db.Choice, content UiContent) UiChoice {
-	return UiChoice{c.Id, questionIdx, rand.Int(), content, true, c.Correct}
+func NewUiChoiceChosen(questionIdx int, questionType db.QuestionType, c db.Choice, content UiContent) UiChoice {
+	return UiChoice{c.Id, questionIdx, rand.Int(), questionType, content, true, c.Correct}
 }
 
-func EmptyChoice(questionIdx int) UiChoice {
-	return UiChoice{-1, questionIdx, rand.Int(), UiContent{}, false, false}
+func EmptyChoice(questionIdx int, questionType db.QuestionType) UiChoice {
+	return UiChoice{-1, questionIdx, rand.Int(), questionType, UiContent{}, false, false}
+}
+
+func (c UiChoice) IsMultiSelect() bool {
+	return c.QuestionType == db.MultiSelectQuestionType
+}
+
+func (c UiChoice) IsOrdering() bool {
+	return c.QuestionType == db.OrderingQuestionType
 }
 
 func (c UiChoice) ElementType() string {
@@ -365,23 +665,23 @@ func (a StudentCoursePageArgs) HasCompletedModules() bool {
 }
 
 func (r *Renderer) RenderStudentPage(w http.ResponseWriter, args StudentPageArgs) error {
-	return r.templates["student.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, true, args))
+	return r.execute(w, "student.html", "page.html", NewPageArgs(true, true, args))
 }
 
 func (r *Renderer) RenderStudentCoursePage(w http.ResponseWriter, args StudentCoursePageArgs) error {
-	return r.templates["student.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, true, args))
+	return r.execute(w, "student.html", "page.html", NewPageArgs(true, true, args))
 }
 
 func (r *Renderer) RenderTeacherCoursePage(w http.ResponseWriter, courses []UiCourse, newCourseId int) error {
-	return r.templates["courses.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, true, CoursePageArgs{newCourseId, true, true, courses}))
+	return r.execute(w, "courses.html", "page.html", NewPageArgs(true, true, CoursePageArgs{newCourseId, true, true, courses}))
 }
 
 func (r *Renderer) RenderCreateCoursePage(w http.ResponseWriter) error {
-	return r.templates["create_course.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, true, EmptyCourse()))
+	return r.execute(w, "create_course.html", "page.html", NewPageArgs(true, true, EmptyCourse()))
 }
 
 func (r *Renderer) RenderCourseCreated(w http.ResponseWriter) error {
-	return r.templates["create_course.html"].ExecuteTemplate(w, "created_course_response.html", nil)
+	return r.execute(w, "create_course.html", "created_course_response.html", nil)
 }
 
 func (r *Renderer) RenderEditCoursePage(w http.ResponseWriter, course UiCourse, publicFixed bool) error {
@@ -389,19 +689,19 @@ func (r *Renderer) RenderEditCoursePage(w http.ResponseWriter, course UiCourse,
 	if publicFixed {
 		uiCourse = UiFixedPublicCourse(course)
 	}
-	return r.templates["create_course.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, true, uiCourse))
+	return r.execute(w, "create_course.html", "page.html", NewPageArgs(true, true, uiCourse))
 }
 
 func (r *Renderer) RenderCourseEdited(w http.ResponseWriter) error {
-	return r.templates["create_course.html"].ExecuteTemplate(w, "edited_course_response.html", nil)
+	return r.execute(w, "create_course.html", "edited_course_response.html", nil)
 }
 
 func (r *Renderer) RenderNewModule(w http.ResponseWriter, module UiModule) error {
-	return r.templates["add_element.html"].ExecuteTemplate(w, "add_element.html", module)
+	return r.execute(w, "add_element.html", "add_element.html", module)
 }
 
 func (r *Renderer) RenderNewQuestion(w http.ResponseWriter, question UiQuestion) error {
-	return r.templates["add_element.html"].ExecuteTemplate(w, "add_element.html", question)
+	return r.execute(w, "add_element.html", "add_element.html", question)
 }
 
 type UiContent struct {
@@ -417,6 +717,14 @@ func NewUiContent(content db.Content) UiContent {
 }
 
 func newMd() goldmark.Markdown {
+	return newMdWithAssetUrls(func(hash string) string {
+		return "/asset/" + hash
+	})
+}
+
+// Like newMd, but with asset: references pointing wherever assetUrl says,
+// e.g. to files next to the page instead of the server.
+func newMdWithAssetUrls(assetUrl func(hash string) string) goldmark.Markdown {
 	// For some of these, I should consider how rendering
 	// these fits into the protocol. For example, I want
 	// to allow people to include custom interactive diagrams
@@ -430,9 +738,41 @@ func newMd() goldmark.Markdown {
 			&qjskatex.Extension{},
 			extension.Table,
 		),
+		goldmark.WithParserOptions(
+			parser.WithASTTransformers(util.Prioritized(assetLinkTransformer{assetUrl}, 100)),
+		),
 	)
 }
 
+// Points links and images to asset: references, e.g. ![diagram](asset:3f2a...),
+// at where the asset is.
+type assetLinkTransformer struct {
+	assetUrl func(hash string) string
+}
+
+func (t assetLinkTransformer) Transform(document *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := node.(type) {
+		case *ast.Link:
+			node.Destination = t.resolve(node.Destination)
+		case *ast.Image:
+			node.Destination = t.resolve(node.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+func (t assetLinkTransformer) resolve(destination []byte) []byte {
+	hash, ok := protocol.ParseAssetUrl(string(destination))
+	if !ok {
+		return destination
+	}
+	return []byte(t.assetUrl(hash))
+}
+
 func NewUiContentRendered(content db.Content) (UiContent, error) {
 	var buf bytes.Buffer
 	if err := newMd().Convert([]byte(content.Content), &buf); err != nil {
@@ -458,11 +798,11 @@ func (c UiContent) IsEmpty() bool {
 }
 
 func (r *Renderer) RenderNewContent(w http.ResponseWriter, content UiContent) error {
-	return r.templates["add_element.html"].ExecuteTemplate(w, "add_element.html", content)
+	return r.execute(w, "add_element.html", "add_element.html", content)
 }
 
 func (r *Renderer) RenderNewChoice(w http.ResponseWriter, choice UiChoice) error {
-	return r.templates["add_element.html"].ExecuteTemplate(w, "add_element.html", choice)
+	return r.execute(w, "add_element.html", "add_element.html", choice)
 }
 
 type UiEditModule struct {
@@ -475,11 +815,57 @@ type UiEditModule struct {
 }
 
 func (r *Renderer) RenderEditModulePage(w http.ResponseWriter, module UiEditModule) error {
-	return r.templates["edit_module.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, true, module))
+	return r.execute(w, "edit_module.html", "page.html", NewPageArgs(true, true, module))
 }
 
 func (r *Renderer) RenderModuleEdited(w http.ResponseWriter) error {
-	return r.templates["edit_module.html"].ExecuteTemplate(w, "edited_module_response.html", nil)
+	return r.execute(w, "edit_module.html", "edited_module_response.html", nil)
+}
+
+type UiImportedQuestions struct {
+	Imported int
+	// Items that couldn't be imported, with where they are in the file
+	Problems []string
+}
+
+func (r *Renderer) RenderQuestionsImported(w http.ResponseWriter, result UiImportedQuestions) error {
+	return r.execute(w, "edit_module.html", "imported_questions_response.html", result)
+}
+
+type UiAccessToken struct {
+	Id         int64
+	Name       string
+	Scope      db.TokenScope
+	CreatedAt  string
+	LastUsedAt string
+}
+
+const uiTimeFormat = "2006-01-02 15:04 UTC"
+
+func NewUiAccessToken(token db.AccessToken) UiAccessToken {
+	lastUsedAt := "Never"
+	if token.LastUsedAt.Valid {
+		lastUsedAt = token.LastUsedAt.Time.UTC().Format(uiTimeFormat)
+	}
+	return UiAccessToken{token.Id, token.Name, token.Scope, token.CreatedAt.UTC().Format(uiTimeFormat), lastUsedAt}
+}
+
+type UiAccountPage struct {
+	Tokens []UiAccessToken
+}
+
+func (r *Renderer) RenderAccountPage(w http.ResponseWriter, page UiAccountPage) error {
+	return r.execute(w, "account.html", "page.html", NewPageArgs(true, true, page))
+}
+
+type UiCreatedAccessToken struct {
+	Token UiAccessToken
+	// The token itself, which is only shown this once
+	Secret string
+}
+
+func (r *Renderer) RenderAccessTokenCreated(w http.ResponseWriter, created UiCreatedAccessToken) error {
+	return r.execute(w, "account.html", "created_token_response.html", created)
 }
 
 type UiPrereqPageArgs struct {
@@ -497,7 +883,7 @@ func NewUiPrereq(m UiModule, prereq bool) UiPrereq {
 }
 
 func (r *Renderer) RenderPrereqPage(w http.ResponseWriter, pageArgs UiPrereqPageArgs) error {
-	return r.templates["prereq.html"].ExecuteTemplate(w, "page.html", NewPageArgs(true, true, pageArgs))
+	return r.execute(w, "prereq.html", "page.html", NewPageArgs(true, true, pageArgs))
 }
 
 type UiPrereqForm struct {
@@ -506,11 +892,11 @@ type UiPrereqForm struct {
 }
 
 func (r *Renderer) RenderPrereqForm(w http.ResponseWriter, prereqForm UiPrereqForm) error {
-	return r.templates["prereq.html"].ExecuteTemplate(w, "prereq_form", prereqForm)
+	return r.execute(w, "prereq.html", "prereq_form", prereqForm)
 }
 
 func (r *Renderer) RenderPrereqEditedResponse(w http.ResponseWriter, module UiModule) error {
-	return r.templates["prereq.html"].ExecuteTemplate(w, "edit_prereq_response", module)
+	return r.execute(w, "prereq.html", "edit_prereq_response", module)
 }
 
 type UiTakeModulePage struct {
@@ -518,6 +904,14 @@ type UiTakeModulePage struct {
 	Blocks     []UiBlock
 	VisitIndex int
 	Preview    bool
+	// Set when previewing a local file rather than a module on the server
+	Local *UiLocalPreview
+}
+
+type UiLocalPreview struct {
+	// The version of the file rendered, so the page knows when to reload
+	Version  string
+	Problems []protocol.Diagnostic
 }
 
 func (u UiTakeModulePage) IsPage() bool {
@@ -530,11 +924,12 @@ func (u UiTakeModulePage) ModuleBlock(index int) UiTakeModule {
 		Block:      u.Blocks[index],
 		VisitIndex: u.VisitIndex,
 		Preview:    u.Preview,
+		Local:      u.Local != nil,
 	}
 }
 
 func (r *Renderer) RenderTakeModulePage(w http.ResponseWriter, module UiTakeModulePage) error {
-	return r.templates["take_module.html"].ExecuteTemplate(w, "page.html", NewPageArgs(false, true, module))
+	return r.execute(w, "take_module.html", "page.html", NewPageArgs(false, true, module))
 }
 
 type UiTakeModule struct {
@@ -542,6 +937,7 @@ type UiTakeModule struct {
 	Block      UiBlock
 	VisitIndex int
 	Preview    bool
+	Local      bool
 }
 
 func (u UiTakeModule) IsPage() bool {
@@ -555,13 +951,65 @@ func (u UiTakeModule) ShowNextButton() bool {
 
 // Renders just the content, i.e. the header + content, not the full page.
 func (r *Renderer) RenderTakeModule(w http.ResponseWriter, module UiTakeModule) error {
-	return r.templates["take_module.html"].ExecuteTemplate(w, "content_inner", module)
+	return r.execute(w, "take_module.html", "content_inner", module)
 }
 
 func (r *Renderer) RenderQuestionSubmitted(w http.ResponseWriter, module UiTakeModule) error {
-	return r.templates["take_module.html"].ExecuteTemplate(w, "question_submitted", module)
+	return r.execute(w, "take_module.html", "question_submitted", module)
 }
 
 func (r *Renderer) RenderExportedModule(w http.ResponseWriter, text string) error {
-	return r.templates["export_module.html"].ExecuteTemplate(w, "export_module.html", template.HTML(text)) // Use template.HTML to prevent escaping
+	return r.execute(w, "export_module.html", "export_module.html", template.HTML(text)) // Use template.HTML to prevent escaping
+}
+
+// Static site pages, which link to each other and their styles by relative paths
+
+type UiSiteModuleLink struct {
+	Title       string
+	Description string
+	Filename    string
+}
+
+type UiSiteCourse struct {
+	Title       string
+	Description string
+	Modules     []UiSiteModuleLink
+}
+
+// A question with its answer hidden until the reader reveals it.
+type UiSiteQuestion struct {
+	Number int
+	Text   template.HTML
+	// "radio" or "checkbox" for questions with choices
+	ChoiceInput string
+	Choices     []template.HTML
+	// An ordering question's items, shuffled
+	Items []template.HTML
+	// Whether the question is answered by writing something
+	FreeResponse bool
+	Answer       template.HTML
+	Explanation  template.HTML
+}
+
+type UiSiteBlock struct {
+	Content  template.HTML
+	Question *UiSiteQuestion
+}
+
+type UiSiteModule struct {
+	CourseTitle string
+	Title       string
+	Description string
+	Blocks      []UiSiteBlock
+	// Filenames of the modules before and after this one, if any
+	Previous string
+	Next     string
+}
+
+func (r *Renderer) RenderSiteIndex(w io.Writer, course UiSiteCourse) error {
+	return r.execute(w, "site_index.html", "site_page.html", course)
+}
+
+func (r *Renderer) RenderSiteModule(w io.Writer, module UiSiteModule) error {
+	return r.execute(w, "site_module.html", "site_page.html", module)
 }
@@ -1,19 +1,25 @@
 package internal_test
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"noobular/internal"
 	"noobular/internal/client"
 	"noobular/internal/db"
+	"noobular/internal/protocol"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
-	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/stretchr/testify/require"
 )
 
@@ -21,32 +27,39 @@ const testUrl = "http://localhost:8080"
 const testJwtSecretHex = "5b0c060a53f2c6cd88dde0993fac31648ae75fe092b56571e6b51da56a8e4e87"
 
 func testServer(dbClient *db.DbClient) *http.Server {
-	jwtSecret, _ := hex.DecodeString(testJwtSecretHex)
-	urlStr := testUrl
-	urlUrl, _ := url.Parse(urlStr)
-	webAuthn, _ := webauthn.New(&webauthn.Config{
-		RPDisplayName: "WebAuthn Demo",   // Display Name for your site
-		RPID:          urlUrl.Hostname(), // Generally the domain name for your site
-		RPOrigins:     []string{urlStr},  // The origin URL for WebAuthn requests
-	})
+	return testServerWithLimits(dbClient, internal.DefaultLimits(), internal.NewMetrics())
+}
 
-	port := 8080
+func testServerWithLimits(dbClient *db.DbClient, limits internal.Limits, metrics *internal.Metrics) *http.Server {
+	jwtSecret, _ := hex.DecodeString(testJwtSecretHex)
+	config := internal.Config{Env: internal.Local, BaseUrl: testUrl, Limits: limits}.WithDefaults()
+	webAuthn, _ := config.WebAuthn()
 	renderer := internal.NewRenderer("..")
-	return internal.NewServer(dbClient, renderer, webAuthn, jwtSecret, port, internal.Local)
+	return internal.NewServer(dbClient, renderer, webAuthn, jwtSecret, config, metrics)
 }
 
 type testContext struct {
 	t *testing.T
 	server *http.Server
 	db *db.DbClient
+	metrics *internal.Metrics
 	userCount int
 }
 
 func (c testContext) Close() {
 	c.server.Close()
+	// Otherwise the next test's first POST can reuse a connection to this closed server.
+	http.DefaultClient.CloseIdleConnections()
 	c.db.Close()
 }
 
+// What the metrics server would serve, since metrics aren't on the test server.
+func (c testContext) metricsText() string {
+	var buf bytes.Buffer
+	require.Nil(c.t, c.metrics.WriteText(&buf))
+	return buf.String()
+}
+
 func (c *testContext) createUser() db.User {
 	fmt.Println("Creating user:", c.userCount)
 	user, err := c.db.CreateUser("test" + strconv.Itoa(c.userCount))
@@ -56,15 +69,80 @@ func (c *testContext) createUser() db.User {
 }
 
 func startServer(t *testing.T) testContext {
+	return startServerWithLimits(t, internal.DefaultLimits())
+}
+
+func startServerWithLimits(t *testing.T, limits internal.Limits) testContext {
 	dbClient := db.NewMemoryDbClient()
-	server := testServer(dbClient)
-	ready := make(chan struct{})
-	go func() {
-		close(ready)
-		server.ListenAndServe()
-	}()
-	<-ready
-	return testContext{t: t, server: server, db: dbClient, userCount: 0}
+	metrics := internal.NewMetrics()
+	server := testServerWithLimits(dbClient, limits, metrics)
+	// Listening before returning, so the test's first request can't beat the server to it
+	listener, err := net.Listen("tcp", server.Addr)
+	require.Nil(t, err)
+	go server.Serve(listener)
+	return testContext{t: t, server: server, db: dbClient, metrics: metrics, userCount: 0}
+}
+
+var buildCliOnce sync.Once
+var cliPath string
+var cliBuildErr error
+
+// The noobular command, built once for every test that runs it.
+func buildCli(t *testing.T) string {
+	// The main package isn't imported, so go test only knows to rerun
+	// when it changes because its files were looked at.
+	sources, _ := filepath.Glob("../*.go")
+	for _, source := range sources {
+		os.Stat(source)
+	}
+	buildCliOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "noobular-cli")
+		if err != nil {
+			cliBuildErr = err
+			return
+		}
+		cliPath = filepath.Join(dir, "noobular")
+		output, err := exec.Command("go", "build", "-o", cliPath, "..").CombinedOutput()
+		if err != nil {
+			cliBuildErr = fmt.Errorf("%v: %s", err, output)
+		}
+	})
+	require.Nil(t, cliBuildErr)
+	return cliPath
+}
+
+type cliResult struct {
+	stdout string
+	stderr string
+	exitCode int
+}
+
+// Runs the noobular command, without any of the environment it reads
+// config from, so only its arguments decide what it does.
+func runCli(t *testing.T, args ...string) cliResult {
+	cmd := exec.Command(buildCli(t), args...)
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "NOOBULAR_") && !strings.HasPrefix(env, "ENVIRONMENT=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if _, exited := err.(*exec.ExitError); err != nil && !exited {
+		require.Nil(t, err)
+	}
+	return cliResult{stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()}
+}
+
+// Flags for remote commands to reach the test server as the user.
+func cliRemoteFlags(t *testing.T, userId int64) []string {
+	jwtSecret, _ := hex.DecodeString(testJwtSecretHex)
+	cookie, _ := internal.CreateAuthCookie(jwtSecret, userId, false)
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	require.Nil(t, os.WriteFile(credentials, []byte(cookie.Value), 0600))
+	return []string{"-url", testUrl, "-credentials", credentials}
 }
 
 type testClient struct {
@@ -78,10 +156,17 @@ func newTestClient(t *testing.T) testClient {
 }
 
 func (c testClient) request(method string, path string, body string) *http.Response {
+	return c.requestWithHeaders(method, path, body, map[string]string{})
+}
+
+func (c testClient) requestWithHeaders(method string, path string, body string, headers map[string]string) *http.Response {
 	req, _ := http.NewRequest(method, c.baseUrl+path, strings.NewReader(body))
 	if method == "POST" || method == "PUT" {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
 	if c.session_token != nil {
 		req.AddCookie(c.session_token)
 	}
@@ -133,6 +218,13 @@ func bodyText(t *testing.T, resp *http.Response) string {
 	return string(bodyBytes)
 }
 
+func readBundle(t *testing.T, data []byte) protocol.Course {
+	limits := internal.DefaultLimits()
+	bundle, err := protocol.ReadBundle(data, limits.BundleLimits())
+	require.Nil(t, err)
+	return bundle
+}
+
 type titleDescInput struct {
 	Title string
 	Description string
@@ -280,7 +372,7 @@ func (b uiQuestionBuilder) build() internal.UiQuestion {
 		choiceContents = append(choiceContents, db.NewContent(-1, choice.choiceText))
 		choices = append(choices, db.NewChoice(-1, -1, -1, choice.isCorrect))
 	}
-	return internal.NewUiQuestionEdit(db.NewQuestion(-1, -1, -1), db.NewContent(-1, b.questionText), choices, choiceContents, db.NewContent(-1, b.explanation))
+	return internal.NewUiQuestionEdit(db.NewQuestion(-1, -1, -1, db.MultipleChoiceQuestionType, db.AllOrNothingGrading), db.NewContent(-1, b.questionText), choices, choiceContents, db.NewContent(-1, b.explanation))
 }
 
 func newTestUiQuestion(moduleId int64, questionNumber int) internal.UiQuestion {
@@ -437,3 +529,25 @@ func completeModuleRoute(courseId int, moduleId int) string {
 func nextModulePieceRoute(courseId int, moduleId int, blockIdx int) string {
 	return fmt.Sprintf("/student/course/%d/module/%d/block/%d/piece", courseId, moduleId, blockIdx)
 }
+
+func answerQuestionRoute(courseId int, moduleId int, blockIdx int) string {
+	return fmt.Sprintf("/student/course/%d/module/%d/block/%d/answer", courseId, moduleId, blockIdx)
+}
+
+// A buffer the server can log to while a test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -3,160 +3,850 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"noobular/internal"
 	"noobular/internal/client"
 	"noobular/internal/db"
+	"noobular/internal/protocol"
 
-	"github.com/go-webauthn/webauthn/webauthn"
+	"gopkg.in/yaml.v3"
 )
 
-func main() {
-	if len(os.Args) != 1 && len(os.Args) != 5 {
-		log.Fatal(`Usage: noobular [<auth> <course_id> <module_id> <filepath>]`)
+// Exit codes
+const (
+	exitOk = 0
+	// The command failed, or found problems, e.g. lint errors
+	exitFailure = 1
+	// The command was used wrong, e.g. missing arguments
+	exitUsage = 2
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"serve", "", "Run the server", runServe},
+	{"upload", "<course_id> <module_id> <filepath>", "Upload a module's markdown or JSON source", runUpload},
+	{"export", "<course_id> [<module_id>]", "Export a module, or a whole course as a zip bundle", runExport},
+	{"sync", "<dir>", "Upload the modules in a course directory that changed", runSync},
+	{"lint", "<filepath>...", "Check module files for problems", runLint},
+	{"fmt", "<filepath>...", "Rewrite module files in canonical form", runFmt},
+	{"import", "<filepath.gift|filepath.csv>", "Print a question bank as a module", runImport},
+	{"export-site", "<course_id> <dir>", "Render a course from the local database to static HTML", runExportSite},
+	{"preview", "<filepath>", "Preview a module file in the browser, reloading when it's saved", runPreview},
+	{"migrate", "", "Create or migrate the local database", runMigrate},
+}
+
+const usage = `Usage: noobular <command> [flags] [arguments]
+
+Commands:
+%s
+Run "noobular help <command>" for a command's flags.
+`
+
+func printUsage(w io.Writer) {
+	var lines strings.Builder
+	for _, cmd := range commands {
+		fmt.Fprintf(&lines, "  %-12s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintf(w, usage, lines.String())
+}
 
-	envStr := os.Getenv("ENVIRONMENT")
-	env := internal.Environment(envStr)
-	envNotSet := env != internal.Local && env != internal.Production
+// Returned by commands that were used wrong, to print how to use them.
+type usageError struct {
+	message string
+}
 
-	if len(os.Args) == 5 {
-		if envNotSet {
-			log.Println("No environment set: defaulting to production for upload")
-			env = internal.Production
-		}
-		cfg := parseUploadConfig(env)
-		uploadModule(cfg)
-	} else {
-		if envNotSet {
-			log.Println("No environment set: defaulting to local for server")
-			env = internal.Local
+func (e usageError) Error() string {
+	return e.message
+}
+
+func usageErrorf(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// Returned by commands that already printed what went wrong.
+var errReported = errors.New("problems reported")
+
+// Like errReported, but the flag package printed it, so it's a usage error.
+var errBadFlags = errors.New("invalid flags")
+
+func newFlagSet(cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: noobular %s [flags] %s\n\n%s.\n", cmd.name, cmd.args, cmd.summary)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out, "\nFlags:")
+			fs.PrintDefaults()
 		}
-		cfg := parseServerConfig(env)
-		runServer(cfg)
 	}
+	return fs
 }
 
-type uploadConfig struct {
-	baseUrl  string
-	auth     string
-	courseId int64
-	moduleId int64
-	filepath string
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
 
-func parseUploadConfig(env internal.Environment) uploadConfig {
-	urlStr := "http://localhost:8080"
-	if env == internal.Production {
-		urlStr = "https://noobular.com"
+func run(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	name := args[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 && name == "help" {
+			return run([]string{args[1], "-h"})
+		}
+		printUsage(os.Stdout)
+		return exitOk
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		fs := newFlagSet(cmd)
+		err := cmd.run(fs, args[1:])
+		var usageErr usageError
+		switch {
+		case err == nil:
+			return exitOk
+		case errors.Is(err, flag.ErrHelp):
+			return exitOk
+		case errors.As(err, &usageErr):
+			fmt.Fprintf(os.Stderr, "noobular %s: %v\n\n", cmd.name, err)
+			fs.Usage()
+			return exitUsage
+		case errors.Is(err, errBadFlags):
+			return exitUsage
+		case errors.Is(err, errReported):
+			return exitFailure
+		default:
+			fmt.Fprintf(os.Stderr, "noobular %s: %v\n", cmd.name, err)
+			return exitFailure
+		}
+	}
+	fmt.Fprintf(os.Stderr, "noobular: unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+// Parses flags, then checks there are between min and max arguments after them,
+// where max < 0 means any number.
+func parseArgs(fs *flag.FlagSet, args []string, min int, max int) ([]string, error) {
+	fs.SetOutput(os.Stderr)
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, err
 	}
+	if err != nil {
+		// The flag package already printed the problem and usage
+		return nil, errBadFlags
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return nil, usageErrorf("wrong number of arguments")
+	}
+	return fs.Args(), nil
+}
 
-	auth := os.Args[1]
-	courseIdInt, err := strconv.Atoi(os.Args[2])
+func parseId(name string, arg string) (int64, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
 	if err != nil {
-		log.Fatal("course_id must be an integer")
+		return 0, usageErrorf("%s must be an integer", name)
+	}
+	return id, nil
+}
+
+// Remote commands
+
+func defaultEnvironment(fallback internal.Environment) internal.Environment {
+	env := internal.Environment(os.Getenv("ENVIRONMENT"))
+	if env != internal.Local && env != internal.Production {
+		return fallback
 	}
-	courseId := int64(courseIdInt)
-	moduleIdInt, err := strconv.Atoi(os.Args[3])
+	return env
+}
+
+func defaultBaseUrl(env internal.Environment) string {
+	if env == internal.Production {
+		return "https://noobular.com"
+	}
+	return "http://localhost:8080"
+}
+
+func defaultCredentialsPath() string {
+	dir, err := os.UserConfigDir()
 	if err != nil {
-		log.Fatal("module_id must be an integer")
+		return "credentials"
 	}
-	moduleId := int64(moduleIdInt)
-	filepath := os.Args[4]
+	return filepath.Join(dir, "noobular", "credentials")
+}
 
-	return uploadConfig{urlStr, auth, courseId, moduleId, filepath}
+// Flags for commands that talk to a server.
+type remoteFlags struct {
+	baseUrl     *string
+	credentials *string
 }
 
-func uploadModule(cfg uploadConfig) {
+func addRemoteFlags(fs *flag.FlagSet) remoteFlags {
+	return remoteFlags{
+		fs.String("url", defaultBaseUrl(defaultEnvironment(internal.Production)), "base `URL` of the server"),
+		fs.String("credentials", defaultCredentialsPath(), "`file` with an access token from your account page, or a session token"),
+	}
+}
+
+// Returns a client logged in with the token in the credentials file.
+func (f remoteFlags) client() (client.Client, error) {
+	data, err := os.ReadFile(*f.credentials)
+	if err != nil {
+		return client.Client{}, fmt.Errorf("reading credentials: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return client.Client{}, fmt.Errorf("credentials file %s is empty", *f.credentials)
+	}
+	if strings.HasPrefix(token, db.AccessTokenPrefix) {
+		return client.NewTokenClient(*f.baseUrl, token), nil
+	}
 	session_token := http.Cookie{
 		Name:     "session_token",
-		Value:    cfg.auth,
+		Value:    token,
 		Expires:  time.Now().Add(1 * time.Minute),
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 		Secure:   true,
 		Path:     "/",
 	}
-	client := client.NewClient(cfg.baseUrl, &session_token)
+	return client.NewClient(*f.baseUrl, &session_token), nil
+}
 
-	data, err := os.ReadFile(cfg.filepath)
-	if err != nil {
-		log.Fatal(err)
+// Returns the body of a successful response, or what the server said went wrong.
+func checkResponse(resp *http.Response, baseUrl string) ([]byte, error) {
+	if resp == nil {
+		return nil, fmt.Errorf("could not reach %s", baseUrl)
 	}
-	resp, err := client.UploadModule(cfg.courseId, cfg.moduleId, string(data))
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		log.Fatal("Upload failed")
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func runUpload(fs *flag.FlagSet, args []string) error {
+	remote := addRemoteFlags(fs)
+	args, err := parseArgs(fs, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	courseId, err := parseId("course_id", args[0])
+	if err != nil {
+		return err
+	}
+	moduleId, err := parseId("module_id", args[1])
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(args[2])
+	if err != nil {
+		return err
+	}
+	c, err := remote.client()
+	if err != nil {
+		return err
+	}
+	var resp *http.Response
+	if isJSONModule(args[2]) {
+		resp = c.UploadModuleJSON(courseId, moduleId, string(data))
+	} else {
+		resp = c.UploadModule(courseId, moduleId, string(data))
+	}
+	_, err = checkResponse(resp, *remote.baseUrl)
+	if err != nil {
+		return fmt.Errorf("upload failed: %v", err)
 	}
 	log.Println("Upload successful")
-	return
+	return nil
 }
 
-type serverConfig struct {
-	env               internal.Environment
-	port              int
-	jwtSecret         []byte
-	certChainFilepath string
-	privKeyFilepath   string
-	webAuthn          *webauthn.WebAuthn
+func runExport(fs *flag.FlagSet, args []string) error {
+	remote := addRemoteFlags(fs)
+	format := fs.String("format", "markdown", "`format` of a module: markdown, json, or zip with its assets. Courses are always zip")
+	output := fs.String("o", "", "`file` to write to instead of stdout")
+	args, err := parseArgs(fs, args, 1, 2)
+	if err != nil {
+		return err
+	}
+	courseId, err := parseId("course_id", args[0])
+	if err != nil {
+		return err
+	}
+	var moduleId int64
+	if len(args) == 2 {
+		moduleId, err = parseId("module_id", args[1])
+		if err != nil {
+			return err
+		}
+		switch *format {
+		case "markdown", "json", "zip":
+		default:
+			return usageErrorf("unknown format %s", *format)
+		}
+	}
+	c, err := remote.client()
+	if err != nil {
+		return err
+	}
+	var resp *http.Response
+	if len(args) == 1 {
+		resp = c.ExportCourse(courseId)
+	} else {
+		resp = c.ExportModule(courseId, moduleId, *format)
+	}
+	body, err := checkResponse(resp, *remote.baseUrl)
+	if err != nil {
+		return fmt.Errorf("export failed: %v", err)
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+	return os.WriteFile(*output, body, 0644)
 }
 
-func parseServerConfig(env internal.Environment) serverConfig {
-	jwtSecretHex := os.Getenv("JWT_SECRET")
-	if jwtSecretHex == "" {
-		token := make([]byte, 32)
-		rand.Read(token)
-		log.Println("Example: set -x JWT_SECRET", hex.EncodeToString(token))
-		log.Fatal("JWT_SECRET must be set")
+// Syncs a course directory (see protocol.SyncManifest) to its course on the server.
+func runSync(fs *flag.FlagSet, args []string) error {
+	remote := addRemoteFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "print what would change without changing anything")
+	deleteRemoved := fs.Bool("delete", false, "delete modules that aren't in the manifest, instead of just reporting them")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
 	}
-	jwtSecret, err := hex.DecodeString(jwtSecretHex)
+	dir := args[0]
+	manifestPath := filepath.Join(dir, protocol.SyncManifestFilename)
+	data, err := os.ReadFile(manifestPath)
 	if err != nil {
-		log.Fatal("JWT_SECRET must be a valid hex string")
+		return err
+	}
+	manifest, err := protocol.ParseSyncManifest(data)
+	if err != nil {
+		return err
+	}
+	// Parse everything first so nothing is uploaded if any file has problems
+	sources := make([]string, len(manifest.Modules))
+	modules := make([]protocol.Module, len(manifest.Modules))
+	ok := true
+	for i, file := range manifest.Modules {
+		path := filepath.Join(dir, file.File)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ok = false
+			continue
+		}
+		sources[i] = string(data)
+		if isJSONModule(file.File) {
+			modules[i], err = protocol.ParseJSON(data)
+		} else {
+			modules[i], err = protocol.Parse(sources[i])
+		}
+		if diagnostic, isDiagnostic := err.(protocol.Diagnostic); isDiagnostic {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", path, diagnostic.Line, diagnostic.Column, diagnostic.Message)
+			ok = false
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			ok = false
+		}
+	}
+	if !ok {
+		return errReported
 	}
 
-	urlStr := "http://localhost:8080"
-	if env == internal.Production {
-		urlStr = "https://noobular.com"
+	c, err := remote.client()
+	if err != nil {
+		return err
+	}
+	body, err := checkResponse(c.GetModuleSummaries(manifest.CourseId), *remote.baseUrl)
+	if err != nil {
+		return fmt.Errorf("getting course %d: %v", manifest.CourseId, err)
+	}
+	var summaries []protocol.ModuleSummary
+	err = json.Unmarshal(body, &summaries)
+	if err != nil {
+		return fmt.Errorf("getting course %d: %v", manifest.CourseId, err)
+	}
+	actions, err := protocol.PlanSync(manifest, modules, summaries, *deleteRemoved)
+	if err != nil {
+		return err
+	}
+
+	manifestIdxs := make(map[string]int)
+	for i, file := range manifest.Modules {
+		manifestIdxs[file.File] = i
+	}
+	created := false
+	for _, action := range actions {
+		name := action.File
+		if name == "" {
+			name = fmt.Sprintf("module %d (%s)", action.ModuleId, action.Title)
+		}
+		if action.ActionType == protocol.SyncRemoved {
+			fmt.Printf("%-9s %s, not in the manifest, run with -delete to delete it\n", action.ActionType, name)
+			continue
+		}
+		fmt.Printf("%-9s %s\n", action.ActionType, name)
+		if *dryRun {
+			continue
+		}
+		switch action.ActionType {
+		case protocol.SyncCreate:
+			idx := manifestIdxs[action.File]
+			var resp *http.Response
+			if isJSONModule(action.File) {
+				resp = c.CreateModuleJSON(manifest.CourseId, sources[idx])
+			} else {
+				resp = c.CreateModule(manifest.CourseId, sources[idx])
+			}
+			body, err := checkResponse(resp, *remote.baseUrl)
+			if err != nil {
+				return fmt.Errorf("creating %s: %v", action.File, err)
+			}
+			moduleId, err := strconv.ParseInt(string(body), 10, 64)
+			if err != nil {
+				return fmt.Errorf("creating %s: unexpected response %q", action.File, body)
+			}
+			// Record the id right away, so a later failure doesn't lose track of it
+			manifest.Modules[idx].Id = moduleId
+			created = true
+			err = writeSyncManifest(manifestPath, manifest)
+			if err != nil {
+				return err
+			}
+		case protocol.SyncUpdate:
+			idx := manifestIdxs[action.File]
+			var resp *http.Response
+			if isJSONModule(action.File) {
+				resp = c.UploadModuleJSON(manifest.CourseId, action.ModuleId, sources[idx])
+			} else {
+				resp = c.UploadModule(manifest.CourseId, action.ModuleId, sources[idx])
+			}
+			_, err := checkResponse(resp, *remote.baseUrl)
+			if err != nil {
+				return fmt.Errorf("uploading %s: %v", action.File, err)
+			}
+		case protocol.SyncDelete:
+			_, err := checkResponse(c.DeleteModule(manifest.CourseId, action.ModuleId), *remote.baseUrl)
+			if err != nil {
+				return fmt.Errorf("deleting %s: %v", name, err)
+			}
+		}
+	}
+	if created {
+		log.Println("Added new module ids to", manifestPath)
+	}
+	return nil
+}
+
+// Module files are in the protocol's JSON form if they end in .json, and markdown otherwise.
+func isJSONModule(path string) bool {
+	return filepath.Ext(path) == ".json"
+}
+
+func writeSyncManifest(path string, manifest protocol.SyncManifest) error {
+	data, err := manifest.JSON()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Local commands
+
+// Modules are checked against the limits of the server they're for, which
+// come from the same config file and environment the server reads.
+func addLimitsFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", os.Getenv("NOOBULAR_CONFIG"), "YAML config `file` to take limits from. Defaults to $NOOBULAR_CONFIG")
+}
+
+func loadLimits(configPath string) (internal.Limits, error) {
+	config, err := internal.LoadConfig(configPath, os.Getenv)
+	if err != nil {
+		return internal.Limits{}, err
+	}
+	err = config.Limits.Validate()
+	if err != nil {
+		return internal.Limits{}, fmt.Errorf("invalid config: %v", err)
+	}
+	return config.Limits, nil
+}
+
+func runLint(fs *flag.FlagSet, args []string) error {
+	format := fs.String("format", "text", "`format` of problems: text, as file:line:column: message, or json")
+	configPath := addLimitsFlag(fs)
+	args, err := parseArgs(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return usageErrorf("unknown format %s", *format)
+	}
+	limits, err := loadLimits(*configPath)
+	if err != nil {
+		return err
+	}
+	if !lintFiles(args, *format, limits) {
+		return errReported
+	}
+	return nil
+}
+
+type jsonDiagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// Prints every problem in each module file, returning whether there were none.
+func lintFiles(filepaths []string, format string, limits internal.Limits) bool {
+	ok := true
+	problems := []jsonDiagnostic{}
+	for _, filepath := range filepaths {
+		data, err := os.ReadFile(filepath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ok = false
+			continue
+		}
+		for _, diagnostic := range internal.LintModule(string(data), limits) {
+			problems = append(problems, jsonDiagnostic{filepath, diagnostic.Line, diagnostic.Column, diagnostic.Message})
+			ok = false
+		}
+	}
+	if format == "json" {
+		data, _ := json.MarshalIndent(problems, "", "  ")
+		fmt.Println(string(data))
+		return ok
 	}
-	urlUrl, err := url.Parse(urlStr)
+	for _, problem := range problems {
+		fmt.Printf("%s:%d:%d: %s\n", problem.File, problem.Line, problem.Column, problem.Message)
+	}
+	return ok
+}
+
+func runFmt(fs *flag.FlagSet, args []string) error {
+	args, err := parseArgs(fs, args, 1, -1)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if !formatFiles(args) {
+		return errReported
 	}
+	return nil
+}
 
-	webAuthn, err := webauthn.New(&webauthn.Config{
-		RPDisplayName: "Noobular",        // Display Name for your site
-		RPID:          urlUrl.Hostname(), // Generally the domain name for your site
-		RPOrigins:     []string{urlStr},  // The origin URL for WebAuthn requests
+// Rewrites each module file in canonical form, returning whether they all parsed.
+// Files that don't parse are left as they are.
+func formatFiles(filepaths []string) bool {
+	ok := true
+	for _, filepath := range filepaths {
+		data, err := os.ReadFile(filepath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ok = false
+			continue
+		}
+		formatted, err := internal.FormatModule(string(data))
+		if diagnostic, isDiagnostic := err.(protocol.Diagnostic); isDiagnostic {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", filepath, diagnostic.Line, diagnostic.Column, diagnostic.Message)
+			ok = false
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filepath, err)
+			ok = false
+			continue
+		}
+		if formatted == string(data) {
+			continue
+		}
+		err = os.WriteFile(filepath, []byte(formatted), 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func runImport(fs *flag.FlagSet, args []string) error {
+	format := fs.String("format", "", "`format` of the file: gift or csv. Defaults to the file's extension")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if !importFile(args[0], protocol.ImportFormat(*format)) {
+		return errReported
+	}
+	return nil
+}
+
+// Prints a question bank file as a module, named after the file, returning whether
+// every item in it could be imported. The module has the items that could be either way.
+func importFile(path string, format protocol.ImportFormat) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	extension := filepath.Ext(path)
+	if format == "" {
+		format = protocol.ImportFormat(strings.TrimPrefix(extension, "."))
+	}
+	blocks, diagnostics, err := protocol.ImportBlocks(format, string(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return false
+	}
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", path, diagnostic.Line, diagnostic.Column, diagnostic.Message)
+	}
+	title := strings.TrimSuffix(filepath.Base(path), extension)
+	module := protocol.NewModule(title, "Imported from "+filepath.Base(path), blocks)
+	fmt.Println(module.Markdown())
+	return len(diagnostics) == 0
+}
+
+// Subcommands that open the database directly find it the same way the
+// server does, through the config file and environment, unless -db says otherwise.
+type dbFlags struct {
+	configPath *string
+	dbPath     *string
+}
+
+func addDbFlags(fs *flag.FlagSet) dbFlags {
+	return dbFlags{
+		configPath: fs.String("config", os.Getenv("NOOBULAR_CONFIG"), "YAML config `file` to find the database in. Defaults to $NOOBULAR_CONFIG"),
+		dbPath:     fs.String("db", "", "`path` of the sqlite database. Defaults to $NOOBULAR_DB_PATH, then the config's, then "+db.DefaultDbPath),
+	}
+}
+
+// Returns where the database is, once the flags are parsed.
+func (f dbFlags) path(fs *flag.FlagSet) (string, error) {
+	config, err := internal.LoadConfig(*f.configPath, os.Getenv)
+	if err != nil {
+		return "", err
+	}
+	fs.Visit(func(given *flag.Flag) {
+		if given.Name == "db" {
+			config.DbPath = *f.dbPath
+		}
 	})
+	return config.WithDefaults().DbPath, nil
+}
+
+// Renders a course from the local database to a static site in dir.
+func runExportSite(fs *flag.FlagSet, args []string) error {
+	dbFlags := addDbFlags(fs)
+	args, err := parseArgs(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	courseId, err := parseId("course_id", args[0])
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	dbPath, err := dbFlags.path(fs)
+	if err != nil {
+		return err
+	}
+	dbClient := db.NewFileDbClient(dbPath)
+	defer dbClient.Close()
+	renderer := internal.NewRenderer(".")
+	err = internal.ExportSite(dbClient, renderer, int(courseId), args[1])
+	if err != nil {
+		return err
+	}
+	log.Println("Exported course", courseId, "to", args[1])
+	return nil
+}
 
-	certChainFilepath := os.Getenv("CERT_PATH")
-	privKeyFilepath := os.Getenv("PRIV_KEY_PATH")
+// Serves a preview of a module file, without a database, until interrupted.
+func runPreview(fs *flag.FlagSet, args []string) error {
+	addr := fs.String("addr", "localhost:8080", "`address` to listen on")
+	configPath := addLimitsFlag(fs)
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	path := args[0]
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	limits, err := loadLimits(*configPath)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:    *addr,
+		Handler: internal.NewPreviewHandler(path, internal.NewRenderer("."), limits),
+	}
+	fmt.Printf("Previewing %s at http://%s\n", path, *addr)
+	return server.ListenAndServe()
+}
 
-	return serverConfig{env, 8080, jwtSecret, certChainFilepath, privKeyFilepath, webAuthn}
+// Opening the database creates it or runs any migrations it needs.
+func runMigrate(fs *flag.FlagSet, args []string) error {
+	dbFlags := addDbFlags(fs)
+	_, err := parseArgs(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	dbPath, err := dbFlags.path(fs)
+	if err != nil {
+		return err
+	}
+	dbClient := db.NewFileDbClient(dbPath)
+	dbClient.Close()
+	return nil
 }
 
-func runServer(cfg serverConfig) {
-	dbClient := db.NewDbClient()
+// Server
+
+func runServe(fs *flag.FlagSet, args []string) error {
+	configPath := fs.String("config", os.Getenv("NOOBULAR_CONFIG"), "YAML config `file`, overridden by the environment and flags. Defaults to $NOOBULAR_CONFIG")
+	env := fs.String("env", "", "`environment` to run in: local or production. Defaults to $ENVIRONMENT, then local")
+	addr := fs.String("addr", "", "`address` to listen on. Defaults to $NOOBULAR_ADDR, then :8080")
+	metricsAddr := fs.String("metrics-addr", "", "`address` to serve /metrics on, e.g. an admin port. Defaults to $NOOBULAR_METRICS_ADDR, and metrics aren't served without one")
+	port := fs.Int("port", 0, "`port` to listen on, short for -addr :port")
+	baseUrl := fs.String("url", "", "public `URL` of the server. Defaults to $NOOBULAR_BASE_URL, then the environment's")
+	rpId := fs.String("rp-id", "", "WebAuthn relying party `id`. Defaults to $NOOBULAR_RP_ID, then the URL's host")
+	origins := fs.String("origins", "", "comma separated `URLs` passkeys can be used from. Defaults to $NOOBULAR_ORIGINS, then the URL")
+	dbPath := fs.String("db", "", "`path` of the sqlite database. Defaults to $NOOBULAR_DB_PATH, then "+db.DefaultDbPath)
+	cert := fs.String("cert", "", "TLS certificate chain `file` in production. Defaults to $CERT_PATH")
+	key := fs.String("key", "", "TLS private key `file` in production. Defaults to $PRIV_KEY_PATH")
+	logLevel := fs.String("log-level", "", "minimum `level` to log: debug, info, warn or error. Defaults to $NOOBULAR_LOG_LEVEL, then info")
+	check := fs.Bool("check", false, "print the config and exit, without starting the server")
+	_, err := parseArgs(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	config, err := internal.LoadConfig(*configPath, os.Getenv)
+	if err != nil {
+		return err
+	}
+	// Only flags that were given override the file and environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			config.Env = internal.Environment(*env)
+		case "addr":
+			config.Addr = *addr
+		case "metrics-addr":
+			config.MetricsAddr = *metricsAddr
+		case "port":
+			config.Addr = fmt.Sprintf(":%d", *port)
+		case "url":
+			config.BaseUrl = *baseUrl
+		case "rp-id":
+			config.RPID = *rpId
+		case "origins":
+			config.Origins = internal.SplitList(*origins)
+		case "db":
+			config.DbPath = *dbPath
+		case "cert":
+			config.CertPath = *cert
+		case "key":
+			config.KeyPath = *key
+		case "log-level":
+			config.Log.Level = *logLevel
+		}
+	})
+	config = config.WithDefaults()
+	err = config.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	if *check {
+		data, err := yaml.Marshal(config)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+	logger, err := internal.NewLogger(os.Stderr, config.Log)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	jwtSecret, err := parseJwtSecret()
+	if err != nil {
+		return err
+	}
+	return runServer(config, jwtSecret)
+}
+
+func parseJwtSecret() ([]byte, error) {
+	jwtSecretHex := os.Getenv("JWT_SECRET")
+	if jwtSecretHex == "" {
+		token := make([]byte, 32)
+		rand.Read(token)
+		log.Println("Example: set -x JWT_SECRET", hex.EncodeToString(token))
+		return nil, fmt.Errorf("JWT_SECRET must be set")
+	}
+	jwtSecret, err := hex.DecodeString(jwtSecretHex)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_SECRET must be a valid hex string")
+	}
+	return jwtSecret, nil
+}
+
+func runServer(config internal.Config, jwtSecret []byte) error {
+	webAuthn, err := config.WebAuthn()
+	if err != nil {
+		return err
+	}
+	dbClient := db.NewFileDbClient(config.DbPath)
 	defer dbClient.Close()
 	renderer := internal.NewRenderer(".")
-	server := internal.NewServer(dbClient, renderer, cfg.webAuthn, cfg.jwtSecret, cfg.port, cfg.env)
-	fmt.Println("Listening on port", server.Addr)
+	metrics := internal.NewMetrics()
+	server := internal.NewServer(dbClient, renderer, webAuthn, jwtSecret, config, metrics)
+	if config.MetricsAddr != "" {
+		metricsServer := internal.NewMetricsServer(config.MetricsAddr, metrics)
+		slog.Info("Serving metrics", "addr", metricsServer.Addr)
+		go func() {
+			err := metricsServer.ListenAndServe()
+			slog.Error("Metrics server stopped", "error", err)
+		}()
+	}
+	slog.Info("Listening", "addr", server.Addr, "env", config.Env)
 
-	if cfg.env == internal.Local {
-		log.Fatal(server.ListenAndServe())
-	} else if cfg.env == internal.Production {
-		log.Fatal(server.ListenAndServeTLS(cfg.certChainFilepath, cfg.privKeyFilepath))
+	if config.Env == internal.Production {
+		return server.ListenAndServeTLS(config.CertPath, config.KeyPath)
 	}
+	return server.ListenAndServe()
 }